@@ -4,13 +4,11 @@ go 1.19
 
 require (
 	github.com/hdt3213/rdb v1.0.14
+	github.com/robfig/cron/v3 v3.0.0
 	github.com/tidwall/btree v1.7.0
 	go.uber.org/zap v1.26.0
 	gopkg.in/natefinch/lumberjack.v2 v2.2.1
 	gopkg.in/yaml.v3 v3.0.1
 )
 
-require (
-	github.com/robfig/cron/v3 v3.0.0 // indirect
-	go.uber.org/multierr v1.10.0 // indirect
-)
+require go.uber.org/multierr v1.10.0 // indirect
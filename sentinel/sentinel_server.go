@@ -19,12 +19,12 @@ func main() {
 	src.SConfig.LoadSentinelConfig(path)
 
 	log.InitLog(src.SConfig.Logs)
-	log.SaveDBLogger.Infof("sentinel init config!", src.Config)
+	log.SaveDBLogger.Infof("sentinel init config!", src.SConfig)
 
 	src.InitSentinelServer()
-	log.SaveDBLogger.Infof("init sentinel server!", src.Config)
+	log.SaveDBLogger.Infof("init sentinel server!", src.SConfig)
 
-	err := src.StartTCPServer(src.Config.Port)
+	err := src.StartListener(src.SConfig.Port, src.SConfig.Protocol)
 	if err != nil {
 		log.SaveDBLogger.Error("sentinel server start fail err=", err)
 		return
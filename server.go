@@ -24,11 +24,18 @@ func main() {
 	src.InitServer()
 	log.SaveDBLogger.Infof("init server!", src.Config)
 
-	err := src.StartTCPServer(src.Config.Port)
+	err := src.StartListener(src.Config.Port, src.Config.Protocol)
 	if err != nil {
 		log.SaveDBLogger.Error("tcp server start fail err=", err)
 		return
 	}
+	for _, l := range src.Config.Listeners {
+		err = src.StartListener(l.Port, l.Protocol)
+		if err != nil {
+			log.SaveDBLogger.Error("tcp server start fail err=", err)
+			return
+		}
+	}
 
 	banner := "   ___________ _   _________    _____________   ___  ________  _____    ______  ________________________\n  / __/ __/ _ \\ | / / __/ _ \\  / __/_  __/ _ | / _ \\/_  __/ / / / _ \\  / __/ / / / ___/ ___/ __/ __/ __/\n _\\ \\/ _// , _/ |/ / _// , _/ _\\ \\  / / / __ |/ , _/ / / / /_/ / ___/ _\\ \\/ /_/ / /__/ /__/ _/_\\ \\_\\ \\  \n/___/___/_/|_||___/___/_/|_| /___/ /_/ /_/ |_/_/|_| /_/  \\____/_/    /___/\\____/\\___/\\___/___/___/___/  "
 	println(banner)
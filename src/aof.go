@@ -1,7 +1,7 @@
 package src
 
 import (
-	"fmt"
+	rdb "github.com/hdt3213/rdb/parser"
 	"io"
 	"os"
 	"savedb/src/log"
@@ -15,6 +15,8 @@ type CmdLine = [][]byte
 
 const (
 	aofQueueSize = 1 << 20
+	//rdb文件的魔数, 用来识别带rdb前缀的aof文件
+	rdbMagic = "REDIS"
 )
 
 const (
@@ -28,8 +30,12 @@ const (
 
 type payload struct {
 	cmdLine CmdLine
+	//事务中的所有命令, 用MULTI/EXEC包起来一次写入
+	txLines []CmdLine
 	dbIndex int
 	wg      *sync.WaitGroup
+	//不是命令, 写到这里时说明之前的命令都已经写进文件了
+	flushed chan struct{}
 }
 
 type Listener interface {
@@ -41,11 +47,23 @@ func (persister *Persister) RemoveListener(listener Listener) {
 	persister.pausingAof.Lock()
 	defer persister.pausingAof.Unlock()
 	delete(persister.listeners, listener)
+	persister.hasListener.Store(len(persister.listeners) > 0)
 }
 
-func (persister *Persister) SaveCmdLine(dbIndex int, cmdLine CmdLine) {
+// 调用方持有pausingAof锁
+func (persister *Persister) addListener(listener Listener) {
+	persister.listeners[listener] = struct{}{}
+	persister.hasListener.Store(true)
+}
+
+// 没有开启aof并且没有listener时不需要记录命令
+func (persister *Persister) skipCmdLine() bool {
 	// aofChan will be set as nil temporarily during load aof see Persister.LoadAof
-	if persister.aofChan == nil {
+	return persister.aofChan == nil || (!Config.AppendOnly && !persister.hasListener.Load())
+}
+
+func (persister *Persister) SaveCmdLine(dbIndex int, cmdLine CmdLine) {
+	if persister.skipCmdLine() {
 		return
 	}
 
@@ -66,8 +84,43 @@ func (persister *Persister) SaveCmdLine(dbIndex int, cmdLine CmdLine) {
 
 }
 
+// SaveTxCmdLines 把一个事务产生的所有命令作为一个整体写入aof, 重放时只有读到EXEC才会执行
+func (persister *Persister) SaveTxCmdLines(dbIndex int, cmdLines []CmdLine) {
+	if persister.skipCmdLine() || len(cmdLines) == 0 {
+		return
+	}
+	p := &payload{
+		txLines: cmdLines,
+		dbIndex: dbIndex,
+	}
+	if persister.aofFsync == FsyncAlways {
+		persister.writeAof(p)
+		return
+	}
+	persister.aofChan <- p
+}
+
+// 等待aofChan中已有的命令写进文件
+func (persister *Persister) flushAof() {
+	if persister.aofChan == nil {
+		return
+	}
+	flushed := make(chan struct{})
+	persister.aofChan <- &payload{flushed: flushed}
+	<-flushed
+}
+
+var (
+	multiCmdLine = ToCmdLine("multi")
+	execCmdLine  = ToCmdLine("exec")
+)
+
 func (persister *Persister) listenCmd() {
 	for p := range persister.aofChan {
+		if p.flushed != nil {
+			close(p.flushed)
+			continue
+		}
 		persister.writeAof(p)
 	}
 	persister.aofFinished <- struct{}{}
@@ -82,25 +135,42 @@ func (persister *Persister) writeAof(p *payload) {
 		// select db
 		selectCmd := ToCmdLine("SELECT", strconv.Itoa(p.dbIndex))
 		persister.buffer = append(persister.buffer, selectCmd)
-		data := MakeMultiBulkReply(selectCmd).ToBytes()
-		_, err := persister.aofFile.Write(data)
-		if err != nil {
-			log.SaveDBLogger.Warn(err)
-			return // skip this command
+		if persister.aofFile != nil {
+			data := MakeMultiBulkReply(selectCmd).ToBytes()
+			_, err := persister.aofFile.Write(data)
+			if err != nil {
+				log.SaveDBLogger.Warn(err)
+				return // skip this command
+			}
 		}
 		persister.currentDB = p.dbIndex
 	}
 	// save command
-	data := MakeMultiBulkReply(p.cmdLine).ToBytes()
-	persister.buffer = append(persister.buffer, p.cmdLine)
-	_, err := persister.aofFile.Write(data)
-	if err != nil {
-		log.SaveDBLogger.Warn(err)
+	var data []byte
+	if p.txLines != nil {
+		lines := make([]CmdLine, 0, len(p.txLines)+2)
+		lines = append(lines, multiCmdLine)
+		lines = append(lines, p.txLines...)
+		lines = append(lines, execCmdLine)
+		for _, line := range lines {
+			data = append(data, MakeMultiBulkReply(line).ToBytes()...)
+		}
+		persister.buffer = append(persister.buffer, lines...)
+	} else {
+		data = MakeMultiBulkReply(p.cmdLine).ToBytes()
+		persister.buffer = append(persister.buffer, p.cmdLine)
+	}
+	//没有开启aof时只通知listener
+	if persister.aofFile != nil {
+		_, err := persister.aofFile.Write(data)
+		if err != nil {
+			log.SaveDBLogger.Warn(err)
+		}
 	}
 	for listener := range persister.listeners {
 		listener.Callback(persister.buffer)
 	}
-	if persister.aofFsync == FsyncAlways {
+	if persister.aofFsync == FsyncAlways && persister.aofFile != nil {
 		_ = persister.aofFile.Sync()
 	}
 }
@@ -120,31 +190,21 @@ func (persister *Persister) LoadAof(maxBytes int) {
 		log.SaveDBLogger.Warn(err)
 		return
 	}
-	// todo 混合模式暂不实现
-	//rdbFile, err := os.Open(GetRDBFilePath())
-	//if err != nil {
-	//	if _, ok := err.(*os.PathError); ok {
-	//		log.SaveDBLogger.Warn(err)
-	//	}
-	//}
 	defer func() {
 		_ = file.Close()
-		//_ = rdbFile.Close()
 	}()
-
-	//decoder := rdb.NewDecoder(rdbFile)
-	//err = persister.db.LoadRDB(decoder)
-	err = fmt.Errorf("")
-	//offset 设置为 0，表示不进行偏移，而 whence 设置为 io.SeekStart，表示将文件指针设置到文件的起始位置。
-	_, _ = file.Seek(0, io.SeekStart)
-	if err == nil {
+	//混合模式: 重写后的aof文件以rdb开头, 先加载rdb再从rdb结束的位置继续重放aof
+	offset, err := persister.loadRdbPreamble(file)
+	if err != nil {
+		log.SaveDBLogger.Errorf("load rdb preamble failed: %v", err)
+		return
+	}
+	if maxBytes > 0 {
 		//上次落盘到现在产生的aof日志
-		//maxBytes = maxBytes - decoder.GetReadCount()
-		//if maxBytes > 0 {
-		//has rdb preamble
-		//设置文件从哪里开始读
-		//	_, _ = file.Seek(int64(decoder.GetReadCount())+1, io.SeekStart)
-		//}
+		maxBytes -= int(offset)
+		if maxBytes <= 0 {
+			return
+		}
 	}
 	var reader io.Reader
 	if maxBytes > 0 {
@@ -176,6 +236,9 @@ func (persister *Persister) LoadAof(maxBytes int) {
 
 		s := BytesArrayToStringArray(r.Args)
 		command := strings.ToLower(s[0])
+		if isLegacyExpire(command, s[1:]) {
+			command = "pexpireat"
+		}
 		//fmt.Println("command=", command, "args=", s[1:])
 		msg := CreateMsg(nil, command, s[1:])
 		//插入数据库
@@ -190,6 +253,47 @@ func (persister *Persister) LoadAof(maxBytes int) {
 	}
 }
 
+// 旧版本的aof中记录的是 expire key 毫秒时间戳, 现在的expire是相对的秒数.
+// 相对的秒数不会超过1e12(三万多年), 2001年之后的毫秒时间戳都比它大, 重放时按pexpireat处理
+const legacyExpireThreshold = 1e12
+
+func isLegacyExpire(command string, args []string) bool {
+	if command != "expire" || len(args) != 2 {
+		return false
+	}
+	n, err := strconv.ParseInt(args[1], 10, 64)
+	return err == nil && n >= legacyExpireThreshold
+}
+
+// 文件以REDIS开头时加载rdb前缀, 返回aof尾部的起始位置, 文件指针会被设置到这个位置
+func (persister *Persister) loadRdbPreamble(file *os.File) (int64, error) {
+	magic := make([]byte, len(rdbMagic))
+	n, _ := io.ReadFull(file, magic)
+	//offset 设置为 0，表示不进行偏移，而 whence 设置为 io.SeekStart，表示将文件指针设置到文件的起始位置。
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return 0, err
+	}
+	if n < len(rdbMagic) || string(magic) != rdbMagic {
+		return 0, nil
+	}
+	decoder := rdb.NewDecoder(file)
+	if err := persister.db.LoadRDB(decoder); err != nil {
+		return 0, err
+	}
+	//decoder内部有缓冲区, 文件指针已经越过了rdb的结尾, 按decoder实际读取的字节数重新定位
+	offset := int64(decoder.GetReadCount())
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		return 0, err
+	}
+	//encoder在校验和后面会多写一个换行符
+	lf := make([]byte, 1)
+	if n, _ := file.Read(lf); n == 1 && lf[0] == '\n' {
+		offset++
+	}
+	_, err := file.Seek(offset, io.SeekStart)
+	return offset, err
+}
+
 func (persister *Persister) Fsync() {
 	persister.pausingAof.Lock()
 	if persister.aofFile != nil {
@@ -202,9 +306,11 @@ func (persister *Persister) Fsync() {
 
 // Close gracefully stops aof persistence procedure
 func (persister *Persister) Close() {
-	if persister.aofFile != nil {
+	if persister.aofChan != nil {
 		close(persister.aofChan)
 		<-persister.aofFinished // wait for aof finished
+	}
+	if persister.aofFile != nil {
 		err := persister.aofFile.Close()
 		if err != nil {
 			log.SaveDBLogger.Warn(err)
@@ -231,12 +337,10 @@ func (persister *Persister) fsyncEverySecond() {
 func (persister *Persister) generateAof(ctx *RewriteCtx) error {
 	// rewrite aof tmpFile
 	tmpFile := ctx.tmpFile
-	// load aof tmpFile
-	//指向临时的db persister也是临时的
-	tmpAof := persister.newRewriteHandler()
-	tmpAof.LoadAof(int(ctx.fileSize))
+	//直接遍历快照, 不需要重放aof
+	snap := ctx.snapshot
 	for i := 0; i < dbsSize; i++ {
-		size := tmpAof.db.FindDB(i).keys.Len()
+		size, _ := snap.KeyCount(i)
 		if size <= 0 {
 			continue
 		}
@@ -247,19 +351,26 @@ func (persister *Persister) generateAof(ctx *RewriteCtx) error {
 			return err
 		}
 		// dump db
-		tmpAof.db.ForEche(i, func(key string, entity any, expiration *time.Time) bool {
-			cmd := EntityToCmd(key, entity)
-			if cmd != nil {
-				_, _ = tmpFile.Write(cmd.ToBytes())
+		var err2 error
+		snap.ForEach(i, func(key string, entity any, expiration *time.Time) bool {
+			for _, line := range EntityToCmds(key, entity) {
+				if _, err2 = tmpFile.Write(MakeMultiBulkReply(line).ToBytes()); err2 != nil {
+					return false
+				}
 			}
 			if expiration != nil {
 				cmd := MakeExpireCmd(key, *expiration)
 				if cmd != nil {
-					_, _ = tmpFile.Write(cmd.ToBytes())
+					if _, err2 = tmpFile.Write(cmd.ToBytes()); err2 != nil {
+						return false
+					}
 				}
 			}
 			return true
 		})
+		if err2 != nil {
+			return err2
+		}
 	}
 	return nil
 }
@@ -0,0 +1,135 @@
+package src
+
+import (
+	"bytes"
+	rdb "github.com/hdt3213/rdb/encoder"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func makeAofTestPersister(t *testing.T, content []byte) *Persister {
+	initTestLog(t)
+	Config.Dir = t.TempDir()
+	Config.AppendFilename = "append.aof"
+	if err := os.WriteFile(GetAofFilePath(), content, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return &Persister{db: MakeTempServer(), loading: &atomic.Bool{}}
+}
+
+func assertAofValue(t *testing.T, p *Persister, dbIndex int, key string, expect string) {
+	val, ok := p.db.FindDB(dbIndex).Data.Get(key)
+	if !ok {
+		t.Errorf("db %d key %s not exist", dbIndex, key)
+		return
+	}
+	if string(val.([]byte)) != expect {
+		t.Errorf("db %d key %s expect %s, actual %s", dbIndex, key, expect, val)
+	}
+}
+
+func TestLoadAof(t *testing.T) {
+	var buf bytes.Buffer
+	buf.Write(ToBytes(ToCmdLine("set", "a", "1")))
+	buf.Write(ToBytes(ToCmdLine("select", "1")))
+	buf.Write(ToBytes(ToCmdLine("set", "b", "2")))
+	p := makeAofTestPersister(t, buf.Bytes())
+	p.LoadAof(0)
+	assertAofValue(t, p, 0, "a", "1")
+	assertAofValue(t, p, 1, "b", "2")
+}
+
+func TestLoadAofWithRdbPreamble(t *testing.T) {
+	var buf bytes.Buffer
+	encoder := rdb.NewEncoder(&buf).EnableCompress()
+	if err := encoder.WriteHeader(); err != nil {
+		t.Fatal(err)
+	}
+	if err := encoder.WriteAux("aof-preamble", "1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := encoder.WriteDBHeader(0, 2, 0); err != nil {
+		t.Fatal(err)
+	}
+	_ = encoder.WriteStringObject("a", []byte("1"))
+	_ = encoder.WriteStringObject("b", []byte("old"))
+	if err := encoder.WriteEnd(); err != nil {
+		t.Fatal(err)
+	}
+	preambleSize := buf.Len()
+	buf.Write(ToBytes(ToCmdLine("select", "0")))
+	buf.Write(ToBytes(ToCmdLine("set", "b", "new")))
+	buf.Write(ToBytes(ToCmdLine("select", "2")))
+	buf.Write(ToBytes(ToCmdLine("set", "c", "3")))
+	p := makeAofTestPersister(t, buf.Bytes())
+	p.LoadAof(0)
+	assertAofValue(t, p, 0, "a", "1")
+	assertAofValue(t, p, 0, "b", "new")
+	assertAofValue(t, p, 2, "c", "3")
+
+	//只加载rdb前缀和尾部的第一条命令
+	p = makeAofTestPersister(t, buf.Bytes())
+	p.LoadAof(preambleSize + len(ToBytes(ToCmdLine("select", "0"))))
+	assertAofValue(t, p, 0, "b", "old")
+	if _, ok := p.db.FindDB(2).Data.Get("c"); ok {
+		t.Error("maxBytes should limit the aof tail")
+	}
+}
+
+func TestRewriteWithRdbPreambleKeepsFieldExpire(t *testing.T) {
+	initTestLog(t)
+	Config.Dir = t.TempDir()
+	Config.AppendFilename = "append.aof"
+	Config.AofUseRdbPreamble = true
+	defer func() { Config.AofUseRdbPreamble = false }()
+	aofFile, err := os.OpenFile(GetAofFilePath(), os.O_APPEND|os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, c := makeMultiTestServer()
+	p := &Persister{db: *s, loading: &atomic.Bool{}, listeners: make(map[Listener]struct{}), aofFile: aofFile}
+	execTestCmd(s, c, "hset", "h", "a", "1", "b", "2")
+	execTestCmd(s, c, "hexpire", "h", "100", "fields", "1", "a")
+	if err = p.Rewrite(); err != nil {
+		t.Fatal(err)
+	}
+	_ = p.aofFile.Close()
+	content, err := os.ReadFile(GetAofFilePath())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p = makeAofTestPersister(t, content)
+	p.LoadAof(0)
+	if r := execTestCmd(&p.db, c, "httl", "h", "fields", "2", "a", "b"); r != "*2\r\n:100\r\n:-1\r\n" {
+		t.Errorf("field expiration should survive rewrite, actual %q", r)
+	}
+}
+
+func TestLoadAofWithLegacyExpire(t *testing.T) {
+	var buf bytes.Buffer
+	now := time.Now().UnixMilli()
+	buf.Write(ToBytes(ToCmdLine("set", "a", "1")))
+	buf.Write(ToBytes(ToCmdLine("expire", "a", strconv.FormatInt(now+100000, 10))))
+	buf.Write(ToBytes(ToCmdLine("set", "b", "2")))
+	buf.Write(ToBytes(ToCmdLine("expire", "b", strconv.FormatInt(now-1000, 10))))
+	buf.Write(ToBytes(ToCmdLine("set", "c", "3")))
+	buf.Write(ToBytes(ToCmdLine("expire", "c", "100")))
+	p := makeAofTestPersister(t, buf.Bytes())
+	p.LoadAof(0)
+	db := p.db.FindDB(0)
+	//旧格式的expire记录的是毫秒时间戳, 加载后过期时间不变
+	if at := db.getExpiration("a"); at == nil || at.UnixMilli() != now+100000 {
+		t.Errorf("legacy expire should be read as a timestamp, actual %v", at)
+	}
+	if _, ok := db.Data.Get("b"); ok {
+		t.Error("legacy expire in the past should remove the key")
+	}
+	//新格式是相对的秒数, 从加载时开始计算
+	if at := db.getExpiration("c"); at == nil || at.UnixMilli() < now+100000 || at.After(time.Now().Add(100*time.Second)) {
+		t.Errorf("expire should be relative seconds, actual %v", at)
+	}
+}
@@ -0,0 +1,269 @@
+package src
+
+import (
+	"container/list"
+	"errors"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// 阻塞命令
+// 1.命令没有数据可以返回时proc返回blockReply, Exec在释放key的锁之前把客户端登记到等待的key上, 之后在连接自己的读协程中等待
+// 2.写命令通过db.signalKey标记有等待者的key, 执行完释放锁之后由执行写命令的协程按登记顺序(FIFO)替等待者重新执行命令
+// 3.重新执行成功时把结果交给等待者, 仍然没有数据时继续等待; 超时返回空数组
+// 4.登记和标记时会持有key的锁再获取BlockedClients.mu, 所以持有mu时不能再去获取key的锁
+// 5.连接的读取在单独的协程中, 客户端断开时markClosed取消等待, 已经断开的等待者不会被服务, 避免弹出的数据丢失
+
+type blockKey struct {
+	db  int
+	key string
+}
+
+type blockedClient struct {
+	mu   sync.Mutex
+	done bool
+	//被取消时不会再有结果
+	canceled bool
+	result   chan Reply
+	command  saveDBCommand
+	args     []string
+	dbIndex  int
+	keys     []string
+	//连接断开时关闭
+	closed <-chan struct{}
+	//每个key的等待队列中的位置
+	elements []*list.Element
+}
+
+type blockingState struct {
+	mu      sync.Mutex
+	waiters map[blockKey]*list.List
+	//有等待者并且被写过的key
+	ready []blockKey
+}
+
+var BlockedClients = &blockingState{waiters: make(map[blockKey]*list.List)}
+
+// 阻塞命令没有数据时的返回值, timeout为0时一直等待
+// 事务中的阻塞命令不等待, 直接回复空数组
+type blockReply struct {
+	timeout time.Duration
+}
+
+func (r *blockReply) ToBytes() []byte {
+	return nullMultiBulkBytes
+}
+
+func (r *blockReply) ToResp3Bytes() []byte {
+	return resp3NullBytes
+}
+
+func blockResult(timeout time.Duration) Reply {
+	return &blockReply{timeout: timeout}
+}
+
+// BLOCK的毫秒数
+func parseBlockTimeout(arg string) (time.Duration, bool) {
+	ms, err := strconv.ParseInt(arg, 10, 64)
+	if err != nil || ms < 0 {
+		return 0, false
+	}
+	return time.Duration(ms) * time.Millisecond, true
+}
+
+// BLPOP等命令的超时秒数, 可以是小数
+func parseBlockSeconds(arg string) (time.Duration, error) {
+	secs, err := strconv.ParseFloat(arg, 64)
+	if err != nil || math.IsNaN(secs) || math.IsInf(secs, 0) {
+		return 0, errors.New("ERR timeout is not a float or out of range")
+	}
+	if secs < 0 {
+		return 0, errors.New("ERR timeout is negative")
+	}
+	timeout := time.Duration(secs * float64(time.Second))
+	if secs > 0 && timeout < time.Millisecond {
+		//不足1毫秒时按1毫秒, 0表示一直等待
+		timeout = time.Millisecond
+	}
+	return timeout, nil
+}
+
+// 写命令修改了key之后调用, 只记录有客户端在等待的key
+func (db *SaveDBTables) signalKey(key string) {
+	b := BlockedClients
+	k := blockKey{db: db.index, key: key}
+	b.mu.Lock()
+	if l, ok := b.waiters[k]; ok && l.Len() > 0 {
+		b.ready = append(b.ready, k)
+	}
+	b.mu.Unlock()
+}
+
+// 持有key的锁时调用, 保证登记之前的写命令不会错过这个客户端
+func (b *blockingState) register(c *Connection, command saveDBCommand, args []string, keys []string) *blockedClient {
+	w := &blockedClient{
+		result:  make(chan Reply, 1),
+		command: command,
+		args:    args,
+		dbIndex: c.dbIndex,
+		keys:    keys,
+	}
+	if c.closing != nil {
+		w.closed = c.closing.ch
+	}
+	b.mu.Lock()
+	for _, key := range keys {
+		k := blockKey{db: w.dbIndex, key: key}
+		l, ok := b.waiters[k]
+		if !ok {
+			l = list.New()
+			b.waiters[k] = l
+		}
+		w.elements = append(w.elements, l.PushBack(w))
+	}
+	b.mu.Unlock()
+	return w
+}
+
+func (b *blockingState) unregister(w *blockedClient) {
+	b.mu.Lock()
+	for i, key := range w.keys {
+		k := blockKey{db: w.dbIndex, key: key}
+		if l, ok := b.waiters[k]; ok {
+			l.Remove(w.elements[i])
+			if l.Len() == 0 {
+				delete(b.waiters, k)
+			}
+		}
+	}
+	b.mu.Unlock()
+}
+
+// 在连接的读协程中等待结果, 超时或者连接断开时返回nil
+func (b *blockingState) wait(w *blockedClient, timeout time.Duration) Reply {
+	var timer <-chan time.Time
+	if timeout > 0 {
+		t := time.NewTimer(timeout)
+		defer t.Stop()
+		timer = t.C
+	}
+	select {
+	case res := <-w.result:
+		return res
+	case <-timer:
+	case <-w.closed:
+	}
+	if !b.cancel(w) {
+		//超时的同时已经被服务了
+		return <-w.result
+	}
+	return nil
+}
+
+// 取消等待, 返回false表示已经被服务了, 结果会从result中返回
+func (b *blockingState) cancel(w *blockedClient) bool {
+	w.mu.Lock()
+	if w.done {
+		w.mu.Unlock()
+		return w.canceled
+	}
+	w.done = true
+	w.canceled = true
+	w.mu.Unlock()
+	b.unregister(w)
+	return true
+}
+
+func (w *blockedClient) isClosed() bool {
+	select {
+	case <-w.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+// 处理被写过的key, 按登记顺序替等待的客户端重新执行命令
+func (b *blockingState) handleReady(s *SaveServer) {
+	for {
+		b.mu.Lock()
+		if len(b.ready) == 0 {
+			b.mu.Unlock()
+			return
+		}
+		k := b.ready[0]
+		b.ready = b.ready[1:]
+		var clients []*blockedClient
+		if l, ok := b.waiters[k]; ok {
+			for e := l.Front(); e != nil; e = e.Next() {
+				clients = append(clients, e.Value.(*blockedClient))
+			}
+		}
+		b.mu.Unlock()
+		for _, w := range clients {
+			b.serve(s, w)
+		}
+	}
+}
+
+func (b *blockingState) serve(s *SaveServer, w *blockedClient) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.done {
+		return
+	}
+	//连接已经断开, 不能再替它弹出数据
+	if w.isClosed() {
+		w.done = true
+		w.canceled = true
+		b.unregister(w)
+		return
+	}
+	var readKeys, writeKeys []string
+	if w.command.funcKeys != nil {
+		readKeys, writeKeys = w.command.funcKeys(w.args)
+	}
+	db := s.FindDB(w.dbIndex)
+	db.Locks(readKeys, writeKeys)
+	res := w.command.saveCommandProc(db, w.args)
+	db.addVersion(writeKeys...)
+	db.UnLocks(readKeys, writeKeys)
+	if _, ok := res.(*blockReply); ok {
+		return
+	}
+	w.done = true
+	b.unregister(w)
+	w.result <- res
+}
+
+// proc返回blockReply之后调用, 调用时还持有key的锁
+func (s *SaveServer) blockClient(c *Connection, command saveDBCommand, args []string, readKeys, writeKeys []string) *blockedClient {
+	keys := make([]string, 0, len(readKeys)+len(writeKeys))
+	seen := make(map[string]struct{})
+	for _, key := range append(append([]string{}, readKeys...), writeKeys...) {
+		if _, ok := seen[key]; !ok {
+			seen[key] = struct{}{}
+			keys = append(keys, key)
+		}
+	}
+	w := BlockedClients.register(c, command, args, keys)
+	if c.closing != nil {
+		c.closing.blocked.Store(w)
+	}
+	return w
+}
+
+// 等待被唤醒, 超时回复空数组
+func (s *SaveServer) waitBlocked(c *Connection, w *blockedClient, res Reply) {
+	reply := BlockedClients.wait(w, res.(*blockReply).timeout)
+	if c.closing != nil {
+		c.closing.blocked.Store(nil)
+	}
+	if reply == nil {
+		c.writeReply(MakeNullMultiBulkReply())
+		return
+	}
+	c.writeReply(reply)
+}
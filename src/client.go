@@ -1,18 +1,22 @@
 package src
 
 import (
-	"encoding/binary"
-	"encoding/json"
+	"bufio"
+	"bytes"
 	"fmt"
 	"io"
 	"net"
 	"strconv"
+	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 )
 
 type TCPClient struct {
 	connection Connection
+	//保证请求和回复一一对应, 同一时刻只有一个请求或者一组pipeline在等待回复
+	mu sync.Mutex
 }
 
 func (client *TCPClient) close() {
@@ -50,16 +54,18 @@ func handleClientRead(con net.Conn, client *TCPClient) {
 			close(client.connection.Read)
 		}
 	}()
+	reader := bufio.NewReaderSize(con, ReaderBufferSize)
 	for {
-		buf := make([]byte, 65535)
-		buf1 := buf[:6]
-		_, err := io.ReadFull(con, buf1)
+		head := make([]byte, 6)
+		_, err := io.ReadFull(reader, head)
 		if err != nil {
 			fmt.Println("time=  Read head error=", time.Now(), err)
 			return
 		}
-		length := ReadInt(buf[2:6])
-		_, err = io.ReadFull(con, buf1[6:6+length])
+		length := ReadInt(head[2:6])
+		buf := make([]byte, 6+length)
+		copy(buf, head)
+		_, err = io.ReadFull(reader, buf[6:])
 		if err != nil {
 			fmt.Println("time=  Read head error=", time.Now(), err)
 			return
@@ -89,39 +95,106 @@ func handleClientWrite(connection *Connection, conn net.Conn) {
 		}
 	}
 }
+
+// SendMsg 给命令行使用, 参数按空格切分, 含有空格或者特殊字符的参数可以用引号包起来
 func (client *TCPClient) SendMsg(str string) string {
-	strBytes := []byte(str)
-	// 创建一个带有长度前缀的字节数组
-	data := make([]byte, 4+len(strBytes))
-	// 将长度写入前四个字节
-	binary.BigEndian.PutUint32(data[:4], uint32(len(strBytes)))
-	copy(data[4:], strBytes)
-	msg := &Message{ReturnData: &data}
-	client.connection.Writer <- msg
+	args, err := SplitArgs(str)
+	if err != nil {
+		return err.Error()
+	}
+	if len(args) == 0 {
+		return "command is null"
+	}
+	return FormatReply(client.SendCmd(args...))
+}
 
-	for {
-		select {
-		case msg, ok := <-client.connection.Read:
-			if !ok {
-				//主动关闭链接了
-				return "Connection close"
-			} else {
-				m := *msg.ReturnData
-				status := Read2Byte(m[:2])
-				len := ReadInt(m[2:6])
-				restr := string(m[6 : 6+len])
-				data := make(map[string]interface{})
-				data["status"] = status
-				data["msg"] = restr
-				res, err := json.MarshalIndent(data, "", "\t")
-				if err != nil {
-					panic(err)
-				}
-				return string(res)
+// SendCmd 发送一条命令并等待结果, 每个参数都是二进制安全的
+func (client *TCPClient) SendCmd(args ...string) Reply {
+	bs := make([][]byte, len(args))
+	for i, arg := range args {
+		bs[i] = []byte(arg)
+	}
+	return client.Do(bs...)
+}
+
+// Do 发送一条命令并等待结果, 参数带长度前缀, 可以是任意的字节
+func (client *TCPClient) Do(args ...[]byte) Reply {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	data := EncodeArgs(args...)
+	client.connection.Writer <- &Message{ReturnData: &data}
+	res, ok := client.readResult()
+	if !ok {
+		//主动关闭链接了
+		return MakeErrReply("Connection close")
+	}
+	return res
+}
+
+func (client *TCPClient) readResult() (Reply, bool) {
+	msg, ok := <-client.connection.Read
+	if !ok {
+		return nil, false
+	}
+	return decodeResult(*msg.ReturnData), true
+}
+
+// 结果的前6个字节是状态和长度, 后面是RESP2编码的回复
+func decodeResult(m []byte) Reply {
+	l := ReadInt(m[2:6])
+	reply, err := ReadReply(bufio.NewReader(bytes.NewReader(m[6 : 6+l])))
+	if err != nil {
+		return MakeErrReply(err.Error())
+	}
+	return reply
+}
+
+// FormatReply 按redis-cli的格式显示回复
+func FormatReply(reply Reply) string {
+	var sb strings.Builder
+	formatReply(&sb, reply, "")
+	return sb.String()
+}
+
+// indent是数组元素编号之后的缩进
+func formatReply(sb *strings.Builder, reply Reply, indent string) {
+	switch r := reply.(type) {
+	case *StatusReply:
+		sb.WriteString(r.Status)
+	case *StandardErrReply:
+		sb.WriteString("(error) " + r.Status)
+	case *IntReply:
+		sb.WriteString("(integer) " + strconv.FormatInt(r.Code, 10))
+	case *BulkReply:
+		if r.Arg == nil {
+			sb.WriteString("(nil)")
+		} else {
+			sb.WriteString(strconv.Quote(string(r.Arg)))
+		}
+	case *NullBulkReply, *NullMultiBulkReply:
+		sb.WriteString("(nil)")
+	case *MultiRawReply:
+		if len(r.Replies) == 0 {
+			sb.WriteString("(empty array)")
+			break
+		}
+		width := len(strconv.Itoa(len(r.Replies)))
+		for i, item := range r.Replies {
+			prefix := fmt.Sprintf("%*d) ", width, i+1)
+			if i > 0 {
+				sb.WriteString(indent)
+			}
+			sb.WriteString(prefix)
+			formatReply(sb, item, indent+strings.Repeat(" ", len(prefix)))
+			if i < len(r.Replies)-1 {
+				sb.WriteString("\n")
 			}
 		}
+	default:
+		sb.Write(reply.ToBytes())
 	}
 }
+
 func (client *TCPClient) GetConnection() Connection {
 	return client.connection
 }
@@ -0,0 +1,596 @@
+package src
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"savedb/src/data"
+	"savedb/src/log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 集群模式
+// 1.一共16384个slot, key的slot是CRC16(key)%16384, key中有{hash tag}时只计算花括号里的部分
+// 2.节点之间每秒通过CLUSTER GOSSIP交换自己负责的slot和知道的其他节点, 同一个slot冲突时配置epoch大的节点胜出
+// 3.Exec在给key加锁之前检查slot, 不是自己负责的slot回复MOVED, 正在迁出并且key已经不在本地时回复ASK
+// 4.迁移slot: 目标节点SETSLOT IMPORTING, 源节点SETSLOT MIGRATING, 然后GETKEYSINSLOT+MIGRATE把key搬过去, 最后SETSLOT NODE
+// 5.节点id, epoch和slot的分配保存在dir下的cluster-config-file中, 重启后保持不变
+
+const (
+	clusterSlots              = 16384
+	clusterGossipPeriod       = time.Second
+	clusterRequestTimeout     = time.Second
+	defaultClusterNodeTimeout = 15 * time.Second
+	defaultClusterConfigFile  = "nodes.conf"
+	//集群模式下数据表按slot分片, 每个slot固定占用几个分片
+	slotShards = dataDictSize / clusterSlots
+)
+
+// Cluster 没有开启集群模式时为nil
+var Cluster *ClusterState
+
+type clusterNode struct {
+	id          string
+	host        string
+	port        int
+	configEpoch int64
+	myself      bool
+	client      *peerClient
+	pingSent    time.Time
+	pongRecv    time.Time
+}
+
+func (n *clusterNode) addr() string {
+	return net.JoinHostPort(n.host, strconv.Itoa(n.port))
+}
+
+type ClusterState struct {
+	mu     sync.RWMutex
+	myself *clusterNode
+	nodes  map[string]*clusterNode
+	//MEET之后还没有回复的节点, 还不知道id, 按地址索引
+	handshakes   map[string]*clusterNode
+	slots        [clusterSlots]*clusterNode
+	migrating    map[int]*clusterNode
+	importing    map[int]*clusterNode
+	currentEpoch int64
+	//配置变了, 由cron协程写回配置文件
+	dirty bool
+}
+
+func newClusterState() *ClusterState {
+	c := &ClusterState{
+		nodes:      make(map[string]*clusterNode),
+		handshakes: make(map[string]*clusterNode),
+		migrating:  make(map[int]*clusterNode),
+		importing:  make(map[int]*clusterNode),
+	}
+	c.myself = &clusterNode{id: newReplId(), myself: true}
+	c.nodes[c.myself.id] = c.myself
+	return c
+}
+
+// InitCluster 在加载数据之前调用, 集群模式下重新创建按slot分片的db
+func InitCluster() {
+	if !Config.ClusterEnabled {
+		return
+	}
+	for i := range Server.Dbs {
+		Server.Dbs[i].Store(makeDB(i, Server.Dbs))
+	}
+	Cluster = newClusterState()
+	if err := Cluster.loadConfig(); err != nil {
+		log.SaveDBLogger.Errorf("load cluster config failed: %v", err)
+	}
+	Cluster.myself.host, Cluster.myself.port = clusterAnnounceIp(), respPort()
+	Cluster.dirty = true
+	log.SaveDBLogger.Infof("cluster mode enabled, node id %s", Cluster.myself.id)
+	go Cluster.cron()
+}
+
+func clusterAnnounceIp() string {
+	if Config.ClusterAnnounceIp != "" {
+		return Config.ClusterAnnounceIp
+	}
+	return "127.0.0.1"
+}
+
+func clusterNodeTimeout() time.Duration {
+	if Config.ClusterNodeTimeout <= 0 {
+		return defaultClusterNodeTimeout
+	}
+	return time.Duration(Config.ClusterNodeTimeout) * time.Millisecond
+}
+
+func clusterConfigPath() string {
+	name := Config.ClusterConfigFile
+	if name == "" {
+		name = defaultClusterConfigFile
+	}
+	return filepath.Join(Config.Dir, name)
+}
+
+// 集群模式下的数据表使用的hash, 同一个slot的key落在相邻的slotShards个分片中
+func slotHash(key string) uint32 {
+	return uint32(keySlot(key))*slotShards + data.Fnv32(key)%slotShards
+}
+
+func newDataDict() *data.ConcurrentDict {
+	if Config.ClusterEnabled {
+		return data.MakeConcurrentWithHash(dataDictSize, slotHash)
+	}
+	return data.MakeConcurrent(dataDictSize)
+}
+
+// slot中的key, count<0时返回全部
+func keysInSlot(slot int, count int) []string {
+	db := Server.FindDB(0)
+	keys := make([]string, 0)
+	for i := 0; i < slotShards; i++ {
+		for _, key := range db.Data.ShardKeys(slot*slotShards + i) {
+			if count >= 0 && len(keys) >= count {
+				return keys
+			}
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+func (c *ClusterState) cron() {
+	ticker := time.NewTicker(clusterGossipPeriod)
+	defer ticker.Stop()
+	for range ticker.C {
+		c.gossip()
+		c.mu.Lock()
+		if c.dirty {
+			if err := c.saveConfig(); err != nil {
+				log.SaveDBLogger.Errorf("save cluster config failed: %v", err)
+			} else {
+				c.dirty = false
+			}
+		}
+		c.mu.Unlock()
+	}
+}
+
+// 每秒给所有节点发送一次gossip, 网络请求不持有锁
+func (c *ClusterState) gossip() {
+	c.mu.Lock()
+	handshakes := make([]*clusterNode, 0, len(c.handshakes))
+	for addr, node := range c.handshakes {
+		//握手超时的节点放弃
+		if time.Since(node.pingSent) > clusterNodeTimeout() {
+			node.client.Close()
+			delete(c.handshakes, addr)
+			continue
+		}
+		handshakes = append(handshakes, node)
+	}
+	nodes := make([]*clusterNode, 0, len(c.nodes))
+	for _, node := range c.nodes {
+		if !node.myself {
+			nodes = append(nodes, node)
+		}
+	}
+	c.mu.Unlock()
+	for _, node := range handshakes {
+		c.sendGossip(node, "meet")
+	}
+	for _, node := range nodes {
+		c.sendGossip(node, "ping")
+	}
+}
+
+func (c *ClusterState) sendGossip(node *clusterNode, typ string) {
+	c.mu.Lock()
+	if node.pingSent.IsZero() {
+		node.pingSent = time.Now()
+	}
+	args := append([]string{"CLUSTER", "GOSSIP", typ}, c.gossipMessage()...)
+	c.mu.Unlock()
+	reply, err := node.client.Do(args...)
+	if err != nil {
+		return
+	}
+	r, ok := reply.(*MultiBulkReply)
+	if !ok {
+		return
+	}
+	msg, err := parseGossip(BytesArrayToStringArray(r.Args))
+	if err != nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if node.handshake() {
+		c.finishHandshake(node, msg)
+		return
+	}
+	if msg.id != node.id {
+		//地址上换了一个节点, 等待它通过gossip重新加入
+		return
+	}
+	node.pingSent = time.Time{}
+	node.pongRecv = time.Now()
+	c.apply(node, msg)
+}
+
+// 握手中的节点id为空
+func (n *clusterNode) handshake() bool {
+	return n.id == ""
+}
+
+// 调用方持有锁
+func (c *ClusterState) finishHandshake(node *clusterNode, msg *gossipMsg) {
+	delete(c.handshakes, node.addr())
+	if msg.id == c.myself.id {
+		node.client.Close()
+		return
+	}
+	known, ok := c.nodes[msg.id]
+	if ok {
+		node.client.Close()
+		node = known
+	} else {
+		node.id = msg.id
+		c.nodes[node.id] = node
+		log.SaveDBLogger.Infof("cluster node %s %s joined", node.id, node.addr())
+	}
+	node.pingSent = time.Time{}
+	node.pongRecv = time.Now()
+	c.dirty = true
+	c.apply(node, msg)
+}
+
+// gossip消息: id ip port current_epoch config_epoch slots nodes
+// slots是"0-100,200"这样的区间, nodes是"id,ip,port;id,ip,port", 没有时是"-"
+type gossipMsg struct {
+	id           string
+	host         string
+	port         int
+	currentEpoch int64
+	configEpoch  int64
+	slots        []int
+	nodes        []*clusterNode
+}
+
+// 调用方持有锁
+func (c *ClusterState) gossipMessage() []string {
+	known := make([]string, 0, len(c.nodes))
+	for _, node := range c.nodes {
+		if !node.myself {
+			known = append(known, node.id+","+node.host+","+strconv.Itoa(node.port))
+		}
+	}
+	nodes := "-"
+	if len(known) > 0 {
+		nodes = strings.Join(known, ";")
+	}
+	slots := "-"
+	if ranges := slotRanges(c.ownedSlots(c.myself)); len(ranges) > 0 {
+		slots = strings.Join(ranges, ",")
+	}
+	me := c.myself
+	return []string{me.id, me.host, strconv.Itoa(me.port), strconv.FormatInt(c.currentEpoch, 10),
+		strconv.FormatInt(me.configEpoch, 10), slots, nodes}
+}
+
+func parseGossip(args []string) (*gossipMsg, error) {
+	if len(args) != 7 {
+		return nil, fmt.Errorf("illegal gossip message")
+	}
+	msg := &gossipMsg{id: args[0], host: args[1]}
+	var err error
+	if msg.port, err = strconv.Atoi(args[2]); err != nil {
+		return nil, err
+	}
+	if msg.currentEpoch, err = strconv.ParseInt(args[3], 10, 64); err != nil {
+		return nil, err
+	}
+	if msg.configEpoch, err = strconv.ParseInt(args[4], 10, 64); err != nil {
+		return nil, err
+	}
+	if args[5] != "-" {
+		for _, r := range strings.Split(args[5], ",") {
+			start, end, err := parseSlotRange(r)
+			if err != nil {
+				return nil, err
+			}
+			for slot := start; slot <= end; slot++ {
+				msg.slots = append(msg.slots, slot)
+			}
+		}
+	}
+	if args[6] != "-" {
+		for _, n := range strings.Split(args[6], ";") {
+			fields := strings.Split(n, ",")
+			if len(fields) != 3 {
+				return nil, fmt.Errorf("illegal gossip node %s", n)
+			}
+			port, err := strconv.Atoi(fields[2])
+			if err != nil {
+				return nil, err
+			}
+			msg.nodes = append(msg.nodes, &clusterNode{id: fields[0], host: fields[1], port: port})
+		}
+	}
+	return msg, nil
+}
+
+func parseSlotRange(r string) (int, int, error) {
+	bounds := strings.SplitN(r, "-", 2)
+	start, err := strconv.Atoi(bounds[0])
+	if err != nil {
+		return 0, 0, err
+	}
+	end := start
+	if len(bounds) == 2 {
+		if end, err = strconv.Atoi(bounds[1]); err != nil {
+			return 0, 0, err
+		}
+	}
+	if start < 0 || end >= clusterSlots || start > end {
+		return 0, 0, fmt.Errorf("illegal slot range %s", r)
+	}
+	return start, end, nil
+}
+
+// 收到其他节点的gossip: 更新epoch, slot的归属和新发现的节点. 调用方持有锁
+func (c *ClusterState) apply(sender *clusterNode, msg *gossipMsg) {
+	if sender.host != msg.host || sender.port != msg.port {
+		sender.client.Close()
+		sender.host, sender.port = msg.host, msg.port
+		sender.client = &peerClient{addr: sender.addr(), timeout: clusterRequestTimeout}
+		c.dirty = true
+	}
+	for _, epoch := range []int64{msg.currentEpoch, msg.configEpoch} {
+		if epoch > c.currentEpoch {
+			c.currentEpoch = epoch
+			c.dirty = true
+		}
+	}
+	if sender.configEpoch != msg.configEpoch {
+		sender.configEpoch = msg.configEpoch
+		c.dirty = true
+	}
+	//配置epoch相同时id小的节点让步, 保证每个节点的配置epoch都不一样
+	if sender.configEpoch == c.myself.configEpoch && sender.id < c.myself.id {
+		c.currentEpoch++
+		c.myself.configEpoch = c.currentEpoch
+		c.dirty = true
+	}
+	for _, slot := range msg.slots {
+		owner := c.slots[slot]
+		if owner == sender || (owner != nil && owner.configEpoch >= sender.configEpoch) {
+			continue
+		}
+		if owner == c.myself {
+			log.SaveDBLogger.Warnf("slot %d is taken over by node %s", slot, sender.id)
+		}
+		c.slots[slot] = sender
+		delete(c.migrating, slot)
+		c.dirty = true
+	}
+	for _, n := range msg.nodes {
+		if _, ok := c.nodes[n.id]; ok || n.id == c.myself.id {
+			continue
+		}
+		c.addNode(n.id, n.host, n.port)
+		c.dirty = true
+	}
+}
+
+// 调用方持有锁
+func (c *ClusterState) addNode(id string, host string, port int) *clusterNode {
+	node := &clusterNode{id: id, host: host, port: port, pongRecv: time.Now()}
+	node.client = &peerClient{addr: node.addr(), timeout: clusterRequestTimeout}
+	c.nodes[id] = node
+	log.SaveDBLogger.Infof("cluster node %s %s added", id, node.addr())
+	return node
+}
+
+// Gossip 处理CLUSTER GOSSIP, 只接受已知节点的ping和新节点的meet
+func (c *ClusterState) Gossip(conn *Connection, args []string) Reply {
+	if len(args) != 8 {
+		return MakeErrReply("ERR wrong number of arguments for 'cluster|gossip' command")
+	}
+	msg, err := parseGossip(args[1:])
+	if err != nil {
+		return MakeErrReply("ERR " + err.Error())
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	sender, ok := c.nodes[msg.id]
+	if !ok {
+		if strings.ToLower(args[0]) != "meet" || msg.id == c.myself.id {
+			return MakeErrReply("ERR unknown node " + msg.id)
+		}
+		sender = c.addNode(msg.id, msg.host, msg.port)
+		c.dirty = true
+	}
+	conn.Type = ConnTypeCluster
+	c.apply(sender, msg)
+	return MakeMultiBulkReply(ToCmdLine(c.gossipMessage()...))
+}
+
+// 调用方持有锁
+func (c *ClusterState) ownedSlots(node *clusterNode) []int {
+	slots := make([]int, 0)
+	for slot, owner := range c.slots {
+		if owner == node {
+			slots = append(slots, slot)
+		}
+	}
+	return slots
+}
+
+// 连续的slot合并成区间, 比如 0-100 200
+func slotRanges(slots []int) []string {
+	ranges := make([]string, 0)
+	for i := 0; i < len(slots); {
+		j := i
+		for j+1 < len(slots) && slots[j+1] == slots[j]+1 {
+			j++
+		}
+		if i == j {
+			ranges = append(ranges, strconv.Itoa(slots[i]))
+		} else {
+			ranges = append(ranges, strconv.Itoa(slots[i])+"-"+strconv.Itoa(slots[j]))
+		}
+		i = j + 1
+	}
+	return ranges
+}
+
+// CLUSTER NODES中一个节点的信息, 配置文件使用相同的格式. 调用方持有锁
+func (c *ClusterState) nodeLine(node *clusterNode) string {
+	flags := "master"
+	if node.myself {
+		flags = "myself,master"
+	} else if !node.pongRecv.IsZero() && time.Since(node.pongRecv) > clusterNodeTimeout() {
+		flags += ",fail?"
+	}
+	link := "connected"
+	if !node.myself && !node.pingSent.IsZero() && time.Since(node.pingSent) > clusterNodeTimeout() {
+		link = "disconnected"
+	}
+	fields := []string{node.id, fmt.Sprintf("%s@%d", node.addr(), node.port), flags, "-",
+		strconv.FormatInt(node.pingSent.UnixMilli(), 10), strconv.FormatInt(node.pongRecv.UnixMilli(), 10),
+		strconv.FormatInt(node.configEpoch, 10), link}
+	if node.pingSent.IsZero() {
+		fields[4] = "0"
+	}
+	if node.pongRecv.IsZero() {
+		fields[5] = "0"
+	}
+	fields = append(fields, slotRanges(c.ownedSlots(node))...)
+	if node.myself {
+		fields = append(fields, c.transferLines()...)
+	}
+	return strings.Join(fields, " ")
+}
+
+// 迁移中的slot: [slot->-目标id] [slot-<-源id]
+func (c *ClusterState) transferLines() []string {
+	lines := make([]string, 0)
+	for _, slot := range sortedSlots(c.migrating) {
+		lines = append(lines, fmt.Sprintf("[%d->-%s]", slot, c.migrating[slot].id))
+	}
+	for _, slot := range sortedSlots(c.importing) {
+		lines = append(lines, fmt.Sprintf("[%d-<-%s]", slot, c.importing[slot].id))
+	}
+	return lines
+}
+
+func sortedSlots(m map[int]*clusterNode) []int {
+	slots := make([]int, 0, len(m))
+	for slot := range m {
+		slots = append(slots, slot)
+	}
+	sort.Ints(slots)
+	return slots
+}
+
+// 调用方持有锁
+func (c *ClusterState) nodesText() string {
+	ids := make([]string, 0, len(c.nodes))
+	for id := range c.nodes {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	var sb strings.Builder
+	for _, id := range ids {
+		sb.WriteString(c.nodeLine(c.nodes[id]) + "\n")
+	}
+	return sb.String()
+}
+
+// 调用方持有锁
+func (c *ClusterState) saveConfig() error {
+	content := c.nodesText() + "vars currentEpoch " + strconv.FormatInt(c.currentEpoch, 10) + "\n"
+	tmp := clusterConfigPath() + ".tmp"
+	if err := os.WriteFile(tmp, []byte(content), 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, clusterConfigPath())
+}
+
+// 启动时加载配置文件, 文件不存在时使用新的节点id
+func (c *ClusterState) loadConfig() error {
+	file, err := os.Open(clusterConfigPath())
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+	nodes := make(map[string]*clusterNode)
+	var myself *clusterNode
+	var slots [clusterSlots]*clusterNode
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		if fields[0] == "vars" {
+			if len(fields) == 3 && fields[1] == "currentEpoch" {
+				c.currentEpoch, _ = strconv.ParseInt(fields[2], 10, 64)
+			}
+			continue
+		}
+		if len(fields) < 8 {
+			return fmt.Errorf("illegal cluster config line: %s", scanner.Text())
+		}
+		host, rawPort, err := net.SplitHostPort(strings.Split(fields[1], "@")[0])
+		if err != nil {
+			return err
+		}
+		port, err := strconv.Atoi(rawPort)
+		if err != nil {
+			return err
+		}
+		node := &clusterNode{id: fields[0], host: host, port: port}
+		node.configEpoch, _ = strconv.ParseInt(fields[6], 10, 64)
+		if strings.Contains(fields[2], "myself") {
+			node.myself = true
+			myself = node
+		} else {
+			node.client = &peerClient{addr: node.addr(), timeout: clusterRequestTimeout}
+			node.pongRecv = time.Now()
+		}
+		nodes[node.id] = node
+		for _, r := range fields[8:] {
+			//迁移状态不保存
+			if strings.HasPrefix(r, "[") {
+				continue
+			}
+			start, end, err := parseSlotRange(r)
+			if err != nil {
+				return err
+			}
+			for slot := start; slot <= end; slot++ {
+				slots[slot] = node
+			}
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		return err
+	}
+	if myself == nil {
+		return fmt.Errorf("cluster config has no myself node")
+	}
+	c.myself, c.nodes, c.slots = myself, nodes, slots
+	return nil
+}
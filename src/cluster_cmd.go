@@ -0,0 +1,522 @@
+package src
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 集群的命令
+// 1.CLUSTER MYID/INFO/NODES/SLOTS/MEET/ADDSLOTS/DELSLOTS/KEYSLOT/COUNTKEYSINSLOT/GETKEYSINSLOT/SETSLOT
+// 2.ASKING之后的下一条命令可以访问正在导入的slot
+// 3.MIGRATE host port key|"" db timeout [COPY] [REPLACE] [KEYS key...] 用EntityToCmd把key发给目标节点后删除本地的key
+
+var crc16Table = makeCrc16Table()
+
+// CRC16 XMODEM, 和redis集群使用的算法一致
+func makeCrc16Table() [256]uint16 {
+	var table [256]uint16
+	for i := range table {
+		crc := uint16(i) << 8
+		for j := 0; j < 8; j++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+		table[i] = crc
+	}
+	return table
+}
+
+func crc16(key string) uint16 {
+	var crc uint16
+	for i := 0; i < len(key); i++ {
+		crc = crc<<8 ^ crc16Table[byte(crc>>8)^key[i]]
+	}
+	return crc
+}
+
+// key的slot, 有非空的{hash tag}时只计算第一对花括号中的内容
+func keySlot(key string) int {
+	if s := strings.IndexByte(key, '{'); s >= 0 {
+		if e := strings.IndexByte(key[s+1:], '}'); e > 0 {
+			key = key[s+1 : s+1+e]
+		}
+	}
+	return int(crc16(key) & (clusterSlots - 1))
+}
+
+// ClusterCommand CLUSTER subcommand [args...]
+func ClusterCommand(conn *Connection, args []string) Reply {
+	if Cluster == nil {
+		return MakeErrReply("ERR This instance has cluster support disabled")
+	}
+	if len(args) == 0 {
+		return MakeErrReply("ERR wrong number of arguments for 'cluster' command")
+	}
+	sub := strings.ToLower(args[0])
+	switch sub {
+	case "gossip":
+		return Cluster.Gossip(conn, args[1:])
+	case "myid":
+		Cluster.mu.RLock()
+		defer Cluster.mu.RUnlock()
+		return MakeBulkReply([]byte(Cluster.myself.id))
+	case "info":
+		return Cluster.info()
+	case "nodes":
+		Cluster.mu.RLock()
+		defer Cluster.mu.RUnlock()
+		return MakeBulkReply([]byte(Cluster.nodesText()))
+	case "slots":
+		return Cluster.slotsReply()
+	case "meet":
+		if len(args) != 3 {
+			return MakeErrReply("ERR wrong number of arguments for 'cluster|meet' command")
+		}
+		return Cluster.meet(args[1], args[2])
+	case "addslots", "delslots":
+		if len(args) < 2 {
+			return MakeErrReply("ERR wrong number of arguments for 'cluster|" + sub + "' command")
+		}
+		slots := make([]int, 0, len(args)-1)
+		for _, arg := range args[1:] {
+			slot, err := parseSlot(arg)
+			if err != nil {
+				return MakeErrReply(err.Error())
+			}
+			slots = append(slots, slot)
+		}
+		if sub == "addslots" {
+			return Cluster.addSlots(slots)
+		}
+		return Cluster.delSlots(slots)
+	case "keyslot":
+		if len(args) != 2 {
+			return MakeErrReply("ERR wrong number of arguments for 'cluster|keyslot' command")
+		}
+		return MakeIntReply(int64(keySlot(args[1])))
+	case "countkeysinslot":
+		if len(args) != 2 {
+			return MakeErrReply("ERR wrong number of arguments for 'cluster|countkeysinslot' command")
+		}
+		slot, err := parseSlot(args[1])
+		if err != nil {
+			return MakeErrReply(err.Error())
+		}
+		return MakeIntReply(int64(len(keysInSlot(slot, -1))))
+	case "getkeysinslot":
+		if len(args) != 3 {
+			return MakeErrReply("ERR wrong number of arguments for 'cluster|getkeysinslot' command")
+		}
+		slot, err := parseSlot(args[1])
+		if err != nil {
+			return MakeErrReply(err.Error())
+		}
+		count, err := strconv.Atoi(args[2])
+		if err != nil || count < 0 {
+			return MakeErrReply("ERR Invalid number of keys")
+		}
+		return MakeMultiBulkReply(ToCmdLine(keysInSlot(slot, count)...))
+	case "setslot":
+		if len(args) < 3 {
+			return MakeErrReply("ERR wrong number of arguments for 'cluster|setslot' command")
+		}
+		slot, err := parseSlot(args[1])
+		if err != nil {
+			return MakeErrReply(err.Error())
+		}
+		nodeId := ""
+		if len(args) > 3 {
+			nodeId = args[3]
+		}
+		return Cluster.setSlot(slot, strings.ToLower(args[2]), nodeId)
+	}
+	return MakeErrReply("ERR unknown subcommand '" + args[0] + "'. Try CLUSTER HELP.")
+}
+
+func parseSlot(arg string) (int, error) {
+	slot, err := strconv.Atoi(arg)
+	if err != nil || slot < 0 || slot >= clusterSlots {
+		return 0, fmt.Errorf("ERR Invalid or out of range slot")
+	}
+	return slot, nil
+}
+
+func (c *ClusterState) info() Reply {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	assigned := 0
+	owners := make(map[*clusterNode]struct{})
+	for _, owner := range c.slots {
+		if owner != nil {
+			assigned++
+			owners[owner] = struct{}{}
+		}
+	}
+	state := "ok"
+	if assigned < clusterSlots {
+		state = "fail"
+	}
+	var sb strings.Builder
+	sb.WriteString("cluster_enabled:1" + CRLF)
+	sb.WriteString("cluster_state:" + state + CRLF)
+	sb.WriteString("cluster_slots_assigned:" + strconv.Itoa(assigned) + CRLF)
+	sb.WriteString("cluster_known_nodes:" + strconv.Itoa(len(c.nodes)) + CRLF)
+	sb.WriteString("cluster_size:" + strconv.Itoa(len(owners)) + CRLF)
+	sb.WriteString("cluster_current_epoch:" + strconv.FormatInt(c.currentEpoch, 10) + CRLF)
+	sb.WriteString("cluster_my_epoch:" + strconv.FormatInt(c.myself.configEpoch, 10) + CRLF)
+	return MakeBulkReply([]byte(sb.String()))
+}
+
+// 每段连续的slot: [start, end, [ip, port, id]]
+func (c *ClusterState) slotsReply() Reply {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	replies := make([]Reply, 0)
+	for start := 0; start < clusterSlots; {
+		owner := c.slots[start]
+		end := start
+		for end+1 < clusterSlots && c.slots[end+1] == owner {
+			end++
+		}
+		if owner != nil {
+			node := MakeMultiRawReply([]Reply{
+				MakeBulkReply([]byte(owner.host)),
+				MakeIntReply(int64(owner.port)),
+				MakeBulkReply([]byte(owner.id)),
+			})
+			replies = append(replies, MakeMultiRawReply([]Reply{
+				MakeIntReply(int64(start)), MakeIntReply(int64(end)), node,
+			}))
+		}
+		start = end + 1
+	}
+	return MakeMultiRawReply(replies)
+}
+
+// 先握手, cron协程发送meet得到对方的id之后加入集群
+func (c *ClusterState) meet(host string, rawPort string) Reply {
+	port, err := strconv.Atoi(rawPort)
+	if err != nil || port <= 0 || port > 65535 {
+		return MakeErrReply("ERR Invalid node address specified: " + host + ":" + rawPort)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	node := &clusterNode{host: host, port: port, pingSent: time.Now()}
+	for _, known := range c.nodes {
+		if known.addr() == node.addr() {
+			return MakeStatusReply(OkStr)
+		}
+	}
+	if _, ok := c.handshakes[node.addr()]; !ok {
+		node.client = &peerClient{addr: node.addr(), timeout: clusterRequestTimeout}
+		c.handshakes[node.addr()] = node
+	}
+	return MakeStatusReply(OkStr)
+}
+
+func (c *ClusterState) addSlots(slots []int) Reply {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	seen := make(map[int]struct{}, len(slots))
+	for _, slot := range slots {
+		if c.slots[slot] != nil {
+			return MakeErrReply("ERR Slot " + strconv.Itoa(slot) + " is already busy")
+		}
+		if _, ok := seen[slot]; ok {
+			return MakeErrReply("ERR Slot " + strconv.Itoa(slot) + " specified multiple times")
+		}
+		seen[slot] = struct{}{}
+	}
+	for _, slot := range slots {
+		c.slots[slot] = c.myself
+		delete(c.importing, slot)
+	}
+	c.dirty = true
+	return MakeStatusReply(OkStr)
+}
+
+func (c *ClusterState) delSlots(slots []int) Reply {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, slot := range slots {
+		if c.slots[slot] == nil {
+			return MakeErrReply("ERR Slot " + strconv.Itoa(slot) + " is already unassigned")
+		}
+	}
+	for _, slot := range slots {
+		c.slots[slot] = nil
+		delete(c.migrating, slot)
+		delete(c.importing, slot)
+	}
+	c.dirty = true
+	return MakeStatusReply(OkStr)
+}
+
+// SETSLOT slot IMPORTING id | MIGRATING id | NODE id | STABLE
+func (c *ClusterState) setSlot(slot int, action string, nodeId string) Reply {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if action == "stable" {
+		delete(c.migrating, slot)
+		delete(c.importing, slot)
+		return MakeStatusReply(OkStr)
+	}
+	node, ok := c.nodes[nodeId]
+	if !ok {
+		return MakeErrReply("ERR I don't know about node " + nodeId)
+	}
+	switch action {
+	case "importing":
+		if c.slots[slot] == c.myself {
+			return MakeErrReply("ERR I'm already the owner of hash slot " + strconv.Itoa(slot))
+		}
+		if node == c.myself {
+			return MakeErrReply("ERR I can't import hash slot " + strconv.Itoa(slot) + " from myself")
+		}
+		c.importing[slot] = node
+	case "migrating":
+		if c.slots[slot] != c.myself {
+			return MakeErrReply("ERR I'm not the owner of hash slot " + strconv.Itoa(slot))
+		}
+		if node == c.myself {
+			return MakeErrReply("ERR I can't migrate hash slot " + strconv.Itoa(slot) + " to myself")
+		}
+		c.migrating[slot] = node
+	case "node":
+		if c.slots[slot] == c.myself && node != c.myself && len(keysInSlot(slot, 1)) > 0 {
+			return MakeErrReply("ERR Can't assign hashslot " + strconv.Itoa(slot) +
+				" to a different node while I still hold keys for this hash slot.")
+		}
+		//迁移完成时如果自己的配置epoch不是唯一最大的, 不经过其他节点同意增大epoch, 通过gossip让其他节点接受新的归属
+		if _, importing := c.importing[slot]; importing && node == c.myself && !c.hasMaxConfigEpoch() {
+			c.currentEpoch++
+			c.myself.configEpoch = c.currentEpoch
+		}
+		delete(c.migrating, slot)
+		delete(c.importing, slot)
+		c.slots[slot] = node
+		c.dirty = true
+	default:
+		return MakeErrReply("ERR Invalid CLUSTER SETSLOT action or number of arguments")
+	}
+	return MakeStatusReply(OkStr)
+}
+
+// 自己的配置epoch大于0并且比其他节点都大. 调用方持有锁
+func (c *ClusterState) hasMaxConfigEpoch() bool {
+	if c.myself.configEpoch == 0 || c.myself.configEpoch < c.currentEpoch {
+		return false
+	}
+	for _, node := range c.nodes {
+		if node != c.myself && node.configEpoch >= c.myself.configEpoch {
+			return false
+		}
+	}
+	return true
+}
+
+// 检查key是否由这个节点负责, 返回空字符串表示可以在本地执行, 否则是重定向的错误
+func (c *ClusterState) checkKeys(cmd string, keys []string, asking bool) string {
+	slot := keySlot(keys[0])
+	for _, key := range keys[1:] {
+		if keySlot(key) != slot {
+			return "CROSSSLOT Keys in request don't hash to the same slot"
+		}
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	owner := c.slots[slot]
+	if owner == nil {
+		return "CLUSTERDOWN Hash slot not served"
+	}
+	if owner == c.myself {
+		target, ok := c.migrating[slot]
+		//MIGRATE自己处理不存在的key
+		if !ok || cmd == "migrate" {
+			return ""
+		}
+		//已经迁走的key让客户端去目标节点找
+		switch missing := countMissingKeys(keys); {
+		case missing == 0:
+			return ""
+		case missing == len(keys):
+			return fmt.Sprintf("ASK %d %s", slot, target.addr())
+		default:
+			return "TRYAGAIN Multiple keys request during rehashing of slot"
+		}
+	}
+	if _, ok := c.importing[slot]; ok && asking {
+		if len(keys) > 1 && countMissingKeys(keys) > 0 {
+			return "TRYAGAIN Multiple keys request during rehashing of slot"
+		}
+		return ""
+	}
+	return fmt.Sprintf("MOVED %d %s", slot, owner.addr())
+}
+
+// 在命令加锁之前调用, 自己加读锁, 避免和正在执行的写命令竞争
+func countMissingKeys(keys []string) int {
+	db := Server.FindDB(0)
+	db.Data.RWLocks(nil, keys)
+	defer db.Data.RWUnLocks(nil, keys)
+	missing := 0
+	for _, key := range keys {
+		if _, ok := db.Data.Get(key); !ok {
+			missing++
+		}
+	}
+	return missing
+}
+
+// 集群模式下在加锁之前检查key的slot, 需要重定向时回复错误并返回true
+func (s *SaveServer) clusterRedirect(c *Connection, cmd string, args []string) bool {
+	if Cluster == nil || cmd == "asking" {
+		return false
+	}
+	//ASKING只对下一条命令有效
+	asking := c.asking
+	c.asking = false
+	if c.fromMaster || c.Type == ConnTypeCluster {
+		return false
+	}
+	commandFunc, ok := saveCommandMap[cmd]
+	if !ok || commandFunc.funcKeys == nil {
+		return false
+	}
+	readKeys, writeKeys := commandFunc.funcKeys(args)
+	keys := make([]string, 0, len(readKeys)+len(writeKeys))
+	keys = append(append(keys, readKeys...), writeKeys...)
+	if len(keys) == 0 {
+		return false
+	}
+	errMsg := Cluster.checkKeys(cmd, keys, asking)
+	if errMsg == "" {
+		return false
+	}
+	c.flagTxError()
+	ReturnErr(errMsg, c)
+	return true
+}
+
+// MIGRATE要写的key, KEYS选项时key参数必须是空字符串
+func migrateKeys(args []string) ([]string, []string) {
+	if len(args) < 5 {
+		return nil, nil
+	}
+	for i := 5; i < len(args); i++ {
+		if strings.ToLower(args[i]) == "keys" {
+			if args[2] != "" || i+1 == len(args) {
+				return nil, nil
+			}
+			return nil, args[i+1:]
+		}
+	}
+	if args[2] == "" {
+		return nil, nil
+	}
+	return nil, args[2:3]
+}
+
+func Migrate(db *SaveDBTables, args []string) Reply {
+	if len(args) < 5 {
+		return errReply("ERR wrong number of arguments for 'migrate' command")
+	}
+	if _, err := strconv.Atoi(args[1]); err != nil {
+		return errReply("ERR value is not an integer or out of range")
+	}
+	if _, err := strconv.Atoi(args[3]); err != nil {
+		return errReply("ERR value is not an integer or out of range")
+	}
+	timeout, err := strconv.Atoi(args[4])
+	if err != nil {
+		return errReply("ERR value is not an integer or out of range")
+	}
+	if timeout <= 0 {
+		timeout = 1000
+	}
+	copyKeys, replace := false, false
+	for i := 5; i < len(args); i++ {
+		switch strings.ToLower(args[i]) {
+		case "copy":
+			copyKeys = true
+		case "replace":
+			replace = true
+		case "keys":
+			i = len(args)
+		default:
+			return errReply("ERR syntax error")
+		}
+	}
+	_, keys := migrateKeys(args)
+	if len(keys) == 0 {
+		return errReply("ERR syntax error")
+	}
+	client := &peerClient{addr: net.JoinHostPort(args[0], args[1]), timeout: time.Duration(timeout) * time.Millisecond}
+	defer client.Close()
+	selected := false
+	moved := 0
+	for _, key := range keys {
+		entity, ok := db.Data.GetWithLock(key)
+		if !ok {
+			continue
+		}
+		if !selected {
+			if err = migrateSend(client, ToCmdLine("SELECT", args[3])); err != nil {
+				return errReply(err.Error())
+			}
+			selected = true
+		}
+		lines := make([]CmdLine, 0, 3)
+		if replace {
+			lines = append(lines, ToCmdLine("DEL", key))
+		} else {
+			reply, err := client.Do("EXISTS", key)
+			if err != nil {
+				return errReply("IOERR error or timeout reading to target instance")
+			}
+			if n, ok := reply.(*IntReply); ok && n.Code == 1 {
+				return errReply("BUSYKEY Target key name already exists.")
+			}
+		}
+		lines = append(lines, EntityToCmds(key, entity)...)
+		if expiration := db.getExpiration(key); expiration != nil {
+			lines = append(lines, MakeExpireCmd(key, *expiration).Args)
+		}
+		for _, line := range lines {
+			if err = migrateSend(client, line); err != nil {
+				return errReply(err.Error())
+			}
+		}
+		if !copyKeys {
+			Del(db, []string{key})
+		}
+		moved++
+	}
+	if moved == 0 {
+		return MakeStatusReply("NOKEY")
+	}
+	return MakeOkReply()
+}
+
+// 集群模式下每条命令之前发送ASKING, 目标节点正在导入slot时才能写入
+func migrateSend(client *peerClient, line CmdLine) error {
+	if Cluster != nil {
+		if _, err := client.Do("ASKING"); err != nil {
+			return fmt.Errorf("IOERR error or timeout writing to target instance")
+		}
+	}
+	reply, err := client.Do(BytesArrayToStringArray(line)...)
+	if err != nil {
+		return fmt.Errorf("IOERR error or timeout writing to target instance")
+	}
+	if IsErrorReply(reply) {
+		return fmt.Errorf("ERR Target instance replied with error: %s", strings.TrimSpace(strings.TrimPrefix(string(reply.ToBytes()), "-")))
+	}
+	return nil
+}
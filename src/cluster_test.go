@@ -0,0 +1,84 @@
+package src
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestKeySlot(t *testing.T) {
+	cases := map[string]int{"foo": 12182, "bar": 5061, "hello": 866, "{foo}.bar": 12182}
+	for key, slot := range cases {
+		if got := keySlot(key); got != slot {
+			t.Errorf("keySlot(%q) = %d, want %d", key, got, slot)
+		}
+	}
+	if keySlot("{user1000}.following") != keySlot("{user1000}.followers") {
+		t.Error("keys with the same hash tag should be in the same slot")
+	}
+	//空的hash tag计算整个key
+	if keySlot("{}foo") != int(crc16("{}foo")%clusterSlots) {
+		t.Error("empty hash tag should hash the whole key")
+	}
+}
+
+func TestGossipSlots(t *testing.T) {
+	initTestLog(t)
+	c := newClusterState()
+	c.myself.host, c.myself.port = "127.0.0.1", 7000
+	for _, slot := range []int{0, 1, 2, 100, 16383} {
+		c.slots[slot] = c.myself
+	}
+	msg, err := parseGossip(c.gossipMessage())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(msg.slots, []int{0, 1, 2, 100, 16383}) {
+		t.Errorf("unexpected slots %v", msg.slots)
+	}
+	//配置epoch更大的节点接管slot
+	other := c.addNode("b", "127.0.0.1", 7001)
+	msg.id, msg.port, msg.configEpoch, msg.slots = "b", 7001, 1, []int{100}
+	c.apply(other, msg)
+	if c.slots[100] != other || c.slots[0] != c.myself {
+		t.Error("slot 100 should be taken over by node b")
+	}
+}
+
+func TestSetSlotNodeEpoch(t *testing.T) {
+	initTestLog(t)
+	c := newClusterState()
+	c.myself.host, c.myself.port = "127.0.0.1", 7000
+	other := c.addNode("b", "127.0.0.1", 7001)
+	c.currentEpoch, c.myself.configEpoch, other.configEpoch = 3, 3, 2
+	c.slots[100], c.slots[200] = other, other
+
+	//自己的配置epoch已经是最大的, 导入完成时不需要增大
+	c.setSlot(100, "importing", "b")
+	c.setSlot(100, "node", c.myself.id)
+	if c.myself.configEpoch != 3 || c.currentEpoch != 3 {
+		t.Fatalf("epoch should not be bumped, actual %d %d", c.myself.configEpoch, c.currentEpoch)
+	}
+
+	//b用更大的epoch声明了这些slot, 冲突时以epoch大的为准
+	msg := &gossipMsg{id: "b", host: "127.0.0.1", port: 7001, currentEpoch: 4, configEpoch: 4, slots: []int{100, 200}}
+	c.apply(other, msg)
+	if c.slots[100] != other || c.slots[200] != other {
+		t.Fatal("slots should be taken over by node b with a bigger config epoch")
+	}
+	//没有在导入的slot直接指定归属时不增大
+	c.setSlot(200, "node", c.myself.id)
+	if c.myself.configEpoch != 3 {
+		t.Fatalf("epoch should not be bumped without importing, actual %d", c.myself.configEpoch)
+	}
+	//再次导入时自己的epoch比b小, 需要增大才能让其他节点接受
+	c.setSlot(100, "importing", "b")
+	c.setSlot(100, "node", c.myself.id)
+	if c.myself.configEpoch != 5 || c.currentEpoch != 5 {
+		t.Fatalf("expect epoch bumped to 5, actual %d %d", c.myself.configEpoch, c.currentEpoch)
+	}
+	msg.slots = []int{100}
+	c.apply(other, msg)
+	if c.slots[100] != c.myself {
+		t.Fatal("stale claim from node b should not take over slot 100")
+	}
+}
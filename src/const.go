@@ -4,19 +4,39 @@ const (
 	COk             = 1
 	CErr            = 0
 	OkStr           = "OK"
+	PongStr         = "PONG"
+	QueuedStr       = "QUEUED"
 	MsgBufferSize   = 65535
 	MsgBufferOffset = 4
-	Persistent      = 0
-	TypeStr         = 1
-	TypeHash        = 2
-	TypeSet         = 3
-	TypeZSet        = 4
-	TypeList        = 5
+	//单个请求的最大长度
+	MsgMaxSize = 512 << 20
+	//连接读写缓冲区大小和待写回复队列的长度
+	ReaderBufferSize = 16 << 10
+	WriterBufferSize = 16 << 10
+	WriterQueueSize  = 1024
+	Persistent       = 0
+	TypeStr          = 1
+	TypeHash         = 2
+	TypeSet          = 3
+	TypeZSet         = 4
+	TypeList         = 5
+	TypeStream       = 6
 
 	//和redis6.0一样
 	ZskiplistMaxlevel = 32
 	ZskiplistP        = 0.25
 	CRLF              = "\r\n"
+
+	//连接使用的协议
+	ProtocolLegacy     = 0
+	ProtocolResp2      = 2
+	ProtocolResp3      = 3
+	ProtocolNameLegacy = "legacy"
+	ProtocolNameResp   = "resp"
+
+	//Connection.Type
+	ConnTypeClient  = 1
+	ConnTypeCluster = 2
 )
 
 type EmptyMultiBulkReply struct{}
@@ -40,6 +60,26 @@ func MakeNullBulkReply() *NullBulkReply {
 type NullBulkReply struct{}
 
 func (n NullBulkReply) ToBytes() []byte {
-	//TODO implement me
-	panic("implement me")
+	return nullBulkBytes
+}
+
+func (n NullBulkReply) ToResp3Bytes() []byte {
+	return resp3NullBytes
+}
+
+// NullMultiBulkReply 表示不存在的数组, 比如被WATCH打断的EXEC
+type NullMultiBulkReply struct{}
+
+var nullMultiBulkBytes = []byte("*-1\r\n")
+
+func MakeNullMultiBulkReply() *NullMultiBulkReply {
+	return &NullMultiBulkReply{}
+}
+
+func (n NullMultiBulkReply) ToBytes() []byte {
+	return nullMultiBulkBytes
+}
+
+func (n NullMultiBulkReply) ToResp3Bytes() []byte {
+	return resp3NullBytes
 }
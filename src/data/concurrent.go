@@ -14,6 +14,8 @@ type ConcurrentDict struct {
 	table      []*shard
 	count      int32
 	shardCount int
+	// hash 为nil时使用fnv32, 集群模式下按slot分片
+	hash func(key string) uint32
 }
 
 type shard struct {
@@ -54,6 +56,13 @@ func MakeConcurrent(shardCount int) *ConcurrentDict {
 	return d
 }
 
+// MakeConcurrentWithHash creates ConcurrentDict which uses the given hash function to choose shard
+func MakeConcurrentWithHash(shardCount int, hash func(key string) uint32) *ConcurrentDict {
+	d := MakeConcurrent(shardCount)
+	d.hash = hash
+	return d
+}
+
 const prime32 = uint32(16777619)
 
 func fnv32(key string) uint32 {
@@ -65,6 +74,18 @@ func fnv32(key string) uint32 {
 	return hash
 }
 
+// Fnv32 is the default hash function of ConcurrentDict
+func Fnv32(key string) uint32 {
+	return fnv32(key)
+}
+
+func (dict *ConcurrentDict) hashCode(key string) uint32 {
+	if dict.hash != nil {
+		return dict.hash(key)
+	}
+	return fnv32(key)
+}
+
 func (dict *ConcurrentDict) spread(hashCode uint32) uint32 {
 	if dict == nil {
 		panic("dict is nil")
@@ -85,7 +106,7 @@ func (dict *ConcurrentDict) Get(key string) (val interface{}, exists bool) {
 	if dict == nil {
 		panic("dict is nil")
 	}
-	hashCode := fnv32(key)
+	hashCode := dict.hashCode(key)
 	index := dict.spread(hashCode)
 	s := dict.getShard(index)
 	s.mutex.Lock()
@@ -98,7 +119,7 @@ func (dict *ConcurrentDict) GetWithLock(key string) (val interface{}, exists boo
 	if dict == nil {
 		panic("dict is nil")
 	}
-	hashCode := fnv32(key)
+	hashCode := dict.hashCode(key)
 	index := dict.spread(hashCode)
 	s := dict.getShard(index)
 	val, exists = s.m[key]
@@ -118,7 +139,7 @@ func (dict *ConcurrentDict) Put(key string, val interface{}) (result int) {
 	if dict == nil {
 		panic("dict is nil")
 	}
-	hashCode := fnv32(key)
+	hashCode := dict.hashCode(key)
 	index := dict.spread(hashCode)
 	s := dict.getShard(index)
 	s.mutex.Lock()
@@ -137,7 +158,7 @@ func (dict *ConcurrentDict) PutWithLock(key string, val interface{}) (result int
 	if dict == nil {
 		panic("dict is nil")
 	}
-	hashCode := fnv32(key)
+	hashCode := dict.hashCode(key)
 	index := dict.spread(hashCode)
 	s := dict.getShard(index)
 
@@ -155,7 +176,7 @@ func (dict *ConcurrentDict) PutIfAbsent(key string, val interface{}) (result int
 	if dict == nil {
 		panic("dict is nil")
 	}
-	hashCode := fnv32(key)
+	hashCode := dict.hashCode(key)
 	index := dict.spread(hashCode)
 	s := dict.getShard(index)
 	s.mutex.Lock()
@@ -173,7 +194,7 @@ func (dict *ConcurrentDict) PutIfAbsentWithLock(key string, val interface{}) (re
 	if dict == nil {
 		panic("dict is nil")
 	}
-	hashCode := fnv32(key)
+	hashCode := dict.hashCode(key)
 	index := dict.spread(hashCode)
 	s := dict.getShard(index)
 
@@ -190,7 +211,7 @@ func (dict *ConcurrentDict) PutIfExists(key string, val interface{}) (result int
 	if dict == nil {
 		panic("dict is nil")
 	}
-	hashCode := fnv32(key)
+	hashCode := dict.hashCode(key)
 	index := dict.spread(hashCode)
 	s := dict.getShard(index)
 	s.mutex.Lock()
@@ -207,7 +228,7 @@ func (dict *ConcurrentDict) PutIfExistsWithLock(key string, val interface{}) (re
 	if dict == nil {
 		panic("dict is nil")
 	}
-	hashCode := fnv32(key)
+	hashCode := dict.hashCode(key)
 	index := dict.spread(hashCode)
 	s := dict.getShard(index)
 
@@ -223,7 +244,7 @@ func (dict *ConcurrentDict) Remove(key string) (val interface{}, result int) {
 	if dict == nil {
 		panic("dict is nil")
 	}
-	hashCode := fnv32(key)
+	hashCode := dict.hashCode(key)
 	index := dict.spread(hashCode)
 	s := dict.getShard(index)
 	s.mutex.Lock()
@@ -241,7 +262,7 @@ func (dict *ConcurrentDict) RemoveWithLock(key string) (val interface{}, result
 	if dict == nil {
 		panic("dict is nil")
 	}
-	hashCode := fnv32(key)
+	hashCode := dict.hashCode(key)
 	index := dict.spread(hashCode)
 	s := dict.getShard(index)
 
@@ -286,6 +307,23 @@ func (dict *ConcurrentDict) ForEach(consumer Consumer) {
 	}
 }
 
+// ShardCount returns the number of shards
+func (dict *ConcurrentDict) ShardCount() int {
+	return len(dict.table)
+}
+
+// ShardKeys returns a copy of keys in the given shard, it allows traversing a big dict without holding a lock for long
+func (dict *ConcurrentDict) ShardKeys(index int) []string {
+	s := dict.table[index]
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	keys := make([]string, 0, len(s.m))
+	for key := range s.m {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
 // Keys returns all keys in dict
 func (dict *ConcurrentDict) Keys() []string {
 	keys := make([]string, dict.Len())
@@ -374,13 +412,13 @@ func (dict *ConcurrentDict) RandomDistinctKeys(limit int) []string {
 
 // Clear removes all keys in dict
 func (dict *ConcurrentDict) Clear() {
-	*dict = *MakeConcurrent(dict.shardCount)
+	*dict = *MakeConcurrentWithHash(dict.shardCount, dict.hash)
 }
 
 func (dict *ConcurrentDict) toLockIndices(keys []string, reverse bool) []uint32 {
 	indexMap := make(map[uint32]struct{})
 	for _, key := range keys {
-		index := dict.spread(fnv32(key))
+		index := dict.spread(dict.hashCode(key))
 		indexMap[index] = struct{}{}
 	}
 	indices := make([]uint32, 0, len(indexMap))
@@ -402,7 +440,7 @@ func (dict *ConcurrentDict) RWLocks(writeKeys []string, readKeys []string) {
 	indices := dict.toLockIndices(keys, false)
 	writeIndexSet := make(map[uint32]struct{})
 	for _, wKey := range writeKeys {
-		idx := dict.spread(fnv32(wKey))
+		idx := dict.spread(dict.hashCode(wKey))
 		writeIndexSet[idx] = struct{}{}
 	}
 	for _, index := range indices {
@@ -422,7 +460,7 @@ func (dict *ConcurrentDict) RWUnLocks(writeKeys []string, readKeys []string) {
 	indices := dict.toLockIndices(keys, true)
 	writeIndexSet := make(map[uint32]struct{})
 	for _, wKey := range writeKeys {
-		idx := dict.spread(fnv32(wKey))
+		idx := dict.spread(dict.hashCode(wKey))
 		writeIndexSet[idx] = struct{}{}
 	}
 	for _, index := range indices {
@@ -1,6 +1,7 @@
 package data
 
 import (
+	"math"
 	"strconv"
 )
 
@@ -227,6 +228,22 @@ func (sortedSet *SortedSet) PopMin(count int) []*Element {
 	return removed
 }
 
+// PopMax removes and returns at most count members with the highest scores
+func (sortedSet *SortedSet) PopMax(count int) []*Element {
+	size := sortedSet.Len()
+	if size == 0 || count <= 0 {
+		return nil
+	}
+	if int64(count) > size {
+		count = int(size)
+	}
+	removed := sortedSet.RangeByRank(0, int64(count), true)
+	for _, element := range removed {
+		sortedSet.Remove(element.Member)
+	}
+	return removed
+}
+
 // RemoveByRank removes member ranking within [start, stop)
 // sort by ascending order and rank starts from 0
 func (sortedSet *SortedSet) RemoveByRank(start int64, stop int64) int64 {
@@ -236,3 +253,110 @@ func (sortedSet *SortedSet) RemoveByRank(start int64, stop int64) int64 {
 	}
 	return int64(len(removed))
 }
+
+// Aggregate combines the scores of the same member from different sets
+type Aggregate func(a, b float64) float64
+
+var (
+	AggregateSum Aggregate = func(a, b float64) float64 {
+		s := a + b
+		if math.IsNaN(s) {
+			// inf + -inf
+			return 0
+		}
+		return s
+	}
+	AggregateMin Aggregate = math.Min
+	AggregateMax Aggregate = math.Max
+)
+
+// 乘上权重之后的分数, inf * 0 按0处理
+func weightedScore(score float64, weights []float64, i int) float64 {
+	if weights == nil {
+		return score
+	}
+	s := score * weights[i]
+	if math.IsNaN(s) {
+		return 0
+	}
+	return s
+}
+
+// Union returns members in any of the sets, weights can be nil, nil sets are treated as empty
+func Union(sets []*SortedSet, weights []float64, aggregate Aggregate) *SortedSet {
+	scores := make(map[string]float64)
+	for i, set := range sets {
+		if set == nil {
+			continue
+		}
+		for member, element := range set.dict {
+			score := weightedScore(element.Score, weights, i)
+			if old, ok := scores[member]; ok {
+				score = aggregate(old, score)
+			}
+			scores[member] = score
+		}
+	}
+	return makeSortedSetFrom(scores)
+}
+
+// Inter returns members in all of the sets
+func Inter(sets []*SortedSet, weights []float64, aggregate Aggregate) *SortedSet {
+	scores := make(map[string]float64)
+	for _, set := range sets {
+		if set == nil {
+			return MakeSortedSet()
+		}
+	}
+	if len(sets) == 0 {
+		return MakeSortedSet()
+	}
+	for member, element := range sets[0].dict {
+		score := weightedScore(element.Score, weights, 0)
+		found := true
+		for i := 1; i < len(sets); i++ {
+			other, ok := sets[i].dict[member]
+			if !ok {
+				found = false
+				break
+			}
+			score = aggregate(score, weightedScore(other.Score, weights, i))
+		}
+		if found {
+			scores[member] = score
+		}
+	}
+	return makeSortedSetFrom(scores)
+}
+
+// Diff returns members of the first set which are not in the others, scores are kept
+func Diff(sets []*SortedSet) *SortedSet {
+	scores := make(map[string]float64)
+	if len(sets) == 0 || sets[0] == nil {
+		return MakeSortedSet()
+	}
+	for member, element := range sets[0].dict {
+		found := false
+		for _, other := range sets[1:] {
+			if other == nil {
+				continue
+			}
+			if _, ok := other.dict[member]; ok {
+				found = true
+				break
+			}
+		}
+		if !found {
+			scores[member] = element.Score
+		}
+	}
+	return makeSortedSetFrom(scores)
+}
+
+func makeSortedSetFrom(scores map[string]float64) *SortedSet {
+	sortedSet := MakeSortedSet()
+	for member, score := range scores {
+		sortedSet.Add(member, score)
+	}
+	return sortedSet
+}
@@ -3,7 +3,7 @@ package data
 import "testing"
 
 func TestSortedSet_PopMin(t *testing.T) {
-	var set = Make()
+	var set = MakeSortedSet()
 	set.Add("s1", 1)
 	set.Add("s2", 2)
 	set.Add("s3", 3)
@@ -14,3 +14,40 @@ func TestSortedSet_PopMin(t *testing.T) {
 		t.Fail()
 	}
 }
+
+func TestSortedSet_PopMax(t *testing.T) {
+	var set = MakeSortedSet()
+	set.Add("s1", 1)
+	set.Add("s2", 2)
+	set.Add("s3", 3)
+
+	var results = set.PopMax(5)
+	if len(results) != 3 || results[0].Member != "s3" || results[2].Member != "s1" || set.Len() != 0 {
+		t.Fail()
+	}
+}
+
+func TestSortedSet_Algebra(t *testing.T) {
+	a := MakeSortedSet()
+	a.Add("x", 1)
+	a.Add("y", 2)
+	b := MakeSortedSet()
+	b.Add("y", 3)
+	b.Add("z", 4)
+
+	union := Union([]*SortedSet{a, b, nil}, []float64{2, 1, 1}, AggregateSum)
+	if e, _ := union.Get("y"); union.Len() != 3 || e.Score != 7 {
+		t.Errorf("union error, len %d", union.Len())
+	}
+	inter := Inter([]*SortedSet{a, b}, nil, AggregateMax)
+	if e, ok := inter.Get("y"); inter.Len() != 1 || !ok || e.Score != 3 {
+		t.Error("inter error")
+	}
+	if Inter([]*SortedSet{a, nil}, nil, AggregateSum).Len() != 0 {
+		t.Error("inter with missing set should be empty")
+	}
+	diff := Diff([]*SortedSet{a, b})
+	if e, ok := diff.Get("x"); diff.Len() != 1 || !ok || e.Score != 1 {
+		t.Error("diff error")
+	}
+}
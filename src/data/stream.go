@@ -0,0 +1,332 @@
+package data
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/tidwall/btree"
+)
+
+// StreamID is a 128-bit id composed of a milliseconds time and a sequence number
+type StreamID struct {
+	Ms  uint64
+	Seq uint64
+}
+
+var MaxStreamID = StreamID{Ms: math.MaxUint64, Seq: math.MaxUint64}
+
+func (id StreamID) String() string {
+	return strconv.FormatUint(id.Ms, 10) + "-" + strconv.FormatUint(id.Seq, 10)
+}
+
+func (id StreamID) Less(other StreamID) bool {
+	return id.Ms < other.Ms || (id.Ms == other.Ms && id.Seq < other.Seq)
+}
+
+// Incr returns the smallest id greater than id, false if id is the max id
+func (id StreamID) Incr() (StreamID, bool) {
+	if id.Seq < math.MaxUint64 {
+		return StreamID{Ms: id.Ms, Seq: id.Seq + 1}, true
+	}
+	if id.Ms < math.MaxUint64 {
+		return StreamID{Ms: id.Ms + 1}, true
+	}
+	return id, false
+}
+
+// Decr returns the greatest id less than id, false if id is 0-0
+func (id StreamID) Decr() (StreamID, bool) {
+	if id.Seq > 0 {
+		return StreamID{Ms: id.Ms, Seq: id.Seq - 1}, true
+	}
+	if id.Ms > 0 {
+		return StreamID{Ms: id.Ms - 1, Seq: math.MaxUint64}, true
+	}
+	return id, false
+}
+
+// ParseStreamID parses "ms-seq" or "ms", missingSeq is used when the sequence part is omitted
+func ParseStreamID(s string, missingSeq uint64) (StreamID, bool) {
+	msPart, seqPart, hasSeq := strings.Cut(s, "-")
+	ms, err := strconv.ParseUint(msPart, 10, 64)
+	if err != nil {
+		return StreamID{}, false
+	}
+	if !hasSeq {
+		return StreamID{Ms: ms, Seq: missingSeq}, true
+	}
+	seq, err := strconv.ParseUint(seqPart, 10, 64)
+	if err != nil {
+		return StreamID{}, false
+	}
+	return StreamID{Ms: ms, Seq: seq}, true
+}
+
+// StreamEntry is an immutable message, Fields stores field and value alternately
+type StreamEntry struct {
+	ID     StreamID
+	Fields []string
+}
+
+// Stream is an append only log of entries ordered by id
+type Stream struct {
+	entries *btree.BTreeG[*StreamEntry]
+	// LastID is the greatest id ever added, it does not go back when entries are trimmed
+	LastID       StreamID
+	EntriesAdded uint64
+	groups       map[string]*StreamGroup
+}
+
+func MakeStream() *Stream {
+	return &Stream{
+		entries: btree.NewBTreeG[*StreamEntry](func(a, b *StreamEntry) bool {
+			return a.ID.Less(b.ID)
+		}),
+		groups: make(map[string]*StreamGroup),
+	}
+}
+
+func (s *Stream) Len() int {
+	return s.entries.Len()
+}
+
+// Add appends an entry, the caller guarantees id is greater than LastID
+func (s *Stream) Add(id StreamID, fields []string) {
+	s.entries.Set(&StreamEntry{ID: id, Fields: fields})
+	s.LastID = id
+	s.EntriesAdded++
+}
+
+func (s *Stream) Get(id StreamID) (*StreamEntry, bool) {
+	return s.entries.Get(&StreamEntry{ID: id})
+}
+
+func (s *Stream) First() (*StreamEntry, bool) {
+	return s.entries.Min()
+}
+
+func (s *Stream) Last() (*StreamEntry, bool) {
+	return s.entries.Max()
+}
+
+// Range visits entries within [start, end], count <= 0 means no limit
+func (s *Stream) Range(start, end StreamID, count int, desc bool, consumer func(entry *StreamEntry) bool) {
+	if end.Less(start) {
+		return
+	}
+	n := 0
+	iter := func(entry *StreamEntry) bool {
+		if desc && entry.ID.Less(start) || !desc && end.Less(entry.ID) {
+			return false
+		}
+		n++
+		return consumer(entry) && (count <= 0 || n < count)
+	}
+	if desc {
+		s.entries.Descend(&StreamEntry{ID: end}, iter)
+	} else {
+		s.entries.Ascend(&StreamEntry{ID: start}, iter)
+	}
+}
+
+// TrimMaxLen removes the oldest entries until at most maxLen entries remain
+func (s *Stream) TrimMaxLen(maxLen int) int {
+	removed := 0
+	for s.entries.Len() > maxLen {
+		s.entries.PopMin()
+		removed++
+	}
+	return removed
+}
+
+// TrimMinID removes entries whose id is less than minID
+func (s *Stream) TrimMinID(minID StreamID) int {
+	removed := 0
+	for {
+		first, ok := s.entries.Min()
+		if !ok || !first.ID.Less(minID) {
+			return removed
+		}
+		s.entries.PopMin()
+		removed++
+	}
+}
+
+func (s *Stream) Group(name string) *StreamGroup {
+	return s.groups[name]
+}
+
+// CreateGroup returns false if the group already exists
+func (s *Stream) CreateGroup(name string, lastID StreamID) (*StreamGroup, bool) {
+	if _, ok := s.groups[name]; ok {
+		return nil, false
+	}
+	group := &StreamGroup{
+		Name:      name,
+		LastID:    lastID,
+		pel:       makePEL(),
+		consumers: make(map[string]*StreamConsumer),
+	}
+	s.groups[name] = group
+	return group, true
+}
+
+func (s *Stream) DestroyGroup(name string) bool {
+	if _, ok := s.groups[name]; !ok {
+		return false
+	}
+	delete(s.groups, name)
+	return true
+}
+
+// Groups returns groups sorted by name
+func (s *Stream) Groups() []*StreamGroup {
+	groups := make([]*StreamGroup, 0, len(s.groups))
+	for _, g := range s.groups {
+		groups = append(groups, g)
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		return groups[i].Name < groups[j].Name
+	})
+	return groups
+}
+
+// Clone deep copies the stream, entries are immutable and shared
+func (s *Stream) Clone() *Stream {
+	c := &Stream{
+		entries:      s.entries.Copy(),
+		LastID:       s.LastID,
+		EntriesAdded: s.EntriesAdded,
+		groups:       make(map[string]*StreamGroup, len(s.groups)),
+	}
+	for name, g := range s.groups {
+		group, _ := c.CreateGroup(name, g.LastID)
+		for _, consumer := range g.Consumers() {
+			group.Consumer(consumer.Name, true).SeenTime = consumer.SeenTime
+		}
+		g.pel.Scan(func(nack *StreamNACK) bool {
+			n := group.AddPending(nack.ID, group.consumers[nack.Consumer.Name], nack.DeliveryTime)
+			n.DeliveryCount = nack.DeliveryCount
+			return true
+		})
+	}
+	return c
+}
+
+// StreamGroup is a consumer group, pel stores messages delivered but not acknowledged
+type StreamGroup struct {
+	Name      string
+	LastID    StreamID
+	pel       *btree.BTreeG[*StreamNACK]
+	consumers map[string]*StreamConsumer
+}
+
+// StreamNACK is a pending message of a group
+type StreamNACK struct {
+	ID            StreamID
+	Consumer      *StreamConsumer
+	DeliveryTime  int64
+	DeliveryCount uint64
+}
+
+type StreamConsumer struct {
+	Name     string
+	SeenTime int64
+	pel      *btree.BTreeG[*StreamNACK]
+}
+
+func makePEL() *btree.BTreeG[*StreamNACK] {
+	return btree.NewBTreeG[*StreamNACK](func(a, b *StreamNACK) bool {
+		return a.ID.Less(b.ID)
+	})
+}
+
+// Consumer returns the consumer with given name, creates it if create is true
+func (g *StreamGroup) Consumer(name string, create bool) *StreamConsumer {
+	consumer, ok := g.consumers[name]
+	if !ok && create {
+		consumer = &StreamConsumer{Name: name, pel: makePEL()}
+		g.consumers[name] = consumer
+	}
+	return consumer
+}
+
+// DeleteConsumer removes the consumer and its pending messages, returns the number of pending messages
+func (g *StreamGroup) DeleteConsumer(name string) (int, bool) {
+	consumer, ok := g.consumers[name]
+	if !ok {
+		return 0, false
+	}
+	pending := consumer.pel.Len()
+	consumer.pel.Scan(func(nack *StreamNACK) bool {
+		g.pel.Delete(nack)
+		return true
+	})
+	delete(g.consumers, name)
+	return pending, true
+}
+
+// Consumers returns consumers sorted by name
+func (g *StreamGroup) Consumers() []*StreamConsumer {
+	consumers := make([]*StreamConsumer, 0, len(g.consumers))
+	for _, c := range g.consumers {
+		consumers = append(consumers, c)
+	}
+	sort.Slice(consumers, func(i, j int) bool {
+		return consumers[i].Name < consumers[j].Name
+	})
+	return consumers
+}
+
+func (g *StreamGroup) PendingLen() int {
+	return g.pel.Len()
+}
+
+func (g *StreamGroup) Pending(id StreamID) (*StreamNACK, bool) {
+	return g.pel.Get(&StreamNACK{ID: id})
+}
+
+// AddPending delivers id to consumer, an existing pending message changes its owner
+func (g *StreamGroup) AddPending(id StreamID, consumer *StreamConsumer, now int64) *StreamNACK {
+	nack, ok := g.pel.Get(&StreamNACK{ID: id})
+	if ok {
+		nack.Consumer.pel.Delete(nack)
+	} else {
+		nack = &StreamNACK{ID: id}
+		g.pel.Set(nack)
+	}
+	nack.Consumer = consumer
+	nack.DeliveryTime = now
+	consumer.pel.Set(nack)
+	return nack
+}
+
+// Ack removes id from the pending list
+func (g *StreamGroup) Ack(id StreamID) bool {
+	nack, ok := g.pel.Delete(&StreamNACK{ID: id})
+	if !ok {
+		return false
+	}
+	nack.Consumer.pel.Delete(nack)
+	return true
+}
+
+// RangePending visits pending messages within [start, end], of all consumers if consumer is nil
+func (g *StreamGroup) RangePending(start, end StreamID, consumer *StreamConsumer, visit func(nack *StreamNACK) bool) {
+	pel := g.pel
+	if consumer != nil {
+		pel = consumer.pel
+	}
+	pel.Ascend(&StreamNACK{ID: start}, func(nack *StreamNACK) bool {
+		if end.Less(nack.ID) {
+			return false
+		}
+		return visit(nack)
+	})
+}
+
+func (c *StreamConsumer) PendingLen() int {
+	return c.pel.Len()
+}
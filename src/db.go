@@ -10,7 +10,9 @@ import (
 
 const (
 	dataDictSize = 1 << 16
-	dbsSize      = 16
+	//过期时间, 版本号这些辅助的表
+	smallDictSize = 1 << 10
+	dbsSize       = 16
 )
 
 func init() {
@@ -18,49 +20,140 @@ func init() {
 	saveCommandMap["select"] = saveDBCommand{name: "select", arity: 1}
 	saveCommandMap["bgsave"] = saveDBCommand{name: "bgsave", arity: 0}
 	saveCommandMap["bgrewriteaof"] = saveDBCommand{name: "bgrewriteaof", arity: 0}
-	saveCommandMap["flushall"] = saveDBCommand{name: "flushall", arity: 0}
-	saveCommandMap["flushdb"] = saveDBCommand{name: "flushdb", saveCommandProc: FlushDB, arity: 0}
+	saveCommandMap["flushall"] = saveDBCommand{name: "flushall", arity: 0, write: true}
+	saveCommandMap["flushdb"] = saveDBCommand{name: "flushdb", saveCommandProc: FlushDB, arity: 0, funcKeys: writeNoKeys, write: true}
+	saveCommandMap["hello"] = saveDBCommand{name: "hello", arity: -1}
+	saveCommandMap["ping"] = saveDBCommand{name: "ping", saveCommandProc: Ping, arity: -1}
+	saveCommandMap["echo"] = saveDBCommand{name: "echo", saveCommandProc: Echo, arity: 1}
+	saveCommandMap["multi"] = saveDBCommand{name: "multi", arity: 0}
+	saveCommandMap["exec"] = saveDBCommand{name: "exec", arity: 0}
+	saveCommandMap["discard"] = saveDBCommand{name: "discard", arity: 0}
+	saveCommandMap["watch"] = saveDBCommand{name: "watch", arity: -1}
+	saveCommandMap["unwatch"] = saveDBCommand{name: "unwatch", arity: 0}
+	saveCommandMap["subscribe"] = saveDBCommand{name: "subscribe", arity: -1}
+	saveCommandMap["unsubscribe"] = saveDBCommand{name: "unsubscribe", arity: -1}
+	saveCommandMap["psubscribe"] = saveDBCommand{name: "psubscribe", arity: -1}
+	saveCommandMap["punsubscribe"] = saveDBCommand{name: "punsubscribe", arity: -1}
+	saveCommandMap["publish"] = saveDBCommand{name: "publish", saveCommandProc: Publish, arity: 2}
+	saveCommandMap["pubsub"] = saveDBCommand{name: "pubsub", arity: -1}
+	saveCommandMap["replicaof"] = saveDBCommand{name: "replicaof", arity: 2}
+	saveCommandMap["slaveof"] = saveDBCommand{name: "slaveof", arity: 2}
+	saveCommandMap["psync"] = saveDBCommand{name: "psync", arity: 2}
+	saveCommandMap["replconf"] = saveDBCommand{name: "replconf", arity: -1}
+	saveCommandMap["info"] = saveDBCommand{name: "info", arity: -1}
+	saveCommandMap["sentinel"] = saveDBCommand{name: "sentinel", arity: -1}
+	saveCommandMap["cluster"] = saveDBCommand{name: "cluster", arity: -1}
+	saveCommandMap["asking"] = saveDBCommand{name: "asking", arity: 0}
+	saveCommandMap["migrate"] = saveDBCommand{name: "migrate", saveCommandProc: Migrate, arity: -1, funcKeys: migrateKeys, write: true}
 
 	saveCommandMap["get"] = saveDBCommand{name: "get", saveCommandProc: Get, arity: 1, funcKeys: readFirstKey}
-	saveCommandMap["set"] = saveDBCommand{name: "set", saveCommandProc: SetExc, arity: 2, funcKeys: writeFirstKey}
-	saveCommandMap["del"] = saveDBCommand{name: "del", saveCommandProc: Del, arity: -1, funcKeys: writeAllKeys}
+	saveCommandMap["set"] = saveDBCommand{name: "set", saveCommandProc: SetExc, arity: -1, funcKeys: writeFirstKey, write: true}
+	saveCommandMap["setnx"] = saveDBCommand{name: "setnx", saveCommandProc: SetNX, arity: 2, funcKeys: writeFirstKey, write: true}
+	saveCommandMap["setex"] = saveDBCommand{name: "setex", saveCommandProc: SetEX, arity: 3, funcKeys: writeFirstKey, write: true}
+	saveCommandMap["getset"] = saveDBCommand{name: "getset", saveCommandProc: GetSet, arity: 2, funcKeys: writeFirstKey, write: true}
+	saveCommandMap["getdel"] = saveDBCommand{name: "getdel", saveCommandProc: GetDel, arity: 1, funcKeys: writeFirstKey, write: true}
+	saveCommandMap["getex"] = saveDBCommand{name: "getex", saveCommandProc: GetEX, arity: -1, funcKeys: writeFirstKey, write: true}
+	saveCommandMap["mget"] = saveDBCommand{name: "mget", saveCommandProc: MGet, arity: -1, funcKeys: readAllKeys}
+	saveCommandMap["mset"] = saveDBCommand{name: "mset", saveCommandProc: MSet, arity: -1, funcKeys: writePairKeys, write: true}
+	saveCommandMap["msetnx"] = saveDBCommand{name: "msetnx", saveCommandProc: MSetNX, arity: -1, funcKeys: writePairKeys, write: true}
+	saveCommandMap["append"] = saveDBCommand{name: "append", saveCommandProc: Append, arity: 2, funcKeys: writeFirstKey, write: true}
+	saveCommandMap["strlen"] = saveDBCommand{name: "strlen", saveCommandProc: StrLen, arity: 1, funcKeys: readFirstKey}
+	saveCommandMap["getrange"] = saveDBCommand{name: "getrange", saveCommandProc: GetRange, arity: 3, funcKeys: readFirstKey}
+	saveCommandMap["setrange"] = saveDBCommand{name: "setrange", saveCommandProc: SetRange, arity: 3, funcKeys: writeFirstKey, write: true}
+	saveCommandMap["incr"] = saveDBCommand{name: "incr", saveCommandProc: Incr, arity: 1, funcKeys: writeFirstKey, write: true}
+	saveCommandMap["decr"] = saveDBCommand{name: "decr", saveCommandProc: Decr, arity: 1, funcKeys: writeFirstKey, write: true}
+	saveCommandMap["incrby"] = saveDBCommand{name: "incrby", saveCommandProc: IncrBy, arity: 2, funcKeys: writeFirstKey, write: true}
+	saveCommandMap["decrby"] = saveDBCommand{name: "decrby", saveCommandProc: DecrBy, arity: 2, funcKeys: writeFirstKey, write: true}
+	saveCommandMap["incrbyfloat"] = saveDBCommand{name: "incrbyfloat", saveCommandProc: IncrByFloat, arity: 2, funcKeys: writeFirstKey, write: true}
+	saveCommandMap["setbit"] = saveDBCommand{name: "setbit", saveCommandProc: SetBit, arity: 3, funcKeys: writeFirstKey, write: true}
+	saveCommandMap["getbit"] = saveDBCommand{name: "getbit", saveCommandProc: GetBit, arity: 2, funcKeys: readFirstKey}
+	saveCommandMap["bitcount"] = saveDBCommand{name: "bitcount", saveCommandProc: BitCount, arity: -1, funcKeys: readFirstKey}
+	saveCommandMap["bitpos"] = saveDBCommand{name: "bitpos", saveCommandProc: BitPos, arity: -1, funcKeys: readFirstKey}
+	saveCommandMap["bitop"] = saveDBCommand{name: "bitop", saveCommandProc: BitOp, arity: -1, funcKeys: bitOpKeys, write: true}
+	saveCommandMap["bitfield"] = saveDBCommand{name: "bitfield", saveCommandProc: BitField, arity: -1, funcKeys: writeFirstKey, write: true}
+	saveCommandMap["bitfield_ro"] = saveDBCommand{name: "bitfield_ro", saveCommandProc: BitFieldRO, arity: -1, funcKeys: readFirstKey}
+	saveCommandMap["pfadd"] = saveDBCommand{name: "pfadd", saveCommandProc: PfAdd, arity: -1, funcKeys: writeFirstKey, write: true}
+	saveCommandMap["pfcount"] = saveDBCommand{name: "pfcount", saveCommandProc: PfCount, arity: -1, funcKeys: readAllKeys}
+	saveCommandMap["pfmerge"] = saveDBCommand{name: "pfmerge", saveCommandProc: PfMerge, arity: -1, funcKeys: pfMergeKeys, write: true}
+	saveCommandMap["del"] = saveDBCommand{name: "del", saveCommandProc: Del, arity: -1, funcKeys: writeAllKeys, write: true}
 
 	saveCommandMap["keys"] = saveDBCommand{name: "keys", saveCommandProc: Keys, arity: 1}
-	saveCommandMap["exists"] = saveDBCommand{name: "exists", saveCommandProc: Exists, arity: 1, funcKeys: readFirstKey}
+	saveCommandMap["scan"] = saveDBCommand{name: "scan", saveCommandProc: Scan, arity: -1}
+	saveCommandMap["exists"] = saveDBCommand{name: "exists", saveCommandProc: Exists, arity: -1, funcKeys: readAllKeys}
+	saveCommandMap["unlink"] = saveDBCommand{name: "unlink", saveCommandProc: Del, arity: -1, funcKeys: writeAllKeys, write: true}
+	saveCommandMap["type"] = saveDBCommand{name: "type", saveCommandProc: Type, arity: 1, funcKeys: readFirstKey}
+	saveCommandMap["rename"] = saveDBCommand{name: "rename", saveCommandProc: Rename, arity: 2, funcKeys: writeFirstTwoKeys, write: true}
+	saveCommandMap["renamenx"] = saveDBCommand{name: "renamenx", saveCommandProc: RenameNX, arity: 2, funcKeys: writeFirstTwoKeys, write: true}
+	saveCommandMap["copy"] = saveDBCommand{name: "copy", saveCommandProc: Copy, arity: -1, funcKeys: copyKeys, write: true}
+	saveCommandMap["move"] = saveDBCommand{name: "move", saveCommandProc: Move, arity: 2, funcKeys: writeFirstKey, write: true}
+	saveCommandMap["randomkey"] = saveDBCommand{name: "randomkey", saveCommandProc: RandomKey, arity: 0}
+	saveCommandMap["dbsize"] = saveDBCommand{name: "dbsize", saveCommandProc: DBSize, arity: 0}
+	saveCommandMap["touch"] = saveDBCommand{name: "touch", saveCommandProc: Touch, arity: -1, funcKeys: readAllKeys}
 
-	saveCommandMap["hmset"] = saveDBCommand{name: "hmset", saveCommandProc: HmSet, arity: -1, funcKeys: writeFirstKey}
-	saveCommandMap["hget"] = saveDBCommand{name: "hget", saveCommandProc: HGet, arity: 2, funcKeys: writeFirstKey}
-	saveCommandMap["hdel"] = saveDBCommand{name: "hdel", saveCommandProc: HDel, arity: -1, funcKeys: writeFirstKey}
-	saveCommandMap["hexists"] = saveDBCommand{name: "hexists", saveCommandProc: HExists, arity: 2, funcKeys: readAllKeys}
+	saveCommandMap["hmset"] = saveDBCommand{name: "hmset", saveCommandProc: HmSet, arity: -1, funcKeys: writeFirstKey, write: true}
+	saveCommandMap["hset"] = saveDBCommand{name: "hset", saveCommandProc: HSet, arity: -1, funcKeys: writeFirstKey, write: true}
+	saveCommandMap["hsetnx"] = saveDBCommand{name: "hsetnx", saveCommandProc: HSetNX, arity: 3, funcKeys: writeFirstKey, write: true}
+	saveCommandMap["hincrby"] = saveDBCommand{name: "hincrby", saveCommandProc: HIncrBy, arity: 3, funcKeys: writeFirstKey, write: true}
+	saveCommandMap["hincrbyfloat"] = saveDBCommand{name: "hincrbyfloat", saveCommandProc: HIncrByFloat, arity: 3, funcKeys: writeFirstKey, write: true}
+	saveCommandMap["hget"] = saveDBCommand{name: "hget", saveCommandProc: HGet, arity: 2, funcKeys: readFirstKey}
+	saveCommandMap["hmget"] = saveDBCommand{name: "hmget", saveCommandProc: HMGet, arity: -1, funcKeys: readFirstKey}
+	saveCommandMap["hkeys"] = saveDBCommand{name: "hkeys", saveCommandProc: HKeys, arity: 1, funcKeys: readFirstKey}
+	saveCommandMap["hvals"] = saveDBCommand{name: "hvals", saveCommandProc: HVals, arity: 1, funcKeys: readFirstKey}
+	saveCommandMap["hstrlen"] = saveDBCommand{name: "hstrlen", saveCommandProc: HStrLen, arity: 2, funcKeys: readFirstKey}
+	saveCommandMap["hrandfield"] = saveDBCommand{name: "hrandfield", saveCommandProc: HRandField, arity: -1, funcKeys: readFirstKey}
+	saveCommandMap["hdel"] = saveDBCommand{name: "hdel", saveCommandProc: HDel, arity: -1, funcKeys: writeFirstKey, write: true}
+	saveCommandMap["hexists"] = saveDBCommand{name: "hexists", saveCommandProc: HExists, arity: 2, funcKeys: readFirstKey}
 	saveCommandMap["hcard"] = saveDBCommand{name: "hcard", saveCommandProc: HCard, arity: 1, funcKeys: readFirstKey}
 	saveCommandMap["hgetall"] = saveDBCommand{name: "hgetall", saveCommandProc: HGetAll, arity: 1, funcKeys: readFirstKey}
+	saveCommandMap["hexpire"] = saveDBCommand{name: "hexpire", saveCommandProc: HExpire, arity: -1, funcKeys: writeFirstKey, write: true}
+	saveCommandMap["hpexpire"] = saveDBCommand{name: "hpexpire", saveCommandProc: HPExpire, arity: -1, funcKeys: writeFirstKey, write: true}
+	saveCommandMap["hexpireat"] = saveDBCommand{name: "hexpireat", saveCommandProc: HExpireAt, arity: -1, funcKeys: writeFirstKey, write: true}
+	saveCommandMap["hpexpireat"] = saveDBCommand{name: "hpexpireat", saveCommandProc: HPExpireAt, arity: -1, funcKeys: writeFirstKey, write: true}
+	saveCommandMap["httl"] = saveDBCommand{name: "httl", saveCommandProc: HTTL, arity: -1, funcKeys: readFirstKey}
+	saveCommandMap["hpttl"] = saveDBCommand{name: "hpttl", saveCommandProc: HPTTL, arity: -1, funcKeys: readFirstKey}
+	saveCommandMap["hpersist"] = saveDBCommand{name: "hpersist", saveCommandProc: HPersist, arity: -1, funcKeys: writeFirstKey, write: true}
+	saveCommandMap["hscan"] = saveDBCommand{name: "hscan", saveCommandProc: HScan, arity: -1, funcKeys: readFirstKey}
 
-	saveCommandMap["sadd"] = saveDBCommand{name: "sadd", saveCommandProc: SAdd, arity: -1, funcKeys: writeFirstKey}
-	saveCommandMap["srem"] = saveDBCommand{name: "srem", saveCommandProc: SRem, arity: -1, funcKeys: writeFirstKey}
-	saveCommandMap["shaskey"] = saveDBCommand{name: "shaskey", saveCommandProc: SHasKey, arity: 1, funcKeys: writeFirstKey}
-	saveCommandMap["spop"] = saveDBCommand{name: "spop", saveCommandProc: SPop, arity: 1, funcKeys: writeFirstKey}
+	saveCommandMap["sadd"] = saveDBCommand{name: "sadd", saveCommandProc: SAdd, arity: -1, funcKeys: writeFirstKey, write: true}
+	saveCommandMap["srem"] = saveDBCommand{name: "srem", saveCommandProc: SRem, arity: -1, funcKeys: writeFirstKey, write: true}
+	saveCommandMap["shaskey"] = saveDBCommand{name: "shaskey", saveCommandProc: SHasKey, arity: 1, funcKeys: readFirstKey}
+	saveCommandMap["spop"] = saveDBCommand{name: "spop", saveCommandProc: SPop, arity: -1, funcKeys: writeFirstKey, write: true}
+	saveCommandMap["srandmember"] = saveDBCommand{name: "srandmember", saveCommandProc: SRandMember, arity: -1, funcKeys: readFirstKey}
+	saveCommandMap["smove"] = saveDBCommand{name: "smove", saveCommandProc: SMove, arity: 3, funcKeys: writeFirstTwoKeys, write: true}
 	saveCommandMap["scard"] = saveDBCommand{name: "scard", saveCommandProc: SCard, arity: 1, funcKeys: readFirstKey}
-	saveCommandMap["sdiff"] = saveDBCommand{name: "sdiff", saveCommandProc: SDiff, arity: 2, funcKeys: readAllKeys}
-	saveCommandMap["sinter"] = saveDBCommand{name: "sinter", saveCommandProc: SInter, arity: 2, funcKeys: writeAllKeys}
+	saveCommandMap["sdiff"] = saveDBCommand{name: "sdiff", saveCommandProc: SDiff, arity: -1, funcKeys: readAllKeys}
+	saveCommandMap["sinter"] = saveDBCommand{name: "sinter", saveCommandProc: SInter, arity: -1, funcKeys: readAllKeys}
+	saveCommandMap["sintercard"] = saveDBCommand{name: "sintercard", saveCommandProc: SInterCard, arity: -1, funcKeys: readNumKeys}
+	saveCommandMap["sdiffstore"] = saveDBCommand{name: "sdiffstore", saveCommandProc: SDiffStore, arity: -1, funcKeys: storeKeys, write: true}
+	saveCommandMap["sinterstore"] = saveDBCommand{name: "sinterstore", saveCommandProc: SInterStore, arity: -1, funcKeys: storeKeys, write: true}
+	saveCommandMap["sunionstore"] = saveDBCommand{name: "sunionstore", saveCommandProc: SUnionStore, arity: -1, funcKeys: storeKeys, write: true}
 	saveCommandMap["sismember"] = saveDBCommand{name: "sismember", saveCommandProc: SIsMember, arity: 2, funcKeys: readFirstKey}
+	saveCommandMap["smismember"] = saveDBCommand{name: "smismember", saveCommandProc: SMIsMember, arity: -1, funcKeys: readFirstKey}
+	saveCommandMap["saremembers"] = saveDBCommand{name: "saremembers", saveCommandProc: SAreMembers, arity: -1, funcKeys: readFirstKey}
 	saveCommandMap["smembers"] = saveDBCommand{name: "smembers", saveCommandProc: SMembers, arity: 1, funcKeys: readFirstKey}
-	saveCommandMap["sunion"] = saveDBCommand{name: "sunion", saveCommandProc: SUnion, arity: 2, funcKeys: readAllKeys}
+	saveCommandMap["sscan"] = saveDBCommand{name: "sscan", saveCommandProc: SScan, arity: -1, funcKeys: readFirstKey}
+	saveCommandMap["sunion"] = saveDBCommand{name: "sunion", saveCommandProc: SUnion, arity: -1, funcKeys: readAllKeys}
 
 	saveCommandMap["llen"] = saveDBCommand{name: "llen", saveCommandProc: LLen, arity: 1, funcKeys: readFirstKey}
-	saveCommandMap["lpop"] = saveDBCommand{name: "lpop", saveCommandProc: LPop, arity: 1, funcKeys: writeFirstKey}
-	saveCommandMap["lpush"] = saveDBCommand{name: "lpush", saveCommandProc: LPush, arity: 2, funcKeys: writeFirstKey}
-	saveCommandMap["lpushx"] = saveDBCommand{name: "lpushx", saveCommandProc: LPushX, arity: 2, funcKeys: writeFirstKey}
+	saveCommandMap["lpop"] = saveDBCommand{name: "lpop", saveCommandProc: LPop, arity: 1, funcKeys: writeFirstKey, write: true}
+	saveCommandMap["lpush"] = saveDBCommand{name: "lpush", saveCommandProc: LPush, arity: 2, funcKeys: writeFirstKey, write: true}
+	saveCommandMap["lpushx"] = saveDBCommand{name: "lpushx", saveCommandProc: LPushX, arity: 2, funcKeys: writeFirstKey, write: true}
 	saveCommandMap["lrange"] = saveDBCommand{name: "lrange", saveCommandProc: LRange, arity: 3, funcKeys: readFirstKey}
-	saveCommandMap["lrem"] = saveDBCommand{name: "lrem", saveCommandProc: LRem, arity: 3, funcKeys: writeFirstKey}
-	saveCommandMap["lset"] = saveDBCommand{name: "lset", saveCommandProc: LSet, arity: 3, funcKeys: writeFirstKey}
-	saveCommandMap["rpop"] = saveDBCommand{name: "rpop", saveCommandProc: RPop, arity: 1, funcKeys: writeFirstKey}
-	saveCommandMap["rpoplpush"] = saveDBCommand{name: "rpoplpush", saveCommandProc: RPopLPush, arity: 2, funcKeys: writeAllKeys}
-	saveCommandMap["rpush"] = saveDBCommand{name: "rpush", saveCommandProc: RPush, arity: -1, funcKeys: writeFirstKey}
-	saveCommandMap["rpushx"] = saveDBCommand{name: "rpushx", saveCommandProc: RPushX, arity: -1, funcKeys: writeFirstKey}
-	saveCommandMap["ltrim"] = saveDBCommand{name: "ltrim", saveCommandProc: LTrim, arity: 3, funcKeys: writeFirstKey}
-	saveCommandMap["linsert"] = saveDBCommand{name: "linsert", saveCommandProc: LInsert, arity: 4, funcKeys: writeFirstKey}
-
-	saveCommandMap["zadd"] = saveDBCommand{name: "zadd", saveCommandProc: ZAdd, arity: -1, funcKeys: writeFirstKey}
+	saveCommandMap["lrem"] = saveDBCommand{name: "lrem", saveCommandProc: LRem, arity: 3, funcKeys: writeFirstKey, write: true}
+	saveCommandMap["lset"] = saveDBCommand{name: "lset", saveCommandProc: LSet, arity: 3, funcKeys: writeFirstKey, write: true}
+	saveCommandMap["rpop"] = saveDBCommand{name: "rpop", saveCommandProc: RPop, arity: 1, funcKeys: writeFirstKey, write: true}
+	saveCommandMap["rpoplpush"] = saveDBCommand{name: "rpoplpush", saveCommandProc: RPopLPush, arity: 2, funcKeys: writeAllKeys, write: true}
+	saveCommandMap["rpush"] = saveDBCommand{name: "rpush", saveCommandProc: RPush, arity: -1, funcKeys: writeFirstKey, write: true}
+	saveCommandMap["rpushx"] = saveDBCommand{name: "rpushx", saveCommandProc: RPushX, arity: -1, funcKeys: writeFirstKey, write: true}
+	saveCommandMap["ltrim"] = saveDBCommand{name: "ltrim", saveCommandProc: LTrim, arity: 3, funcKeys: writeFirstKey, write: true}
+	saveCommandMap["linsert"] = saveDBCommand{name: "linsert", saveCommandProc: LInsert, arity: 4, funcKeys: writeFirstKey, write: true}
+	saveCommandMap["lmove"] = saveDBCommand{name: "lmove", saveCommandProc: LMove, arity: 4, funcKeys: writeFirstTwoKeys, write: true}
+	saveCommandMap["blpop"] = saveDBCommand{name: "blpop", saveCommandProc: BLPop, arity: -1, funcKeys: writeKeysExceptLast, write: true}
+	saveCommandMap["brpop"] = saveDBCommand{name: "brpop", saveCommandProc: BRPop, arity: -1, funcKeys: writeKeysExceptLast, write: true}
+	saveCommandMap["blmove"] = saveDBCommand{name: "blmove", saveCommandProc: BLMove, arity: 5, funcKeys: writeFirstTwoKeys, write: true}
+	saveCommandMap["brpoplpush"] = saveDBCommand{name: "brpoplpush", saveCommandProc: BRPopLPush, arity: 3, funcKeys: writeFirstTwoKeys, write: true}
+
+	saveCommandMap["zadd"] = saveDBCommand{name: "zadd", saveCommandProc: ZAdd, arity: -1, funcKeys: writeFirstKey, write: true}
 	saveCommandMap["zscore"] = saveDBCommand{name: "zscore", saveCommandProc: ZScore, arity: 2, funcKeys: readFirstKey}
 	saveCommandMap["zrank"] = saveDBCommand{name: "zrank", saveCommandProc: ZRank, arity: 2, funcKeys: readFirstKey}
 	saveCommandMap["zrevrank"] = saveDBCommand{name: "zrevrank", saveCommandProc: ZRevRank, arity: 2, funcKeys: readFirstKey}
@@ -70,16 +163,51 @@ func init() {
 	saveCommandMap["zcount"] = saveDBCommand{name: "zcount", saveCommandProc: ZCount, arity: 3, funcKeys: readFirstKey}
 	saveCommandMap["zrangebyscore"] = saveDBCommand{name: "zrangebyscore", saveCommandProc: ZRangeByScore, arity: -1, funcKeys: readFirstKey}
 	saveCommandMap["zrevrangebyscore"] = saveDBCommand{name: "ZRevRangeByScore", saveCommandProc: ZRevRangeByScore, arity: -1, funcKeys: readFirstKey}
-	saveCommandMap["zremrangebyscore"] = saveDBCommand{name: "zremrangebyscore", saveCommandProc: ZRemRangeByScore, arity: -1, funcKeys: readFirstKey}
-	saveCommandMap["zpopMin"] = saveDBCommand{name: "zpopMin", saveCommandProc: ZPopMin, arity: 2, funcKeys: writeFirstKey}
-	saveCommandMap["zrem"] = saveDBCommand{name: "zrem", saveCommandProc: ZRem, arity: -1, funcKeys: writeFirstKey}
-	saveCommandMap["zincrby"] = saveDBCommand{name: "zincrby", saveCommandProc: ZIncrBy, arity: 3, funcKeys: writeFirstKey}
+	saveCommandMap["zremrangebyscore"] = saveDBCommand{name: "zremrangebyscore", saveCommandProc: ZRemRangeByScore, arity: -1, funcKeys: writeFirstKey, write: true}
+	saveCommandMap["zpopmin"] = saveDBCommand{name: "zpopmin", saveCommandProc: ZPopMin, arity: -1, funcKeys: writeFirstKey, write: true}
+	saveCommandMap["zpopmax"] = saveDBCommand{name: "zpopmax", saveCommandProc: ZPopMax, arity: -1, funcKeys: writeFirstKey, write: true}
+	saveCommandMap["bzpopmin"] = saveDBCommand{name: "bzpopmin", saveCommandProc: BZPopMin, arity: -1, funcKeys: writeKeysExceptLast, write: true}
+	saveCommandMap["bzpopmax"] = saveDBCommand{name: "bzpopmax", saveCommandProc: BZPopMax, arity: -1, funcKeys: writeKeysExceptLast, write: true}
+	saveCommandMap["zrem"] = saveDBCommand{name: "zrem", saveCommandProc: ZRem, arity: -1, funcKeys: writeFirstKey, write: true}
+	saveCommandMap["zscan"] = saveDBCommand{name: "zscan", saveCommandProc: ZScan, arity: -1, funcKeys: readFirstKey}
+	saveCommandMap["zunion"] = saveDBCommand{name: "zunion", saveCommandProc: ZUnion, arity: -1, funcKeys: readNumKeys}
+	saveCommandMap["zinter"] = saveDBCommand{name: "zinter", saveCommandProc: ZInter, arity: -1, funcKeys: readNumKeys}
+	saveCommandMap["zdiff"] = saveDBCommand{name: "zdiff", saveCommandProc: ZDiff, arity: -1, funcKeys: readNumKeys}
+	saveCommandMap["zunionstore"] = saveDBCommand{name: "zunionstore", saveCommandProc: ZUnionStore, arity: -1, funcKeys: storeNumKeys, write: true}
+	saveCommandMap["zinterstore"] = saveDBCommand{name: "zinterstore", saveCommandProc: ZInterStore, arity: -1, funcKeys: storeNumKeys, write: true}
+	saveCommandMap["zdiffstore"] = saveDBCommand{name: "zdiffstore", saveCommandProc: ZDiffStore, arity: -1, funcKeys: storeNumKeys, write: true}
+	saveCommandMap["geoadd"] = saveDBCommand{name: "geoadd", saveCommandProc: GeoAdd, arity: -1, funcKeys: writeFirstKey, write: true}
+	saveCommandMap["geopos"] = saveDBCommand{name: "geopos", saveCommandProc: GeoPos, arity: -1, funcKeys: readFirstKey}
+	saveCommandMap["geodist"] = saveDBCommand{name: "geodist", saveCommandProc: GeoDist, arity: -1, funcKeys: readFirstKey}
+	saveCommandMap["geohash"] = saveDBCommand{name: "geohash", saveCommandProc: GeoHash, arity: -1, funcKeys: readFirstKey}
+	saveCommandMap["geosearch"] = saveDBCommand{name: "geosearch", saveCommandProc: GeoSearch, arity: -1, funcKeys: readFirstKey}
+	saveCommandMap["geosearchstore"] = saveDBCommand{name: "geosearchstore", saveCommandProc: GeoSearchStore, arity: -1, funcKeys: geoSearchStoreKeys, write: true}
+	saveCommandMap["xadd"] = saveDBCommand{name: "xadd", saveCommandProc: XAdd, arity: -1, funcKeys: writeFirstKey, write: true}
+	saveCommandMap["xlen"] = saveDBCommand{name: "xlen", saveCommandProc: XLen, arity: 1, funcKeys: readFirstKey}
+	saveCommandMap["xsetid"] = saveDBCommand{name: "xsetid", saveCommandProc: XSetID, arity: -1, funcKeys: writeFirstKey, write: true}
+	saveCommandMap["xrange"] = saveDBCommand{name: "xrange", saveCommandProc: XRange, arity: -1, funcKeys: readFirstKey}
+	saveCommandMap["xrevrange"] = saveDBCommand{name: "xrevrange", saveCommandProc: XRevRange, arity: -1, funcKeys: readFirstKey}
+	saveCommandMap["xread"] = saveDBCommand{name: "xread", saveCommandProc: XRead, arity: -1, funcKeys: xReadKeys}
+	saveCommandMap["xreadgroup"] = saveDBCommand{name: "xreadgroup", saveCommandProc: XReadGroup, arity: -1, funcKeys: xReadGroupKeys, write: true}
+	saveCommandMap["xgroup"] = saveDBCommand{name: "xgroup", saveCommandProc: XGroup, arity: -1, funcKeys: xGroupKeys, write: true}
+	saveCommandMap["xack"] = saveDBCommand{name: "xack", saveCommandProc: XAck, arity: -1, funcKeys: writeFirstKey, write: true}
+	saveCommandMap["xpending"] = saveDBCommand{name: "xpending", saveCommandProc: XPending, arity: -1, funcKeys: readFirstKey}
+	saveCommandMap["xclaim"] = saveDBCommand{name: "xclaim", saveCommandProc: XClaim, arity: -1, funcKeys: writeFirstKey, write: true}
+	saveCommandMap["xautoclaim"] = saveDBCommand{name: "xautoclaim", saveCommandProc: XAutoClaim, arity: -1, funcKeys: writeFirstKey, write: true}
+	saveCommandMap["zincrby"] = saveDBCommand{name: "zincrby", saveCommandProc: ZIncrBy, arity: 3, funcKeys: writeFirstKey, write: true}
 	saveCommandMap["zlexcount"] = saveDBCommand{name: "zlexcount", saveCommandProc: ZLexCount, arity: 3, funcKeys: readFirstKey}
 	saveCommandMap["zrangebylex"] = saveDBCommand{name: "zrangebylex", saveCommandProc: ZRangeByLex, arity: -1, funcKeys: readFirstKey}
-	saveCommandMap["zremrangebylex"] = saveDBCommand{name: "zremrangebylex", saveCommandProc: ZRemRangeByLex, arity: 3, funcKeys: readFirstKey}
+	saveCommandMap["zremrangebylex"] = saveDBCommand{name: "zremrangebylex", saveCommandProc: ZRemRangeByLex, arity: 3, funcKeys: writeFirstKey, write: true}
 
-	saveCommandMap["expire"] = saveDBCommand{name: "expire", saveCommandProc: Expire, arity: 2, funcKeys: writeFirstKey}
+	saveCommandMap["expire"] = saveDBCommand{name: "expire", saveCommandProc: Expire, arity: -1, funcKeys: writeFirstKey, write: true}
+	saveCommandMap["pexpire"] = saveDBCommand{name: "pexpire", saveCommandProc: PExpire, arity: -1, funcKeys: writeFirstKey, write: true}
+	saveCommandMap["expireat"] = saveDBCommand{name: "expireat", saveCommandProc: ExpireAt, arity: -1, funcKeys: writeFirstKey, write: true}
+	saveCommandMap["pexpireat"] = saveDBCommand{name: "pexpireat", saveCommandProc: PExpireAt, arity: -1, funcKeys: writeFirstKey, write: true}
+	saveCommandMap["persist"] = saveDBCommand{name: "persist", saveCommandProc: Persist, arity: 1, funcKeys: writeFirstKey, write: true}
 	saveCommandMap["ttl"] = saveDBCommand{name: "ttl", saveCommandProc: TTL, arity: 1, funcKeys: readFirstKey}
+	saveCommandMap["pttl"] = saveDBCommand{name: "pttl", saveCommandProc: PTTL, arity: 1, funcKeys: readFirstKey}
+	saveCommandMap["expiretime"] = saveDBCommand{name: "expiretime", saveCommandProc: ExpireTime, arity: 1, funcKeys: readFirstKey}
+	saveCommandMap["pexpiretime"] = saveDBCommand{name: "pexpiretime", saveCommandProc: PExpireTime, arity: 1, funcKeys: readFirstKey}
 
 }
 
@@ -88,10 +216,11 @@ var saveCommandMap map[string]saveDBCommand
 
 // 所有的命令 基本上和redis一样
 type saveDBCommand struct {
-	name            string                                       //参数名字
-	saveCommandProc func(db *SaveDBTables, args []string) Result //执行的函数
-	arity           int                                          //参数个数
-	funcKeys        KeysLockFunc                                 //获取命令中所有用于加锁的key
+	name            string                                      //参数名字
+	saveCommandProc func(db *SaveDBTables, args []string) Reply //执行的函数
+	arity           int                                         //参数个数
+	funcKeys        KeysLockFunc                                //获取命令中所有用于加锁的key
+	write           bool                                        //会修改数据, 只读的从节点拒绝执行
 }
 
 type KeysLockFunc func(args []string) ([]string, []string)
@@ -100,21 +229,33 @@ type KeysLockFunc func(args []string) ([]string, []string)
 type SaveDBTables struct {
 	index   int
 	Data    *data.ConcurrentDict
-	Expires map[string]time.Time //带有过期的key统一管理
+	Expires *data.ConcurrentDict //带有过期的key统一管理 value是time.Time
 	AllKeys                      //缓存淘汰
 	addAof  func(CmdLine)
+	//事务中的命令作为一个整体写入aof
+	addTxAof func([]CmdLine)
+	//每次写key时版本号加1, WATCH通过比较版本号判断key是否被修改过
+	versions *data.ConcurrentDict
+	//flushdb时加1, 使所有WATCH的key失效
+	epoch *atomic.Uint32
+	//同一个server的所有db, MOVE和COPY DB时查找目标db
+	dbs []*atomic.Value
 }
 
 func (db *SaveDBTables) ForEach(i int, cb func(key string, data any, expiration *time.Time) bool) {
 	db.Data.ForEach(func(key string, raw interface{}) bool {
-		var expiration *time.Time
-		rawExpireTime, ok := db.Expires[key]
-		if ok {
-			expiration = &rawExpireTime
-		}
-		return cb(key, raw, expiration)
+		return cb(key, raw, db.getExpiration(key))
 	})
 }
+
+func (db *SaveDBTables) getExpiration(key string) *time.Time {
+	raw, ok := db.Expires.Get(key)
+	if !ok {
+		return nil
+	}
+	expiration := raw.(time.Time)
+	return &expiration
+}
 func (db *SaveDBTables) PutEntity(key string, entity any) int {
 	ret := db.Data.PutWithLock(key, entity)
 	//todo callbacks
@@ -132,19 +273,22 @@ type SaveObject struct {
 func init() {
 	Server.Dbs = make([]*atomic.Value, dbsSize)
 	for i := 0; i < dbsSize; i++ {
-		db := makeDB(i)
+		db := makeDB(i, Server.Dbs)
 		holder := &atomic.Value{}
 		holder.Store(db)
 		Server.Dbs[i] = holder
 	}
 }
-func makeDB(index int) *SaveDBTables {
-	db := &SaveDBTables{}
-	db.Data = data.MakeConcurrent(dataDictSize)
-	db.Expires = make(map[string]time.Time)
+func makeDB(index int, dbs []*atomic.Value) *SaveDBTables {
+	db := &SaveDBTables{dbs: dbs}
+	db.Data = newDataDict()
+	db.Expires = data.MakeConcurrent(smallDictSize)
 	db.AllKeys = NewLKeys()
 	db.index = index
 	db.addAof = func(line CmdLine) {}
+	db.addTxAof = func(lines []CmdLine) {}
+	db.versions = data.MakeConcurrent(smallDictSize)
+	db.epoch = &atomic.Uint32{}
 	return db
 }
 
@@ -157,7 +301,7 @@ func NewSaveObject(key *string, keyType byte) *SaveObject {
 	return o
 }
 
-func BGSaveRDB() Result {
+func BGSaveRDB() Reply {
 	go func() {
 		defer func() {
 			if err := recover(); err != nil {
@@ -171,7 +315,7 @@ func BGSaveRDB() Result {
 			log.SaveDBLogger.Errorf("bgsave error %v", err)
 		}
 	}()
-	return CreateStrResult(COk, "Background saving started.")
+	return MakeStatusReply("Background saving started.")
 }
 
 // Redis中触发重写的操作
@@ -179,20 +323,42 @@ func BGSaveRDB() Result {
 // 2.手动打开 AOF 开关（config set appendonly yes） todo
 // 3.从库加载完主库 RDB 后（AOF 被启动的前提下） todo
 // 4.定时触发：AOF 文件大小比例超出阈值、AOF 文件大小绝对值超出阈值（AOF 被启动的前提下）todo
-func BGReWriteAof() Result {
+func BGReWriteAof() Reply {
 	go func() {
 		err := Server.persister.Rewrite()
 		if err != nil {
 			log.SaveDBLogger.Errorf("bgrewriteaof error %v", err)
 		}
 	}()
-	return CreateStrResult(COk, "Background bgrewriteaof started.")
+	return MakeStatusReply("Background bgrewriteaof started.")
+}
+
+func Ping(db *SaveDBTables, args []string) Reply {
+	if len(args) > 1 {
+		return errReply("ERR wrong number of arguments for 'ping' command")
+	}
+	if len(args) == 1 {
+		return MakeBulkReply([]byte(args[0]))
+	}
+	return MakeStatusReply(PongStr)
+}
+
+func Echo(db *SaveDBTables, args []string) Reply {
+	return MakeBulkReply([]byte(args[0]))
 }
 func (db *SaveDBTables) Locks(readKeys, writeKeys []string) {
 	if readKeys == nil && writeKeys == nil {
 		return
 	}
+	//writeKeys不为nil就是写命令, 快照开始时要等待正在执行的写命令结束
+	if writeKeys != nil {
+		snapshotBarrier.RLock()
+	}
 	db.Data.RWLocks(writeKeys, readKeys)
+	if len(writeKeys) > 0 {
+		//快照期间修改之前先保存旧值
+		db.preserve(writeKeys)
+	}
 }
 
 func (db *SaveDBTables) UnLocks(readKeys, writeKeys []string) {
@@ -200,21 +366,40 @@ func (db *SaveDBTables) UnLocks(readKeys, writeKeys []string) {
 		return
 	}
 	db.Data.RWUnLocks(writeKeys, readKeys)
+	if writeKeys != nil {
+		snapshotBarrier.RUnlock()
+	}
 }
 func (s *SaveServer) Exec(c *Connection, msg *Message) {
 	if Config.Maxmemory > 0 && s.persister != nil {
 		status := s.persister.freeMemoryIfNeededAndSafe()
 		if status != COk {
-			CreateSpecialCMD(c, CreateStrResult(CErr, "OutOfMemoryError"), nil)
+			ReturnErr("OutOfMemoryError", c)
 			return
 		}
 	}
 	cmd := *msg.Command
-	var wm *Message
+	if s.execPubSubCommand(c, msg) {
+		return
+	}
+	if ReplSlave.rejectWrite(c, cmd) {
+		return
+	}
+	if s.clusterRedirect(c, cmd, msg.Args) {
+		return
+	}
+	if s.execMultiCommand(c, msg) {
+		BlockedClients.handleReady(s)
+		return
+	}
 	switch cmd {
 	case "select":
 		index, _ := strconv.Atoi(msg.Args[0])
-		CreateSpecialCMD(c, CreateStrResult(COk, OkStr), SelectDB(index, c))
+		if Cluster != nil && index != 0 {
+			ReturnErr("ERR SELECT is not allowed in cluster mode", c)
+			return
+		}
+		CreateSpecialCMD(c, MakeOkReply(), SelectDB(index, c))
 		return
 	case "bgsave":
 		CreateSpecialCMD(c, BGSaveRDB(), nil)
@@ -225,14 +410,46 @@ func (s *SaveServer) Exec(c *Connection, msg *Message) {
 	case "flushall":
 		CreateSpecialCMD(c, FlushAll(), nil)
 		return
+	case "hello":
+		if c.protocol == ProtocolLegacy {
+			ReturnErr("ERR HELLO is only supported on resp listeners", c)
+			return
+		}
+		c.writeReply(Hello(c, msg.Args))
+		return
+	case "replicaof", "slaveof":
+		c.writeReply(ReplSlave.ReplicaOf(msg.Args))
+		return
+	case "psync":
+		ReplMaster.PSync(c, msg.Args)
+		return
+	case "replconf":
+		if reply := ReplMaster.ReplConf(c, msg.Args); reply != nil {
+			c.writeReply(reply)
+		}
+		return
+	case "info":
+		c.writeReply(Info(msg.Args))
+		return
+	case "sentinel":
+		c.writeReply(SentinelCommand(msg.Args))
+		return
+	case "cluster":
+		c.writeReply(ClusterCommand(c, msg.Args))
+		return
+	case "asking":
+		if Cluster == nil {
+			ReturnErr("ERR This instance has cluster support disabled", c)
+			return
+		}
+		c.asking = true
+		c.writeReply(MakeOkReply())
+		return
 	}
 	commandFunc, ok := saveCommandMap[cmd]
 	if !ok {
 		log.SaveDBLogger.Errorf("command [%s] error ", cmd)
-		wm = createWriterMsg(CreateStrResult(CErr, "command error"))
-		if c.Writer != nil {
-			c.Writer <- wm
-		}
+		ReturnErr("command error", c)
 		return
 	}
 	var readKeys, writeKeys []string
@@ -240,11 +457,48 @@ func (s *SaveServer) Exec(c *Connection, msg *Message) {
 		readKeys, writeKeys = commandFunc.funcKeys(msg.Args)
 	}
 	db := s.FindDB(c.dbIndex)
+	if isCrossDBCommand(cmd, msg.Args) {
+		crossDBMu.Lock()
+		defer crossDBMu.Unlock()
+	}
 	db.Locks(readKeys, writeKeys)
-	wMsg := createWriterMsg(commandFunc.saveCommandProc(db, msg.Args))
-	//写回
-	if c.Writer != nil {
-		c.Writer <- wMsg
+	res := commandFunc.saveCommandProc(db, msg.Args)
+	db.addVersion(writeKeys...)
+	var blocked *blockedClient
+	if _, ok := res.(*blockReply); ok {
+		blocked = s.blockClient(c, commandFunc, msg.Args, readKeys, writeKeys)
 	}
 	db.UnLocks(readKeys, writeKeys)
+	BlockedClients.handleReady(s)
+	if blocked != nil {
+		s.waitBlocked(c, blocked, res)
+		return
+	}
+	//写回
+	c.writeReply(res)
+}
+
+// 被WATCH的key的版本号和WATCH它的连接数, version在key的写锁中修改, watchers在watchMu中修改
+type watchedKey struct {
+	version  uint32
+	watchers int
+}
+
+// 只记录被WATCH过的key的版本号, 避免每个写过的key都占一份内存
+func (db *SaveDBTables) addVersion(keys ...string) {
+	for _, key := range keys {
+		v, ok := db.versions.Get(key)
+		if !ok {
+			continue
+		}
+		v.(*watchedKey).version++
+	}
+}
+
+func (db *SaveDBTables) GetVersion(key string) uint64 {
+	var version uint32
+	if v, ok := db.versions.Get(key); ok {
+		version = v.(*watchedKey).version
+	}
+	return uint64(db.epoch.Load())<<32 | uint64(version)
 }
@@ -0,0 +1,87 @@
+package src
+
+// glob风格的匹配, 和redis的stringmatchlen一致, KEYS SCAN MATCH PSUBSCRIBE等命令共用
+// 1.* 匹配任意长度的字符串, ? 匹配一个字节
+// 2.[abc] 匹配其中一个字节, [^abc] 匹配不在其中的字节, [a-z] 匹配范围内的字节, 范围的两端写反时会交换
+// 3.\ 转义下一个字节, 在[]中也一样; 末尾单独的\按普通字符处理
+// 4.没有闭合的[ 按普通字符匹配
+// 5.按字节比较, 遇到*时只记录最后一个*的位置回溯, 不会因为多个*出现指数级的回溯
+
+func globMatch(pattern, s string) bool {
+	p, i := 0, 0
+	//最后一个*的位置和它当前匹配到的位置
+	starP, starI := -1, 0
+	for i < len(s) {
+		if p < len(pattern) {
+			if pattern[p] == '*' {
+				starP, starI = p, i
+				p++
+				continue
+			}
+			if next, ok := globMatchOne(pattern, p, s[i]); ok {
+				p = next
+				i++
+				continue
+			}
+		}
+		if starP < 0 {
+			return false
+		}
+		//让最后一个*多匹配一个字节
+		starI++
+		i = starI
+		p = starP + 1
+	}
+	for p < len(pattern) && pattern[p] == '*' {
+		p++
+	}
+	return p == len(pattern)
+}
+
+// 匹配pattern[p]开始的一个非*的元素, 返回下一个元素的位置
+func globMatchOne(pattern string, p int, c byte) (int, bool) {
+	switch pattern[p] {
+	case '?':
+		return p + 1, true
+	case '[':
+		if next, match, closed := globMatchClass(pattern, p+1, c); closed {
+			return next, match
+		}
+	case '\\':
+		if p+1 < len(pattern) {
+			p++
+		}
+	}
+	return p + 1, pattern[p] == c
+}
+
+// [之后的集合, 返回]之后的位置; 没有]时closed为false
+func globMatchClass(pattern string, p int, c byte) (next int, match bool, closed bool) {
+	not := p < len(pattern) && pattern[p] == '^'
+	if not {
+		p++
+	}
+	for ; p < len(pattern); p++ {
+		switch {
+		case pattern[p] == '\\' && p+1 < len(pattern):
+			p++
+			if pattern[p] == c {
+				match = true
+			}
+		case pattern[p] == ']':
+			return p + 1, match != not, true
+		case p+2 < len(pattern) && pattern[p+1] == '-':
+			start, end := pattern[p], pattern[p+2]
+			if start > end {
+				start, end = end, start
+			}
+			if c >= start && c <= end {
+				match = true
+			}
+			p += 2
+		case pattern[p] == c:
+			match = true
+		}
+	}
+	return p, false, false
+}
@@ -0,0 +1,60 @@
+package src
+
+import "testing"
+
+func TestGlobMatch(t *testing.T) {
+	cases := []struct {
+		pattern string
+		s       string
+		match   bool
+	}{
+		{"*", "", true},
+		{"*", "anything", true},
+		{"user:*", "user:1", true},
+		{"user:*", "olduser:1", false},
+		{"h?llo", "hello", true},
+		{"h?llo", "hllo", false},
+		{"h*llo", "hllo", true},
+		{"h[ae]llo", "hallo", true},
+		{"h[ae]llo", "hillo", false},
+		{"h[^e]llo", "hallo", true},
+		{"h[^e]llo", "hello", false},
+		{"h[a-b]llo", "hbllo", true},
+		{"h[b-a]llo", "hbllo", true},
+		{"h[a-b]llo", "hcllo", false},
+		{"a.b", "a.b", true},
+		{"a.b", "axb", false},
+		{"(x)+", "(x)+", true},
+		{`a\*b`, "a*b", true},
+		{`a\*b`, "axb", false},
+		{`[\]]`, "]", true},
+		{`a\`, `a\`, true},
+		{"[abc", "b", false},
+		{"[abc", "[abc", true},
+		{"a[", "a[", true},
+		{"", "", true},
+		{"", "a", false},
+		{"*a*a*a*a*a*a*a*a*b", "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa", false},
+		{"a*b*c", "axxbyyc", true},
+		{"a*b*c", "axxbyyd", false},
+	}
+	for _, tc := range cases {
+		if r := globMatch(tc.pattern, tc.s); r != tc.match {
+			t.Errorf("globMatch(%q, %q) expect %v, actual %v", tc.pattern, tc.s, tc.match, r)
+		}
+	}
+}
+
+func TestKeysGlob(t *testing.T) {
+	initTestLog(t)
+	s, c := makeMultiTestServer()
+	execTestCmd(s, c, "set", "user:1", "a")
+	execTestCmd(s, c, "set", "olduser:1", "b")
+	execTestCmd(s, c, "set", "user.x", "c")
+	if r := execTestCmd(s, c, "keys", "user:*"); r != "*1\r\n$6\r\nuser:1\r\n" {
+		t.Errorf("expect only user:1, actual %q", r)
+	}
+	if r := execTestCmd(s, c, "keys", "user.?"); r != "*1\r\n$6\r\nuser.x\r\n" {
+		t.Errorf("expect only user.x, actual %q", r)
+	}
+}
@@ -0,0 +1,54 @@
+package src
+
+import (
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var startTime = time.Now()
+
+// Info INFO [section], 目前支持server、replication和sentinel
+func Info(args []string) Reply {
+	if len(args) > 1 {
+		return MakeErrReply("ERR syntax error")
+	}
+	section := "all"
+	if len(args) == 1 {
+		section = strings.ToLower(args[0])
+	}
+	var sb strings.Builder
+	if section == "all" || section == "default" || section == "server" {
+		sb.WriteString("# Server" + CRLF)
+		sb.WriteString("go_version:" + runtime.Version() + CRLF)
+		sb.WriteString("process_id:" + strconv.Itoa(os.Getpid()) + CRLF)
+		port := Config.Port
+		if Sentinel != nil {
+			port = SConfig.Port
+		}
+		sb.WriteString("tcp_port:" + strconv.Itoa(port) + CRLF)
+		sb.WriteString("uptime_in_seconds:" + strconv.FormatInt(int64(time.Since(startTime).Seconds()), 10) + CRLF)
+		sb.WriteString(CRLF)
+	}
+	//哨兵没有数据, 只输出监控的主节点
+	if Sentinel != nil {
+		if section == "all" || section == "default" || section == "sentinel" {
+			sb.WriteString("# Sentinel" + CRLF)
+			Sentinel.info(&sb)
+		}
+		return MakeBulkReply([]byte(sb.String()))
+	}
+	if section == "all" || section == "default" || section == "replication" {
+		sb.WriteString("# Replication" + CRLF)
+		if ReplSlave.isReplica() {
+			sb.WriteString("role:slave" + CRLF)
+			ReplSlave.info(&sb)
+		} else {
+			sb.WriteString("role:master" + CRLF)
+			ReplMaster.info(&sb)
+		}
+	}
+	return MakeBulkReply([]byte(sb.String()))
+}
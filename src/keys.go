@@ -3,7 +3,7 @@ package src
 import (
 	"bytes"
 	"github.com/tidwall/btree"
-	"regexp"
+	"math"
 	"savedb/src/timewheel"
 	"strconv"
 	"strings"
@@ -26,101 +26,235 @@ func NewLKeys() AllKeys {
 	}
 	return keys
 }
-func Expire(db *SaveDBTables, args []string) Result {
+
+// EXPIRE key seconds [NX|XX|GT|LT]
+func Expire(db *SaveDBTables, args []string) Reply {
+	return expireGeneric(db, args, "expire", time.Second, false)
+}
+
+// PEXPIRE key milliseconds [NX|XX|GT|LT]
+func PExpire(db *SaveDBTables, args []string) Reply {
+	return expireGeneric(db, args, "pexpire", time.Millisecond, false)
+}
+
+// EXPIREAT key unix-time-seconds [NX|XX|GT|LT]
+func ExpireAt(db *SaveDBTables, args []string) Reply {
+	return expireGeneric(db, args, "expireat", time.Second, true)
+}
+
+// PEXPIREAT key unix-time-milliseconds [NX|XX|GT|LT]
+func PExpireAt(db *SaveDBTables, args []string) Reply {
+	return expireGeneric(db, args, "pexpireat", time.Millisecond, true)
+}
+
+// 过期时间统一换算成毫秒时间戳, aof中总是记录pexpireat, 重放时不受重启时间的影响
+func expireGeneric(db *SaveDBTables, args []string, cmd string, unit time.Duration, absolute bool) Reply {
+	if len(args) < 2 {
+		return errReply("ERR wrong number of arguments for '" + cmd + "' command")
+	}
 	key := args[0]
-	expire, err := strconv.ParseInt(args[1], 10, 64)
+	n, err := strconv.ParseInt(args[1], 10, 64)
 	if err != nil {
-		return CreateStrResult(CErr, "args error, cant transfer int")
+		return errReply("ERR value is not an integer or out of range")
+	}
+	var nx, xx, gt, lt bool
+	for _, option := range args[2:] {
+		switch strings.ToLower(option) {
+		case "nx":
+			nx = true
+		case "xx":
+			xx = true
+		case "gt":
+			gt = true
+		case "lt":
+			lt = true
+		default:
+			return errReply("ERR Unsupported option " + option)
+		}
+	}
+	if nx && (xx || gt || lt) {
+		return errReply("ERR NX and XX, GT or LT options at the same time are not compatible")
 	}
-	if !db.AllKeys.Exist(key) {
-		return CreateStrResult(CErr, "key not exist")
+	if gt && lt {
+		return errReply("ERR GT and LT options at the same time are not compatible")
 	}
-	nowTime := time.Now().UnixMilli()
-	if nowTime > expire {
-		return Result{}
+	unitMs := int64(unit / time.Millisecond)
+	if n > math.MaxInt64/unitMs || n < math.MinInt64/unitMs {
+		return errReply("ERR invalid expire time in '" + cmd + "' command")
 	}
-	ttl := time.Unix(expire/1000, 0)
-	PutExpire(db, key, ttl)
-	db.addAof(MakeExpireCmd(key, ttl).Args)
-	return CreateStrResult(COk, OkStr)
+	ms := n * unitMs
+	if !absolute {
+		now := time.Now().UnixMilli()
+		if ms > math.MaxInt64-now {
+			return errReply("ERR invalid expire time in '" + cmd + "' command")
+		}
+		ms += now
+	}
+	if _, ok := db.Data.GetWithLock(key); !ok {
+		return MakeIntReply(0)
+	}
+	expireAt := time.UnixMilli(ms)
+	//没有过期时间的key相当于过期时间无穷大
+	current := db.getExpiration(key)
+	if (nx && current != nil) || (xx && current == nil) ||
+		(gt && (current == nil || !expireAt.After(*current))) ||
+		(lt && current != nil && !expireAt.Before(*current)) {
+		return MakeIntReply(0)
+	}
+	if !expireAt.After(time.Now()) {
+		//已经过期的时间直接删除key
+		db.removeKey(key)
+		db.addAof(ToCmdLine("del", key))
+		return MakeIntReply(1)
+	}
+	PutExpire(db, key, expireAt)
+	db.addAof(MakeExpireCmd(key, expireAt).Args)
+	return MakeIntReply(1)
+}
+
+// 时间轮中任务的key, 不同db中同名的key互不影响
+func expireTaskKey(db *SaveDBTables, key string) string {
+	return strconv.Itoa(db.index) + ":" + key
 }
+
 func PutExpire(db *SaveDBTables, key string, time time.Time) {
-	db.Expires[key] = time
-	args := make([]string, 1)
-	args = append(args, key)
-	timewheel.AddTimer(time, key, func() {
-		keys := make([]string, 0)
-		keys = append(keys, key)
-		db.Locks(nil, keys)
+	db.Expires.Put(key, time)
+	timewheel.AddTimer(time, expireTaskKey(db, key), func() {
+		//事务中设置的过期时间, db是带aof收集器的副本, 删除时要用当前的db
+		live := db.current()
+		keys := []string{key}
+		live.Locks(nil, keys)
 		defer func() {
-			db.UnLocks(nil, keys)
+			live.UnLocks(nil, keys)
 		}()
-		Del(db, args)
+		Del(live, keys)
+		live.addVersion(key)
 	})
 }
-func TTL(db *SaveDBTables, args []string) Result {
+
+// 删除key的过期时间和时间轮中的任务
+func removeExpire(db *SaveDBTables, key string) {
+	if _, ok := db.Expires.Get(key); !ok {
+		return
+	}
+	db.Expires.Remove(key)
+	timewheel.Cancel(expireTaskKey(db, key))
+}
+
+// PERSIST key, 返回是否删除了过期时间
+func Persist(db *SaveDBTables, args []string) Reply {
 	key := args[0]
-	value, ok := db.Expires[key]
-	if !ok {
-		return CreateStrResult(COk, "-2")
+	if _, ok := db.Data.GetWithLock(key); !ok || db.getExpiration(key) == nil {
+		return MakeIntReply(0)
+	}
+	removeExpire(db, key)
+	db.addAof(ToCmdLine("persist", key))
+	return MakeIntReply(1)
+}
+
+// 不存在的key返回-2, 没有过期时间的key返回-1
+func ttlGeneric(db *SaveDBTables, key string, unit time.Duration, absolute bool) Reply {
+	if _, ok := db.Data.GetWithLock(key); !ok {
+		return MakeIntReply(-2)
+	}
+	expiration := db.getExpiration(key)
+	if expiration == nil {
+		return MakeIntReply(-1)
+	}
+	unitMs := int64(unit / time.Millisecond)
+	if absolute {
+		return MakeIntReply(expiration.UnixMilli() / unitMs)
+	}
+	remaining := expiration.UnixMilli() - time.Now().UnixMilli()
+	if remaining < 0 {
+		remaining = 0
 	}
-	nowTime := time.Now().Unix()
-	ttl := value.Unix() - nowTime
-	return CreateStrResult(COk, strconv.Itoa(int(ttl)))
+	//和redis一样四舍五入
+	return MakeIntReply((remaining + unitMs/2) / unitMs)
+}
+
+func TTL(db *SaveDBTables, args []string) Reply {
+	return ttlGeneric(db, args[0], time.Second, false)
+}
+
+func PTTL(db *SaveDBTables, args []string) Reply {
+	return ttlGeneric(db, args[0], time.Millisecond, false)
+}
+
+// EXPIRETIME key, 过期的unix时间戳(秒)
+func ExpireTime(db *SaveDBTables, args []string) Reply {
+	return ttlGeneric(db, args[0], time.Second, true)
 }
 
-func Del(db *SaveDBTables, args []string) Result {
-	var deleted int
+func PExpireTime(db *SaveDBTables, args []string) Reply {
+	return ttlGeneric(db, args[0], time.Millisecond, true)
+}
+
+// DEL key [key ...], 返回删除的key的个数
+func Del(db *SaveDBTables, args []string) Reply {
+	deleted := make([]string, 0, len(args))
 	for _, k := range args {
-		if !db.AllKeys.Exist(k) {
-			continue
+		if db.removeKey(k) {
+			deleted = append(deleted, k)
 		}
-		db.Data.RemoveWithLock(k)
-		db.AllKeys.RemoveKey(db, k)
-		deleted++
 	}
-	if deleted > 0 {
-		db.addAof(ToCmdLine2("del", args...))
+	if len(deleted) > 0 {
+		db.addAof(ToCmdLine2("del", deleted...))
+	}
+	return MakeIntReply(int64(len(deleted)))
+}
+
+// key的数据类型对应的名字, 和TYPE命令的返回值一样
+func typeName(dataType byte) string {
+	switch dataType {
+	case TypeStr:
+		return "string"
+	case TypeHash:
+		return "hash"
+	case TypeSet:
+		return "set"
+	case TypeZSet:
+		return "zset"
+	case TypeList:
+		return "list"
+	case TypeStream:
+		return "stream"
 	}
-	return CreateStrResult(COk, OkStr)
+	return "none"
 }
 
-func Keys(db *SaveDBTables, args []string) Result {
-	pattern := strings.ReplaceAll(args[0], "*", ".*")
+func Keys(db *SaveDBTables, args []string) Reply {
+	pattern := args[0]
 	var matchingKeys []string
-	re := regexp.MustCompile(pattern)
 	iter := db.AllKeys.keys.Iter()
 	for ok := iter.First(); ok; ok = iter.Next() {
 		key := string(iter.Item().key)
-		if re.MatchString(key) {
+		if globMatch(pattern, key) {
 			matchingKeys = append(matchingKeys, key)
 		}
 	}
 	iter.Release()
-	res := strings.Join(matchingKeys, ",")
-	return CreateStrResult(COk, res)
+	return MakeStringsReply(matchingKeys)
 }
 
-func Exists(db *SaveDBTables, args []string) Result {
-	_, ok := db.Data.GetWithLock(args[0])
-	if ok {
-		return CreateStrResult(COk, "1")
-	} else {
-		return CreateStrResult(COk, "0")
+// EXISTS key [key ...], 重复的key重复计数
+func Exists(db *SaveDBTables, args []string) Reply {
+	count := 0
+	for _, key := range args {
+		if _, ok := db.Data.GetWithLock(key); ok {
+			count++
+		}
 	}
+	return MakeIntReply(int64(count))
 }
 
 func findMatchingKeys(inputMap map[string]string, pattern string) []string {
-	pattern = strings.ReplaceAll(pattern, "*", ".*")
 	var matchingKeys []string
-	re := regexp.MustCompile(pattern)
-
 	for key := range inputMap {
-		if re.MatchString(key) {
+		if globMatch(pattern, key) {
 			matchingKeys = append(matchingKeys, key)
 		}
 	}
-
 	return matchingKeys
 }
 func (a *AllKeys) PutKey(key string, keyType byte) {
@@ -136,7 +270,7 @@ func (a *AllKeys) RemoveKey(db *SaveDBTables, key string) {
 		key: StringToBytes(key),
 	}
 	a.keys.Delete(ki)
-	delete(db.Expires, key)
+	db.Expires.Remove(key)
 }
 
 // key缓存命中
@@ -170,25 +304,25 @@ func (a *AllKeys) GetKey(key string) *SaveObject {
 	return value.saveObj
 }
 
-func FlushAll() Result {
+func FlushAll() Reply {
+	snapshotBarrier.RLock()
+	defer snapshotBarrier.RUnlock()
 	for _, db := range Server.Dbs {
-		dataBase := db.Load().(SaveDBTables)
-		dataBase.Data.Clear()
-		dataBase.keys.Clear()
-		for key := range dataBase.Expires {
-			timewheel.Cancel(key)
-		}
-		dataBase.Expires = make(map[string]time.Time)
-
+		dataBase := db.Load().(*SaveDBTables)
+		FlushDB(dataBase, nil)
 	}
-	return CreateStrResult(COk, OkStr)
+	return MakeOkReply()
 }
-func FlushDB(db *SaveDBTables, args []string) Result {
+func FlushDB(db *SaveDBTables, args []string) Reply {
+	db.preserveAll()
 	db.Data.Clear()
 	db.keys.Clear()
-	for key := range db.Expires {
-		timewheel.Cancel(key)
-	}
-	db.Expires = make(map[string]time.Time)
-	return CreateStrResult(COk, OkStr)
+	db.Expires.ForEach(func(key string, _ interface{}) bool {
+		timewheel.Cancel(expireTaskKey(db, key))
+		return true
+	})
+	db.Expires.Clear()
+	db.epoch.Add(1)
+	db.addAof(ToCmdLine("flushdb"))
+	return MakeOkReply()
 }
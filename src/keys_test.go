@@ -3,6 +3,7 @@ package src
 import (
 	"fmt"
 	"strconv"
+	"strings"
 	"testing"
 	"time"
 )
@@ -24,7 +25,7 @@ func TestPattern(t *testing.T) {
 	}
 
 	// 定义匹配规则，*表示任意字符串
-	pattern := ".*eld.*"
+	pattern := "*ele*"
 
 	// 在map中查找满足匹配规则的key
 	matchingKeys := findMatchingKeys(myMap, pattern)
@@ -42,3 +43,54 @@ func Test111(t *testing.T) {
 		fmt.Println("defer")
 	}()
 }
+
+func TestExpire(t *testing.T) {
+	s, c := makeMultiTestServer()
+	var aofLines []CmdLine
+	s.FindDB(0).addAof = func(line CmdLine) {
+		aofLines = append(aofLines, line)
+	}
+	execTestCmd(s, c, "set", "a", "1")
+	future := strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10)
+	tests := [][]string{
+		{":-2\r\n", "ttl", "b"},
+		{":-1\r\n", "ttl", "a"},
+		{":0\r\n", "expire", "b", "100"},
+		{":0\r\n", "expire", "a", "100", "xx"},
+		{":0\r\n", "expire", "a", "100", "gt"},
+		{":1\r\n", "expire", "a", "100", "nx"},
+		{":100\r\n", "ttl", "a"},
+		{":0\r\n", "expire", "a", "200", "nx"},
+		{":0\r\n", "expire", "a", "50", "gt"},
+		{":1\r\n", "pexpire", "a", "200000", "gt"},
+		{":200\r\n", "ttl", "a"},
+		{":1\r\n", "expire", "a", "10", "lt", "xx"},
+		{":10000\r\n", "pttl", "a"},
+		{":1\r\n", "expireat", "a", future},
+		{":" + future + "\r\n", "expiretime", "a"},
+		{":" + future + "000\r\n", "pexpiretime", "a"},
+		{":1\r\n", "persist", "a"},
+		{":0\r\n", "persist", "a"},
+		{":-1\r\n", "expiretime", "a"},
+		{"-ERR NX and XX, GT or LT options at the same time are not compatible\r\n", "expire", "a", "1", "nx", "gt"},
+		{"-ERR GT and LT options at the same time are not compatible\r\n", "expire", "a", "1", "gt", "lt"},
+		{"-ERR Unsupported option foo\r\n", "expire", "a", "1", "foo"},
+		{"-ERR invalid expire time in 'expire' command\r\n", "expire", "a", "9223372036854775807"},
+		{":1\r\n", "pexpireat", "a", "1000"},
+		{":-2\r\n", "ttl", "a"},
+	}
+	for _, test := range tests {
+		if r := execTestCmd(s, c, test[1:]...); r != test[0] {
+			t.Errorf("%v expect %q, actual %q", test[1:], test[0], r)
+		}
+	}
+	//aof中只有绝对的毫秒时间戳
+	commands := make([]string, 0)
+	for _, line := range aofLines {
+		commands = append(commands, string(line[0]))
+	}
+	expect := "set pexpireat pexpireat pexpireat pexpireat persist del"
+	if strings.Join(commands, " ") != expect {
+		t.Errorf("aof commands expect %s, actual %v", expect, commands)
+	}
+}
@@ -0,0 +1,225 @@
+package src
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 通用的key命令 TYPE RENAME RENAMENX COPY MOVE RANDOMKEY DBSIZE TOUCH
+// 1.RENAME和MOVE把对象连同过期时间一起移走, 原来key在时间轮中的任务会被取消, 新key重新加一个任务
+// 2.MOVE和带DB参数的COPY要同时锁两个db中的key, 两个客户端方向相反地MOVE会死锁,
+//   所以这类命令在锁key之前先拿crossDBMu, 同一时间只有一个跨db的命令在执行
+// 3.目标db的key由命令自己加锁, 快照屏障已经在锁源db的key时拿到了
+
+var crossDBMu sync.Mutex
+
+// MOVE和带DB参数的COPY需要锁另一个db中的key
+func isCrossDBCommand(cmd string, args []string) bool {
+	switch cmd {
+	case "move":
+		return true
+	case "copy":
+		for i := 2; i < len(args); i++ {
+			if strings.ToLower(args[i]) == "db" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// 按编号查找同一个server中的另一个db
+func (db *SaveDBTables) otherDB(arg string) (*SaveDBTables, string) {
+	index, err := strconv.Atoi(arg)
+	if err != nil {
+		return nil, "ERR value is not an integer or out of range"
+	}
+	if index < 0 || index >= len(db.dbs) {
+		return nil, "ERR DB index is out of range"
+	}
+	if Cluster != nil && index != 0 {
+		return nil, "ERR SELECT is not allowed in cluster mode"
+	}
+	return db.dbs[index].Load().(*SaveDBTables), ""
+}
+
+// 删除key和它的过期时间, 调用者持有key的写锁
+func (db *SaveDBTables) removeKey(key string) bool {
+	if _, result := db.Data.RemoveWithLock(key); result == 0 {
+		return false
+	}
+	removeExpire(db, key)
+	db.AllKeys.RemoveKey(db, key)
+	return true
+}
+
+// 把对象放到key下, 有过期时间时重新加入时间轮, hash中field的过期时间也一样
+func (db *SaveDBTables) putKey(key string, entity any, dataType byte, expiration *time.Time) {
+	db.Data.PutWithLock(key, entity)
+	db.AllKeys.PutKey(key, dataType)
+	if expiration != nil {
+		PutExpire(db, key, *expiration)
+	}
+	if hash, ok := entity.(*Hash); ok {
+		db.restoreFieldExpires(key, hash)
+	}
+	db.signalKey(key)
+}
+
+func Type(db *SaveDBTables, args []string) Reply {
+	obj := db.AllKeys.GetKey(args[0])
+	if obj == nil {
+		return MakeStatusReply("none")
+	}
+	return MakeStatusReply(typeName(obj.dataType))
+}
+
+// 把src移到dst, dst存在时先删除
+func renameKey(db *SaveDBTables, src, dst string) {
+	entity, _ := db.Data.GetWithLock(src)
+	dataType := db.AllKeys.GetKey(src).dataType
+	expiration := db.getExpiration(src)
+	db.removeKey(src)
+	db.removeKey(dst)
+	db.putKey(dst, entity, dataType, expiration)
+}
+
+// RENAME key newkey
+func Rename(db *SaveDBTables, args []string) Reply {
+	src, dst := args[0], args[1]
+	if !db.AllKeys.Exist(src) {
+		return errReply("ERR no such key")
+	}
+	if src != dst {
+		renameKey(db, src, dst)
+	}
+	db.addAof(ToCmdLine2("rename", src, dst))
+	return MakeOkReply()
+}
+
+// RENAMENX key newkey, newkey存在时不修改
+func RenameNX(db *SaveDBTables, args []string) Reply {
+	src, dst := args[0], args[1]
+	if !db.AllKeys.Exist(src) {
+		return errReply("ERR no such key")
+	}
+	if db.AllKeys.Exist(dst) {
+		return MakeIntReply(0)
+	}
+	renameKey(db, src, dst)
+	db.addAof(ToCmdLine2("rename", src, dst))
+	return MakeIntReply(1)
+}
+
+// COPY source destination [DB destination-db] [REPLACE]
+func Copy(db *SaveDBTables, args []string) Reply {
+	if len(args) < 2 {
+		return errReply("ERR wrong number of arguments for 'copy' command")
+	}
+	src, dst := args[0], args[1]
+	dest := db
+	replace := false
+	for i := 2; i < len(args); i++ {
+		switch strings.ToLower(args[i]) {
+		case "replace":
+			replace = true
+		case "db":
+			if i+1 >= len(args) {
+				return errReply("ERR syntax error")
+			}
+			other, msg := db.otherDB(args[i+1])
+			if other == nil {
+				return errReply(msg)
+			}
+			if other.index != db.index {
+				dest = other
+			}
+			i++
+		default:
+			return errReply("ERR syntax error")
+		}
+	}
+	if dest == db && src == dst {
+		return errReply("ERR source and destination objects are the same")
+	}
+	entity, ok := db.Data.GetWithLock(src)
+	if !ok {
+		return MakeIntReply(0)
+	}
+	if dest != db {
+		//同一个db中dst已经由Exec加锁
+		dstKeys := []string{dst}
+		dest.Data.RWLocks(dstKeys, nil)
+		defer dest.Data.RWUnLocks(dstKeys, nil)
+		dest.preserve(dstKeys)
+		defer dest.addVersion(dst)
+	}
+	if dest.AllKeys.Exist(dst) {
+		if !replace {
+			return MakeIntReply(0)
+		}
+		dest.removeKey(dst)
+	}
+	dest.putKey(dst, cloneEntity(entity), db.AllKeys.GetKey(src).dataType, db.getExpiration(src))
+	db.addAof(ToCmdLine2("copy", args...))
+	return MakeIntReply(1)
+}
+
+// MOVE key db, 目标db中已经有这个key时不移动
+func Move(db *SaveDBTables, args []string) Reply {
+	key := args[0]
+	if Cluster != nil {
+		return errReply("ERR MOVE is not allowed in cluster mode")
+	}
+	dest, msg := db.otherDB(args[1])
+	if dest == nil {
+		return errReply(msg)
+	}
+	if dest.index == db.index {
+		return errReply("ERR source and destination objects are the same")
+	}
+	entity, ok := db.Data.GetWithLock(key)
+	if !ok {
+		return MakeIntReply(0)
+	}
+	keys := []string{key}
+	dest.Data.RWLocks(keys, nil)
+	defer dest.Data.RWUnLocks(keys, nil)
+	if dest.AllKeys.Exist(key) {
+		return MakeIntReply(0)
+	}
+	dest.preserve(keys)
+	dataType := db.AllKeys.GetKey(key).dataType
+	expiration := db.getExpiration(key)
+	db.removeKey(key)
+	dest.putKey(key, entity, dataType, expiration)
+	dest.addVersion(key)
+	db.addAof(ToCmdLine2("move", key, args[1]))
+	return MakeIntReply(1)
+}
+
+func RandomKey(db *SaveDBTables, args []string) Reply {
+	keys := db.Data.RandomKeys(1)
+	if len(keys) == 0 {
+		return MakeNullBulkReply()
+	}
+	return MakeBulkReply([]byte(keys[0]))
+}
+
+func DBSize(db *SaveDBTables, args []string) Reply {
+	return MakeIntReply(int64(db.Data.Len()))
+}
+
+// TOUCH key [key ...], 更新访问信息, 返回存在的key的个数
+func Touch(db *SaveDBTables, args []string) Reply {
+	count := 0
+	for _, key := range args {
+		if _, ok := db.Data.GetWithLock(key); ok {
+			db.AllKeys.ActivateKey(key)
+			count++
+		}
+	}
+	return MakeIntReply(int64(count))
+}
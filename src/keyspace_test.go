@@ -0,0 +1,66 @@
+package src
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestKeyspace(t *testing.T) {
+	s, c := makeMultiTestServer()
+	execTestCmd(s, c, "set", "a", "1")
+	execTestCmd(s, c, "rpush", "l", "x", "y")
+	execTestCmd(s, c, "zadd", "z", "1", "m")
+	expireAt := strconv.FormatInt(time.Now().Add(time.Hour).UnixMilli(), 10)
+	execTestCmd(s, c, "pexpireat", "a", expireAt)
+	tests := [][]string{
+		{":3\r\n", "dbsize"},
+		{":3\r\n", "exists", "a", "l", "b", "a"},
+		{"+string\r\n", "type", "a"},
+		{"+list\r\n", "type", "l"},
+		{"+zset\r\n", "type", "z"},
+		{"+none\r\n", "type", "b"},
+		{"-ERR no such key\r\n", "rename", "b", "c"},
+		{"+OK\r\n", "rename", "a", "b"},
+		{"+none\r\n", "type", "a"},
+		{"$1\r\n1\r\n", "get", "b"},
+		{":3600\r\n", "ttl", "b"},
+		{":0\r\n", "renamenx", "b", "l"},
+		{":1\r\n", "renamenx", "b", "a"},
+		{":1\r\n", "copy", "l", "l2"},
+		{":0\r\n", "copy", "l", "l2"},
+		{":1\r\n", "copy", "a", "l2", "replace"},
+		{"+string\r\n", "type", "l2"},
+		{":1\r\n", "copy", "z", "z", "db", "1"},
+		{":1\r\n", "move", "a", "1"},
+		{":0\r\n", "move", "z", "1"},
+		{":2\r\n", "touch", "l", "z", "b"},
+		{":2\r\n", "del", "l", "l2", "b"},
+		{":1\r\n", "unlink", "z"},
+		{"$-1\r\n", "randomkey"},
+		{"+OK\r\n", "select", "1"},
+		{":2\r\n", "dbsize"},
+		{"$1\r\n1\r\n", "get", "a"},
+		{":3600\r\n", "ttl", "a"},
+		{":1\r\n", "del", "z"},
+		{"$1\r\na\r\n", "randomkey"},
+	}
+	for _, test := range tests {
+		if r := execTestCmd(s, c, test[1:]...); r != test[0] {
+			t.Errorf("%v expect %q, actual %q", test[1:], test[0], r)
+		}
+	}
+}
+
+func TestRenameExpire(t *testing.T) {
+	s, c := makeMultiTestServer()
+	execTestCmd(s, c, "set", "a", "1")
+	expireAt := strconv.FormatInt(time.Now().Add(100*time.Millisecond).UnixMilli(), 10)
+	execTestCmd(s, c, "pexpireat", "a", expireAt)
+	execTestCmd(s, c, "rename", "a", "b")
+	//时间轮每秒转一格
+	time.Sleep(2500 * time.Millisecond)
+	if r := execTestCmd(s, c, "exists", "a", "b"); r != ":0\r\n" {
+		t.Errorf("renamed key should expire, actual %q", r)
+	}
+}
@@ -26,6 +26,31 @@ func EntityToCmd(key string, entity any) *MultiBulkReply {
 	return cmd
 }
 
+// EntityToCmds 和EntityToCmd一样, stream和带有field过期时间的hash需要多条命令才能恢复
+func EntityToCmds(key string, entity any) []CmdLine {
+	if stream, ok := entity.(*Stream); ok {
+		return streamToCmds(key, stream)
+	}
+	cmd := EntityToCmd(key, entity)
+	if cmd == nil {
+		return nil
+	}
+	cmds := []CmdLine{cmd.Args}
+	if hash, ok := entity.(*Hash); ok {
+		cmds = append(cmds, fieldExpireCmds(key, hash)...)
+	}
+	return cmds
+}
+
+// hash中每个有过期时间的field对应一条hpexpireat
+func fieldExpireCmds(key string, hash *Hash) []CmdLine {
+	cmds := make([]CmdLine, 0, len(hash.E))
+	for field, at := range hash.E {
+		cmds = append(cmds, makeFieldExpireCmd(key, at, []string{field}))
+	}
+	return cmds
+}
+
 var setCmd = []byte("set")
 
 func stringToCmd(key string, bytes []byte) *MultiBulkReply {
@@ -97,7 +122,38 @@ func zSetToCmd(key string, zset *ZSet) *MultiBulkReply {
 	return MakeMultiBulkReply(args)
 }
 
-var pExpireAtBytes = []byte("expire")
+// 先用XADD写入所有消息, 再用XSETID恢复最后的id, 然后恢复消费组 消费者和PEL
+func streamToCmds(key string, stream *Stream) []CmdLine {
+	s := stream.S
+	cmds := make([]CmdLine, 0, s.Len()+2)
+	s.Range(data.StreamID{}, data.MaxStreamID, 0, false, func(entry *data.StreamEntry) bool {
+		cmds = append(cmds, ToCmdLine2("xadd", append([]string{key, entry.ID.String()}, entry.Fields...)...))
+		return true
+	})
+	if s.Len() == 0 {
+		//空的stream: 写入一条消息后马上裁剪掉
+		id := data.StreamID{Seq: 1}
+		if id.Less(s.LastID) {
+			id = s.LastID
+		}
+		cmds = append(cmds, ToCmdLine("xadd", key, "maxlen", "0", id.String(), "x", "y"))
+	}
+	cmds = append(cmds, ToCmdLine("xsetid", key, s.LastID.String(), "entriesadded", strconv.FormatUint(s.EntriesAdded, 10)))
+	for _, group := range s.Groups() {
+		cmds = append(cmds, ToCmdLine("xgroup", "create", key, group.Name, group.LastID.String()))
+		for _, consumer := range group.Consumers() {
+			cmds = append(cmds, ToCmdLine("xgroup", "createconsumer", key, group.Name, consumer.Name))
+		}
+		group.RangePending(data.StreamID{}, data.MaxStreamID, nil, func(nack *data.StreamNACK) bool {
+			cmds = append(cmds, ToCmdLine("xclaim", key, group.Name, nack.Consumer.Name, "0", nack.ID.String(),
+				"time", strconv.FormatInt(nack.DeliveryTime, 10), "retrycount", strconv.FormatUint(nack.DeliveryCount, 10), "force", "justid"))
+			return true
+		})
+	}
+	return cmds
+}
+
+var pExpireAtBytes = []byte("pexpireat")
 
 // MakeExpireCmd generates command line to set expiration for the given key
 func MakeExpireCmd(key string, expireAt time.Time) *MultiBulkReply {
@@ -0,0 +1,223 @@
+package src
+
+import "sync"
+
+// MULTI/EXEC/DISCARD/WATCH
+// 1.MULTI之后的命令不会立即执行, 放进连接的队列并返回QUEUED
+// 2.EXEC时根据所有命令的funcKeys一次性加锁, 保证事务中的命令不会和其他命令交叉执行
+// 3.WATCH记录key的版本号, EXEC时如果版本号变化说明key被修改过, 放弃执行事务
+// 4.事务产生的aof用MULTI/EXEC包起来一次写入, 重放时没有读到EXEC的事务不会被执行
+// 5.versions只保存被WATCH的key, 最后一个WATCH它的连接EXEC DISCARD UNWATCH或者关闭之后删除
+
+type watchKey struct {
+	dbIndex int
+	key     string
+}
+
+// 事务中不允许执行的命令, 这些命令不经过saveCommandProc或者会修改连接的状态
+var txForbiddenCommands = map[string]struct{}{
+	"select":       {},
+	"bgsave":       {},
+	"bgrewriteaof": {},
+	"flushall":     {},
+	"hello":        {},
+	"subscribe":    {},
+	"unsubscribe":  {},
+	"psubscribe":   {},
+	"punsubscribe": {},
+	"pubsub":       {},
+	"replicaof":    {},
+	"slaveof":      {},
+	"psync":        {},
+	"replconf":     {},
+	"info":         {},
+	"sentinel":     {},
+	"cluster":      {},
+	"asking":       {},
+}
+
+// 处理事务相关的命令, 返回true表示命令已经处理完了
+func (s *SaveServer) execMultiCommand(c *Connection, msg *Message) bool {
+	switch *msg.Command {
+	case "multi":
+		if c.multi {
+			ReturnErr("ERR MULTI calls can not be nested", c)
+			return true
+		}
+		c.multi = true
+		c.writeReply(MakeOkReply())
+		return true
+	case "exec":
+		if !c.multi {
+			ReturnErr("ERR EXEC without MULTI", c)
+			return true
+		}
+		c.writeReply(s.execMulti(c))
+		return true
+	case "discard":
+		if !c.multi {
+			ReturnErr("ERR DISCARD without MULTI", c)
+			return true
+		}
+		s.unwatch(c.watching)
+		c.resetMulti()
+		c.writeReply(MakeOkReply())
+		return true
+	case "watch":
+		if c.multi {
+			ReturnErr("ERR WATCH inside MULTI is not allowed", c)
+			return true
+		}
+		if len(msg.Args) == 0 {
+			ReturnErr("ERR wrong number of arguments for 'watch' command", c)
+			return true
+		}
+		s.watch(c, msg.Args)
+		c.writeReply(MakeOkReply())
+		return true
+	case "unwatch":
+		if !c.multi {
+			s.unwatch(c.watching)
+			c.watching = nil
+			c.writeReply(MakeOkReply())
+			return true
+		}
+	}
+	if !c.multi {
+		return false
+	}
+	if _, ok := txForbiddenCommands[*msg.Command]; ok {
+		c.txError = true
+		ReturnErr("ERR Command not allowed inside a transaction", c)
+		return true
+	}
+	c.queue = append(c.queue, msg)
+	c.writeReply(MakeStatusReply(QueuedStr))
+	return true
+}
+
+// 保护versions中的watchers, watch持有key的读锁, 多个连接可能同时WATCH同一个key
+var watchMu sync.Mutex
+
+func (s *SaveServer) watch(c *Connection, keys []string) {
+	db := s.FindDB(c.dbIndex)
+	db.Locks(keys, nil)
+	defer db.UnLocks(keys, nil)
+	watchMu.Lock()
+	defer watchMu.Unlock()
+	if c.watching == nil {
+		c.watching = make(map[watchKey]uint64)
+	}
+	for _, key := range keys {
+		k := watchKey{dbIndex: c.dbIndex, key: key}
+		if _, ok := c.watching[k]; ok {
+			continue
+		}
+		v, ok := db.versions.Get(key)
+		if !ok {
+			v = &watchedKey{}
+			db.versions.Put(key, v)
+		}
+		v.(*watchedKey).watchers++
+		c.watching[k] = db.GetVersion(key)
+	}
+}
+
+// UNWATCH EXEC DISCARD和连接关闭时调用, 没有连接WATCH的key删除版本号
+func (s *SaveServer) unwatch(watching map[watchKey]uint64) {
+	if len(watching) == 0 {
+		return
+	}
+	watchMu.Lock()
+	defer watchMu.Unlock()
+	for k := range watching {
+		db := s.FindDB(k.dbIndex)
+		v, ok := db.versions.Get(k.key)
+		if !ok {
+			continue
+		}
+		if w := v.(*watchedKey); w.watchers > 1 {
+			w.watchers--
+		} else {
+			db.versions.Remove(k.key)
+		}
+	}
+}
+
+func (s *SaveServer) execMulti(c *Connection) Reply {
+	queue, txError, watching := c.queue, c.txError, c.watching
+	defer s.unwatch(watching)
+	c.resetMulti()
+	if txError {
+		return MakeErrReply("EXECABORT Transaction discarded because of previous errors.")
+	}
+	db := s.FindDB(c.dbIndex)
+	var readKeys []string
+	//事务按写命令加锁, 执行期间不会开始快照
+	writeKeys := make([]string, 0)
+	crossDB := false
+	for _, msg := range queue {
+		crossDB = crossDB || isCrossDBCommand(*msg.Command, msg.Args)
+		cmd := saveCommandMap[*msg.Command]
+		if cmd.funcKeys == nil {
+			continue
+		}
+		r, w := cmd.funcKeys(msg.Args)
+		readKeys = append(readKeys, r...)
+		writeKeys = append(writeKeys, w...)
+	}
+	for k := range watching {
+		if k.dbIndex == c.dbIndex {
+			readKeys = append(readKeys, k.key)
+		}
+	}
+	if crossDB {
+		crossDBMu.Lock()
+		defer crossDBMu.Unlock()
+	}
+	db.Locks(readKeys, writeKeys)
+	defer db.UnLocks(readKeys, writeKeys)
+	for k, version := range watching {
+		if s.FindDB(k.dbIndex).GetVersion(k.key) != version {
+			return MakeNullMultiBulkReply()
+		}
+	}
+
+	var aofLines []CmdLine
+	txDB := db.withAofCollector(func(line CmdLine) {
+		aofLines = append(aofLines, line)
+	})
+	replies := make([]Reply, 0, len(queue))
+	for _, msg := range queue {
+		cmd := saveCommandMap[*msg.Command]
+		if cmd.saveCommandProc == nil {
+			replies = append(replies, MakeOkReply())
+			continue
+		}
+		replies = append(replies, cmd.saveCommandProc(txDB, msg.Args))
+	}
+	db.addVersion(writeKeys...)
+	db.addTxAof(aofLines)
+	return MakeMultiRawReply(replies)
+}
+
+// 浅拷贝一份db, 除了addAof之外和原来的db共享所有数据, 事务中产生的aof先收集起来最后一起写入
+func (db *SaveDBTables) withAofCollector(collect func(CmdLine)) *SaveDBTables {
+	tx := *db
+	tx.addAof = collect
+	return &tx
+}
+
+func (c *Connection) resetMulti() {
+	c.multi = false
+	c.txError = false
+	c.queue = nil
+	c.watching = nil
+}
+
+// 事务中的命令入队失败时, EXEC会直接返回EXECABORT
+func (c *Connection) flagTxError() {
+	if c.multi {
+		c.txError = true
+	}
+}
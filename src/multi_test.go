@@ -0,0 +1,126 @@
+package src
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func makeMultiTestServer() (*SaveServer, *Connection) {
+	s := MakeTempServer()
+	c := &Connection{protocol: ProtocolResp2, Writer: make(chan *Message, WriterQueueSize)}
+	return &s, c
+}
+
+func execTestCmd(s *SaveServer, c *Connection, args ...string) string {
+	command := args[0]
+	s.Exec(c, &Message{Command: &command, Args: args[1:]})
+	msg := <-c.Writer
+	return string(*msg.ReturnData)
+}
+
+func TestMultiExec(t *testing.T) {
+	s, c := makeMultiTestServer()
+	execTestCmd(s, c, "multi")
+	if r := execTestCmd(s, c, "set", "a", "1"); r != "+QUEUED\r\n" {
+		t.Fatalf("expect QUEUED, actual %q", r)
+	}
+	execTestCmd(s, c, "get", "a")
+	if r := execTestCmd(s, c, "exec"); r != "*2\r\n+OK\r\n$1\r\n1\r\n" {
+		t.Errorf("exec result error, actual %q", r)
+	}
+	if r := execTestCmd(s, c, "exec"); r != "-ERR EXEC without MULTI\r\n" {
+		t.Errorf("expect error, actual %q", r)
+	}
+}
+
+func TestWatch(t *testing.T) {
+	s, c := makeMultiTestServer()
+	_, other := makeMultiTestServer()
+	execTestCmd(s, c, "watch", "a")
+	execTestCmd(s, other, "set", "a", "2")
+	execTestCmd(s, c, "multi")
+	execTestCmd(s, c, "set", "a", "1")
+	if r := execTestCmd(s, c, "exec"); r != "*-1\r\n" {
+		t.Fatalf("expect aborted, actual %q", r)
+	}
+	if r := execTestCmd(s, c, "get", "a"); r != "$1\r\n2\r\n" {
+		t.Errorf("expect 2, actual %q", r)
+	}
+
+	execTestCmd(s, c, "watch", "a")
+	execTestCmd(s, c, "multi")
+	execTestCmd(s, c, "set", "a", "3")
+	if r := execTestCmd(s, c, "exec"); r != "*1\r\n+OK\r\n" {
+		t.Errorf("expect exec ok, actual %q", r)
+	}
+}
+
+func TestWatchZRemRange(t *testing.T) {
+	s, c := makeMultiTestServer()
+	_, other := makeMultiTestServer()
+	execTestCmd(s, c, "zadd", "z", "1", "a", "2", "b")
+	execTestCmd(s, c, "watch", "z")
+	execTestCmd(s, other, "zremrangebyscore", "z", "0", "1")
+	execTestCmd(s, c, "multi")
+	execTestCmd(s, c, "zcard", "z")
+	if r := execTestCmd(s, c, "exec"); r != "*-1\r\n" {
+		t.Errorf("zremrangebyscore should abort exec, actual %q", r)
+	}
+
+	execTestCmd(s, c, "watch", "z")
+	execTestCmd(s, other, "zremrangebylex", "z", "-", "+")
+	execTestCmd(s, c, "multi")
+	execTestCmd(s, c, "zcard", "z")
+	if r := execTestCmd(s, c, "exec"); r != "*-1\r\n" {
+		t.Errorf("zremrangebylex should abort exec, actual %q", r)
+	}
+}
+
+func TestWatchReleasesVersions(t *testing.T) {
+	s, c := makeMultiTestServer()
+	_, other := makeMultiTestServer()
+	versions := s.FindDB(0).versions
+	execTestCmd(s, c, "watch", "a", "b")
+	execTestCmd(s, c, "unwatch")
+	if n := versions.Len(); n != 0 {
+		t.Fatalf("unwatch should release versions, actual %d", n)
+	}
+	execTestCmd(s, c, "watch", "a")
+	execTestCmd(s, other, "watch", "a")
+	execTestCmd(s, c, "multi")
+	execTestCmd(s, c, "set", "a", "1")
+	execTestCmd(s, c, "exec")
+	if n := versions.Len(); n != 1 {
+		t.Fatalf("key watched by another connection should keep its version, actual %d", n)
+	}
+	execTestCmd(s, other, "multi")
+	execTestCmd(s, other, "discard")
+	if n := versions.Len(); n != 0 {
+		t.Errorf("discard should release versions, actual %d", n)
+	}
+}
+
+func TestExpireInMulti(t *testing.T) {
+	s, c := makeMultiTestServer()
+	var aof []string
+	var mu sync.Mutex
+	s.FindDB(0).addAof = func(line CmdLine) {
+		mu.Lock()
+		defer mu.Unlock()
+		aof = append(aof, string(line[0]))
+	}
+	execTestCmd(s, c, "multi")
+	execTestCmd(s, c, "set", "a", "1", "px", "100")
+	execTestCmd(s, c, "exec")
+	//时间轮每秒转一格, 到期的del要写到当前db的aof中
+	time.Sleep(2500 * time.Millisecond)
+	if r := execTestCmd(s, c, "exists", "a"); r != ":0\r\n" {
+		t.Errorf("key should expire, actual %q", r)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(aof) == 0 || aof[len(aof)-1] != "del" {
+		t.Errorf("expire in multi should write del to aof, actual %v", aof)
+	}
+}
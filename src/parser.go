@@ -11,6 +11,15 @@ import (
 	"strings"
 )
 
+const (
+	//和redis的proto-max-bulk-len一样, 单个bulk string的最大长度
+	maxBulkLen = 512 << 20
+	//数组的最大元素个数, 和redis一样不超过int32
+	maxMultiBulkLen = 1<<31 - 1
+	//按声明的长度预先分配的上限, 更大的数据随着读取增长, 避免客户端只发一个头就占用大量内存
+	maxPrealloc = 1 << 20
+)
+
 // Payload stores redis.Reply or error
 type Payload struct {
 	Data Reply
@@ -131,22 +140,20 @@ func parse0(rawReader io.Reader, ch chan<- *Payload) {
 
 func parseBulkString(header []byte, reader *bufio.Reader, ch chan<- *Payload) error {
 	strLen, err := strconv.ParseInt(string(header[1:]), 10, 64)
-	if err != nil || strLen < -1 {
-		protocolError(ch, "illegal bulk string header: "+string(header))
-		return nil
+	if err != nil || strLen < -1 || strLen > maxBulkLen {
+		return newProtocolError("illegal bulk string header: " + string(header))
 	} else if strLen == -1 {
 		ch <- &Payload{
 			Data: MakeNullBulkReply(),
 		}
 		return nil
 	}
-	body := make([]byte, strLen+2)
-	_, err = io.ReadFull(reader, body)
+	body, err := readBulkBody(reader, strLen)
 	if err != nil {
 		return err
 	}
 	ch <- &Payload{
-		Data: MakeBulkReply(body[:len(body)-2]),
+		Data: MakeBulkReply(body),
 	}
 	return nil
 }
@@ -175,16 +182,15 @@ func parseRDBBulkString(reader *bufio.Reader, ch chan<- *Payload) error {
 
 func parseArray(header []byte, reader *bufio.Reader, ch chan<- *Payload) error {
 	nStrs, err := strconv.ParseInt(string(header[1:]), 10, 64)
-	if err != nil || nStrs < 0 {
-		protocolError(ch, "illegal array header "+string(header[1:]))
-		return nil
+	if err != nil || nStrs < 0 || nStrs > maxMultiBulkLen {
+		return newProtocolError("illegal array header " + string(header[1:]))
 	} else if nStrs == 0 {
 		ch <- &Payload{
 			Data: MakeEmptyMultiBulkReply(),
 		}
 		return nil
 	}
-	lines := make([][]byte, 0, nStrs)
+	lines := make([][]byte, 0, preallocCount(nStrs))
 	for i := int64(0); i < nStrs; i++ {
 		var line []byte
 		line, err = reader.ReadBytes('\n')
@@ -193,22 +199,20 @@ func parseArray(header []byte, reader *bufio.Reader, ch chan<- *Payload) error {
 		}
 		length := len(line)
 		if length < 4 || line[length-2] != '\r' || line[0] != '$' {
-			protocolError(ch, "illegal bulk string header "+string(line))
-			break
+			//数组不完整, 不能把已经读到的部分当成命令
+			return newProtocolError("illegal bulk string header " + strconv.Quote(string(line)))
 		}
 		strLen, err := strconv.ParseInt(string(line[1:length-2]), 10, 64)
-		if err != nil || strLen < -1 {
-			protocolError(ch, "illegal bulk string length "+string(line))
-			break
+		if err != nil || strLen < -1 || strLen > maxBulkLen {
+			return newProtocolError("illegal bulk string length " + strconv.Quote(string(line)))
 		} else if strLen == -1 {
 			lines = append(lines, []byte{})
 		} else {
-			body := make([]byte, strLen+2)
-			_, err := io.ReadFull(reader, body)
+			body, err := readBulkBody(reader, strLen)
 			if err != nil {
 				return err
 			}
-			lines = append(lines, body[:len(body)-2])
+			lines = append(lines, body)
 		}
 	}
 	ch <- &Payload{
@@ -218,6 +222,94 @@ func parseArray(header []byte, reader *bufio.Reader, ch chan<- *Payload) error {
 }
 
 func protocolError(ch chan<- *Payload, msg string) {
-	err := errors.New("protocol error: " + msg)
-	ch <- &Payload{Err: err}
+	ch <- &Payload{Err: newProtocolError(msg)}
+}
+
+func newProtocolError(msg string) error {
+	return errors.New("protocol error: " + msg)
+}
+
+// 数组按声明的长度预先分配, 最多1024个, 更多的元素随着读取增长
+func preallocCount(n int64) int64 {
+	if n > 1024 {
+		return 1024
+	}
+	return n
+}
+
+// 读取strLen个字节和结尾的CRLF, 大的bulk按实际读到的数据增长
+func readBulkBody(reader *bufio.Reader, strLen int64) ([]byte, error) {
+	if strLen+2 <= maxPrealloc {
+		body := make([]byte, strLen+2)
+		if _, err := io.ReadFull(reader, body); err != nil {
+			return nil, err
+		}
+		return body[:strLen], nil
+	}
+	var buf bytes.Buffer
+	buf.Grow(maxPrealloc)
+	n, err := io.CopyN(&buf, reader, strLen+2)
+	if err != nil {
+		if err == io.EOF && n > 0 {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return buf.Bytes()[:strLen], nil
+}
+
+// ReadReply 读取一个完整的回复, 数组可以嵌套, 数组的元素按各自的类型解析
+// 客户端用来解码命令的结果, ParseStream只能处理由bulk string组成的数组
+func ReadReply(reader *bufio.Reader) (Reply, error) {
+	line, err := reader.ReadBytes('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, errors.New("protocol error: illegal line " + strconv.Quote(string(line)))
+	}
+	line = line[:len(line)-2]
+	switch line[0] {
+	case '+':
+		return MakeStatusReply(string(line[1:])), nil
+	case '-':
+		return MakeErrReply(string(line[1:])), nil
+	case ':':
+		value, err := strconv.ParseInt(string(line[1:]), 10, 64)
+		if err != nil {
+			return nil, errors.New("protocol error: illegal number " + string(line[1:]))
+		}
+		return MakeIntReply(value), nil
+	case '$':
+		strLen, err := strconv.ParseInt(string(line[1:]), 10, 64)
+		if err != nil || strLen < -1 || strLen > maxBulkLen {
+			return nil, newProtocolError("illegal bulk string header " + string(line))
+		}
+		if strLen == -1 {
+			return MakeNullBulkReply(), nil
+		}
+		body, err := readBulkBody(reader, strLen)
+		if err != nil {
+			return nil, err
+		}
+		return MakeBulkReply(body), nil
+	case '*':
+		n, err := strconv.ParseInt(string(line[1:]), 10, 64)
+		if err != nil || n < -1 || n > maxMultiBulkLen {
+			return nil, newProtocolError("illegal array header " + string(line))
+		}
+		if n == -1 {
+			return MakeNullMultiBulkReply(), nil
+		}
+		replies := make([]Reply, 0, preallocCount(n))
+		for i := int64(0); i < n; i++ {
+			r, err := ReadReply(reader)
+			if err != nil {
+				return nil, err
+			}
+			replies = append(replies, r)
+		}
+		return MakeMultiRawReply(replies), nil
+	}
+	return nil, errors.New("protocol error: unknown reply type " + strconv.Quote(string(line[:1])))
 }
@@ -0,0 +1,56 @@
+package src
+
+import (
+	"fmt"
+	"net"
+	"time"
+)
+
+// 连接其他实例的客户端, 哨兵和集群共用, 同一时间只能在一个协程中使用
+type peerClient struct {
+	addr    string
+	timeout time.Duration
+	conn    net.Conn
+	ch      <-chan *Payload
+}
+
+func (c *peerClient) Do(args ...string) (Reply, error) {
+	if c.conn == nil {
+		conn, err := net.DialTimeout("tcp", c.addr, c.timeout)
+		if err != nil {
+			return nil, err
+		}
+		c.conn = conn
+		c.ch = ParseStream(conn)
+	}
+	_ = c.conn.SetDeadline(time.Now().Add(c.timeout))
+	if _, err := c.conn.Write(MakeMultiBulkReply(ToCmdLine(args...)).ToBytes()); err != nil {
+		c.Close()
+		return nil, err
+	}
+	p, ok := <-c.ch
+	if !ok {
+		c.Close()
+		return nil, fmt.Errorf("connection to %s closed", c.addr)
+	}
+	if p.Err != nil {
+		c.Close()
+		return nil, p.Err
+	}
+	//解析协程一直在读, 需要清除超时时间
+	_ = c.conn.SetDeadline(time.Time{})
+	return p.Data, nil
+}
+
+func (c *peerClient) Close() {
+	if c.conn == nil {
+		return
+	}
+	_ = c.conn.Close()
+	ch := c.ch
+	go func() {
+		for range ch {
+		}
+	}()
+	c.conn, c.ch = nil, nil
+}
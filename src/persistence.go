@@ -5,18 +5,20 @@ import (
 	"context"
 	"fmt"
 	"github.com/hdt3213/rdb/core"
+	"github.com/hdt3213/rdb/model"
 	rdb "github.com/hdt3213/rdb/parser"
 	"os"
+	"savedb/src/data"
+	"sort"
 	"strings"
 	"sync"
 	"sync/atomic"
 )
 
 type Persister struct {
-	ctx        context.Context
-	cancel     context.CancelFunc
-	db         SaveServer
-	tmpDBMaker func() SaveServer
+	ctx    context.Context
+	cancel context.CancelFunc
+	db     SaveServer
 	// aofChan is the channel to receive aof payload(listenCmd will send payload to this channel)
 	aofChan chan *payload
 	// aofFile is the file handler of aof file
@@ -29,6 +31,8 @@ type Persister struct {
 	pausingAof sync.Mutex
 	currentDB  int
 	listeners  map[Listener]struct{}
+	//listeners不为空, 没有开启aof时用来判断是否需要记录命令
+	hasListener atomic.Bool
 	// reuse cmdLine buffer
 	buffer         []CmdLine
 	loading        *atomic.Bool
@@ -55,8 +59,11 @@ func (server *SaveServer) loadRdbFile() error {
 }
 
 func (server *SaveServer) LoadRDB(dec *core.Decoder) error {
-	server.persister.loading.Store(true)
-	defer server.persister.loading.Store(false)
+	//加载aof的rdb前缀时server可能还没有绑定persister
+	if server.persister != nil {
+		server.persister.loading.Store(true)
+		defer server.persister.loading.Store(false)
+	}
 	f := dec.Parse(func(o rdb.RedisObject) bool {
 		db := server.FindDB(o.GetDBIndex())
 		var entity any
@@ -98,6 +105,9 @@ func (server *SaveServer) LoadRDB(dec *core.Decoder) error {
 			}
 			db.PutKey(o.GetKey(), TypeZSet)
 			entity = zSet
+		case model.StreamType:
+			entity = streamFromRDB(o.(*model.StreamObject))
+			db.PutKey(o.GetKey(), TypeStream)
 		}
 		if entity != nil {
 			db.PutEntity(o.GetKey(), entity)
@@ -105,9 +115,10 @@ func (server *SaveServer) LoadRDB(dec *core.Decoder) error {
 				PutExpire(db, o.GetKey(), *o.GetExpiration())
 			}
 			// add to aof
-			args := EntityToCmd(o.GetKey(), entity).Args
 			//启动时这里的addAof方法是个空方法
-			db.addAof(args)
+			for _, args := range EntityToCmds(o.GetKey(), entity) {
+				db.addAof(args)
+			}
 		}
 		return true
 	})
@@ -115,25 +126,71 @@ func (server *SaveServer) LoadRDB(dec *core.Decoder) error {
 	return f
 }
 
-func NewPersister2(db SaveServer, fsync string) (*Persister, error) {
-	return NewPersister(db, fsync, func() SaveServer {
-		return MakeTempServer()
-	})
+// rdb中消息的字段是map, 按第一条消息的字段顺序恢复, 其余的字段排序
+func streamFromRDB(obj *model.StreamObject) *Stream {
+	stream := NewStream()
+	for _, node := range obj.Entries {
+		for _, msg := range node.Msgs {
+			if msg.Deleted {
+				continue
+			}
+			fields := make([]string, 0, len(msg.Fields)*2)
+			seen := make(map[string]struct{}, len(msg.Fields))
+			for _, name := range node.Fields {
+				if value, ok := msg.Fields[name]; ok {
+					fields = append(fields, name, value)
+					seen[name] = struct{}{}
+				}
+			}
+			rest := make([]string, 0)
+			for name := range msg.Fields {
+				if _, ok := seen[name]; !ok {
+					rest = append(rest, name)
+				}
+			}
+			sort.Strings(rest)
+			for _, name := range rest {
+				fields = append(fields, name, msg.Fields[name])
+			}
+			stream.S.Add(data.StreamID{Ms: msg.Id.Ms, Seq: msg.Id.Sequence}, fields)
+		}
+	}
+	if obj.LastId != nil {
+		stream.S.LastID = data.StreamID{Ms: obj.LastId.Ms, Seq: obj.LastId.Sequence}
+	}
+	stream.S.EntriesAdded = obj.Length
+	if obj.IsV2 {
+		stream.S.EntriesAdded = obj.AddedEntriesCount
+	}
+	for _, g := range obj.Groups {
+		group, _ := stream.S.CreateGroup(g.Name, data.StreamID{Ms: g.LastId.Ms, Seq: g.LastId.Sequence})
+		owners := make(map[data.StreamID]*data.StreamConsumer)
+		for _, c := range g.Consumers {
+			consumer := group.Consumer(c.Name, true)
+			consumer.SeenTime = int64(c.SeenTime)
+			for _, id := range c.Pending {
+				owners[data.StreamID{Ms: id.Ms, Seq: id.Sequence}] = consumer
+			}
+		}
+		for _, p := range g.Pending {
+			id := data.StreamID{Ms: p.Id.Ms, Seq: p.Id.Sequence}
+			if consumer, ok := owners[id]; ok {
+				group.AddPending(id, consumer, int64(p.DeliveryTime)).DeliveryCount = p.DeliveryCount
+			}
+		}
+	}
+	return stream
 }
-func NewPersister(db SaveServer, fsync string, tmpDBMaker func() SaveServer) (*Persister, error) {
+
+func NewPersister(db SaveServer, fsync string) (*Persister, error) {
 	persister := &Persister{}
 	persister.aofFsync = strings.ToLower(fsync)
 	persister.db = db
-	persister.tmpDBMaker = tmpDBMaker
 	persister.currentDB = 0
 	holder := &atomic.Bool{}
 	holder.Store(false)
 	persister.loading = holder
 	persister.listeners = make(map[Listener]struct{})
-	// start aof goroutine to write aof file in background and fsync periodically if needed (see fsyncEverySecond)
-	go func() {
-		persister.listenCmd()
-	}()
 	ctx, cancel := context.WithCancel(context.Background())
 	persister.ctx = ctx
 	persister.cancel = cancel
@@ -155,10 +212,12 @@ func (server *SaveServer) bindPersister(aofHandler *Persister) {
 	// bind SaveCmdLine
 	for _, db := range server.Dbs {
 		singleDB := db.Load().(*SaveDBTables)
+		//没有开启aof时命令也可能需要发给从节点, 由persister判断
 		singleDB.addAof = func(line CmdLine) {
-			if Config.AppendOnly { // config may be changed during runtime
-				server.persister.SaveCmdLine(singleDB.index, line)
-			}
+			server.persister.SaveCmdLine(singleDB.index, line)
+		}
+		singleDB.addTxAof = func(lines []CmdLine) {
+			server.persister.SaveTxCmdLines(singleDB.index, lines)
 		}
 	}
 }
@@ -168,7 +227,7 @@ func MakeTempServer() SaveServer {
 	mdb.Dbs = make([]*atomic.Value, dbsSize)
 	for i := range mdb.Dbs {
 		holder := &atomic.Value{}
-		holder.Store(makeDB(i))
+		holder.Store(makeDB(i, mdb.Dbs))
 		mdb.Dbs[i] = holder
 	}
 	return mdb
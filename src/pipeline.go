@@ -0,0 +1,73 @@
+package src
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// Pipeline 把多条命令一次性写到服务端, 服务端按顺序返回每条命令的结果
+// client.Pipeline().Set("k", "v").Get("k").Exec()
+type Pipeline struct {
+	client *TCPClient
+	cmds   [][][]byte
+}
+
+func (client *TCPClient) Pipeline() *Pipeline {
+	return &Pipeline{client: client}
+}
+
+// Do 追加一条命令, 参数是二进制安全的
+func (p *Pipeline) Do(args ...[]byte) *Pipeline {
+	p.cmds = append(p.cmds, args)
+	return p
+}
+
+// Cmd 追加一条命令
+func (p *Pipeline) Cmd(args ...string) *Pipeline {
+	return p.Do(ToCmdLine(args...)...)
+}
+
+func (p *Pipeline) Set(key string, value string) *Pipeline {
+	return p.Cmd("set", key, value)
+}
+
+func (p *Pipeline) Get(key string) *Pipeline {
+	return p.Cmd("get", key)
+}
+
+func (p *Pipeline) Del(keys ...string) *Pipeline {
+	return p.Do(ToCmdLine2("del", keys...)...)
+}
+
+// Len 返回还没有执行的命令数量
+func (p *Pipeline) Len() int {
+	return len(p.cmds)
+}
+
+// Exec 发送所有命令并按顺序返回结果, 执行后pipeline被清空可以继续复用
+func (p *Pipeline) Exec() ([]Reply, error) {
+	cmds := p.cmds
+	p.cmds = nil
+	if len(cmds) == 0 {
+		return nil, nil
+	}
+	var buf bytes.Buffer
+	for _, cmd := range cmds {
+		buf.Write(EncodeArgs(cmd...))
+	}
+	data := buf.Bytes()
+
+	client := p.client
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	client.connection.Writer <- &Message{ReturnData: &data}
+	results := make([]Reply, 0, len(cmds))
+	for range cmds {
+		res, ok := client.readResult()
+		if !ok {
+			return results, fmt.Errorf("connection closed after %d of %d replies", len(results), len(cmds))
+		}
+		results = append(results, res)
+	}
+	return results, nil
+}
@@ -0,0 +1,110 @@
+package src
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net"
+	"savedb/src/log"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func initTestLog(t *testing.T) {
+	if log.SaveDBLogger == nil {
+		log.InitLog(&log.LogConfig{Path: t.TempDir()})
+	}
+}
+
+// 记录服务端写socket的次数
+type countingConn struct {
+	net.Conn
+	writes atomic.Int32
+}
+
+func (c *countingConn) Write(b []byte) (int, error) {
+	c.writes.Add(1)
+	return c.Conn.Write(b)
+}
+
+func makePipelineTestConn() (*Connection, *countingConn, net.Conn) {
+	server, client := net.Pipe()
+	conn := &countingConn{Conn: server}
+	c := &Connection{
+		protocol: ProtocolResp2,
+		Conn:     conn,
+		Writer:   make(chan *Message, WriterQueueSize),
+		Close:    &atomic.Bool{},
+	}
+	return c, conn, client
+}
+
+func TestPipelineReplies(t *testing.T) {
+	initTestLog(t)
+	c, _, client := makePipelineTestConn()
+	defer client.Close()
+	go c.ReadMsg()
+	go c.WriterMsg()
+
+	const n = 20
+	var buf bytes.Buffer
+	for i := 1; i <= n; i++ {
+		buf.Write(ToBytes(ToCmdLine("echo", strconv.Itoa(i))))
+	}
+	data := buf.Bytes()
+	//最后一条命令拆成两次发送, 服务端要等到完整的请求才执行
+	split := len(data) - 5
+	go func() {
+		_, _ = client.Write(data[:split])
+		time.Sleep(50 * time.Millisecond)
+		_, _ = client.Write(data[split:])
+	}()
+
+	reader := bufio.NewReader(client)
+	_ = client.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for i := 1; i <= n; i++ {
+		//bulk回复有两行
+		header, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("read reply %d: %v", i, err)
+		}
+		body, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("read reply %d: %v", i, err)
+		}
+		expect := string(MakeBulkReply([]byte(strconv.Itoa(i))).ToBytes())
+		if actual := header + body; actual != expect {
+			t.Fatalf("reply %d out of order, actual %q", i, actual)
+		}
+	}
+}
+
+func TestWriterBatchesReplies(t *testing.T) {
+	initTestLog(t)
+	c, conn, client := makePipelineTestConn()
+	defer client.Close()
+	const n = 10
+	var expect bytes.Buffer
+	for i := 0; i < n; i++ {
+		reply := MakeIntReply(int64(i))
+		expect.Write(reply.ToBytes())
+		c.writeReply(reply)
+	}
+	//队列中已经有多条回复时, 全部写进缓冲区之后才刷到socket
+	go c.WriterMsg()
+	actual := make([]byte, expect.Len())
+	_ = client.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := io.ReadFull(client, actual); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(actual, expect.Bytes()) {
+		t.Errorf("expect %q, actual %q", expect.Bytes(), actual)
+	}
+	if w := conn.writes.Load(); w != 1 {
+		t.Errorf("expect replies flushed in 1 write, actual %d", w)
+	}
+	c.Close.Store(true)
+	close(c.Writer)
+}
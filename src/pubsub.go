@@ -0,0 +1,338 @@
+package src
+
+import (
+	"savedb/src/log"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// 发布订阅
+// 1.连接订阅频道后进入推送模式, 消息通过连接的Writer推送, 和普通回复共用一个写协程
+// 2.推送不会阻塞发布者, 订阅者的待写队列超过pubsub-buffer-limit时直接断开这个订阅者
+// 3.RESP2和老协议的连接在订阅状态下只能执行订阅相关的命令和PING, RESP3的连接不受限制
+// 4.PSUBSCRIBE和PUBSUB CHANNELS的模式按glob匹配, 见glob.go
+
+var PubSub = MakePubSubHub()
+
+type PubSubHub struct {
+	mu       sync.RWMutex
+	channels map[string]map[*Connection]struct{}
+	patterns map[string]*patternSubscribers
+}
+
+type patternSubscribers struct {
+	conns map[*Connection]struct{}
+}
+
+func MakePubSubHub() *PubSubHub {
+	return &PubSubHub{
+		channels: make(map[string]map[*Connection]struct{}),
+		patterns: make(map[string]*patternSubscribers),
+	}
+}
+
+// 订阅状态下允许执行的命令
+var pubSubContextCommands = map[string]struct{}{
+	"subscribe":    {},
+	"unsubscribe":  {},
+	"psubscribe":   {},
+	"punsubscribe": {},
+	"ping":         {},
+}
+
+var (
+	subscribeBytes    = []byte("subscribe")
+	unsubscribeBytes  = []byte("unsubscribe")
+	psubscribeBytes   = []byte("psubscribe")
+	punsubscribeBytes = []byte("punsubscribe")
+	messageBytes      = []byte("message")
+	pmessageBytes     = []byte("pmessage")
+	pongBytes         = []byte("pong")
+)
+
+// 处理订阅相关的命令, 返回true表示命令已经处理完了
+func (s *SaveServer) execPubSubCommand(c *Connection, msg *Message) bool {
+	cmd := *msg.Command
+	if c.subscribeCount() > 0 && c.protocol != ProtocolResp3 {
+		if _, ok := pubSubContextCommands[cmd]; !ok {
+			ReturnErr("ERR Can't execute '"+cmd+"': only (P)SUBSCRIBE / (P)UNSUBSCRIBE / PING are allowed in this context", c)
+			return true
+		}
+	}
+	//事务中交给multi处理
+	if c.multi {
+		return false
+	}
+	switch cmd {
+	case "subscribe", "psubscribe":
+		if len(msg.Args) == 0 {
+			ReturnErr("ERR wrong number of arguments for '"+cmd+"' command", c)
+			return true
+		}
+		for _, channel := range msg.Args {
+			if cmd == "subscribe" {
+				PubSub.Subscribe(c, channel)
+				c.writeReply(subscribeReply(subscribeBytes, channel, c.subscribeCount()))
+			} else {
+				PubSub.PSubscribe(c, channel)
+				c.writeReply(subscribeReply(psubscribeBytes, channel, c.subscribeCount()))
+			}
+		}
+		return true
+	case "unsubscribe":
+		channels := msg.Args
+		if len(channels) == 0 {
+			channels = sortedNames(c.subChannels)
+		}
+		if len(channels) == 0 {
+			c.writeReply(MakePushReply(MakeBulkReply(unsubscribeBytes), MakeNullBulkReply(), MakeIntReply(int64(c.subscribeCount()))))
+			return true
+		}
+		for _, channel := range channels {
+			PubSub.Unsubscribe(c, channel)
+			c.writeReply(subscribeReply(unsubscribeBytes, channel, c.subscribeCount()))
+		}
+		return true
+	case "punsubscribe":
+		patterns := msg.Args
+		if len(patterns) == 0 {
+			patterns = sortedNames(c.subPatterns)
+		}
+		if len(patterns) == 0 {
+			c.writeReply(MakePushReply(MakeBulkReply(punsubscribeBytes), MakeNullBulkReply(), MakeIntReply(int64(c.subscribeCount()))))
+			return true
+		}
+		for _, pattern := range patterns {
+			PubSub.PUnsubscribe(c, pattern)
+			c.writeReply(subscribeReply(punsubscribeBytes, pattern, c.subscribeCount()))
+		}
+		return true
+	case "ping":
+		//RESP2订阅状态下PING的回复也是数组
+		if c.subscribeCount() == 0 || c.protocol == ProtocolResp3 {
+			return false
+		}
+		if len(msg.Args) > 1 {
+			ReturnErr("ERR wrong number of arguments for 'ping' command", c)
+			return true
+		}
+		payload := []byte{}
+		if len(msg.Args) == 1 {
+			payload = []byte(msg.Args[0])
+		}
+		c.writeReply(MakeMultiBulkReply([][]byte{pongBytes, payload}))
+		return true
+	case "pubsub":
+		c.writeReply(PubSub.Introspect(msg.Args))
+		return true
+	}
+	return false
+}
+
+func subscribeReply(kind []byte, name string, count int) Reply {
+	return MakePushReply(MakeBulkReply(kind), MakeBulkReply([]byte(name)), MakeIntReply(int64(count)))
+}
+
+func Publish(db *SaveDBTables, args []string) Reply {
+	return MakeIntReply(int64(PubSub.Publish(args[0], []byte(args[1]))))
+}
+
+func (h *PubSubHub) Subscribe(c *Connection, channel string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if c.subChannels == nil {
+		c.subChannels = make(map[string]struct{})
+	}
+	c.subChannels[channel] = struct{}{}
+	conns, ok := h.channels[channel]
+	if !ok {
+		conns = make(map[*Connection]struct{})
+		h.channels[channel] = conns
+	}
+	conns[c] = struct{}{}
+}
+
+func (h *PubSubHub) Unsubscribe(c *Connection, channel string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.unsubscribe(c, channel)
+}
+
+func (h *PubSubHub) unsubscribe(c *Connection, channel string) {
+	delete(c.subChannels, channel)
+	conns, ok := h.channels[channel]
+	if !ok {
+		return
+	}
+	delete(conns, c)
+	if len(conns) == 0 {
+		delete(h.channels, channel)
+	}
+}
+
+func (h *PubSubHub) PSubscribe(c *Connection, pattern string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if c.subPatterns == nil {
+		c.subPatterns = make(map[string]struct{})
+	}
+	c.subPatterns[pattern] = struct{}{}
+	subs, ok := h.patterns[pattern]
+	if !ok {
+		subs = &patternSubscribers{conns: make(map[*Connection]struct{})}
+		h.patterns[pattern] = subs
+	}
+	subs.conns[c] = struct{}{}
+}
+
+func (h *PubSubHub) PUnsubscribe(c *Connection, pattern string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.punsubscribe(c, pattern)
+}
+
+func (h *PubSubHub) punsubscribe(c *Connection, pattern string) {
+	delete(c.subPatterns, pattern)
+	subs, ok := h.patterns[pattern]
+	if !ok {
+		return
+	}
+	delete(subs.conns, c)
+	if len(subs.conns) == 0 {
+		delete(h.patterns, pattern)
+	}
+}
+
+// UnsubscribeAll 连接关闭时取消所有订阅
+func (h *PubSubHub) UnsubscribeAll(c *Connection) {
+	if c.subscribeCount() == 0 {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for channel := range c.subChannels {
+		h.unsubscribe(c, channel)
+	}
+	for pattern := range c.subPatterns {
+		h.punsubscribe(c, pattern)
+	}
+}
+
+// Publish 返回收到消息的订阅者数量
+func (h *PubSubHub) Publish(channel string, message []byte) int {
+	count := 0
+	var slow []*Connection
+	h.mu.RLock()
+	if conns, ok := h.channels[channel]; ok {
+		reply := MakePushReply(MakeBulkReply(messageBytes), MakeBulkReply([]byte(channel)), MakeBulkReply(message))
+		for c := range conns {
+			if c.push(reply) {
+				count++
+			} else {
+				slow = append(slow, c)
+			}
+		}
+	}
+	for pattern, subs := range h.patterns {
+		if !globMatch(pattern, channel) {
+			continue
+		}
+		reply := MakePushReply(MakeBulkReply(pmessageBytes), MakeBulkReply([]byte(pattern)),
+			MakeBulkReply([]byte(channel)), MakeBulkReply(message))
+		for c := range subs.conns {
+			if c.push(reply) {
+				count++
+			} else {
+				slow = append(slow, c)
+			}
+		}
+	}
+	h.mu.RUnlock()
+	//释放锁之后再断开, 连接关闭时会取消订阅
+	for _, c := range slow {
+		c.closeSlowSubscriber()
+	}
+	return count
+}
+
+// Introspect PUBSUB CHANNELS [pattern] | NUMSUB [channel ...] | NUMPAT
+func (h *PubSubHub) Introspect(args []string) Reply {
+	if len(args) == 0 {
+		return MakeErrReply("ERR wrong number of arguments for 'pubsub' command")
+	}
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	switch strings.ToLower(args[0]) {
+	case "channels":
+		if len(args) > 2 {
+			return MakeErrReply("ERR wrong number of arguments for 'pubsub|channels' command")
+		}
+		channels := make([][]byte, 0)
+		for _, channel := range sortedNames(h.channels) {
+			if len(args) == 1 || globMatch(args[1], channel) {
+				channels = append(channels, []byte(channel))
+			}
+		}
+		return MakeMultiBulkReply(channels)
+	case "numsub":
+		replies := make([]Reply, 0, 2*(len(args)-1))
+		for _, channel := range args[1:] {
+			replies = append(replies, MakeBulkReply([]byte(channel)), MakeIntReply(int64(len(h.channels[channel]))))
+		}
+		return MakeMultiRawReply(replies)
+	case "numpat":
+		if len(args) != 1 {
+			return MakeErrReply("ERR wrong number of arguments for 'pubsub|numpat' command")
+		}
+		return MakeIntReply(int64(len(h.patterns)))
+	}
+	return MakeErrReply("ERR unknown subcommand '" + args[0] + "'. Try PUBSUB CHANNELS|NUMSUB|NUMPAT.")
+}
+
+func (c *Connection) subscribeCount() int {
+	return len(c.subChannels) + len(c.subPatterns)
+}
+
+// 非阻塞地推送消息, 待写队列超过限制说明订阅者消费太慢, 返回false
+func (c *Connection) push(reply Reply) bool {
+	if c.Writer == nil || (c.Close != nil && c.Close.Load()) {
+		return false
+	}
+	if len(c.Writer) >= pubSubBufferLimit() {
+		return false
+	}
+	select {
+	case c.Writer <- c.replyMessage(reply):
+		return true
+	default:
+		return false
+	}
+}
+
+func (c *Connection) closeSlowSubscriber() {
+	if c.Close != nil && !c.Close.CompareAndSwap(false, true) {
+		return
+	}
+	log.SaveDBLogger.Warnf("subscriber output buffer overflow, close conn=%v", c.RemoteAddr)
+	//关闭socket后读协程会退出并调用ConnClose
+	if c.Conn != nil {
+		_ = c.Conn.Close()
+	}
+}
+
+func pubSubBufferLimit() int {
+	if Config.PubSubBufferLimit <= 0 || Config.PubSubBufferLimit > WriterQueueSize {
+		return WriterQueueSize
+	}
+	return Config.PubSubBufferLimit
+}
+
+func sortedNames[T any](m map[string]T) []string {
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
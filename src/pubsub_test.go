@@ -0,0 +1,49 @@
+package src
+
+import (
+	"sync/atomic"
+	"testing"
+)
+
+func makePubSubTestConn(protocol int, queueSize int) *Connection {
+	return &Connection{protocol: protocol, Writer: make(chan *Message, queueSize), Close: &atomic.Bool{}}
+}
+
+func TestPublish(t *testing.T) {
+	hub := MakePubSubHub()
+	c1 := makePubSubTestConn(ProtocolResp2, 8)
+	c2 := makePubSubTestConn(ProtocolResp3, 8)
+	hub.Subscribe(c1, "news")
+	hub.PSubscribe(c2, "n?ws.*")
+	if n := hub.Publish("news", []byte("a")); n != 1 {
+		t.Fatalf("expect 1 receiver, actual %d", n)
+	}
+	if n := hub.Publish("news.it", []byte("b")); n != 1 {
+		t.Fatalf("expect 1 receiver, actual %d", n)
+	}
+	if r := string(*(<-c1.Writer).ReturnData); r != "*3\r\n$7\r\nmessage\r\n$4\r\nnews\r\n$1\r\na\r\n" {
+		t.Errorf("message error, actual %q", r)
+	}
+	if r := string(*(<-c2.Writer).ReturnData); r != ">4\r\n$8\r\npmessage\r\n$6\r\nn?ws.*\r\n$7\r\nnews.it\r\n$1\r\nb\r\n" {
+		t.Errorf("pmessage error, actual %q", r)
+	}
+	hub.UnsubscribeAll(c1)
+	hub.UnsubscribeAll(c2)
+	if n := hub.Publish("news", []byte("c")); n != 0 || len(hub.channels) != 0 || len(hub.patterns) != 0 {
+		t.Errorf("unsubscribe error, receivers %d", n)
+	}
+}
+
+func TestSlowSubscriber(t *testing.T) {
+	initTestLog(t)
+	hub := MakePubSubHub()
+	c := makePubSubTestConn(ProtocolResp2, 1)
+	hub.Subscribe(c, "news")
+	hub.Publish("news", []byte("a"))
+	if n := hub.Publish("news", []byte("b")); n != 0 {
+		t.Errorf("expect slow subscriber skipped, actual %d", n)
+	}
+	if !c.Close.Load() {
+		t.Error("slow subscriber should be closed")
+	}
+}
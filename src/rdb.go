@@ -16,6 +16,7 @@ func (persister *Persister) GenerateRDB(rdbFilename string) error {
 		return err
 	}
 	err = persister.generateRDB(ctx)
+	ctx.snapshot.Release()
 	if err != nil {
 		return err
 	}
@@ -38,6 +39,7 @@ func (persister *Persister) GenerateRDBForReplication(rdbFilename string, listen
 	}
 
 	err = persister.generateRDB(ctx)
+	ctx.snapshot.Release()
 	if err != nil {
 		return err
 	}
@@ -53,42 +55,36 @@ func (persister *Persister) GenerateRDBForReplication(rdbFilename string, listen
 }
 
 func (persister *Persister) startGenerateRDB(newListener Listener, hook func()) (*RewriteCtx, error) {
-	persister.pausingAof.Lock() // pausing aof
-	defer persister.pausingAof.Unlock()
-	//将aof文件的内容同步落盘
-	err := persister.aofFile.Sync()
+	//开始快照, 快照开始之后的命令会发给新的listener
+	snap, err := persister.startSnapshot(func() {
+		if newListener != nil {
+			persister.addListener(newListener)
+		}
+		if hook != nil {
+			hook()
+		}
+	})
 	if err != nil {
-		log.SaveDBLogger.Warn("fsync failed")
 		return nil, err
 	}
-
-	//获取文件大小
-	fileInfo, _ := os.Stat(GetAofFilePath())
-	filesize := fileInfo.Size()
 	// create tmp file
 	file, err := os.CreateTemp(Config.Dir, "*.rdb")
 	if err != nil {
+		snap.Release()
 		log.SaveDBLogger.Warn("tmp file create failed")
 		return nil, err
 	}
-	if newListener != nil {
-		persister.listeners[newListener] = struct{}{}
-	}
-	if hook != nil {
-		hook()
-	}
 	return &RewriteCtx{
 		tmpFile:  file,
-		fileSize: filesize,
+		fileSize: snap.aofSize,
+		dbIdx:    snap.dbIndex,
+		snapshot: snap,
 	}, nil
 }
 
 func (persister *Persister) generateRDB(ctx *RewriteCtx) error {
-	//使用aof重新生成临时的dbs 然后遍历备份
-	tmpPersister := persister.newRewriteHandler()
-	//todo 暂时只重放aof文件
-	tmpPersister.LoadAof(0)
-
+	//直接遍历快照, 不需要重放aof
+	snap := ctx.snapshot
 	encoder := rdb.NewEncoder(ctx.tmpFile).EnableCompress()
 	err := encoder.WriteHeader()
 	if err != nil {
@@ -114,19 +110,26 @@ func (persister *Persister) generateRDB(ctx *RewriteCtx) error {
 	}
 
 	for i := 0; i < dbsSize; i++ {
-		db := tmpPersister.db.Dbs[i].Load().(*SaveDBTables)
-		keyCount := db.keys.Len()
-		ttlCount := len(db.Expires)
+		keyCount, ttlCount := snap.KeyCount(i)
 		if keyCount == 0 {
 			continue
 		}
-		err = encoder.WriteDBHeader(uint(i), uint64(keyCount), uint64(ttlCount))
-		if err != nil {
-			return err
-		}
+		//encoder不支持stream, stream放到最后直接写入文件; db中只有stream时db header也要自己写
+		headerWritten := false
+		var streams []*rdbStream
 		// dump db
 		var err2 error
-		db.ForEach(i, func(key string, entity any, expiration *time.Time) bool {
+		snap.ForEach(i, func(key string, entity any, expiration *time.Time) bool {
+			if stream, ok := entity.(*Stream); ok {
+				streams = append(streams, &rdbStream{key: key, stream: stream, expiration: expiration})
+				return true
+			}
+			if !headerWritten {
+				if err2 = encoder.WriteDBHeader(uint(i), uint64(keyCount), uint64(ttlCount)); err2 != nil {
+					return false
+				}
+				headerWritten = true
+			}
 			var opts []interface{}
 			if expiration != nil {
 				opts = append(opts, rdb.WithTTL(uint64(expiration.UnixNano()/1e6)))
@@ -175,6 +178,18 @@ func (persister *Persister) generateRDB(ctx *RewriteCtx) error {
 		if err2 != nil {
 			return err2
 		}
+		if len(streams) > 0 {
+			w := &rdbStreamWriter{w: ctx.tmpFile}
+			if !headerWritten {
+				w.writeDBHeader(i, keyCount, ttlCount)
+			}
+			for _, stream := range streams {
+				w.writeStream(stream)
+			}
+			if w.err != nil {
+				return w.err
+			}
+		}
 	}
 	err = encoder.WriteEnd()
 	if err != nil {
@@ -0,0 +1,264 @@
+package src
+
+import (
+	"encoding/binary"
+	"io"
+	"math"
+	"savedb/src/data"
+	"strconv"
+	"time"
+)
+
+// rdb中的stream
+// 1.使用redis的RDB_TYPE_STREAM_LISTPACKS格式: 消息按streamNodeMaxEntries条一组写入listpack, key是这一组第一条消息的id
+// 2.listpack中每条消息的id保存为和第一条消息的差值, 字段和第一条消息一样时只保存值
+// 3.rdb库的encoder不支持stream, 这里直接写入文件, 文件末尾的校验和不包含这部分内容(加载时不校验)
+
+const (
+	rdbTypeStreamListPacks   = 15
+	rdbOpCodeExpireTimeMs    = 252
+	rdbOpCodeResizeDB        = 251
+	rdbOpCodeSelectDB        = 254
+	streamNodeMaxEntries     = 100
+	streamItemFlagSameFields = 1 << 1
+)
+
+type rdbStream struct {
+	key        string
+	stream     *Stream
+	expiration *time.Time
+}
+
+type rdbStreamWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (w *rdbStreamWriter) write(p []byte) {
+	if w.err != nil {
+		return
+	}
+	_, w.err = w.w.Write(p)
+}
+
+// rdb的长度编码
+func (w *rdbStreamWriter) writeLength(n uint64) {
+	switch {
+	case n < 1<<6:
+		w.write([]byte{byte(n)})
+	case n < 1<<14:
+		w.write([]byte{0x40 | byte(n>>8), byte(n)})
+	case n <= math.MaxUint32:
+		buf := make([]byte, 5)
+		buf[0] = 0x80
+		binary.BigEndian.PutUint32(buf[1:], uint32(n))
+		w.write(buf)
+	default:
+		buf := make([]byte, 9)
+		buf[0] = 0x81
+		binary.BigEndian.PutUint64(buf[1:], n)
+		w.write(buf)
+	}
+}
+
+func (w *rdbStreamWriter) writeString(s []byte) {
+	w.writeLength(uint64(len(s)))
+	w.write(s)
+}
+
+func (w *rdbStreamWriter) writeStreamID(id data.StreamID) {
+	w.writeLength(id.Ms)
+	w.writeLength(id.Seq)
+}
+
+// 16字节大端的id
+func rawStreamID(id data.StreamID) []byte {
+	buf := make([]byte, 16)
+	binary.BigEndian.PutUint64(buf, id.Ms)
+	binary.BigEndian.PutUint64(buf[8:], id.Seq)
+	return buf
+}
+
+func millisecondBytes(ms int64) []byte {
+	buf := make([]byte, 8)
+	binary.LittleEndian.PutUint64(buf, uint64(ms))
+	return buf
+}
+
+func (w *rdbStreamWriter) writeDBHeader(index int, keyCount, ttlCount int) {
+	w.write([]byte{rdbOpCodeSelectDB})
+	w.writeLength(uint64(index))
+	w.write([]byte{rdbOpCodeResizeDB})
+	w.writeLength(uint64(keyCount))
+	w.writeLength(uint64(ttlCount))
+}
+
+func (w *rdbStreamWriter) writeStream(obj *rdbStream) {
+	if obj.expiration != nil {
+		w.write([]byte{rdbOpCodeExpireTimeMs})
+		w.write(millisecondBytes(obj.expiration.UnixMilli()))
+	}
+	s := obj.stream.S
+	w.write([]byte{rdbTypeStreamListPacks})
+	w.writeString([]byte(obj.key))
+	var nodes [][]*data.StreamEntry
+	s.Range(data.StreamID{}, data.MaxStreamID, 0, false, func(entry *data.StreamEntry) bool {
+		if len(nodes) == 0 || len(nodes[len(nodes)-1]) == streamNodeMaxEntries {
+			nodes = append(nodes, make([]*data.StreamEntry, 0, streamNodeMaxEntries))
+		}
+		nodes[len(nodes)-1] = append(nodes[len(nodes)-1], entry)
+		return true
+	})
+	w.writeLength(uint64(len(nodes)))
+	for _, node := range nodes {
+		w.writeString(rawStreamID(node[0].ID))
+		w.writeString(streamNodeListpack(node))
+	}
+	w.writeLength(uint64(s.Len()))
+	w.writeStreamID(s.LastID)
+	groups := s.Groups()
+	w.writeLength(uint64(len(groups)))
+	for _, group := range groups {
+		w.writeString([]byte(group.Name))
+		w.writeStreamID(group.LastID)
+		w.writeLength(uint64(group.PendingLen()))
+		group.RangePending(data.StreamID{}, data.MaxStreamID, nil, func(nack *data.StreamNACK) bool {
+			w.write(rawStreamID(nack.ID))
+			w.write(millisecondBytes(nack.DeliveryTime))
+			w.writeLength(nack.DeliveryCount)
+			return true
+		})
+		consumers := group.Consumers()
+		w.writeLength(uint64(len(consumers)))
+		for _, consumer := range consumers {
+			w.writeString([]byte(consumer.Name))
+			w.write(millisecondBytes(consumer.SeenTime))
+			w.writeLength(uint64(consumer.PendingLen()))
+			group.RangePending(data.StreamID{}, data.MaxStreamID, consumer, func(nack *data.StreamNACK) bool {
+				w.write(rawStreamID(nack.ID))
+				return true
+			})
+		}
+	}
+}
+
+// 一组消息的listpack: 主消息(数量 删除数 字段数 字段... 0) 之后是每条消息(标志 id差值 [字段数 字段] 值... 元素个数)
+func streamNodeListpack(entries []*data.StreamEntry) []byte {
+	lp := &listpack{}
+	master := entries[0]
+	masterFields := len(master.Fields) / 2
+	lp.appendInt(int64(len(entries)))
+	lp.appendInt(0)
+	lp.appendInt(int64(masterFields))
+	for i := 0; i < len(master.Fields); i += 2 {
+		lp.appendString(master.Fields[i])
+	}
+	lp.appendInt(0)
+	for _, entry := range entries {
+		same := len(entry.Fields) == len(master.Fields)
+		for i := 0; same && i < len(entry.Fields); i += 2 {
+			same = entry.Fields[i] == master.Fields[i]
+		}
+		flags := int64(0)
+		if same {
+			flags = streamItemFlagSameFields
+		}
+		lp.appendInt(flags)
+		lp.appendInt(int64(entry.ID.Ms - master.ID.Ms))
+		lp.appendInt(int64(entry.ID.Seq - master.ID.Seq))
+		n := len(entry.Fields) / 2
+		if same {
+			for i := 1; i < len(entry.Fields); i += 2 {
+				lp.appendString(entry.Fields[i])
+			}
+			lp.appendInt(int64(n + 3))
+		} else {
+			lp.appendInt(int64(n))
+			for _, field := range entry.Fields {
+				lp.appendString(field)
+			}
+			lp.appendInt(int64(n*2 + 4))
+		}
+	}
+	return lp.bytes()
+}
+
+// redis的listpack编码, 只支持追加
+type listpack struct {
+	body  []byte
+	count int
+}
+
+func (lp *listpack) appendEntry(encoded []byte) {
+	lp.body = append(lp.body, encoded...)
+	//backlen: 元素长度的变长编码, 不超过127时和redis一样是一个字节
+	//更长的元素redis按大端写入, 但加载用的rdb库从前往后按小端读取, 这里按rdb库的方式写, 保证自己能加载
+	for l := len(encoded); ; l >>= 7 {
+		if l < 128 {
+			lp.body = append(lp.body, byte(l))
+			break
+		}
+		lp.body = append(lp.body, byte(l&127)|128)
+	}
+	lp.count++
+}
+
+func (lp *listpack) appendInt(v int64) {
+	var buf []byte
+	switch {
+	case v >= 0 && v <= 127:
+		buf = []byte{byte(v)}
+	case v >= -4096 && v <= 4095:
+		u := uint16(v) & 0x1fff
+		buf = []byte{0xc0 | byte(u>>8), byte(u)}
+	case v >= math.MinInt16 && v <= math.MaxInt16:
+		buf = []byte{0xf1, 0, 0}
+		binary.LittleEndian.PutUint16(buf[1:], uint16(v))
+	case v >= -1<<23 && v < 1<<23:
+		u := uint32(v)
+		buf = []byte{0xf2, byte(u), byte(u >> 8), byte(u >> 16)}
+	case v >= math.MinInt32 && v <= math.MaxInt32:
+		buf = []byte{0xf3, 0, 0, 0, 0}
+		binary.LittleEndian.PutUint32(buf[1:], uint32(v))
+	default:
+		buf = make([]byte, 9)
+		buf[0] = 0xf4
+		binary.LittleEndian.PutUint64(buf[1:], uint64(v))
+	}
+	lp.appendEntry(buf)
+}
+
+func (lp *listpack) appendString(s string) {
+	//和redis一样, 能表示成整数的字符串按整数保存
+	if v, err := strconv.ParseInt(s, 10, 64); err == nil && strconv.FormatInt(v, 10) == s {
+		lp.appendInt(v)
+		return
+	}
+	var buf []byte
+	switch l := len(s); {
+	case l < 64:
+		buf = append([]byte{0x80 | byte(l)}, s...)
+	case l < 4096:
+		buf = append([]byte{0xe0 | byte(l>>8), byte(l)}, s...)
+	default:
+		buf = make([]byte, 5, 5+l)
+		buf[0] = 0xf0
+		binary.LittleEndian.PutUint32(buf[1:], uint32(l))
+		buf = append(buf, s...)
+	}
+	lp.appendEntry(buf)
+}
+
+// 4字节总长度 + 2字节元素个数 + 元素 + 0xff
+func (lp *listpack) bytes() []byte {
+	total := 6 + len(lp.body) + 1
+	buf := make([]byte, 6, total)
+	binary.LittleEndian.PutUint32(buf, uint32(total))
+	count := lp.count
+	if count > math.MaxUint16-1 {
+		count = math.MaxUint16
+	}
+	binary.LittleEndian.PutUint16(buf[4:], uint16(count))
+	buf = append(buf, lp.body...)
+	return append(buf, 0xff)
+}
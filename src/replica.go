@@ -0,0 +1,325 @@
+package src
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	rdb "github.com/hdt3213/rdb/parser"
+	"net"
+	"savedb/src/log"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 主从复制 从节点
+// 1.REPLICAOF host port之后在后台连接主节点, 握手之后发送PSYNC, 断开后每秒重连一次
+// 2.第一次同步或者主节点的backlog中已经没有需要的数据时全量同步: 清空数据后加载主节点发来的rdb
+// 3.之后主节点发来的命令通过一个固定的连接执行, 每秒发送一次REPLCONF ACK汇报offset
+// 4.默认只读, 只有主节点发来的命令可以写数据
+
+const replReconnectInterval = time.Second
+
+var ReplSlave = &ReplicationSlave{}
+
+var errReadOnly = "READONLY You can't write against a read only replica."
+
+type ReplicationSlave struct {
+	mu         sync.Mutex
+	masterHost string
+	masterPort int
+	cancel     context.CancelFunc
+	//主节点的replid和已经执行的复制流的offset, 重连时用来部分同步
+	replId string
+	offset atomic.Int64
+	//执行主节点命令的连接, 部分同步时继续使用, 保留选中的db和事务状态
+	masterConn *Connection
+	linkUp     atomic.Bool
+	lastIO     atomic.Int64
+}
+
+func (s *ReplicationSlave) isReplica() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.masterHost != ""
+}
+
+// ReplicaOf REPLICAOF host port | REPLICAOF NO ONE
+func (s *ReplicationSlave) ReplicaOf(args []string) Reply {
+	if strings.ToLower(args[0]) == "no" && strings.ToLower(args[1]) == "one" {
+		s.stop()
+		log.SaveDBLogger.Info("replication stopped, now is a master")
+		return MakeStatusReply(OkStr)
+	}
+	port, err := strconv.Atoi(args[1])
+	if err != nil || port <= 0 || port > 65535 {
+		return MakeErrReply("ERR Invalid master port")
+	}
+	s.start(args[0], port)
+	return MakeStatusReply(OkStr)
+}
+
+func (s *ReplicationSlave) start(host string, port int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.cancel != nil {
+		s.cancel()
+	}
+	//不支持级联复制, 之前的从节点断开
+	ReplMaster.dropAllSlaves()
+	if s.masterHost != host || s.masterPort != port {
+		s.replId = ""
+	}
+	s.masterHost, s.masterPort = host, port
+	ctx, cancel := context.WithCancel(context.Background())
+	s.cancel = cancel
+	go s.run(ctx, host, port)
+	log.SaveDBLogger.Infof("replica of %s:%d", host, port)
+}
+
+func (s *ReplicationSlave) stop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.cancel == nil {
+		return
+	}
+	s.cancel()
+	s.cancel = nil
+	s.masterHost, s.masterPort = "", 0
+	s.linkUp.Store(false)
+	//成为主节点后使用新的replid, 之前的从节点需要全量同步
+	ReplMaster.resetReplId(s.offset.Load())
+}
+
+func (s *ReplicationSlave) run(ctx context.Context, host string, port int) {
+	for {
+		err := s.syncWithMaster(ctx, host, port)
+		s.linkUp.Store(false)
+		select {
+		case <-ctx.Done():
+			return
+		default:
+		}
+		log.SaveDBLogger.Warnf("replication link with %s:%d broken: %v", host, port, err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(replReconnectInterval):
+		}
+	}
+}
+
+func (s *ReplicationSlave) syncWithMaster(ctx context.Context, host string, port int) error {
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(host, strconv.Itoa(port)), 5*time.Second)
+	if err != nil {
+		return err
+	}
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-done:
+		}
+		_ = conn.Close()
+	}()
+	reader := bufio.NewReader(conn)
+	if err = handshake(conn, reader, ToCmdLine("PING")); err != nil {
+		return err
+	}
+	if err = handshake(conn, reader, ToCmdLine("REPLCONF", "listening-port", strconv.Itoa(respPort()))); err != nil {
+		return err
+	}
+	if err = handshake(conn, reader, ToCmdLine("REPLCONF", "capa", "psync2")); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	replId, offset := s.replId, s.offset.Load()+1
+	s.mu.Unlock()
+	if replId == "" {
+		replId, offset = "?", -1
+	}
+	if _, err = conn.Write(MakeMultiBulkReply(ToCmdLine("PSYNC", replId, strconv.FormatInt(offset, 10))).ToBytes()); err != nil {
+		return err
+	}
+	ch := ParseStream(reader)
+	defer func() {
+		go func() {
+			for range ch {
+			}
+		}()
+	}()
+	if err = s.receiveSync(ch); err != nil {
+		return err
+	}
+	s.linkUp.Store(true)
+	go s.sendAck(conn, done)
+	for p := range ch {
+		if p.Err != nil {
+			return p.Err
+		}
+		s.lastIO.Store(time.Now().Unix())
+		r, ok := p.Data.(*MultiBulkReply)
+		if !ok || len(r.Args) == 0 {
+			continue
+		}
+		s.masterConn.dispatch(BytesArrayToStringArray(r.Args))
+		s.offset.Add(int64(len(r.ToBytes())))
+	}
+	return errors.New("connection closed")
+}
+
+// 处理PSYNC的回复, 全量同步时加载rdb
+func (s *ReplicationSlave) receiveSync(ch <-chan *Payload) error {
+	p := <-ch
+	if p.Err != nil {
+		return p.Err
+	}
+	s.lastIO.Store(time.Now().Unix())
+	status, ok := p.Data.(*StatusReply)
+	if !ok {
+		return fmt.Errorf("unexpected PSYNC reply %s", string(p.Data.ToBytes()))
+	}
+	fields := strings.Fields(status.Status)
+	switch {
+	case len(fields) == 3 && fields[0] == "FULLRESYNC":
+		offset, err := strconv.ParseInt(fields[2], 10, 64)
+		if err != nil {
+			return fmt.Errorf("illegal FULLRESYNC offset %s", fields[2])
+		}
+		p = <-ch
+		if p.Err != nil {
+			return p.Err
+		}
+		rdbData, ok := p.Data.(*BulkReply)
+		if !ok {
+			return errors.New("expect rdb after FULLRESYNC")
+		}
+		if err = loadMasterRDB(rdbData.Arg); err != nil {
+			return err
+		}
+		s.mu.Lock()
+		s.replId = fields[1]
+		s.mu.Unlock()
+		s.offset.Store(offset)
+		s.masterConn = makeMasterConn()
+		log.SaveDBLogger.Infof("full resync finished, replid=%s offset=%d rdb=%d bytes", fields[1], offset, len(rdbData.Arg))
+	case len(fields) >= 1 && fields[0] == "CONTINUE":
+		//主节点的replid可能变了
+		if len(fields) == 2 {
+			s.mu.Lock()
+			s.replId = fields[1]
+			s.mu.Unlock()
+		}
+		if s.masterConn == nil {
+			s.masterConn = makeMasterConn()
+		}
+		log.SaveDBLogger.Infof("partial resync from offset %d", s.offset.Load()+1)
+	default:
+		return fmt.Errorf("unexpected PSYNC reply %s", status.Status)
+	}
+	return nil
+}
+
+func loadMasterRDB(data []byte) error {
+	FlushAll()
+	return Server.LoadRDB(rdb.NewDecoder(bytes.NewReader(data)))
+}
+
+// 主节点的命令不需要回复, Writer为nil
+func makeMasterConn() *Connection {
+	return &Connection{protocol: ProtocolResp2, fromMaster: true, dbIndex: 0}
+}
+
+func (s *ReplicationSlave) sendAck(conn net.Conn, done <-chan struct{}) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			ack := ToCmdLine("REPLCONF", "ACK", strconv.FormatInt(s.offset.Load(), 10))
+			if _, err := conn.Write(MakeMultiBulkReply(ack).ToBytes()); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// 发送握手命令并读取一行回复
+func handshake(conn net.Conn, reader *bufio.Reader, cmdLine CmdLine) error {
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+	defer func() {
+		_ = conn.SetDeadline(time.Time{})
+	}()
+	if _, err := conn.Write(MakeMultiBulkReply(cmdLine).ToBytes()); err != nil {
+		return err
+	}
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return err
+	}
+	if strings.HasPrefix(line, "-") {
+		return fmt.Errorf("%s failed: %s", string(cmdLine[0]), strings.TrimSpace(line[1:]))
+	}
+	return nil
+}
+
+// 从节点对外提供redis协议的端口, 通过REPLCONF listening-port告诉主节点
+func respPort() int {
+	if strings.ToLower(Config.Protocol) == ProtocolNameResp {
+		return Config.Port
+	}
+	for _, l := range Config.Listeners {
+		if strings.ToLower(l.Protocol) == ProtocolNameResp {
+			return l.Port
+		}
+	}
+	return Config.Port
+}
+
+// 只读的从节点拒绝客户端的写命令
+func (s *ReplicationSlave) rejectWrite(c *Connection, cmd string) bool {
+	if c.fromMaster || !Config.ReplicaReadOnly || !s.isReplica() {
+		return false
+	}
+	if commandFunc, ok := saveCommandMap[cmd]; !ok || !commandFunc.write {
+		return false
+	}
+	c.flagTxError()
+	c.writeReply(MakeErrReply(errReadOnly))
+	return true
+}
+
+func (s *ReplicationSlave) info(sb *strings.Builder) {
+	s.mu.Lock()
+	host, port, replId := s.masterHost, s.masterPort, s.replId
+	s.mu.Unlock()
+	status := "down"
+	if s.linkUp.Load() {
+		status = "up"
+	}
+	offset := strconv.FormatInt(s.offset.Load(), 10)
+	sb.WriteString("master_host:" + host + CRLF)
+	sb.WriteString("master_port:" + strconv.Itoa(port) + CRLF)
+	sb.WriteString("master_link_status:" + status + CRLF)
+	lastIO := int64(-1)
+	if s.lastIO.Load() > 0 {
+		lastIO = time.Now().Unix() - s.lastIO.Load()
+	}
+	sb.WriteString("master_last_io_seconds_ago:" + strconv.FormatInt(lastIO, 10) + CRLF)
+	sb.WriteString("slave_repl_offset:" + offset + CRLF)
+	readOnly := 0
+	if Config.ReplicaReadOnly {
+		readOnly = 1
+	}
+	sb.WriteString("slave_read_only:" + strconv.Itoa(readOnly) + CRLF)
+	sb.WriteString("connected_slaves:0" + CRLF)
+	sb.WriteString("master_replid:" + replId + CRLF)
+	sb.WriteString("master_repl_offset:" + offset + CRLF)
+}
@@ -0,0 +1,384 @@
+package src
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"savedb/src/log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 主从复制 主节点
+// 1.从节点发送PSYNC replid offset, replid一致并且offset还在backlog中时回复+CONTINUE, 只发送backlog中缺少的部分
+// 2.否则回复+FULLRESYNC replid offset, 通过GenerateRDBForReplication生成rdb发送给从节点, 生成期间的命令先缓存起来
+// 3.ReplicationMaster作为persister的Listener接收所有写命令, 写进backlog并转发给在线的从节点
+// 4.offset是主节点发出的复制流的字节数, 从节点通过REPLCONF ACK offset汇报自己的进度
+
+const (
+	defaultReplBacklogSize = 1 << 20
+	//全量同步时每次发送的rdb大小
+	replSyncChunkSize = 64 << 10
+)
+
+// 全量同步时从节点的输出队列一直满着, 超过这个时间就放弃同步
+var replSyncTimeout = 60 * time.Second
+
+var ReplMaster = MakeReplicationMaster()
+
+type ReplicationMaster struct {
+	mu     sync.Mutex
+	replId string
+	//master_repl_offset
+	offset int64
+	//第一个从节点连接之后才开始记录复制流
+	listening bool
+	//最近的复制流, 第一个字节的offset是offset-len(backlog)+1
+	backlog []byte
+	slaves  map[*Connection]*slaveState
+}
+
+type slaveState struct {
+	conn       *Connection
+	ip         string
+	port       int
+	online     bool
+	pending    []byte
+	ackOffset  int64
+	ackTime    time.Time
+	syncedTime time.Time
+}
+
+func MakeReplicationMaster() *ReplicationMaster {
+	return &ReplicationMaster{
+		replId: newReplId(),
+		slaves: make(map[*Connection]*slaveState),
+	}
+}
+
+func newReplId() string {
+	b := make([]byte, 20)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func replBacklogSize() int {
+	if Config.ReplBacklogSize <= 0 {
+		return defaultReplBacklogSize
+	}
+	return Config.ReplBacklogSize
+}
+
+// Callback 在写aof的协程中被调用, 调用时持有pausingAof锁, 顺序和aof一致
+func (m *ReplicationMaster) Callback(lines []CmdLine) {
+	var buf []byte
+	for _, line := range lines {
+		buf = append(buf, MakeMultiBulkReply(line).ToBytes()...)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.feed(buf)
+}
+
+func (m *ReplicationMaster) feed(buf []byte) {
+	m.offset += int64(len(buf))
+	m.backlog = append(m.backlog, buf...)
+	//超过两倍时再截断, 避免每次都拷贝
+	if size := replBacklogSize(); len(m.backlog) > 2*size {
+		m.backlog = append([]byte(nil), m.backlog[len(m.backlog)-size:]...)
+	}
+	for c, slave := range m.slaves {
+		if !slave.online {
+			slave.pending = append(slave.pending, buf...)
+			continue
+		}
+		if !c.pushRaw(buf) {
+			m.dropSlave(c, "output buffer overflow")
+		}
+	}
+}
+
+func (m *ReplicationMaster) backlogOffset() int64 {
+	return m.offset - int64(len(m.backlog)) + 1
+}
+
+// 从节点跟不上时断开连接, 重连后会尝试从backlog部分同步
+func (m *ReplicationMaster) dropSlave(c *Connection, reason string) {
+	delete(m.slaves, c)
+	log.SaveDBLogger.Warnf("drop replica %v: %s", c.RemoteAddr, reason)
+	if c.Conn != nil {
+		_ = c.Conn.Close()
+	}
+}
+
+// 成为从节点时断开所有从节点
+func (m *ReplicationMaster) dropAllSlaves() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for c := range m.slaves {
+		m.dropSlave(c, "master became a replica")
+	}
+}
+
+// RemoveSlave 连接关闭时调用
+func (m *ReplicationMaster) RemoveSlave(c *Connection) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.slaves, c)
+}
+
+// 阻塞地写入原始字节, 全量同步时使用; 超时或者连接断开时返回false
+func (c *Connection) pushRawWait(data []byte, timeout time.Duration) bool {
+	if c.Writer == nil {
+		return false
+	}
+	var closed <-chan struct{}
+	if c.closing != nil {
+		closed = c.closing.ch
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case c.Writer <- &Message{ReturnData: &data}:
+		return true
+	case <-timer.C:
+		return false
+	case <-closed:
+		return false
+	}
+}
+
+// 非阻塞地写入原始字节, 复制流不区分连接的协议
+func (c *Connection) pushRaw(data []byte) bool {
+	if c.Writer == nil {
+		return false
+	}
+	select {
+	case c.Writer <- &Message{ReturnData: &data}:
+		return true
+	default:
+		return false
+	}
+}
+
+// ReplConf REPLCONF listening-port <port> | capa <capa> | ack <offset>
+func (m *ReplicationMaster) ReplConf(c *Connection, args []string) Reply {
+	if len(args)%2 != 0 {
+		return MakeErrReply("ERR syntax error")
+	}
+	for i := 0; i < len(args); i += 2 {
+		switch strings.ToLower(args[i]) {
+		case "listening-port":
+			port, err := strconv.Atoi(args[i+1])
+			if err != nil {
+				return MakeErrReply("ERR value is not an integer or out of range")
+			}
+			c.replPort = port
+		case "ack":
+			offset, err := strconv.ParseInt(args[i+1], 10, 64)
+			if err != nil {
+				return nil
+			}
+			m.mu.Lock()
+			if slave, ok := m.slaves[c]; ok {
+				slave.ackOffset = offset
+				slave.ackTime = time.Now()
+			}
+			m.mu.Unlock()
+			//ACK不需要回复
+			return nil
+		case "capa":
+		default:
+			return MakeErrReply("ERR Unrecognized REPLCONF option: " + args[i])
+		}
+	}
+	return MakeStatusReply(OkStr)
+}
+
+// PSync PSYNC replid offset, 能部分同步时直接发送backlog, 否则在后台全量同步
+func (m *ReplicationMaster) PSync(c *Connection, args []string) {
+	if c.protocol == ProtocolLegacy {
+		c.writeReply(MakeErrReply("ERR PSYNC is only supported on resp listeners"))
+		return
+	}
+	if ReplSlave.isReplica() {
+		//暂不支持级联复制
+		c.writeReply(MakeErrReply("ERR PSYNC is not supported on a replica"))
+		return
+	}
+	if m.tryPartialSync(c, args[0], args[1]) {
+		return
+	}
+	go m.fullSync(c)
+}
+
+func (m *ReplicationMaster) tryPartialSync(c *Connection, replId string, rawOffset string) bool {
+	offset, err := strconv.ParseInt(rawOffset, 10, 64)
+	if err != nil {
+		return false
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.listening || replId != m.replId || offset < m.backlogOffset() || offset > m.offset+1 {
+		return false
+	}
+	data := []byte("+CONTINUE " + m.replId + CRLF)
+	data = append(data, m.backlog[offset-m.backlogOffset():]...)
+	if !c.pushRaw(data) {
+		return false
+	}
+	m.slaves[c] = m.newSlave(c, true)
+	log.SaveDBLogger.Infof("partial resync with replica %v from offset %d", c.RemoteAddr, offset)
+	return true
+}
+
+func (m *ReplicationMaster) newSlave(c *Connection, online bool) *slaveState {
+	ip := ""
+	if c.RemoteAddr != nil {
+		ip = strings.Split(c.RemoteAddr.String(), ":")[0]
+	}
+	now := time.Now()
+	return &slaveState{conn: c, ip: ip, port: c.replPort, online: online, ackTime: now, syncedTime: now}
+}
+
+func (m *ReplicationMaster) fullSync(c *Connection) {
+	persister := Server.persister
+	rdbFilename := filepath.Join(Config.Dir, fmt.Sprintf("replication-%d.rdb", c.id))
+	defer func() {
+		_ = os.Remove(rdbFilename)
+	}()
+	var replId string
+	var offset int64
+	//快照开始时所有之前的命令都已经经过了Callback, 之后的命令先缓存在pending中
+	err := persister.GenerateRDBForReplication(rdbFilename, nil, func() {
+		if !m.listening {
+			persister.addListener(m)
+		}
+		//下一条命令强制写入select, 从节点加载rdb后从db 0开始
+		persister.currentDB = -1
+		m.mu.Lock()
+		m.listening = true
+		replId, offset = m.replId, m.offset
+		m.slaves[c] = m.newSlave(c, false)
+		m.mu.Unlock()
+	})
+	if err != nil {
+		log.SaveDBLogger.Errorf("full resync with replica %v failed: %v", c.RemoteAddr, err)
+		m.RemoveSlave(c)
+		if c.Conn != nil {
+			_ = c.Conn.Close()
+		}
+		return
+	}
+	if err := m.sendRDB(c, replId, offset, rdbFilename); err != nil {
+		log.SaveDBLogger.Errorf("full resync with replica %v failed: %v", c.RemoteAddr, err)
+		m.RemoveSlave(c)
+		if c.Conn != nil {
+			_ = c.Conn.Close()
+		}
+	}
+}
+
+// 分块发送rdb, 发送期间的命令继续缓存在pending中, 发送完之后再补发pending并上线
+func (m *ReplicationMaster) sendRDB(c *Connection, replId string, offset int64, rdbFilename string) error {
+	file, err := os.Open(rdbFilename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	size := info.Size()
+	//rdb后面没有CRLF, 紧接着是生成rdb期间的命令
+	header := []byte(fmt.Sprintf("+FULLRESYNC %s %d\r\n$%d\r\n", replId, offset, size))
+	if !c.pushRawWait(header, replSyncTimeout) {
+		return errors.New("replica output buffer blocked")
+	}
+	var sent int64
+	for sent < size {
+		//Writer中的消息直到写出之前都会被引用, 每块都要新分配
+		chunk := make([]byte, replSyncChunkSize)
+		n, err := file.Read(chunk)
+		if n > 0 {
+			if !c.pushRawWait(chunk[:n], replSyncTimeout) {
+				return errors.New("replica output buffer blocked")
+			}
+			sent += int64(n)
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if sent != size {
+		return fmt.Errorf("rdb size changed while sending, expect %d, sent %d", size, sent)
+	}
+	for {
+		m.mu.Lock()
+		slave, ok := m.slaves[c]
+		if !ok {
+			m.mu.Unlock()
+			return errors.New("replica removed")
+		}
+		pending := slave.pending
+		slave.pending = nil
+		if len(pending) == 0 {
+			slave.online = true
+			slave.syncedTime = time.Now()
+			m.mu.Unlock()
+			break
+		}
+		m.mu.Unlock()
+		if !c.pushRawWait(pending, replSyncTimeout) {
+			return errors.New("replica output buffer blocked")
+		}
+	}
+	log.SaveDBLogger.Infof("full resync with replica %v finished, rdb %d bytes", c.RemoteAddr, size)
+	return nil
+}
+
+// 成为主节点时更换replid, 之前的从节点需要全量同步
+func (m *ReplicationMaster) resetReplId(offset int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.replId = newReplId()
+	m.offset = offset
+	m.backlog = nil
+}
+
+func (m *ReplicationMaster) info(sb *strings.Builder) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	sb.WriteString("connected_slaves:" + strconv.Itoa(len(m.slaves)) + CRLF)
+	i := 0
+	now := time.Now()
+	for _, slave := range m.slaves {
+		state := "wait_bgsave"
+		if slave.online {
+			state = "online"
+		}
+		sb.WriteString(fmt.Sprintf("slave%d:ip=%s,port=%d,state=%s,offset=%d,lag=%d\r\n",
+			i, slave.ip, slave.port, state, slave.ackOffset, int(now.Sub(slave.ackTime).Seconds())))
+		i++
+	}
+	sb.WriteString("master_replid:" + m.replId + CRLF)
+	sb.WriteString("master_repl_offset:" + strconv.FormatInt(m.offset, 10) + CRLF)
+	active := 0
+	if m.listening {
+		active = 1
+	}
+	sb.WriteString("repl_backlog_active:" + strconv.Itoa(active) + CRLF)
+	sb.WriteString("repl_backlog_size:" + strconv.Itoa(replBacklogSize()) + CRLF)
+	sb.WriteString("repl_backlog_first_byte_offset:" + strconv.FormatInt(m.backlogOffset(), 10) + CRLF)
+	sb.WriteString("repl_backlog_histlen:" + strconv.Itoa(len(m.backlog)) + CRLF)
+}
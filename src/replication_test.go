@@ -0,0 +1,130 @@
+package src
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReplBacklog(t *testing.T) {
+	initTestLog(t)
+	Config.ReplBacklogSize = 64
+	defer func() {
+		Config.ReplBacklogSize = 0
+	}()
+	m := MakeReplicationMaster()
+	m.listening = true
+	var stream []byte
+	for i := 0; i < 10; i++ {
+		line := ToCmdLine("set", "k", strings.Repeat("v", i))
+		stream = append(stream, MakeMultiBulkReply(line).ToBytes()...)
+		m.Callback([]CmdLine{line})
+	}
+	if m.offset != int64(len(stream)) {
+		t.Fatalf("expect offset %d, actual %d", len(stream), m.offset)
+	}
+	if len(m.backlog) > 2*64 {
+		t.Fatalf("backlog not trimmed, len %d", len(m.backlog))
+	}
+	//部分同步只发送缺少的部分
+	c := makePubSubTestConn(ProtocolResp2, 8)
+	from := m.offset - 20 + 1
+	if !m.tryPartialSync(c, m.replId, strconv.FormatInt(from, 10)) {
+		t.Fatal("expect partial resync")
+	}
+	msg := <-c.Writer
+	expect := append([]byte("+CONTINUE "+m.replId+CRLF), stream[len(stream)-20:]...)
+	if !bytes.Equal(*msg.ReturnData, expect) {
+		t.Fatalf("unexpected partial resync data %q", *msg.ReturnData)
+	}
+	//之后的命令直接转发
+	m.Callback([]CmdLine{ToCmdLine("del", "k")})
+	if msg = <-c.Writer; string(*msg.ReturnData) != "*2\r\n$3\r\ndel\r\n$1\r\nk\r\n" {
+		t.Fatalf("unexpected stream %q", *msg.ReturnData)
+	}
+	//replid不一致或者已经不在backlog中时需要全量同步
+	c2 := makePubSubTestConn(ProtocolResp2, 8)
+	if m.tryPartialSync(c2, "?", "-1") || m.tryPartialSync(c2, m.replId, "1") || m.tryPartialSync(c2, newReplId(), strconv.FormatInt(m.offset, 10)) {
+		t.Fatal("expect full resync")
+	}
+}
+
+func TestReplicaRejectWrite(t *testing.T) {
+	readOnly := Config.ReplicaReadOnly
+	Config.ReplicaReadOnly = true
+	defer func() {
+		Config.ReplicaReadOnly = readOnly
+	}()
+	s := &ReplicationSlave{masterHost: "127.0.0.1", masterPort: 6379}
+	c := makePubSubTestConn(ProtocolResp2, 8)
+	for _, cmd := range []string{"set", "zremrangebyscore", "flushall", "flushdb", "xreadgroup"} {
+		if !s.rejectWrite(c, cmd) {
+			t.Errorf("%s should be rejected", cmd)
+			continue
+		}
+		if msg := <-c.Writer; string(*msg.ReturnData) != "-"+errReadOnly+CRLF {
+			t.Errorf("unexpected reply %q", *msg.ReturnData)
+		}
+	}
+	for _, cmd := range []string{"get", "touch", "xread", "publish", "ping"} {
+		if s.rejectWrite(c, cmd) {
+			t.Errorf("%s should not be rejected", cmd)
+		}
+	}
+	c.fromMaster = true
+	if s.rejectWrite(c, "set") {
+		t.Error("commands from the master should not be rejected")
+	}
+}
+
+func TestReplSendRDB(t *testing.T) {
+	initTestLog(t)
+	rdbFilename := filepath.Join(t.TempDir(), "replication.rdb")
+	rdbData := bytes.Repeat([]byte("0123456789"), 3*replSyncChunkSize/10+7)
+	if err := os.WriteFile(rdbFilename, rdbData, 0644); err != nil {
+		t.Fatal(err)
+	}
+	m := MakeReplicationMaster()
+	c := makePubSubTestConn(ProtocolResp2, 2)
+	m.slaves[c] = m.newSlave(c, false)
+	m.slaves[c].pending = []byte("*1\r\n$4\r\nping\r\n")
+	expect := []byte("+FULLRESYNC " + m.replId + " 0\r\n$" + strconv.Itoa(len(rdbData)) + "\r\n")
+	expect = append(append(expect, rdbData...), "*1\r\n$4\r\nping\r\n"...)
+	//rdb比输出队列大很多, 需要边发送边读取
+	received := make(chan []byte)
+	go func() {
+		var buf []byte
+		for len(buf) < len(expect) {
+			buf = append(buf, *(<-c.Writer).ReturnData...)
+		}
+		received <- buf
+	}()
+	if err := m.sendRDB(c, m.replId, 0, rdbFilename); err != nil {
+		t.Fatal(err)
+	}
+	if buf := <-received; !bytes.Equal(buf, expect) {
+		t.Fatalf("unexpected full resync stream, len %d, expect %d", len(buf), len(expect))
+	}
+	if !m.slaves[c].online || m.slaves[c].pending != nil {
+		t.Fatal("replica should be online after full resync")
+	}
+
+	//没有读取时超时失败, 而不是丢弃数据
+	timeout := replSyncTimeout
+	replSyncTimeout = 50 * time.Millisecond
+	defer func() {
+		replSyncTimeout = timeout
+	}()
+	c2 := makePubSubTestConn(ProtocolResp2, 2)
+	m.slaves[c2] = m.newSlave(c2, false)
+	if err := m.sendRDB(c2, m.replId, 0, rdbFilename); err == nil {
+		t.Fatal("expect full resync to fail when the replica does not read")
+	}
+	if m.slaves[c2].online {
+		t.Fatal("replica should not be online after a failed full resync")
+	}
+}
@@ -2,6 +2,7 @@ package src
 
 import (
 	"bytes"
+	"math"
 	"strconv"
 )
 
@@ -9,7 +10,23 @@ type Reply interface {
 	ToBytes() []byte
 }
 
+// Resp3Reply is implemented by replies whose RESP3 encoding differs from RESP2
+type Resp3Reply interface {
+	ToResp3Bytes() []byte
+}
+
+// ToProtocolBytes marshal reply with the protocol of the connection
+func ToProtocolBytes(reply Reply, protocol int) []byte {
+	if protocol == ProtocolResp3 {
+		if r, ok := reply.(Resp3Reply); ok {
+			return r.ToResp3Bytes()
+		}
+	}
+	return reply.ToBytes()
+}
+
 var nullBulkBytes = []byte("$-1\r\n")
+var resp3NullBytes = []byte("_\r\n")
 
 /* ---- Bulk Reply ---- */
 
@@ -47,6 +64,15 @@ func MakeMultiBulkReply(args [][]byte) *MultiBulkReply {
 	}
 }
 
+// MakeStringsReply creates MultiBulkReply from strings
+func MakeStringsReply(values []string) *MultiBulkReply {
+	args := make([][]byte, len(values))
+	for i, v := range values {
+		args[i] = []byte(v)
+	}
+	return MakeMultiBulkReply(args)
+}
+
 // ToBytes marshal redis.Reply
 func (r *MultiBulkReply) ToBytes() []byte {
 	argLen := len(r.Args)
@@ -87,6 +113,59 @@ func (r *MultiRawReply) ToBytes() []byte {
 	return buf.Bytes()
 }
 
+// ToResp3Bytes marshal nested replies with RESP3
+func (r *MultiRawReply) ToResp3Bytes() []byte {
+	argLen := len(r.Replies)
+	var buf bytes.Buffer
+	buf.WriteString("*" + strconv.Itoa(argLen) + CRLF)
+	for _, arg := range r.Replies {
+		buf.Write(ToProtocolBytes(arg, ProtocolResp3))
+	}
+	return buf.Bytes()
+}
+
+/* ---- Map Reply ---- */
+
+// MapReply stores key-value pairs, it is a flat array in RESP2 and a map in RESP3
+type MapReply struct {
+	Keys   []Reply
+	Values []Reply
+}
+
+// MakeMapReply creates MapReply
+func MakeMapReply() *MapReply {
+	return &MapReply{}
+}
+
+// Put appends a key-value pair
+func (r *MapReply) Put(key Reply, value Reply) *MapReply {
+	r.Keys = append(r.Keys, key)
+	r.Values = append(r.Values, value)
+	return r
+}
+
+// ToBytes marshal redis.Reply
+func (r *MapReply) ToBytes() []byte {
+	var buf bytes.Buffer
+	buf.WriteString("*" + strconv.Itoa(len(r.Keys)*2) + CRLF)
+	for i, key := range r.Keys {
+		buf.Write(key.ToBytes())
+		buf.Write(r.Values[i].ToBytes())
+	}
+	return buf.Bytes()
+}
+
+// ToResp3Bytes marshal redis.Reply
+func (r *MapReply) ToResp3Bytes() []byte {
+	var buf bytes.Buffer
+	buf.WriteString("%" + strconv.Itoa(len(r.Keys)) + CRLF)
+	for i, key := range r.Keys {
+		buf.Write(ToProtocolBytes(key, ProtocolResp3))
+		buf.Write(ToProtocolBytes(r.Values[i], ProtocolResp3))
+	}
+	return buf.Bytes()
+}
+
 /* ---- Status Reply ---- */
 
 // StatusReply stores a simple status string
@@ -106,6 +185,11 @@ func (r *StatusReply) ToBytes() []byte {
 	return []byte("+" + r.Status + CRLF)
 }
 
+// MakeOkReply creates +OK
+func MakeOkReply() *StatusReply {
+	return MakeStatusReply(OkStr)
+}
+
 // IsOKReply returns true if the given protocol is +OK
 func IsOKReply(reply Reply) bool {
 	return string(reply.ToBytes()) == "+OK\r\n"
@@ -130,6 +214,40 @@ func (r *IntReply) ToBytes() []byte {
 	return []byte(":" + strconv.FormatInt(r.Code, 10) + CRLF)
 }
 
+/* ---- Double Reply ---- */
+
+// DoubleReply stores a float number, it is a bulk string in RESP2 and a double in RESP3
+type DoubleReply struct {
+	Value float64
+}
+
+// MakeDoubleReply creates DoubleReply
+func MakeDoubleReply(value float64) *DoubleReply {
+	return &DoubleReply{
+		Value: value,
+	}
+}
+
+func formatDouble(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "inf"
+	case math.IsInf(value, -1):
+		return "-inf"
+	}
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
+
+// ToBytes marshal redis.Reply
+func (r *DoubleReply) ToBytes() []byte {
+	return MakeBulkReply([]byte(formatDouble(r.Value))).ToBytes()
+}
+
+// ToResp3Bytes marshal redis.Reply
+func (r *DoubleReply) ToResp3Bytes() []byte {
+	return []byte("," + formatDouble(r.Value) + CRLF)
+}
+
 /* ---- Error Reply ---- */
 
 // ErrorReply is an error and redis.Reply
@@ -163,3 +281,32 @@ func (r *StandardErrReply) ToBytes() []byte {
 func (r *StandardErrReply) Error() string {
 	return r.Status
 }
+
+/* ---- Push Reply ---- */
+
+// PushReply is out-of-band data such as pub/sub messages, it is an array in RESP2 and a push type in RESP3
+type PushReply struct {
+	Replies []Reply
+}
+
+// MakePushReply creates PushReply
+func MakePushReply(replies ...Reply) *PushReply {
+	return &PushReply{
+		Replies: replies,
+	}
+}
+
+// ToBytes marshal redis.Reply
+func (r *PushReply) ToBytes() []byte {
+	return MakeMultiRawReply(r.Replies).ToBytes()
+}
+
+// ToResp3Bytes marshal redis.Reply
+func (r *PushReply) ToResp3Bytes() []byte {
+	var buf bytes.Buffer
+	buf.WriteString(">" + strconv.Itoa(len(r.Replies)) + CRLF)
+	for _, arg := range r.Replies {
+		buf.Write(ToProtocolBytes(arg, ProtocolResp3))
+	}
+	return buf.Bytes()
+}
@@ -0,0 +1,114 @@
+package src
+
+import (
+	"errors"
+	"io"
+	"net"
+	"savedb/src/log"
+	"strconv"
+	"strings"
+)
+
+const serverVersion = "1.0.0"
+
+// 使用redis协议读取请求, 复用aof的解析器ParseStream
+func (c *Connection) readResp() {
+	ch := ParseStream(&closeNotifyReader{c: c})
+	defer func() {
+		//连接关闭后解析协程可能还在写channel
+		go func() {
+			for range ch {
+			}
+		}()
+	}()
+	for p := range ch {
+		if p.Err != nil {
+			if p.Err == io.EOF || errors.Is(p.Err, io.ErrUnexpectedEOF) || errors.Is(p.Err, net.ErrClosed) {
+				log.SaveDBLogger.Infof("connection closed conn=%v", c.Conn.RemoteAddr())
+				return
+			}
+			//和redis一样, 协议错误之后回复错误并关闭连接, 后面的数据已经没法正确解析了
+			if strings.HasPrefix(p.Err.Error(), "protocol error") {
+				c.writeReplyAndWait(MakeErrReply("ERR " + p.Err.Error()))
+				return
+			}
+			log.SaveDBLogger.Errorf("Read data error %v, conn=%v", p.Err, c.Conn.RemoteAddr())
+			return
+		}
+		r, ok := p.Data.(*MultiBulkReply)
+		if !ok {
+			c.writeReply(MakeErrReply("ERR Protocol error: expected array of bulk strings"))
+			continue
+		}
+		if len(r.Args) == 0 {
+			continue
+		}
+		c.dispatch(BytesArrayToStringArray(r.Args))
+	}
+}
+
+// errReply 命令返回的错误, 没有错误码时加上ERR
+func errReply(msg string) *StandardErrReply {
+	if !hasErrorCode(msg) {
+		msg = "ERR " + msg
+	}
+	return MakeErrReply(msg)
+}
+
+// 对类型不对的key执行命令时返回的错误
+var errWrongType = errors.New("WRONGTYPE Operation against a key holding the wrong kind of value")
+
+// 错误信息是否已经带有redis风格的错误码, 比如 ERR WRONGTYPE NOPROTO
+func hasErrorCode(msg string) bool {
+	i := strings.IndexByte(msg, ' ')
+	if i <= 0 {
+		return false
+	}
+	code := msg[:i]
+	for _, ch := range code {
+		if ch < 'A' || ch > 'Z' {
+			return false
+		}
+	}
+	return true
+}
+
+// Hello HELLO [protover [AUTH username password] [SETNAME clientname]]
+func Hello(c *Connection, args []string) Reply {
+	if len(args) > 0 {
+		ver, err := strconv.Atoi(args[0])
+		if err != nil {
+			return MakeErrReply("ERR Protocol version is not an integer or out of range")
+		}
+		if ver != ProtocolResp2 && ver != ProtocolResp3 {
+			return MakeErrReply("NOPROTO unsupported protocol version")
+		}
+		for i := 1; i < len(args); i++ {
+			switch strings.ToLower(args[i]) {
+			case "auth":
+				//暂时没有鉴权, 只检查参数
+				if i+2 >= len(args) {
+					return MakeErrReply("ERR Syntax error in HELLO option 'auth'")
+				}
+				i += 2
+			case "setname":
+				if i+1 >= len(args) {
+					return MakeErrReply("ERR Syntax error in HELLO option 'setname'")
+				}
+				c.name = args[i+1]
+				i++
+			default:
+				return MakeErrReply("ERR Syntax error in HELLO option '" + args[i] + "'")
+			}
+		}
+		c.protocol = ver
+	}
+	return MakeMapReply().
+		Put(MakeBulkReply([]byte("server")), MakeBulkReply([]byte("savedb"))).
+		Put(MakeBulkReply([]byte("version")), MakeBulkReply([]byte(serverVersion))).
+		Put(MakeBulkReply([]byte("proto")), MakeIntReply(int64(c.protocol))).
+		Put(MakeBulkReply([]byte("id")), MakeIntReply(c.id)).
+		Put(MakeBulkReply([]byte("mode")), MakeBulkReply([]byte("standalone"))).
+		Put(MakeBulkReply([]byte("role")), MakeBulkReply([]byte("master"))).
+		Put(MakeBulkReply([]byte("modules")), MakeEmptyMultiBulkReply())
+}
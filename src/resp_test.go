@@ -0,0 +1,112 @@
+package src
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestErrReply(t *testing.T) {
+	cases := []struct {
+		msg    string
+		expect string
+	}{
+		{"key not exist", "-ERR key not exist\r\n"},
+		{"ERR syntax error", "-ERR syntax error\r\n"},
+		{"WRONGTYPE Operation against a key holding the wrong kind of value", "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n"},
+	}
+	for _, c := range cases {
+		actual := string(errReply(c.msg).ToBytes())
+		if actual != c.expect {
+			t.Errorf("expect %q, actual %q", c.expect, actual)
+		}
+	}
+}
+
+func TestReadReply(t *testing.T) {
+	reply := MakeMultiRawReply([]Reply{
+		MakeBulkReply([]byte("a,b")),
+		MakeIntReply(2),
+		MakeNullBulkReply(),
+		MakeMultiBulkReply([][]byte{[]byte("x"), []byte("y")}),
+		MakeDoubleReply(1.5),
+	})
+	decoded, err := ReadReply(bufio.NewReader(bytes.NewReader(reply.ToBytes())))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(decoded.ToBytes()) != string(reply.ToBytes()) {
+		t.Errorf("expect %q, actual %q", reply.ToBytes(), decoded.ToBytes())
+	}
+	expect := "1) \"a,b\"\n2) (integer) 2\n3) (nil)\n4) 1) \"x\"\n   2) \"y\"\n5) \"1.5\""
+	if actual := FormatReply(decoded); actual != expect {
+		t.Errorf("expect %q, actual %q", expect, actual)
+	}
+}
+
+func TestHello(t *testing.T) {
+	c := &Connection{protocol: ProtocolResp2}
+	reply := Hello(c, []string{"3", "SETNAME", "worker"})
+	if c.protocol != ProtocolResp3 || c.name != "worker" {
+		t.Fatalf("hello did not switch protocol, protocol=%d name=%s", c.protocol, c.name)
+	}
+	data := string(ToProtocolBytes(reply, c.protocol))
+	if data[0] != '%' {
+		t.Errorf("expect resp3 map, actual %q", data)
+	}
+	if string(ToProtocolBytes(MakeNullBulkReply(), ProtocolResp3)) != "_\r\n" {
+		t.Error("resp3 null error")
+	}
+	reply = Hello(c, []string{"4"})
+	if !IsErrorReply(reply) {
+		t.Errorf("expect NOPROTO, actual %q", reply.ToBytes())
+	}
+}
+
+func TestParseStreamRejectsIllegalLength(t *testing.T) {
+	initTestLog(t)
+	cases := []string{
+		"*1\r\n$99999999999999\r\n",
+		"*99999999999999\r\n",
+		"$99999999999999\r\n",
+		"*2\r\n$3\r\nget\r\nfoo\r\n",
+	}
+	for _, data := range cases {
+		var payloads []*Payload
+		for p := range ParseStream(bytes.NewReader([]byte(data))) {
+			payloads = append(payloads, p)
+		}
+		//只有一个协议错误, 不能产生不完整的命令
+		if len(payloads) != 1 || payloads[0].Err == nil || !strings.HasPrefix(payloads[0].Err.Error(), "protocol error") {
+			t.Errorf("%q: expect protocol error, actual %v", data, payloads)
+		}
+	}
+}
+
+func TestProtocolErrorClosesConnection(t *testing.T) {
+	initTestLog(t)
+	server, client := net.Pipe()
+	c := &Connection{protocol: ProtocolResp2, Conn: server, Writer: make(chan *Message, WriterQueueSize), Close: &atomic.Bool{}}
+	go c.ReadMsg()
+	go c.WriterMsg()
+	go func() {
+		_, _ = client.Write([]byte("*2\r\n$3\r\nget\r\nfoo\r\n"))
+	}()
+	_ = client.SetReadDeadline(time.Now().Add(5 * time.Second))
+	reader := bufio.NewReader(client)
+	reply, err := ReadReply(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsErrorReply(reply) {
+		t.Errorf("expect protocol error reply, actual %q", reply.ToBytes())
+	}
+	if _, err = reader.ReadByte(); err != io.EOF {
+		t.Errorf("connection should be closed, actual %v", err)
+	}
+}
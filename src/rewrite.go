@@ -2,22 +2,19 @@ package src
 
 import "C"
 import (
+	"errors"
 	"io"
 	"os"
 	"savedb/src/log"
 	"strconv"
+	"time"
 )
 
-func (persister *Persister) newRewriteHandler() *Persister {
-	h := &Persister{}
-	h.db = persister.tmpDBMaker()
-	return h
-}
-
 type RewriteCtx struct {
 	tmpFile  *os.File // tmpFile is the file handler of aof tmpFile
 	fileSize int64
 	dbIdx    int // selected db index when startRewrite
+	snapshot *Snapshot
 }
 
 func (persister *Persister) Rewrite() error {
@@ -35,6 +32,7 @@ func (persister *Persister) Rewrite() error {
 }
 
 func (persister *Persister) DoRewrite(ctx *RewriteCtx) (err error) {
+	defer ctx.snapshot.Release()
 	// start rewrite
 	if !Config.AofUseRdbPreamble {
 		log.SaveDBLogger.Info("generate aof preamble")
@@ -42,35 +40,68 @@ func (persister *Persister) DoRewrite(ctx *RewriteCtx) (err error) {
 	} else {
 		log.SaveDBLogger.Info("generate rdb preamble")
 		err = persister.generateRDB(ctx)
+		if err == nil {
+			err = persister.generateFieldExpires(ctx)
+		}
 	}
 	return err
 }
 
-func (persister *Persister) StartRewrite() (*RewriteCtx, error) {
-	// pausing aof
-	persister.pausingAof.Lock()
-	defer persister.pausingAof.Unlock()
+// rdb中没有hash field的过期时间, 在rdb前缀之后用hpexpireat补上
+func (persister *Persister) generateFieldExpires(ctx *RewriteCtx) error {
+	snap := ctx.snapshot
+	for i := 0; i < dbsSize; i++ {
+		if size, _ := snap.KeyCount(i); size <= 0 {
+			continue
+		}
+		selected := false
+		var err error
+		snap.ForEach(i, func(key string, entity any, expiration *time.Time) bool {
+			hash, ok := entity.(*Hash)
+			if !ok || len(hash.E) == 0 {
+				return true
+			}
+			if !selected {
+				if _, err = ctx.tmpFile.Write(ToBytes(ToCmdLine("select", strconv.Itoa(i)))); err != nil {
+					return false
+				}
+				selected = true
+			}
+			for _, line := range fieldExpireCmds(key, hash) {
+				if _, err = ctx.tmpFile.Write(ToBytes(line)); err != nil {
+					return false
+				}
+			}
+			return true
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
-	err := persister.aofFile.Sync()
+func (persister *Persister) StartRewrite() (*RewriteCtx, error) {
+	if persister.aofFile == nil {
+		return nil, errors.New("appendonly is not enabled")
+	}
+	snap, err := persister.startSnapshot(nil)
 	if err != nil {
-		log.SaveDBLogger.Warn("fsync failed")
 		return nil, err
 	}
 
-	// get current aof file size
-	fileInfo, _ := os.Stat(GetAofFilePath())
-	filesize := fileInfo.Size()
-
 	// create tmp file
 	file, err := os.CreateTemp(Config.Dir, "*.aof")
 	if err != nil {
+		snap.Release()
 		log.SaveDBLogger.Warn("tmp file create failed")
 		return nil, err
 	}
 	return &RewriteCtx{
 		tmpFile:  file,
-		fileSize: filesize,
-		dbIdx:    persister.currentDB,
+		fileSize: snap.aofSize,
+		dbIdx:    snap.dbIndex,
+		snapshot: snap,
 	}, nil
 }
 
@@ -99,13 +130,13 @@ func (persister *Persister) FinishRewrite(ctx *RewriteCtx) {
 			log.SaveDBLogger.Error("seek failed: " + err.Error())
 			return true
 		}
-		//文件尾部切换到当前的数据
-		//data := ToBytes(ToCmdLine("select", strconv.Itoa(ctx.dbIdx)))
-		//_, err = tmpFile.Write(data)
-		//if err != nil {
-		//	log.SaveDBLogger.Error("tmp file rewrite failed: " + err.Error())
-		//	return true
-		//}
+		//重写的内容最后选中的db不一定是开始重写时的db, 尾部先切换到开始重写时的db
+		data := ToBytes(ToCmdLine("select", strconv.Itoa(ctx.dbIdx)))
+		_, err = tmpFile.Write(data)
+		if err != nil {
+			log.SaveDBLogger.Error("tmp file rewrite failed: " + err.Error())
+			return true
+		}
 		//把src复制到temp中 只复制重写期间产生的数据
 		_, err = io.Copy(tmpFile, src)
 		if err != nil {
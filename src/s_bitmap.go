@@ -0,0 +1,500 @@
+package src
+
+import (
+	"math"
+	"math/bits"
+	"strconv"
+	"strings"
+)
+
+// 位图
+// 1.位图就是普通的字符串, 第0位是第一个字节的最高位, 写入超过长度的位置时用0补齐
+// 2.写命令原样记录到aof, 重放时结果一样; rdb中和字符串一样保存
+// 3.BITFIELD把字符串看成任意宽度的有符号/无符号整数数组, OVERFLOW控制INCRBY和SET溢出时的行为
+
+// 位的偏移不能超过512MB
+const maxBitOffset = maxStringLength*8 - 1
+
+func parseBitOffset(arg string) (int64, bool) {
+	offset, err := strconv.ParseInt(arg, 10, 64)
+	if err != nil || offset < 0 || offset > maxBitOffset {
+		return 0, false
+	}
+	return offset, true
+}
+
+func getBit(b []byte, offset int64) byte {
+	i := offset >> 3
+	if i >= int64(len(b)) {
+		return 0
+	}
+	return b[i] >> (7 - uint(offset&7)) & 1
+}
+
+func setBit(b []byte, offset int64, bit byte) {
+	mask := byte(1) << (7 - uint(offset&7))
+	if bit == 1 {
+		b[offset>>3] |= mask
+	} else {
+		b[offset>>3] &^= mask
+	}
+}
+
+// 保证字符串至少有size个字节, 新增的部分是0
+func growString(b []byte, size int64) []byte {
+	if int64(len(b)) >= size {
+		return b
+	}
+	return append(b, make([]byte, size-int64(len(b)))...)
+}
+
+// SetBit SETBIT key offset value, 回复原来的位
+func SetBit(db *SaveDBTables, args []string) Reply {
+	key := args[0]
+	offset, ok := parseBitOffset(args[1])
+	if !ok {
+		return errReply("ERR bit offset is not an integer or out of range")
+	}
+	if args[2] != "0" && args[2] != "1" {
+		return errReply("ERR bit is not an integer or out of range")
+	}
+	value, err := db.GetString(key)
+	if err != nil {
+		return errReply(err.Error())
+	}
+	value = growString(value, offset>>3+1)
+	old := getBit(value, offset)
+	setBit(value, offset, args[2][0]-'0')
+	db.putString(key, value, true)
+	db.addAof(ToCmdLine2("setbit", args...))
+	return MakeIntReply(int64(int(old)))
+}
+
+// GetBit GETBIT key offset
+func GetBit(db *SaveDBTables, args []string) Reply {
+	offset, ok := parseBitOffset(args[1])
+	if !ok {
+		return errReply("ERR bit offset is not an integer or out of range")
+	}
+	value, err := db.GetString(args[0])
+	if err != nil {
+		return errReply(err.Error())
+	}
+	return MakeIntReply(int64(int(getBit(value, offset))))
+}
+
+// 解析 start end [BYTE|BIT], 转换成位的区间[start, end], 负数表示从末尾开始. empty表示区间为空
+func parseBitRange(args []string, size int64) (start int64, end int64, empty bool, err string) {
+	unit := "byte"
+	if len(args) == 3 {
+		unit = strings.ToLower(args[2])
+		if unit != "byte" && unit != "bit" {
+			return 0, 0, false, "ERR syntax error"
+		}
+	}
+	total := size
+	if unit == "bit" {
+		total = size * 8
+	}
+	start, e1 := strconv.ParseInt(args[0], 10, 64)
+	end, e2 := strconv.ParseInt(args[1], 10, 64)
+	if e1 != nil || e2 != nil {
+		return 0, 0, false, "ERR value is not an integer or out of range"
+	}
+	if start < 0 {
+		start += total
+	}
+	if end < 0 {
+		end += total
+	}
+	if start < 0 {
+		start = 0
+	}
+	if end < 0 {
+		end = 0
+	}
+	if end >= total {
+		end = total - 1
+	}
+	if start > end || total == 0 {
+		return 0, 0, true, ""
+	}
+	if unit == "byte" {
+		start, end = start*8, end*8+7
+	}
+	return start, end, false, ""
+}
+
+// BitCount BITCOUNT key [start end [BYTE|BIT]]
+func BitCount(db *SaveDBTables, args []string) Reply {
+	if len(args) != 1 && len(args) != 3 && len(args) != 4 {
+		return errReply("ERR syntax error")
+	}
+	value, err := db.GetString(args[0])
+	if err != nil {
+		return errReply(err.Error())
+	}
+	start, end := int64(0), int64(len(value))*8-1
+	if len(args) > 1 {
+		var empty bool
+		var msg string
+		start, end, empty, msg = parseBitRange(args[1:], int64(len(value)))
+		if msg != "" {
+			return errReply(msg)
+		}
+		if empty {
+			return MakeIntReply(0)
+		}
+	}
+	count := 0
+	//首尾不完整的字节逐位计算, 中间的字节直接数1的个数
+	for start <= end && start&7 != 0 {
+		count += int(getBit(value, start))
+		start++
+	}
+	for start+7 <= end {
+		count += bits.OnesCount8(value[start>>3])
+		start += 8
+	}
+	for ; start <= end; start++ {
+		count += int(getBit(value, start))
+	}
+	return MakeIntReply(int64(count))
+}
+
+// BitPos BITPOS key bit [start [end [BYTE|BIT]]]
+func BitPos(db *SaveDBTables, args []string) Reply {
+	if len(args) < 2 || len(args) > 5 {
+		return errReply("ERR wrong number of arguments for 'bitpos' command")
+	}
+	if args[1] != "0" && args[1] != "1" {
+		return errReply("ERR The bit argument must be 1 or 0.")
+	}
+	bit := args[1][0] - '0'
+	value, err := db.GetString(args[0])
+	if err != nil {
+		return errReply(err.Error())
+	}
+	if value == nil {
+		if bit == 1 {
+			return MakeIntReply(-1)
+		}
+		return MakeIntReply(0)
+	}
+	size := int64(len(value))
+	start, end := int64(0), size*8-1
+	//没有指定end时找0可以返回字符串之后的位置
+	endGiven := len(args) >= 4
+	if len(args) > 2 {
+		rangeArgs := append([]string{args[2]}, "-1")
+		if endGiven {
+			rangeArgs = args[2:]
+		}
+		var empty bool
+		var msg string
+		start, end, empty, msg = parseBitRange(rangeArgs, size)
+		if msg != "" {
+			return errReply(msg)
+		}
+		if empty {
+			return MakeIntReply(-1)
+		}
+	}
+	for i := start; i <= end; i++ {
+		if getBit(value, i) == bit {
+			return MakeIntReply(i)
+		}
+	}
+	if bit == 0 && !endGiven {
+		return MakeIntReply(end + 1)
+	}
+	return MakeIntReply(-1)
+}
+
+// BITOP operation destkey key [key ...]
+func bitOpKeys(args []string) ([]string, []string) {
+	if len(args) < 3 {
+		return nil, nil
+	}
+	readKeys := make([]string, len(args)-2)
+	copy(readKeys, args[2:])
+	return readKeys, []string{args[1]}
+}
+
+// BitOp BITOP AND|OR|XOR|NOT destkey key [key ...], 不存在的key看成空字符串, 回复结果的长度
+func BitOp(db *SaveDBTables, args []string) Reply {
+	if len(args) < 3 {
+		return errReply("ERR wrong number of arguments for 'bitop' command")
+	}
+	op := strings.ToLower(args[0])
+	if op != "and" && op != "or" && op != "xor" && op != "not" {
+		return errReply("ERR syntax error")
+	}
+	if op == "not" && len(args) != 3 {
+		return errReply("ERR BITOP NOT must be called with a single source key.")
+	}
+	dest := args[1]
+	sources := make([][]byte, 0, len(args)-2)
+	maxLen := 0
+	for _, key := range args[2:] {
+		value, err := db.GetString(key)
+		if err != nil {
+			return errReply(err.Error())
+		}
+		sources = append(sources, value)
+		if len(value) > maxLen {
+			maxLen = len(value)
+		}
+	}
+	result := make([]byte, maxLen)
+	for i := range result {
+		var b byte
+		for j, src := range sources {
+			var v byte
+			if i < len(src) {
+				v = src[i]
+			}
+			switch {
+			case op == "not":
+				b = ^v
+			case j == 0:
+				b = v
+			case op == "and":
+				b &= v
+			case op == "or":
+				b |= v
+			default:
+				b ^= v
+			}
+		}
+		result[i] = b
+	}
+	if maxLen == 0 {
+		Del(db, []string{dest})
+	} else {
+		db.putString(dest, result, false)
+		db.addAof(ToCmdLine2("bitop", args...))
+	}
+	return MakeIntReply(int64(maxLen))
+}
+
+type bitfieldType struct {
+	signed bool
+	bits   uint
+}
+
+// i1-i64, u1-u63
+func parseBitfieldType(arg string) (bitfieldType, bool) {
+	if len(arg) < 2 {
+		return bitfieldType{}, false
+	}
+	t := bitfieldType{signed: arg[0] == 'i' || arg[0] == 'I'}
+	if !t.signed && arg[0] != 'u' && arg[0] != 'U' {
+		return t, false
+	}
+	n, err := strconv.Atoi(arg[1:])
+	if err != nil || n < 1 || n > 64 || (!t.signed && n == 64) {
+		return t, false
+	}
+	t.bits = uint(n)
+	return t, true
+}
+
+// 偏移可以是#n, 表示第n个这种类型的整数
+func parseBitfieldOffset(arg string, t bitfieldType) (int64, bool) {
+	multiply := strings.HasPrefix(arg, "#")
+	if multiply {
+		arg = arg[1:]
+	}
+	offset, err := strconv.ParseInt(arg, 10, 64)
+	if err != nil || offset < 0 {
+		return 0, false
+	}
+	if multiply {
+		if offset > maxBitOffset/int64(t.bits) {
+			return 0, false
+		}
+		offset *= int64(t.bits)
+	}
+	if offset+int64(t.bits)-1 > maxBitOffset {
+		return 0, false
+	}
+	return offset, true
+}
+
+func (t bitfieldType) get(b []byte, offset int64) int64 {
+	var v uint64
+	for i := int64(0); i < int64(t.bits); i++ {
+		v = v<<1 | uint64(getBit(b, offset+i))
+	}
+	//有符号数扩展符号位
+	if t.signed && t.bits < 64 && v>>(t.bits-1)&1 == 1 {
+		v |= math.MaxUint64 << t.bits
+	}
+	return int64(v)
+}
+
+func (t bitfieldType) set(b []byte, offset int64, value int64) {
+	v := uint64(value)
+	for i := uint(0); i < t.bits; i++ {
+		setBit(b, offset+int64(i), byte(v>>(t.bits-1-i)&1))
+	}
+}
+
+func (t bitfieldType) bounds() (int64, int64) {
+	if !t.signed {
+		return 0, int64(uint64(1)<<t.bits - 1)
+	}
+	if t.bits == 64 {
+		return math.MinInt64, math.MaxInt64
+	}
+	return -1 << (t.bits - 1), 1<<(t.bits-1) - 1
+}
+
+// old+incr按照overflow处理溢出, ok为false表示FAIL模式下溢出了, SET时old是新值incr是0
+func (t bitfieldType) add(old int64, incr int64, overflow string) (int64, bool) {
+	min, max := t.bounds()
+	over, under := false, false
+	switch {
+	case incr > 0 && old > max-incr:
+		over = true
+	case incr < 0 && old < min-incr:
+		under = true
+	default:
+		if sum := old + incr; sum > max {
+			over = true
+		} else if sum < min {
+			under = true
+		}
+	}
+	if !over && !under {
+		return old + incr, true
+	}
+	switch overflow {
+	case "sat":
+		if over {
+			return max, true
+		}
+		return min, true
+	case "fail":
+		return 0, false
+	}
+	//wrap: 只保留低位
+	v := uint64(old) + uint64(incr)
+	if t.bits < 64 {
+		v &= uint64(1)<<t.bits - 1
+		if t.signed && v>>(t.bits-1)&1 == 1 {
+			v |= math.MaxUint64 << t.bits
+		}
+	}
+	return int64(v), true
+}
+
+// BitField BITFIELD key [GET type offset] [SET type offset value] [INCRBY type offset increment] [OVERFLOW WRAP|SAT|FAIL]
+// 回复每个GET SET INCRBY的结果, FAIL时是空字符串
+func BitField(db *SaveDBTables, args []string) Reply {
+	return bitField(db, args, false)
+}
+
+// BitFieldRO BITFIELD_RO key [GET type offset ...]
+func BitFieldRO(db *SaveDBTables, args []string) Reply {
+	return bitField(db, args, true)
+}
+
+func bitField(db *SaveDBTables, args []string, readOnly bool) Reply {
+	if len(args) < 1 {
+		return errReply("ERR wrong number of arguments for 'bitfield' command")
+	}
+	type bitfieldOp struct {
+		op       string
+		t        bitfieldType
+		offset   int64
+		value    int64
+		overflow string
+	}
+	//先检查所有参数, 有错误时不做任何修改
+	ops := make([]bitfieldOp, 0)
+	overflow := "wrap"
+	write := false
+	for i := 1; i < len(args); {
+		op := strings.ToLower(args[i])
+		if op == "overflow" {
+			if i+1 >= len(args) {
+				return errReply("ERR syntax error")
+			}
+			overflow = strings.ToLower(args[i+1])
+			if overflow != "wrap" && overflow != "sat" && overflow != "fail" {
+				return errReply("ERR Invalid OVERFLOW type specified")
+			}
+			i += 2
+			continue
+		}
+		argc := 3
+		if op == "set" || op == "incrby" {
+			argc = 4
+		} else if op != "get" {
+			return errReply("ERR syntax error")
+		}
+		if i+argc > len(args) {
+			return errReply("ERR syntax error")
+		}
+		if readOnly && op != "get" {
+			return errReply("ERR BITFIELD_RO only supports the GET subcommand")
+		}
+		t, ok := parseBitfieldType(args[i+1])
+		if !ok {
+			return errReply("ERR Invalid bitfield type. Use something like i16 u8. Note that u64 is not supported but i64 is.")
+		}
+		offset, ok := parseBitfieldOffset(args[i+2], t)
+		if !ok {
+			return errReply("ERR bit offset is not an integer or out of range")
+		}
+		o := bitfieldOp{op: op, t: t, offset: offset, overflow: overflow}
+		if argc == 4 {
+			v, err := strconv.ParseInt(args[i+3], 10, 64)
+			if err != nil {
+				return errReply("ERR value is not an integer or out of range")
+			}
+			o.value = v
+			write = true
+		}
+		ops = append(ops, o)
+		i += argc
+	}
+	value, err := db.GetString(args[0])
+	if err != nil {
+		return errReply(err.Error())
+	}
+	results := make([]Reply, 0, len(ops))
+	for _, o := range ops {
+		if o.op == "get" {
+			results = append(results, MakeIntReply(o.t.get(value, o.offset)))
+			continue
+		}
+		value = growString(value, (o.offset+int64(o.t.bits)-1)>>3+1)
+		old := o.t.get(value, o.offset)
+		var v int64
+		var ok bool
+		if o.op == "set" {
+			v, ok = o.t.add(o.value, 0, o.overflow)
+		} else {
+			v, ok = o.t.add(old, o.value, o.overflow)
+		}
+		if !ok {
+			results = append(results, MakeNullBulkReply())
+			continue
+		}
+		o.t.set(value, o.offset, v)
+		if o.op == "set" {
+			results = append(results, MakeIntReply(old))
+		} else {
+			results = append(results, MakeIntReply(v))
+		}
+	}
+	if write && value != nil {
+		//FAIL时也会扩展字符串, 和redis一样
+		db.putString(args[0], value, true)
+		db.addAof(ToCmdLine2("bitfield", args...))
+	}
+	return MakeMultiRawReply(results)
+}
@@ -0,0 +1,49 @@
+package src
+
+import (
+	"testing"
+)
+
+func TestBitmap(t *testing.T) {
+	s, c := makeMultiTestServer()
+	cases := [][]string{
+		{":0\r\n", "setbit", "b", "7", "1"},
+		{":1\r\n", "setbit", "b", "7", "1"},
+		{":1\r\n", "getbit", "b", "7"},
+		{":0\r\n", "getbit", "b", "100"},
+		{":0\r\n", "setbit", "b", "23", "1"},
+		{":2\r\n", "bitcount", "b"},
+		{":1\r\n", "bitcount", "b", "1", "-1"},
+		{":1\r\n", "bitcount", "b", "5", "10", "bit"},
+		{":7\r\n", "bitpos", "b", "1"},
+		{":0\r\n", "bitpos", "b", "0"},
+		{":23\r\n", "bitpos", "b", "1", "1"},
+		{":3\r\n", "bitop", "not", "n", "b"},
+		{"$3\r\n\xfe\xff\xfe\r\n", "get", "n"},
+		{":3\r\n", "bitop", "and", "a", "b", "n"},
+		{":0\r\n", "bitcount", "a"},
+	}
+	for _, tc := range cases {
+		if r := execTestCmd(s, c, tc[1:]...); r != tc[0] {
+			t.Errorf("%v: expect %q, actual %q", tc[1:], tc[0], r)
+		}
+	}
+}
+
+func TestBitField(t *testing.T) {
+	s, c := makeMultiTestServer()
+	cases := [][]string{
+		{"*2\r\n:0\r\n:100\r\n", "bitfield", "f", "set", "i8", "0", "100", "get", "i8", "0"},
+		{"*1\r\n:-106\r\n", "bitfield", "f", "incrby", "i8", "0", "50"},
+		{"*1\r\n:127\r\n", "bitfield", "f", "overflow", "sat", "incrby", "i8", "0", "300"},
+		{"*2\r\n$-1\r\n$-1\r\n", "bitfield", "f", "overflow", "fail", "incrby", "i8", "0", "1", "incrby", "u2", "#4", "5"},
+		{"*2\r\n:1\r\n:3\r\n", "bitfield", "f", "incrby", "u2", "#4", "1", "incrby", "u2", "#4", "2"},
+		{"*1\r\n:0\r\n", "bitfield", "f", "incrby", "u2", "#4", "1"},
+		{"-ERR BITFIELD_RO only supports the GET subcommand\r\n", "bitfield_ro", "f", "set", "u2", "0", "1"},
+	}
+	for _, tc := range cases {
+		if r := execTestCmd(s, c, tc[1:]...); r != tc[0] {
+			t.Errorf("%v: expect %q, actual %q", tc[1:], tc[0], r)
+		}
+	}
+}
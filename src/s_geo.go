@@ -0,0 +1,639 @@
+package src
+
+import (
+	"math"
+	"savedb/src/data"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// 地理位置
+// 1.和redis一样保存在ZSet中, score是经纬度交织之后的52位geohash, 纬度在偶数位, 经度在奇数位
+// 2.geohash的前缀相同的位置在同一个格子里, 对应score的一个连续区间, 搜索时只需要扫描中心格子和周围8个格子
+// 3.GEOADD记录成等价的ZADD, 重放和rdb都不需要特殊处理
+
+const (
+	geoStepMax     = 26
+	geoLatMin      = -85.05112878
+	geoLatMax      = 85.05112878
+	geoLongMin     = -180.0
+	geoLongMax     = 180.0
+	earthRadius    = 6372797.560856
+	mercatorMax    = 20037726.37
+	geoAlphabet    = "0123456789bcdefghjkmnpqrstuvwxyz"
+	errGeoUnit     = "ERR unsupported unit provided. please use M, KM, FT, MI"
+	errGeoNoMember = "ERR could not decode requested zset member"
+)
+
+// 把x的低32位放到偶数位, y的低32位放到奇数位
+func interleave64(x, y uint32) uint64 {
+	return spreadBits(x) | spreadBits(y)<<1
+}
+
+func spreadBits(v uint32) uint64 {
+	x := uint64(v)
+	x = (x | x<<16) & 0x0000FFFF0000FFFF
+	x = (x | x<<8) & 0x00FF00FF00FF00FF
+	x = (x | x<<4) & 0x0F0F0F0F0F0F0F0F
+	x = (x | x<<2) & 0x3333333333333333
+	x = (x | x<<1) & 0x5555555555555555
+	return x
+}
+
+func squashBits(x uint64) uint32 {
+	x &= 0x5555555555555555
+	x = (x | x>>1) & 0x3333333333333333
+	x = (x | x>>2) & 0x0F0F0F0F0F0F0F0F
+	x = (x | x>>4) & 0x00FF00FF00FF00FF
+	x = (x | x>>8) & 0x0000FFFF0000FFFF
+	x = (x | x>>16) & 0x00000000FFFFFFFF
+	return uint32(x)
+}
+
+// 经纬度在step精度下所在格子的下标
+func geoCell(lon, lat, latMin, latMax float64, step uint) (uint32, uint32) {
+	latOffset := (lat - latMin) / (latMax - latMin) * float64(uint64(1)<<step)
+	lonOffset := (lon - geoLongMin) / (geoLongMax - geoLongMin) * float64(uint64(1)<<step)
+	return uint32(latOffset), uint32(lonOffset)
+}
+
+func geoEncode(lon, lat float64) uint64 {
+	latIdx, lonIdx := geoCell(lon, lat, geoLatMin, geoLatMax, geoStepMax)
+	return interleave64(latIdx, lonIdx)
+}
+
+// 格子的经纬度范围
+func geoCellArea(latIdx, lonIdx uint32, step uint) (lonMin, lonMax, latMin, latMax float64) {
+	n := float64(uint64(1) << step)
+	latMin = geoLatMin + float64(latIdx)/n*(geoLatMax-geoLatMin)
+	latMax = geoLatMin + float64(latIdx+1)/n*(geoLatMax-geoLatMin)
+	lonMin = geoLongMin + float64(lonIdx)/n*(geoLongMax-geoLongMin)
+	lonMax = geoLongMin + float64(lonIdx+1)/n*(geoLongMax-geoLongMin)
+	return
+}
+
+// score还原成格子中心的经纬度
+func geoDecode(score float64) (float64, float64) {
+	bits := uint64(score)
+	lonMin, lonMax, latMin, latMax := geoCellArea(squashBits(bits), squashBits(bits>>1), geoStepMax)
+	lon := math.Max(geoLongMin, math.Min(geoLongMax, (lonMin+lonMax)/2))
+	lat := math.Max(geoLatMin, math.Min(geoLatMax, (latMin+latMax)/2))
+	return lon, lat
+}
+
+func degRad(d float64) float64 {
+	return d * math.Pi / 180
+}
+
+// haversine公式计算两点之间的距离, 单位是米
+func geoDistance(lon1, lat1, lon2, lat2 float64) float64 {
+	lat1r, lat2r := degRad(lat1), degRad(lat2)
+	u := math.Sin((lat2r - lat1r) / 2)
+	v := math.Sin((degRad(lon2) - degRad(lon1)) / 2)
+	a := u*u + math.Cos(lat1r)*math.Cos(lat2r)*v*v
+	return 2 * earthRadius * math.Asin(math.Sqrt(a))
+}
+
+// 标准的11位geohash字符串, 纬度范围是-90到90
+func geoHashString(score float64) string {
+	lon, lat := geoDecode(score)
+	latIdx, lonIdx := geoCell(lon, lat, -90, 90, geoStepMax)
+	bits := interleave64(latIdx, lonIdx)
+	buf := make([]byte, 11)
+	for i := 0; i < 11; i++ {
+		idx := 0
+		//只有52位, 最后一个字符补0
+		if i < 10 {
+			idx = int(bits>>(52-(i+1)*5)) & 0x1f
+		}
+		buf[i] = geoAlphabet[idx]
+	}
+	return string(buf)
+}
+
+func geoParseUnit(unit string) (float64, bool) {
+	switch strings.ToLower(unit) {
+	case "m":
+		return 1, true
+	case "km":
+		return 1000, true
+	case "ft":
+		return 0.3048, true
+	case "mi":
+		return 1609.34, true
+	}
+	return 0, false
+}
+
+func geoParseLonLat(lonArg, latArg string) (float64, float64, string) {
+	lon, err1 := strconv.ParseFloat(lonArg, 64)
+	lat, err2 := strconv.ParseFloat(latArg, 64)
+	if err1 != nil || err2 != nil {
+		return 0, 0, "ERR value is not a valid float"
+	}
+	if lon < geoLongMin || lon > geoLongMax || lat < geoLatMin || lat > geoLatMax {
+		return 0, 0, "ERR invalid longitude,latitude pair " + lonArg + "," + latArg
+	}
+	return lon, lat, ""
+}
+
+func formatGeoFloat(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+// GeoAdd GEOADD key [NX|XX] [CH] longitude latitude member [longitude latitude member ...]
+func GeoAdd(db *SaveDBTables, args []string) Reply {
+	if len(args) < 4 {
+		return errReply("ERR wrong number of arguments for 'geoadd' command")
+	}
+	key := args[0]
+	nx, xx, ch := false, false, false
+	i := 1
+	for ; i < len(args); i++ {
+		opt := strings.ToLower(args[i])
+		if opt == "nx" {
+			nx = true
+		} else if opt == "xx" {
+			xx = true
+		} else if opt == "ch" {
+			ch = true
+		} else {
+			break
+		}
+	}
+	if (len(args)-i)%3 != 0 || i == len(args) {
+		return errReply("ERR syntax error")
+	}
+	if nx && xx {
+		return errReply("ERR XX and NX options at the same time are not compatible")
+	}
+	elements := make([]*data.Element, 0, (len(args)-i)/3)
+	for ; i < len(args); i += 3 {
+		lon, lat, msg := geoParseLonLat(args[i], args[i+1])
+		if msg != "" {
+			return errReply(msg)
+		}
+		elements = append(elements, &data.Element{Member: args[i+2], Score: float64(geoEncode(lon, lat))})
+	}
+	sortedSet, err := db.GetZSet(key)
+	if err != nil {
+		return errReply(err.Error())
+	}
+	if sortedSet == nil && xx {
+		return MakeIntReply(0)
+	}
+	if sortedSet == nil {
+		sortedSet, _ = db.GetOrCreateZSet(key)
+	}
+	added, changed := 0, 0
+	//记录成等价的zadd
+	cmd := []string{key}
+	for _, e := range elements {
+		old, exists := sortedSet.Z.Get(e.Member)
+		if (exists && nx) || (!exists && xx) {
+			continue
+		}
+		if exists && old.Score == e.Score {
+			continue
+		}
+		sortedSet.Z.Add(e.Member, e.Score)
+		if exists {
+			changed++
+		} else {
+			added++
+		}
+		cmd = append(cmd, strconv.FormatFloat(e.Score, 'f', -1, 64), e.Member)
+	}
+	if len(cmd) > 1 {
+		db.addAof(ToCmdLine2("zadd", cmd...))
+		db.signalKey(key)
+	}
+	if ch {
+		return MakeIntReply(int64(added + changed))
+	}
+	return MakeIntReply(int64(added))
+}
+
+// GeoPos GEOPOS key [member ...], 不存在的成员返回空
+func GeoPos(db *SaveDBTables, args []string) Reply {
+	if len(args) < 1 {
+		return errReply("ERR wrong number of arguments for 'geopos' command")
+	}
+	sortedSet, err := db.GetZSet(args[0])
+	if err != nil {
+		return errReply(err.Error())
+	}
+	result := make([]Reply, 0, len(args)-1)
+	for _, member := range args[1:] {
+		if sortedSet != nil {
+			if e, ok := sortedSet.Z.Get(member); ok {
+				lon, lat := geoDecode(e.Score)
+				result = append(result, geoCoordReply(lon, lat))
+				continue
+			}
+		}
+		result = append(result, MakeNullMultiBulkReply())
+	}
+	return MakeMultiRawReply(result)
+}
+
+// GeoDist GEODIST key member1 member2 [M|KM|FT|MI]
+func GeoDist(db *SaveDBTables, args []string) Reply {
+	if len(args) != 3 && len(args) != 4 {
+		return errReply("ERR wrong number of arguments for 'geodist' command")
+	}
+	unit := 1.0
+	if len(args) == 4 {
+		var ok bool
+		if unit, ok = geoParseUnit(args[3]); !ok {
+			return errReply(errGeoUnit)
+		}
+	}
+	sortedSet, err := db.GetZSet(args[0])
+	if err != nil {
+		return errReply(err.Error())
+	}
+	if sortedSet == nil {
+		return MakeNullBulkReply()
+	}
+	e1, ok1 := sortedSet.Z.Get(args[1])
+	e2, ok2 := sortedSet.Z.Get(args[2])
+	if !ok1 || !ok2 {
+		return MakeNullBulkReply()
+	}
+	lon1, lat1 := geoDecode(e1.Score)
+	lon2, lat2 := geoDecode(e2.Score)
+	return MakeBulkReply([]byte(strconv.FormatFloat(geoDistance(lon1, lat1, lon2, lat2)/unit, 'f', 4, 64)))
+}
+
+// GeoHash GEOHASH key [member ...]
+func GeoHash(db *SaveDBTables, args []string) Reply {
+	if len(args) < 1 {
+		return errReply("ERR wrong number of arguments for 'geohash' command")
+	}
+	sortedSet, err := db.GetZSet(args[0])
+	if err != nil {
+		return errReply(err.Error())
+	}
+	result := make([][]byte, len(args)-1)
+	for i, member := range args[1:] {
+		if sortedSet == nil {
+			continue
+		}
+		if e, ok := sortedSet.Z.Get(member); ok {
+			result[i] = []byte(geoHashString(e.Score))
+		}
+	}
+	return MakeMultiBulkReply(result)
+}
+
+// 搜索条件, radius大于0时按圆搜索, 否则按矩形搜索
+type geoShape struct {
+	lon, lat      float64
+	radius        float64
+	width, height float64
+	unit          float64
+}
+
+// 判断点是否在范围内, 在范围内时返回到中心的距离
+func (shape *geoShape) contains(lon, lat float64) (float64, bool) {
+	if shape.radius > 0 {
+		dist := geoDistance(shape.lon, shape.lat, lon, lat)
+		return dist, dist <= shape.radius
+	}
+	if earthRadius*math.Abs(degRad(lat)-degRad(shape.lat)) > shape.height/2 {
+		return 0, false
+	}
+	if geoDistance(shape.lon, lat, lon, lat) > shape.width/2 {
+		return 0, false
+	}
+	return geoDistance(shape.lon, shape.lat, lon, lat), true
+}
+
+// 估计合适的精度, 格子的边长要大于搜索的半径
+func (shape *geoShape) estimateStep() uint {
+	radius := shape.radius
+	if radius == 0 {
+		radius = math.Sqrt(shape.width*shape.width+shape.height*shape.height) / 2
+	}
+	if radius == 0 {
+		return geoStepMax
+	}
+	step := 1
+	for radius < mercatorMax {
+		radius *= 2
+		step++
+	}
+	step -= 2
+	//高纬度的格子更窄
+	if shape.lat > 66 || shape.lat < -66 {
+		step--
+		if shape.lat > 80 || shape.lat < -80 {
+			step--
+		}
+	}
+	if step < 1 {
+		step = 1
+	}
+	if step > geoStepMax {
+		step = geoStepMax
+	}
+	return uint(step)
+}
+
+// 中心格子和周围8个格子对应的score区间
+func (shape *geoShape) scoreRanges() [][2]uint64 {
+	halfH, halfW := shape.radius, shape.radius
+	if shape.radius == 0 {
+		halfH, halfW = shape.height/2, shape.width/2
+	}
+	step := shape.estimateStep()
+	var latIdx, lonIdx uint32
+	for ; ; step-- {
+		latIdx, lonIdx = geoCell(shape.lon, shape.lat, geoLatMin, geoLatMax, step)
+		if step == 1 {
+			break
+		}
+		//周围8个格子要能覆盖整个搜索范围, 否则降低精度
+		lonMin, lonMax, latMin, latMax := geoCellArea(latIdx, lonIdx, step)
+		cellH, cellW := latMax-latMin, lonMax-lonMin
+		if geoDistance(shape.lon, shape.lat, shape.lon, math.Min(geoLatMax, latMax+cellH)) >= halfH &&
+			geoDistance(shape.lon, shape.lat, shape.lon, math.Max(geoLatMin, latMin-cellH)) >= halfH &&
+			geoDistance(shape.lon, shape.lat, lonMax+cellW, shape.lat) >= halfW &&
+			geoDistance(shape.lon, shape.lat, lonMin-cellW, shape.lat) >= halfW {
+			break
+		}
+	}
+	n := int64(1) << step
+	shift := uint(2 * (geoStepMax - step))
+	seen := make(map[uint64]bool)
+	ranges := make([][2]uint64, 0, 9)
+	for dLat := int64(-1); dLat <= 1; dLat++ {
+		lat := int64(latIdx) + dLat
+		if lat < 0 || lat >= n {
+			continue
+		}
+		for dLon := int64(-1); dLon <= 1; dLon++ {
+			//经度首尾相接
+			lon := (int64(lonIdx) + dLon + n) % n
+			hash := interleave64(uint32(lat), uint32(lon))
+			if seen[hash] {
+				continue
+			}
+			seen[hash] = true
+			ranges = append(ranges, [2]uint64{hash << shift, (hash + 1) << shift})
+		}
+	}
+	return ranges
+}
+
+type geoPoint struct {
+	member   string
+	score    float64
+	lon, lat float64
+	dist     float64
+}
+
+// 查找范围内的成员, count大于0且any为true时找到count个就返回
+func geoSearch(sortedSet *ZSet, shape *geoShape, count int, any bool) []*geoPoint {
+	points := make([]*geoPoint, 0)
+	for _, r := range shape.scoreRanges() {
+		min := &data.ScoreBorder{Value: float64(r[0])}
+		max := &data.ScoreBorder{Value: float64(r[1]), Exclude: true}
+		sortedSet.Z.ForEach(min, max, 0, -1, false, func(element *data.Element) bool {
+			lon, lat := geoDecode(element.Score)
+			if dist, ok := shape.contains(lon, lat); ok {
+				points = append(points, &geoPoint{member: element.Member, score: element.Score, lon: lon, lat: lat, dist: dist})
+			}
+			return !any || len(points) < count
+		})
+		if any && len(points) >= count {
+			break
+		}
+	}
+	return points
+}
+
+type geoSearchOptions struct {
+	shape     geoShape
+	desc      bool
+	sorted    bool
+	count     int
+	any       bool
+	withDist  bool
+	withCoord bool
+	withHash  bool
+	storeDist bool
+}
+
+// 解析GEOSEARCH的参数, store为true时是GEOSEARCHSTORE
+func parseGeoSearch(sortedSet *ZSet, args []string, store bool) (*geoSearchOptions, string) {
+	opts := &geoSearchOptions{}
+	hasFrom, hasBy := false, false
+	for i := 0; i < len(args); i++ {
+		remain := len(args) - i - 1
+		switch opt := strings.ToLower(args[i]); {
+		case opt == "frommember" && remain >= 1:
+			if hasFrom {
+				return nil, "ERR exactly one of FROMMEMBER or FROMLONLAT can be specified for geosearch"
+			}
+			hasFrom = true
+			if sortedSet == nil {
+				return nil, errGeoNoMember
+			}
+			e, ok := sortedSet.Z.Get(args[i+1])
+			if !ok {
+				return nil, errGeoNoMember
+			}
+			opts.shape.lon, opts.shape.lat = geoDecode(e.Score)
+			i++
+		case opt == "fromlonlat" && remain >= 2:
+			if hasFrom {
+				return nil, "ERR exactly one of FROMMEMBER or FROMLONLAT can be specified for geosearch"
+			}
+			hasFrom = true
+			var msg string
+			if opts.shape.lon, opts.shape.lat, msg = geoParseLonLat(args[i+1], args[i+2]); msg != "" {
+				return nil, msg
+			}
+			i += 2
+		case opt == "byradius" && remain >= 2:
+			if hasBy {
+				return nil, "ERR exactly one of BYRADIUS and BYBOX can be specified for geosearch"
+			}
+			hasBy = true
+			radius, err := strconv.ParseFloat(args[i+1], 64)
+			if err != nil || radius < 0 {
+				return nil, "ERR radius cannot be negative"
+			}
+			unit, ok := geoParseUnit(args[i+2])
+			if !ok {
+				return nil, errGeoUnit
+			}
+			opts.shape.radius, opts.shape.unit = radius*unit, unit
+			i += 2
+		case opt == "bybox" && remain >= 3:
+			if hasBy {
+				return nil, "ERR exactly one of BYRADIUS and BYBOX can be specified for geosearch"
+			}
+			hasBy = true
+			width, err1 := strconv.ParseFloat(args[i+1], 64)
+			height, err2 := strconv.ParseFloat(args[i+2], 64)
+			if err1 != nil || err2 != nil || width < 0 || height < 0 {
+				return nil, "ERR height or width cannot be negative"
+			}
+			unit, ok := geoParseUnit(args[i+3])
+			if !ok {
+				return nil, errGeoUnit
+			}
+			opts.shape.width, opts.shape.height, opts.shape.unit = width*unit, height*unit, unit
+			i += 3
+		case opt == "asc":
+			opts.sorted, opts.desc = true, false
+		case opt == "desc":
+			opts.sorted, opts.desc = true, true
+		case opt == "count" && remain >= 1:
+			count, err := strconv.Atoi(args[i+1])
+			if err != nil || count <= 0 {
+				return nil, "ERR COUNT must be > 0"
+			}
+			opts.count = count
+			i++
+			if i+1 < len(args) && strings.ToLower(args[i+1]) == "any" {
+				opts.any = true
+				i++
+			}
+		case opt == "withdist" && !store:
+			opts.withDist = true
+		case opt == "withcoord" && !store:
+			opts.withCoord = true
+		case opt == "withhash" && !store:
+			opts.withHash = true
+		case opt == "storedist" && store:
+			opts.storeDist = true
+		default:
+			return nil, "ERR syntax error"
+		}
+	}
+	if !hasFrom {
+		return nil, "ERR exactly one of FROMMEMBER or FROMLONLAT can be specified for geosearch"
+	}
+	if !hasBy {
+		return nil, "ERR exactly one of BYRADIUS and BYBOX can be specified for geosearch"
+	}
+	//有COUNT但没有ANY时要先排序才能取最近的
+	if opts.count > 0 && !opts.any && !opts.sorted {
+		opts.sorted = true
+	}
+	return opts, ""
+}
+
+func geoSearchPoints(sortedSet *ZSet, opts *geoSearchOptions) []*geoPoint {
+	if sortedSet == nil {
+		return nil
+	}
+	points := geoSearch(sortedSet, &opts.shape, opts.count, opts.any)
+	if opts.sorted {
+		sort.SliceStable(points, func(i, j int) bool {
+			if opts.desc {
+				return points[i].dist > points[j].dist
+			}
+			return points[i].dist < points[j].dist
+		})
+	}
+	if opts.count > 0 && len(points) > opts.count {
+		points = points[:opts.count]
+	}
+	return points
+}
+
+// 经纬度组成的两个元素的数组
+func geoCoordReply(lon, lat float64) Reply {
+	return MakeMultiBulkReply([][]byte{[]byte(formatGeoFloat(lon)), []byte(formatGeoFloat(lat))})
+}
+
+// 没有WITH选项时只返回成员, 否则每个成员是一个数组: 成员 [距离] [hash] [经纬度]
+func geoSearchReply(points []*geoPoint, opts *geoSearchOptions) Reply {
+	if !opts.withDist && !opts.withHash && !opts.withCoord {
+		members := make([]string, len(points))
+		for i, p := range points {
+			members[i] = p.member
+		}
+		return MakeStringsReply(members)
+	}
+	result := make([]Reply, 0, len(points))
+	for _, p := range points {
+		item := []Reply{MakeBulkReply([]byte(p.member))}
+		if opts.withDist {
+			item = append(item, MakeBulkReply([]byte(strconv.FormatFloat(p.dist/opts.shape.unit, 'f', 4, 64))))
+		}
+		if opts.withHash {
+			item = append(item, MakeIntReply(int64(p.score)))
+		}
+		if opts.withCoord {
+			item = append(item, geoCoordReply(p.lon, p.lat))
+		}
+		result = append(result, MakeMultiRawReply(item))
+	}
+	return MakeMultiRawReply(result)
+}
+
+// GeoSearch GEOSEARCH key FROMMEMBER member|FROMLONLAT lon lat BYRADIUS radius unit|BYBOX width height unit
+// [ASC|DESC] [COUNT count [ANY]] [WITHCOORD] [WITHDIST] [WITHHASH]
+func GeoSearch(db *SaveDBTables, args []string) Reply {
+	if len(args) < 1 {
+		return errReply("ERR wrong number of arguments for 'geosearch' command")
+	}
+	sortedSet, err := db.GetZSet(args[0])
+	if err != nil {
+		return errReply(err.Error())
+	}
+	opts, msg := parseGeoSearch(sortedSet, args[1:], false)
+	if msg != "" {
+		return errReply(msg)
+	}
+	return geoSearchReply(geoSearchPoints(sortedSet, opts), opts)
+}
+
+// GeoSearchStore GEOSEARCHSTORE destination source ... [STOREDIST], 结果为空时删除destination
+func GeoSearchStore(db *SaveDBTables, args []string) Reply {
+	if len(args) < 2 {
+		return errReply("ERR wrong number of arguments for 'geosearchstore' command")
+	}
+	dest := args[0]
+	sortedSet, err := db.GetZSet(args[1])
+	if err != nil {
+		return errReply(err.Error())
+	}
+	opts, msg := parseGeoSearch(sortedSet, args[2:], true)
+	if msg != "" {
+		return errReply(msg)
+	}
+	points := geoSearchPoints(sortedSet, opts)
+	if len(points) == 0 {
+		Del(db, []string{dest})
+		return MakeIntReply(0)
+	}
+	z := NewZSet()
+	for _, p := range points {
+		if opts.storeDist {
+			z.Z.Add(p.member, p.dist/opts.shape.unit)
+		} else {
+			z.Z.Add(p.member, p.score)
+		}
+	}
+	db.Data.PutWithLock(dest, z)
+	db.AllKeys.PutKey(dest, TypeZSet)
+	removeExpire(db, dest)
+	db.addAof(ToCmdLine2("geosearchstore", args...))
+	return MakeIntReply(int64(len(points)))
+}
+
+// GEOSEARCHSTORE destination source ...
+func geoSearchStoreKeys(args []string) ([]string, []string) {
+	if len(args) < 2 {
+		return nil, nil
+	}
+	return []string{args[1]}, []string{args[0]}
+}
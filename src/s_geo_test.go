@@ -0,0 +1,30 @@
+package src
+
+import (
+	"testing"
+)
+
+func TestGeo(t *testing.T) {
+	s, c := makeMultiTestServer()
+	cases := [][]string{
+		{":2\r\n", "geoadd", "sicily", "13.361389", "38.115556", "Palermo", "15.087269", "37.502669", "Catania"},
+		{":0\r\n", "geoadd", "sicily", "nx", "13.361389", "38.115556", "Palermo"},
+		{"$11\r\n166274.1516\r\n", "geodist", "sicily", "Palermo", "Catania"},
+		{"$8\r\n166.2742\r\n", "geodist", "sicily", "Palermo", "Catania", "km"},
+		{"*3\r\n$11\r\nsqc8b49rny0\r\n$-1\r\n$11\r\nsqdtr74hyu0\r\n", "geohash", "sicily", "Palermo", "none", "Catania"},
+		{"*2\r\n*2\r\n$18\r\n13.361389338970184\r\n$16\r\n38.1155563954963\r\n*-1\r\n", "geopos", "sicily", "Palermo", "none"},
+		{"*2\r\n$7\r\nCatania\r\n$7\r\nPalermo\r\n", "geosearch", "sicily", "fromlonlat", "15", "37", "byradius", "200", "km", "asc"},
+		{"*2\r\n*2\r\n$7\r\nPalermo\r\n$8\r\n190.4424\r\n*2\r\n$7\r\nCatania\r\n$7\r\n56.4413\r\n", "geosearch", "sicily", "fromlonlat", "15", "37", "byradius", "200", "km", "desc", "withdist"},
+		{":2\r\n", "geoadd", "sicily", "12.758489", "38.788135", "edge1", "17.241510", "38.788135", "edge2"},
+		{"*2\r\n*2\r\n$7\r\nCatania\r\n$7\r\n56.4413\r\n*2\r\n$7\r\nPalermo\r\n$8\r\n190.4424\r\n", "geosearch", "sicily", "fromlonlat", "15", "37", "byradius", "200", "km", "asc", "withdist"},
+		{"*4\r\n*2\r\n$7\r\nCatania\r\n$7\r\n56.4413\r\n*2\r\n$7\r\nPalermo\r\n$8\r\n190.4424\r\n*2\r\n$5\r\nedge2\r\n$8\r\n279.7403\r\n*2\r\n$5\r\nedge1\r\n$8\r\n279.7405\r\n", "geosearch", "sicily", "fromlonlat", "15", "37", "bybox", "400", "400", "km", "asc", "withdist"},
+		{"*2\r\n$5\r\nedge1\r\n$5\r\nedge2\r\n", "geosearch", "sicily", "fromlonlat", "15", "37", "bybox", "400", "400", "km", "desc", "count", "2"},
+		{":1\r\n", "geosearchstore", "dst", "sicily", "frommember", "Palermo", "byradius", "10", "km"},
+		{"-ERR could not decode requested zset member\r\n", "geosearch", "sicily", "frommember", "none", "byradius", "10", "km"},
+	}
+	for _, tc := range cases {
+		if r := execTestCmd(s, c, tc[1:]...); r != tc[0] {
+			t.Errorf("%v: expect %q, actual %q", tc[1:], tc[0], r)
+		}
+	}
+}
@@ -1,14 +1,17 @@
 package src
 
 import (
-	"fmt"
+	"math"
 	"strconv"
 	"strings"
+	"time"
 )
 
 // Hash 基本上和set一样
 type Hash struct {
 	M map[string]*string
+	//field的过期时间, 没有过期时间的field不在其中
+	E map[string]time.Time
 }
 
 func NewHash() *Hash {
@@ -25,7 +28,7 @@ func (db *SaveDBTables) GetOrCreateHash(key string) (*Hash, error) {
 		return val.(*Hash), nil
 	}
 	if _, ok := val.(*Hash); !ok {
-		return nil, fmt.Errorf("")
+		return nil, errWrongType
 	}
 	return val.(*Hash), nil
 }
@@ -35,14 +38,14 @@ func (db *SaveDBTables) GetHash(key string) (*Hash, error) {
 		return nil, nil
 	}
 	if _, ok := val.(*Hash); !ok {
-		return nil, fmt.Errorf("")
+		return nil, errWrongType
 	}
 	db.AllKeys.ActivateKey(key)
 	return val.(*Hash), nil
 }
-func HmSet(db *SaveDBTables, args []string) Result {
+func HmSet(db *SaveDBTables, args []string) Reply {
 	if len(args)%2 != 1 {
-		return CreateStrResult(CErr, "args number error")
+		return errReply("args number error")
 	}
 	key := args[0]
 	size := (len(args) - 1) / 2
@@ -55,105 +58,319 @@ func HmSet(db *SaveDBTables, args []string) Result {
 
 	hash, err := db.GetOrCreateHash(key)
 	if err != nil {
-		return CreateStrResult(CErr, err.Error())
+		return errReply(err.Error())
 	}
-	for i, value := range fields {
-		hash.M[value] = &values[i]
+	for i, field := range fields {
+		hash.M[field] = &values[i]
+		removeFieldExpire(db, key, hash, field)
 	}
 	db.addAof(ToCmdLine2("hmset", args...))
-	return CreateResult(COk, []byte(strconv.Itoa(len(values))))
+	return MakeIntReply(int64(len(values)))
 }
 
-func HGet(db *SaveDBTables, args []string) Result {
+// HSET key field value [field value ...], 返回新增的field个数
+func HSet(db *SaveDBTables, args []string) Reply {
+	if len(args) < 3 || len(args)%2 != 1 {
+		return errReply("ERR wrong number of arguments for 'hset' command")
+	}
+	hash, err := db.GetOrCreateHash(args[0])
+	if err != nil {
+		return errReply(err.Error())
+	}
+	added := 0
+	for i := 1; i < len(args); i += 2 {
+		if _, ok := hash.M[args[i]]; !ok {
+			added++
+		}
+		value := args[i+1]
+		hash.M[args[i]] = &value
+		removeFieldExpire(db, args[0], hash, args[i])
+	}
+	db.addAof(ToCmdLine2("hset", args...))
+	return MakeIntReply(int64(added))
+}
+
+// HSETNX key field value, field已经存在时不修改
+func HSetNX(db *SaveDBTables, args []string) Reply {
+	hash, err := db.GetOrCreateHash(args[0])
+	if err != nil {
+		return errReply(err.Error())
+	}
+	if _, ok := hash.M[args[1]]; ok {
+		return MakeIntReply(0)
+	}
+	value := args[2]
+	hash.M[args[1]] = &value
+	db.addAof(ToCmdLine2("hset", args...))
+	return MakeIntReply(1)
+}
+
+// HINCRBY key field increment
+func HIncrBy(db *SaveDBTables, args []string) Reply {
+	delta, err := strconv.ParseInt(args[2], 10, 64)
+	if err != nil {
+		return errReply("ERR value is not an integer or out of range")
+	}
+	hash, err := db.GetOrCreateHash(args[0])
+	if err != nil {
+		return errReply(err.Error())
+	}
+	var n int64
+	if value, ok := hash.M[args[1]]; ok {
+		n, err = strconv.ParseInt(*value, 10, 64)
+		if err != nil {
+			return errReply("ERR hash value is not an integer")
+		}
+	}
+	if (delta > 0 && n > math.MaxInt64-delta) || (delta < 0 && n < math.MinInt64-delta) {
+		return errReply("ERR increment or decrement would overflow")
+	}
+	n += delta
+	value := strconv.FormatInt(n, 10)
+	hash.M[args[1]] = &value
+	db.addAof(ToCmdLine2("hincrby", args...))
+	return MakeIntReply(n)
+}
+
+// HINCRBYFLOAT key field increment, 记录成hset计算之后的值, field有过期时间时再记录hpexpireat
+func HIncrByFloat(db *SaveDBTables, args []string) Reply {
+	delta, err := strconv.ParseFloat(args[2], 64)
+	if err != nil || math.IsNaN(delta) || math.IsInf(delta, 0) {
+		return errReply("ERR value is not a valid float")
+	}
+	hash, err := db.GetOrCreateHash(args[0])
+	if err != nil {
+		return errReply(err.Error())
+	}
+	var f float64
+	if value, ok := hash.M[args[1]]; ok {
+		f, err = strconv.ParseFloat(*value, 64)
+		if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+			return errReply("ERR hash value is not a float")
+		}
+	}
+	f += delta
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return errReply("ERR increment would produce NaN or Infinity")
+	}
+	value := strconv.FormatFloat(f, 'f', -1, 64)
+	hash.M[args[1]] = &value
+	db.addAof(ToCmdLine("hset", args[0], args[1], value))
+	//hset会清除field的过期时间, 重放时要再设置回来
+	if at, ok := hash.E[args[1]]; ok {
+		db.addAof(makeFieldExpireCmd(args[0], at, []string{args[1]}))
+	}
+	return MakeBulkReply([]byte(value))
+}
+
+func HGet(db *SaveDBTables, args []string) Reply {
 	key := args[0]
 	key2 := args[1]
 	// get entity
 	dict, err := db.GetHash(key)
 	if err != nil {
-		return CreateStrResult(CErr, err.Error())
+		return errReply(err.Error())
 	}
 	if dict == nil {
-		return CreateStrResult(CErr, "key not exist")
+		return MakeNullBulkReply()
 	}
 	value, ok := dict.M[key2]
 	if !ok {
-		return CreateStrResult(CErr, "key2 not exist")
+		return MakeNullBulkReply()
 	}
 
-	return CreateStrResult(COk, *value)
+	return MakeBulkReply([]byte(*value))
 }
 
-func HDel(db *SaveDBTables, args []string) Result {
+func HDel(db *SaveDBTables, args []string) Reply {
+	if len(args) < 2 {
+		return errReply("ERR wrong number of arguments for 'hdel' command")
+	}
 	key := args[0]
 	hash, err := db.GetHash(key)
 	if err != nil {
-		return CreateStrResult(CErr, err.Error())
+		return errReply(err.Error())
 	}
 	if hash == nil {
-		return CreateStrResult(CErr, "key inexistence")
+		return MakeIntReply(0)
 	}
 
-	if len(args[1:]) == 0 || key == "" {
-		return CreateStrResult(CErr, "key inexistence")
+	deleted := 0
+	for _, field := range args[1:] {
+		if _, ok := hash.M[field]; !ok {
+			continue
+		}
+		delete(hash.M, field)
+		removeFieldExpire(db, key, hash, field)
+		deleted++
 	}
-
-	for _, value := range args[1:] {
-		delete(hash.M, value)
+	if deleted > 0 {
+		db.addAof(ToCmdLine2("hdel", args...))
+	}
+	if len(hash.M) == 0 {
+		db.removeKey(key)
 	}
-	db.addAof(ToCmdLine2("hdel", args...))
-	return CreateResult(COk, []byte(strconv.Itoa(len(args[1:]))))
+	return MakeIntReply(int64(deleted))
 }
 
-func HExists(db *SaveDBTables, args []string) Result {
+func HExists(db *SaveDBTables, args []string) Reply {
 	key1 := args[0]
 	key2 := args[1]
 	v, err := db.GetHash(key1)
 	if err != nil {
-		return CreateStrResult(CErr, err.Error())
+		return errReply(err.Error())
 	}
 	if v != nil {
 		if _, ok := v.M[key2]; ok {
-			return CreateResult(COk, nil)
+			return MakeIntReply(1)
 		}
 	}
-	return CreateStrResult(CErr, "key inexistence")
+	return MakeIntReply(0)
 }
 
-func HCard(db *SaveDBTables, args []string) Result {
-	if string(Exists(db, args).Res) != "1" {
-		return CreateStrResult(CErr, "key inexistence")
-	}
+func HCard(db *SaveDBTables, args []string) Reply {
 	key := args[0]
 	v, err := db.GetHash(key)
 	if err != nil {
-		return CreateStrResult(CErr, err.Error())
+		return errReply(err.Error())
+	}
+	if v == nil {
+		return MakeIntReply(0)
 	}
-	return CreateResult(COk, []byte(strconv.Itoa(len(v.M))))
+	return MakeIntReply(int64(len(v.M)))
 }
 
-func HGetAll(db *SaveDBTables, args []string) Result {
+func HGetAll(db *SaveDBTables, args []string) Reply {
 	key := args[0]
 	v, err := db.GetHash(key)
 	if err != nil {
-		return CreateStrResult(CErr, err.Error())
+		return errReply(err.Error())
 	}
 	if v == nil {
-		return CreateStrResult(CErr, "hash not exist")
-	}
-
-	records := make([]string, 0)
-	var builder strings.Builder
-	var index = 0
-	var size = len(v.M)
-	for k, record := range v.M {
-		records = append(records, *record)
-		builder.WriteString(k)
-		builder.WriteString("=")
-		builder.WriteString(*record)
-		if index < size-1 {
-			builder.WriteString(",")
+		return MakeMapReply()
+	}
+	reply := MakeMapReply()
+	for field, value := range v.M {
+		reply.Put(MakeBulkReply([]byte(field)), MakeBulkReply([]byte(*value)))
+	}
+	return reply
+}
+
+// HMGET key field [field ...], 不存在的field返回空
+func HMGet(db *SaveDBTables, args []string) Reply {
+	if len(args) < 2 {
+		return errReply("ERR wrong number of arguments for 'hmget' command")
+	}
+	hash, err := db.GetHash(args[0])
+	if err != nil {
+		return errReply(err.Error())
+	}
+	values := make([][]byte, len(args)-1)
+	if hash != nil {
+		for i, field := range args[1:] {
+			if value, ok := hash.M[field]; ok {
+				values[i] = []byte(*value)
+			}
 		}
-		index++
 	}
-	return CreateStrResult(COk, builder.String())
+	return MakeMultiBulkReply(values)
+}
+
+func HKeys(db *SaveDBTables, args []string) Reply {
+	hash, err := db.GetHash(args[0])
+	if err != nil {
+		return errReply(err.Error())
+	}
+	if hash == nil {
+		return MakeEmptyMultiBulkReply()
+	}
+	return MakeStringsReply(hash.fields())
+}
+
+func HVals(db *SaveDBTables, args []string) Reply {
+	hash, err := db.GetHash(args[0])
+	if err != nil {
+		return errReply(err.Error())
+	}
+	if hash == nil {
+		return MakeEmptyMultiBulkReply()
+	}
+	values := make([]string, 0, len(hash.M))
+	for _, value := range hash.M {
+		values = append(values, *value)
+	}
+	return MakeStringsReply(values)
+}
+
+// HSTRLEN key field
+func HStrLen(db *SaveDBTables, args []string) Reply {
+	hash, err := db.GetHash(args[0])
+	if err != nil {
+		return errReply(err.Error())
+	}
+	if hash == nil {
+		return MakeIntReply(0)
+	}
+	value, ok := hash.M[args[1]]
+	if !ok {
+		return MakeIntReply(0)
+	}
+	return MakeIntReply(int64(len(*value)))
+}
+
+// HRANDFIELD key [count [WITHVALUES]], count为负数时可以返回重复的field
+func HRandField(db *SaveDBTables, args []string) Reply {
+	if len(args) < 1 || len(args) > 3 {
+		return errReply("ERR wrong number of arguments for 'hrandfield' command")
+	}
+	count := 1
+	withValues := false
+	if len(args) > 1 {
+		n, err := strconv.Atoi(args[1])
+		if err != nil {
+			return errReply("ERR value is not an integer or out of range")
+		}
+		count = n
+	}
+	if len(args) == 3 {
+		if strings.ToLower(args[2]) != "withvalues" {
+			return errReply("ERR syntax error")
+		}
+		withValues = true
+	}
+	hash, err := db.GetHash(args[0])
+	if err != nil {
+		return errReply(err.Error())
+	}
+	if hash == nil || count == 0 {
+		if len(args) == 1 {
+			return MakeNullBulkReply()
+		}
+		return MakeEmptyMultiBulkReply()
+	}
+	var fields []string
+	if count < 0 {
+		fields = randomPick(hash.fields(), -count, false)
+	} else {
+		fields = randomPick(hash.fields(), count, true)
+	}
+	if len(args) == 1 {
+		return MakeBulkReply([]byte(fields[0]))
+	}
+	if !withValues {
+		return MakeStringsReply(fields)
+	}
+	result := make([]string, 0, 2*len(fields))
+	for _, field := range fields {
+		result = append(result, field, *hash.M[field])
+	}
+	return MakeStringsReply(result)
+}
+
+func (h *Hash) fields() []string {
+	fields := make([]string, 0, len(h.M))
+	for field := range h.M {
+		fields = append(fields, field)
+	}
+	return fields
 }
@@ -0,0 +1,305 @@
+package src
+
+import (
+	"math"
+	"savedb/src/timewheel"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// hash中field的过期时间 HEXPIRE HPEXPIRE HEXPIREAT HPEXPIREAT HTTL HPTTL HPERSIST
+// 1.过期时间保存在Hash.E中, 每个field在时间轮中有一个任务, 到期时删除field并记录hdel, 最后一个field删除之后删除key
+// 2.任务执行时重新检查Hash.E, field被重新设置过期时间, 被HSET覆盖或者key被删除时任务什么也不做
+// 3.RENAME MOVE COPY通过putKey把hash放到新的key下时重新加入时间轮
+// 4.aof中总是记录hpexpireat, 重写时在hmset之后加上每个field的hpexpireat; rdb中没有field的过期时间, 使用rdb前缀重写时在rdb之后补上hpexpireat
+
+// 时间轮中field任务的key, 以h开头和key的任务区分开, key带上长度避免和field混在一起
+func fieldExpireTaskKey(db *SaveDBTables, key string, field string) string {
+	return "h" + strconv.Itoa(db.index) + ":" + strconv.Itoa(len(key)) + ":" + key + field
+}
+
+// 事务中的db是带aof收集器的副本, 定时任务要用server中当前的db
+func (db *SaveDBTables) current() *SaveDBTables {
+	if db.dbs == nil {
+		return db
+	}
+	return db.dbs[db.index].Load().(*SaveDBTables)
+}
+
+func putFieldExpire(db *SaveDBTables, key string, hash *Hash, field string, at time.Time) {
+	if hash.E == nil {
+		hash.E = make(map[string]time.Time)
+	}
+	hash.E[field] = at
+	timewheel.AddTimer(at, fieldExpireTaskKey(db, key, field), func() {
+		live := db.current()
+		keys := []string{key}
+		live.Locks(nil, keys)
+		defer live.UnLocks(nil, keys)
+		expireField(live, key, field)
+		live.addVersion(key)
+	})
+}
+
+// 删除field的过期时间, 返回是否有过期时间
+func removeFieldExpire(db *SaveDBTables, key string, hash *Hash, field string) bool {
+	if _, ok := hash.E[field]; !ok {
+		return false
+	}
+	delete(hash.E, field)
+	timewheel.Cancel(fieldExpireTaskKey(db, key, field))
+	return true
+}
+
+// 到期时由时间轮调用, 调用者持有key的写锁
+func expireField(db *SaveDBTables, key string, field string) {
+	val, ok := db.Data.GetWithLock(key)
+	if !ok {
+		return
+	}
+	hash, ok := val.(*Hash)
+	if !ok {
+		return
+	}
+	at, ok := hash.E[field]
+	if !ok {
+		return
+	}
+	//时间轮按秒转动, 不到1秒的延迟可能提前执行, 还没到期时重新加入时间轮
+	if at.After(time.Now()) {
+		putFieldExpire(db, key, hash, field, at)
+		return
+	}
+	delete(hash.M, field)
+	delete(hash.E, field)
+	db.addAof(ToCmdLine("hdel", key, field))
+	if len(hash.M) == 0 {
+		db.removeKey(key)
+	}
+}
+
+// hash放到新的key下之后, 按新的key重新加入时间轮
+func (db *SaveDBTables) restoreFieldExpires(key string, hash *Hash) {
+	for field, at := range hash.E {
+		putFieldExpire(db, key, hash, field, at)
+	}
+}
+
+// 解析 FIELDS numfields field [field ...]
+func parseHashFields(args []string) ([]string, string) {
+	if len(args) < 2 || strings.ToLower(args[0]) != "fields" {
+		return nil, "ERR Mandatory argument FIELDS is missing or not at the right position"
+	}
+	n, err := strconv.Atoi(args[1])
+	if err != nil || n <= 0 {
+		return nil, "ERR Parameter `numFields` should be greater than 0"
+	}
+	if n != len(args)-2 {
+		return nil, "ERR The `numfields` parameter must match the number of arguments"
+	}
+	return args[2:], ""
+}
+
+// HEXPIRE key seconds [NX|XX|GT|LT] FIELDS numfields field [field ...]
+func HExpire(db *SaveDBTables, args []string) Reply {
+	return hashExpireGeneric(db, args, "hexpire", time.Second, false)
+}
+
+// HPEXPIRE key milliseconds [NX|XX|GT|LT] FIELDS numfields field [field ...]
+func HPExpire(db *SaveDBTables, args []string) Reply {
+	return hashExpireGeneric(db, args, "hpexpire", time.Millisecond, false)
+}
+
+// HEXPIREAT key unix-time-seconds [NX|XX|GT|LT] FIELDS numfields field [field ...]
+func HExpireAt(db *SaveDBTables, args []string) Reply {
+	return hashExpireGeneric(db, args, "hexpireat", time.Second, true)
+}
+
+// HPEXPIREAT key unix-time-milliseconds [NX|XX|GT|LT] FIELDS numfields field [field ...]
+func HPExpireAt(db *SaveDBTables, args []string) Reply {
+	return hashExpireGeneric(db, args, "hpexpireat", time.Millisecond, true)
+}
+
+// 每个field返回 -2:field不存在 0:不满足NX XX GT LT 1:设置成功 2:时间已经过去, field被删除
+func hashExpireGeneric(db *SaveDBTables, args []string, cmd string, unit time.Duration, absolute bool) Reply {
+	if len(args) < 4 {
+		return errReply("ERR wrong number of arguments for '" + cmd + "' command")
+	}
+	key := args[0]
+	n, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil {
+		return errReply("ERR value is not an integer or out of range")
+	}
+	var nx, xx, gt, lt bool
+	rest := args[2:]
+	switch strings.ToLower(rest[0]) {
+	case "nx":
+		nx = true
+	case "xx":
+		xx = true
+	case "gt":
+		gt = true
+	case "lt":
+		lt = true
+	}
+	if nx || xx || gt || lt {
+		rest = rest[1:]
+	}
+	fields, msg := parseHashFields(rest)
+	if fields == nil {
+		return errReply(msg)
+	}
+	unitMs := int64(unit / time.Millisecond)
+	if n < 0 || n > math.MaxInt64/unitMs {
+		return errReply("ERR invalid expire time in '" + cmd + "' command")
+	}
+	ms := n * unitMs
+	if !absolute {
+		now := time.Now().UnixMilli()
+		if ms > math.MaxInt64-now {
+			return errReply("ERR invalid expire time in '" + cmd + "' command")
+		}
+		ms += now
+	}
+	hash, err := db.GetHash(key)
+	if err != nil {
+		return errReply(err.Error())
+	}
+	result := make([]Reply, len(fields))
+	if hash == nil {
+		for i := range fields {
+			result[i] = MakeIntReply(-2)
+		}
+		return MakeMultiRawReply(result)
+	}
+	expireAt := time.UnixMilli(ms)
+	past := !expireAt.After(time.Now())
+	var updated, deleted []string
+	for i, field := range fields {
+		if _, ok := hash.M[field]; !ok {
+			result[i] = MakeIntReply(-2)
+			continue
+		}
+		//没有过期时间的field相当于过期时间无穷大
+		current, hasExpire := hash.E[field]
+		if (nx && hasExpire) || (xx && !hasExpire) ||
+			(gt && (!hasExpire || !expireAt.After(current))) ||
+			(lt && hasExpire && !expireAt.Before(current)) {
+			result[i] = MakeIntReply(0)
+			continue
+		}
+		if past {
+			removeFieldExpire(db, key, hash, field)
+			delete(hash.M, field)
+			deleted = append(deleted, field)
+			result[i] = MakeIntReply(2)
+			continue
+		}
+		putFieldExpire(db, key, hash, field, expireAt)
+		updated = append(updated, field)
+		result[i] = MakeIntReply(1)
+	}
+	if len(updated) > 0 {
+		db.addAof(makeFieldExpireCmd(key, expireAt, updated))
+	}
+	if len(deleted) > 0 {
+		db.addAof(ToCmdLine2("hdel", append([]string{key}, deleted...)...))
+		if len(hash.M) == 0 {
+			db.removeKey(key)
+		}
+	}
+	return MakeMultiRawReply(result)
+}
+
+// hpexpireat key ms FIELDS numfields field [field ...]
+func makeFieldExpireCmd(key string, expireAt time.Time, fields []string) CmdLine {
+	args := []string{key, strconv.FormatInt(expireAt.UnixMilli(), 10), "FIELDS", strconv.Itoa(len(fields))}
+	return ToCmdLine2("hpexpireat", append(args, fields...)...)
+}
+
+// HTTL key FIELDS numfields field [field ...], 不存在的field返回-2, 没有过期时间的field返回-1
+func HTTL(db *SaveDBTables, args []string) Reply {
+	return hashTTLGeneric(db, args, "httl", time.Second)
+}
+
+// HPTTL key FIELDS numfields field [field ...]
+func HPTTL(db *SaveDBTables, args []string) Reply {
+	return hashTTLGeneric(db, args, "hpttl", time.Millisecond)
+}
+
+func hashTTLGeneric(db *SaveDBTables, args []string, cmd string, unit time.Duration) Reply {
+	if len(args) < 3 {
+		return errReply("ERR wrong number of arguments for '" + cmd + "' command")
+	}
+	fields, msg := parseHashFields(args[1:])
+	if fields == nil {
+		return errReply(msg)
+	}
+	hash, err := db.GetHash(args[0])
+	if err != nil {
+		return errReply(err.Error())
+	}
+	unitMs := int64(unit / time.Millisecond)
+	result := make([]Reply, len(fields))
+	for i, field := range fields {
+		if hash == nil {
+			result[i] = MakeIntReply(-2)
+			continue
+		}
+		if _, ok := hash.M[field]; !ok {
+			result[i] = MakeIntReply(-2)
+			continue
+		}
+		at, ok := hash.E[field]
+		if !ok {
+			result[i] = MakeIntReply(-1)
+			continue
+		}
+		remaining := at.UnixMilli() - time.Now().UnixMilli()
+		if remaining < 0 {
+			remaining = 0
+		}
+		result[i] = MakeIntReply((remaining + unitMs/2) / unitMs)
+	}
+	return MakeMultiRawReply(result)
+}
+
+// HPERSIST key FIELDS numfields field [field ...], 每个field返回 -2:不存在 -1:没有过期时间 1:删除了过期时间
+func HPersist(db *SaveDBTables, args []string) Reply {
+	if len(args) < 3 {
+		return errReply("ERR wrong number of arguments for 'hpersist' command")
+	}
+	key := args[0]
+	fields, msg := parseHashFields(args[1:])
+	if fields == nil {
+		return errReply(msg)
+	}
+	hash, err := db.GetHash(key)
+	if err != nil {
+		return errReply(err.Error())
+	}
+	result := make([]Reply, len(fields))
+	var persisted []string
+	for i, field := range fields {
+		if hash == nil {
+			result[i] = MakeIntReply(-2)
+			continue
+		}
+		if _, ok := hash.M[field]; !ok {
+			result[i] = MakeIntReply(-2)
+			continue
+		}
+		if !removeFieldExpire(db, key, hash, field) {
+			result[i] = MakeIntReply(-1)
+			continue
+		}
+		persisted = append(persisted, field)
+		result[i] = MakeIntReply(1)
+	}
+	if len(persisted) > 0 {
+		args := []string{key, "FIELDS", strconv.Itoa(len(persisted))}
+		db.addAof(ToCmdLine2("hpersist", append(args, persisted...)...))
+	}
+	return MakeMultiRawReply(result)
+}
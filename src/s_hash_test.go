@@ -0,0 +1,124 @@
+package src
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHash(t *testing.T) {
+	s, c := makeMultiTestServer()
+	execTestCmd(s, c, "set", "str", "x")
+	tests := [][]string{
+		{":2\r\n", "hset", "h", "a", "1", "b", "x"},
+		{":0\r\n", "hset", "h", "a", "2"},
+		{"-ERR wrong number of arguments for 'hset' command\r\n", "hset", "h", "a"},
+		{":0\r\n", "hsetnx", "h", "a", "3"},
+		{":1\r\n", "hsetnx", "h", "c", "3"},
+		{":12\r\n", "hincrby", "h", "a", "10"},
+		{":-5\r\n", "hincrby", "h", "n", "-5"},
+		{"-ERR hash value is not an integer\r\n", "hincrby", "h", "b", "1"},
+		{"-ERR increment or decrement would overflow\r\n", "hincrby", "h", "a", "9223372036854775800"},
+		{"$3\r\n3.5\r\n", "hincrbyfloat", "h", "c", "0.5"},
+		{"-ERR hash value is not a float\r\n", "hincrbyfloat", "h", "b", "1"},
+		{"*3\r\n$2\r\n12\r\n$-1\r\n$1\r\nx\r\n", "hmget", "h", "a", "none", "b"},
+		{"*1\r\n$-1\r\n", "hmget", "none", "a"},
+		{":1\r\n", "hstrlen", "h", "b"},
+		{":0\r\n", "hstrlen", "h", "none"},
+		{"*0\r\n", "hkeys", "none"},
+		{"*0\r\n", "hvals", "none"},
+		{"$-1\r\n", "hrandfield", "none"},
+		{"-ERR syntax error\r\n", "hrandfield", "h", "1", "values"},
+		{"$-1\r\n", "hget", "none", "a"},
+		{"$-1\r\n", "hget", "h", "none"},
+		{"*0\r\n", "hgetall", "none"},
+		{":0\r\n", "hdel", "none", "a"},
+		{":1\r\n", "hset", "d", "f", "1"},
+		{":1\r\n", "hdel", "d", "f", "none"},
+		{":0\r\n", "exists", "d"},
+		{"-WRONGTYPE Operation against a key holding the wrong kind of value\r\n", "hget", "str", "a"},
+	}
+	for _, test := range tests {
+		if r := execTestCmd(s, c, test[1:]...); r != test[0] {
+			t.Errorf("%v: expect %q, actual %q", test[1:], test[0], r)
+		}
+	}
+	if r := execTestCmd(s, c, "hkeys", "h"); !strings.HasPrefix(r, "*4\r\n") {
+		t.Errorf("hkeys error, actual %q", r)
+	}
+	if r := execTestCmd(s, c, "hrandfield", "h", "-6"); !strings.HasPrefix(r, "*6\r\n") {
+		t.Errorf("hrandfield with negative count should repeat fields, actual %q", r)
+	}
+	if r := execTestCmd(s, c, "hrandfield", "h", "10", "withvalues"); !strings.HasPrefix(r, "*8\r\n") {
+		t.Errorf("hrandfield withvalues error, actual %q", r)
+	}
+}
+
+func TestHashFieldExpire(t *testing.T) {
+	initTestLog(t)
+	s, c := makeMultiTestServer()
+	var aof []string
+	s.FindDB(0).addAof = func(line CmdLine) {
+		aof = append(aof, string(line[0]))
+	}
+	execTestCmd(s, c, "hset", "h", "a", "1", "b", "2", "c", "3")
+	execTestCmd(s, c, "hset", "last", "a", "1")
+	tests := [][]string{
+		{"*2\r\n:1\r\n:-2\r\n", "hexpire", "h", "100", "fields", "2", "a", "none"},
+		{"*1\r\n:0\r\n", "hexpire", "h", "50", "gt", "fields", "1", "a"},
+		{"*1\r\n:1\r\n", "hpexpire", "h", "100", "lt", "fields", "1", "a"},
+		{"*3\r\n:0\r\n:-1\r\n:-2\r\n", "httl", "h", "fields", "3", "a", "b", "none"},
+		{"*1\r\n:1\r\n", "hpexpire", "h", "100", "nx", "fields", "1", "b"},
+		{"*2\r\n:2\r\n:-2\r\n", "hexpireat", "h", "1000", "fields", "2", "c", "c"},
+		{"*1\r\n:1\r\n", "hpersist", "h", "fields", "1", "a"},
+		{"*1\r\n:-1\r\n", "hpersist", "h", "fields", "1", "a"},
+		{"*1\r\n:-2\r\n", "httl", "none", "fields", "1", "a"},
+		{"*1\r\n:1\r\n", "hpexpire", "last", "100", "fields", "1", "a"},
+		{"-ERR The `numfields` parameter must match the number of arguments\r\n", "httl", "h", "fields", "2", "a"},
+		{"-ERR Mandatory argument FIELDS is missing or not at the right position\r\n", "hexpire", "h", "10", "a", "b"},
+		{"+OK\r\n", "rename", "h", "h2"},
+	}
+	for _, test := range tests {
+		if r := execTestCmd(s, c, test[1:]...); r != test[0] {
+			t.Errorf("%v: expect %q, actual %q", test[1:], test[0], r)
+		}
+	}
+	entity, _ := s.FindDB(0).Data.Get("h2")
+	lines := EntityToCmds("h2", entity)
+	if len(lines) != 2 || string(lines[1][0]) != "hpexpireat" {
+		t.Errorf("rewrite should keep the field expiration, actual %d lines", len(lines))
+	}
+	//时间轮每秒转一格
+	time.Sleep(2500 * time.Millisecond)
+	if r := execTestCmd(s, c, "hkeys", "h2"); r != "*1\r\n$1\r\na\r\n" {
+		t.Errorf("field b should expire after rename, actual %q", r)
+	}
+	if r := execTestCmd(s, c, "exists", "last"); r != ":0\r\n" {
+		t.Errorf("key should be removed with its last field, actual %q", r)
+	}
+	expect := "hset hset hpexpireat hpexpireat hpexpireat hdel hpersist hpexpireat rename"
+	if actual := strings.Join(aof[:9], " "); actual != expect {
+		t.Errorf("expect aof %q, actual %q", expect, actual)
+	}
+}
+
+func TestHashIncrByFloatKeepsFieldExpire(t *testing.T) {
+	s, c := makeMultiTestServer()
+	var buf bytes.Buffer
+	s.FindDB(0).addAof = func(line CmdLine) {
+		buf.Write(ToBytes(line))
+	}
+	execTestCmd(s, c, "hset", "h", "f", "1")
+	execTestCmd(s, c, "hexpire", "h", "100", "fields", "1", "f")
+	execTestCmd(s, c, "hincrbyfloat", "h", "f", "0.5")
+	//重放aof之后field还有过期时间
+	p := makeAofTestPersister(t, buf.Bytes())
+	p.LoadAof(0)
+	if r := execTestCmd(&p.db, c, "httl", "h", "fields", "1", "f"); r != "*1\r\n:100\r\n" {
+		t.Errorf("field expiration should survive aof replay, actual %q", r)
+	}
+	if r := execTestCmd(&p.db, c, "hget", "h", "f"); r != "$3\r\n1.5\r\n" {
+		t.Errorf("hincrbyfloat value error, actual %q", r)
+	}
+}
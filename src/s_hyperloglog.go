@@ -0,0 +1,422 @@
+package src
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+)
+
+// HyperLogLog
+// 1.和redis的格式一样保存在字符串中: "HYLL" + 编码(0=dense 1=sparse) + 3字节保留 + 8字节缓存的基数(小端, 最高位为1表示无效)
+// 2.16384个6位的寄存器, 元素的MurmurHash64A低14位选择寄存器, 剩下的位中第一个1的位置作为寄存器的值
+// 3.sparse用ZERO XZERO VAL三种操作码表示连续相同的寄存器, 超过hllSparseMaxBytes或者值大于32时转成dense
+// 4.PFCOUNT不修改key, 只在缓存有效时使用缓存(比如从redis导入的rdb), PFADD和PFMERGE之后缓存失效
+
+const (
+	hllP              = 14
+	hllQ              = 64 - hllP
+	hllRegisters      = 1 << hllP
+	hllRegisterMax    = 1<<6 - 1
+	hllHeaderSize     = 16
+	hllDenseSize      = hllHeaderSize + (hllRegisters*6+7)/8
+	hllDense          = 0
+	hllSparse         = 1
+	hllSparseValMax   = 32
+	hllSparseMaxBytes = 3000
+	hllAlphaInf       = 0.721347520444481703680
+)
+
+var hllMagic = []byte("HYLL")
+
+const errNotHll = "WRONGTYPE Key is not a valid HyperLogLog string value."
+
+func murmurHash64A(key []byte, seed uint64) uint64 {
+	const m = 0xc6a4a7935bd1e995
+	const r = 47
+	h := seed ^ uint64(len(key))*m
+	n := len(key) / 8 * 8
+	for i := 0; i < n; i += 8 {
+		k := binary.LittleEndian.Uint64(key[i:])
+		k *= m
+		k ^= k >> r
+		k *= m
+		h ^= k
+		h *= m
+	}
+	tail := key[n:]
+	switch len(tail) {
+	case 7:
+		h ^= uint64(tail[6]) << 48
+		fallthrough
+	case 6:
+		h ^= uint64(tail[5]) << 40
+		fallthrough
+	case 5:
+		h ^= uint64(tail[4]) << 32
+		fallthrough
+	case 4:
+		h ^= uint64(tail[3]) << 24
+		fallthrough
+	case 3:
+		h ^= uint64(tail[2]) << 16
+		fallthrough
+	case 2:
+		h ^= uint64(tail[1]) << 8
+		fallthrough
+	case 1:
+		h ^= uint64(tail[0])
+		h *= m
+	}
+	h ^= h >> r
+	h *= m
+	h ^= h >> r
+	return h
+}
+
+// 元素对应的寄存器和值
+func hllPatLen(element []byte) (int, uint8) {
+	hash := murmurHash64A(element, 0xadc83b19)
+	index := int(hash & (hllRegisters - 1))
+	hash >>= hllP
+	//保证循环能结束
+	hash |= 1 << hllQ
+	count := uint8(1)
+	for bit := uint64(1); hash&bit == 0; bit <<= 1 {
+		count++
+	}
+	return index, count
+}
+
+func denseGet(regs []byte, i int) uint8 {
+	pos := i * 6
+	b, fb := pos/8, uint(pos&7)
+	v := regs[b] >> fb
+	if b+1 < len(regs) {
+		v |= regs[b+1] << (8 - fb)
+	}
+	return v & hllRegisterMax
+}
+
+func denseSet(regs []byte, i int, val uint8) {
+	pos := i * 6
+	b, fb := pos/8, uint(pos&7)
+	regs[b] &^= hllRegisterMax << fb
+	regs[b] |= val << fb
+	if b+1 < len(regs) {
+		regs[b+1] &^= hllRegisterMax >> (8 - fb)
+		regs[b+1] |= val >> (8 - fb)
+	}
+}
+
+func newHllHeader(encoding byte) []byte {
+	header := make([]byte, hllHeaderSize)
+	copy(header, hllMagic)
+	header[4] = encoding
+	//缓存无效
+	header[15] = 1 << 7
+	return header
+}
+
+// 空的hll: sparse编码, 一个XZERO表示所有寄存器都是0
+func newHll() []byte {
+	return append(newHllHeader(hllSparse), sparseEncode(make([]uint8, hllRegisters))...)
+}
+
+// 把hll解码成寄存器数组, 格式不对时返回false
+func hllRegistersOf(hll []byte) ([]uint8, bool) {
+	if len(hll) < hllHeaderSize || !bytes.Equal(hll[:4], hllMagic) {
+		return nil, false
+	}
+	regs := make([]uint8, hllRegisters)
+	switch hll[4] {
+	case hllDense:
+		if len(hll) != hllDenseSize {
+			return nil, false
+		}
+		for i := range regs {
+			regs[i] = denseGet(hll[hllHeaderSize:], i)
+		}
+		return regs, true
+	case hllSparse:
+		return regs, sparseDecode(hll[hllHeaderSize:], regs)
+	}
+	return nil, false
+}
+
+// ZERO: 00xxxxxx 长度1-64, XZERO: 01xxxxxx yyyyyyyy 长度1-16384, VAL: 1vvvvvxx 值1-32 长度1-4
+func sparseDecode(data []byte, regs []uint8) bool {
+	i := 0
+	for p := 0; p < len(data); p++ {
+		op := data[p]
+		var runLen int
+		var val uint8
+		switch {
+		case op&0xc0 == 0:
+			runLen = int(op&0x3f) + 1
+		case op&0xc0 == 0x40:
+			if p+1 >= len(data) {
+				return false
+			}
+			runLen = (int(op&0x3f)<<8 | int(data[p+1])) + 1
+			p++
+		default:
+			val = (op>>2)&0x1f + 1
+			runLen = int(op&0x3) + 1
+		}
+		if i+runLen > hllRegisters {
+			return false
+		}
+		for j := 0; j < runLen; j++ {
+			regs[i+j] = val
+		}
+		i += runLen
+	}
+	return i == hllRegisters
+}
+
+// 寄存器的值都不超过hllSparseValMax时才能使用
+func sparseEncode(regs []uint8) []byte {
+	data := make([]byte, 0)
+	for i := 0; i < len(regs); {
+		j := i
+		for j+1 < len(regs) && regs[j+1] == regs[i] {
+			j++
+		}
+		runLen := j - i + 1
+		if regs[i] == 0 {
+			for runLen > 0 {
+				if runLen > 64 {
+					n := runLen
+					if n > hllRegisters {
+						n = hllRegisters
+					}
+					data = append(data, 0x40|byte((n-1)>>8), byte(n-1))
+					runLen -= n
+				} else {
+					data = append(data, byte(runLen-1))
+					runLen = 0
+				}
+			}
+		} else {
+			for runLen > 0 {
+				n := runLen
+				if n > 4 {
+					n = 4
+				}
+				data = append(data, 0x80|(regs[i]-1)<<2|byte(n-1))
+				runLen -= n
+			}
+		}
+		i = j + 1
+	}
+	return data
+}
+
+// 根据寄存器生成hll, preferSparse时尽量使用sparse编码
+func hllFromRegisters(regs []uint8, preferSparse bool) []byte {
+	if preferSparse {
+		sparse := true
+		for _, v := range regs {
+			if v > hllSparseValMax {
+				sparse = false
+				break
+			}
+		}
+		if sparse {
+			if data := sparseEncode(regs); len(data) <= hllSparseMaxBytes {
+				return append(newHllHeader(hllSparse), data...)
+			}
+		}
+	}
+	hll := append(newHllHeader(hllDense), make([]byte, hllDenseSize-hllHeaderSize)...)
+	for i, v := range regs {
+		if v != 0 {
+			denseSet(hll[hllHeaderSize:], i, v)
+		}
+	}
+	return hll
+}
+
+func hllSigma(x float64) float64 {
+	if x == 1 {
+		return math.Inf(1)
+	}
+	y, z := 1.0, x
+	for {
+		x *= x
+		zPrime := z
+		z += x * y
+		y += y
+		if zPrime == z {
+			return z
+		}
+	}
+}
+
+func hllTau(x float64) float64 {
+	if x == 0 || x == 1 {
+		return 0
+	}
+	y, z := 1.0, 1-x
+	for {
+		x = math.Sqrt(x)
+		zPrime := z
+		y *= 0.5
+		z -= math.Pow(1-x, 2) * y
+		if zPrime == z {
+			return z / 3
+		}
+	}
+}
+
+// 和redis一样使用Otmar Ertl的估计方法
+func hllCount(regs []uint8) uint64 {
+	var histogram [hllQ + 2]int
+	for _, v := range regs {
+		histogram[v]++
+	}
+	m := float64(hllRegisters)
+	z := m * hllTau((m-float64(histogram[hllQ+1]))/m)
+	for j := hllQ; j >= 1; j-- {
+		z += float64(histogram[j])
+		z *= 0.5
+	}
+	z += m * hllSigma(float64(histogram[0])/m)
+	return uint64(math.Round(hllAlphaInf * m * m / z))
+}
+
+// 读取hll, 不存在时返回nil, 不是hll时返回错误信息
+func (db *SaveDBTables) getHll(key string) ([]byte, string) {
+	value, err := db.GetString(key)
+	if err != nil {
+		return nil, err.Error()
+	}
+	if value == nil {
+		return nil, ""
+	}
+	if len(value) < hllHeaderSize || !bytes.Equal(value[:4], hllMagic) ||
+		(value[4] != hllDense && value[4] != hllSparse) || (value[4] == hllDense && len(value) != hllDenseSize) {
+		return nil, errNotHll
+	}
+	return value, ""
+}
+
+// PfAdd PFADD key [element ...], 有寄存器变化或者新建了key时回复1
+func PfAdd(db *SaveDBTables, args []string) Reply {
+	if len(args) < 1 {
+		return errReply("ERR wrong number of arguments for 'pfadd' command")
+	}
+	key := args[0]
+	hll, msg := db.getHll(key)
+	if msg != "" {
+		return errReply(msg)
+	}
+	changed := false
+	if hll == nil {
+		hll = newHll()
+		changed = true
+	}
+	if hll[4] == hllDense {
+		//dense直接修改寄存器
+		for _, element := range args[1:] {
+			index, count := hllPatLen([]byte(element))
+			if count > denseGet(hll[hllHeaderSize:], index) {
+				denseSet(hll[hllHeaderSize:], index, count)
+				changed = true
+			}
+		}
+	} else {
+		regs, ok := hllRegistersOf(hll)
+		if !ok {
+			return errReply(errNotHll)
+		}
+		regsChanged := false
+		for _, element := range args[1:] {
+			index, count := hllPatLen([]byte(element))
+			if count > regs[index] {
+				regs[index] = count
+				regsChanged = true
+			}
+		}
+		if regsChanged {
+			hll = hllFromRegisters(regs, true)
+			changed = true
+		}
+	}
+	if !changed {
+		return MakeIntReply(0)
+	}
+	hll[15] |= 1 << 7
+	db.putString(key, hll, true)
+	db.addAof(ToCmdLine2("pfadd", args...))
+	return MakeIntReply(1)
+}
+
+// PfCount PFCOUNT key [key ...], 多个key时计算合并之后的基数
+func PfCount(db *SaveDBTables, args []string) Reply {
+	if len(args) < 1 {
+		return errReply("ERR wrong number of arguments for 'pfcount' command")
+	}
+	merged := make([]uint8, hllRegisters)
+	for _, key := range args {
+		hll, msg := db.getHll(key)
+		if msg != "" {
+			return errReply(msg)
+		}
+		if hll == nil {
+			continue
+		}
+		if len(args) == 1 && hll[15]&(1<<7) == 0 {
+			return MakeIntReply(int64(binary.LittleEndian.Uint64(hll[8:16])))
+		}
+		regs, ok := hllRegistersOf(hll)
+		if !ok {
+			return errReply(errNotHll)
+		}
+		for i, v := range regs {
+			if v > merged[i] {
+				merged[i] = v
+			}
+		}
+	}
+	return MakeIntReply(int64(hllCount(merged)))
+}
+
+// PfMerge PFMERGE destkey [sourcekey ...], 目标key原来的值也参与合并, 结果使用dense编码
+func PfMerge(db *SaveDBTables, args []string) Reply {
+	if len(args) < 1 {
+		return errReply("ERR wrong number of arguments for 'pfmerge' command")
+	}
+	merged := make([]uint8, hllRegisters)
+	for _, key := range args {
+		hll, msg := db.getHll(key)
+		if msg != "" {
+			return errReply(msg)
+		}
+		if hll == nil {
+			continue
+		}
+		regs, ok := hllRegistersOf(hll)
+		if !ok {
+			return errReply(errNotHll)
+		}
+		for i, v := range regs {
+			if v > merged[i] {
+				merged[i] = v
+			}
+		}
+	}
+	db.putString(args[0], hllFromRegisters(merged, false), true)
+	db.addAof(ToCmdLine2("pfmerge", args...))
+	return MakeOkReply()
+}
+
+// PFMERGE destkey [sourcekey ...]
+func pfMergeKeys(args []string) ([]string, []string) {
+	if len(args) == 0 {
+		return nil, nil
+	}
+	readKeys := make([]string, len(args)-1)
+	copy(readKeys, args[1:])
+	return readKeys, []string{args[0]}
+}
@@ -0,0 +1,66 @@
+package src
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestPfAdd(t *testing.T) {
+	s, c := makeMultiTestServer()
+	cases := [][]string{
+		{":1\r\n", "pfadd", "h"},
+		{":0\r\n", "pfadd", "h"},
+		{":1\r\n", "pfadd", "h", "a", "b", "c", "d", "e", "f", "g"},
+		{":0\r\n", "pfadd", "h", "a", "b"},
+		{":7\r\n", "pfcount", "h"},
+		{":0\r\n", "pfcount", "none"},
+		{":1\r\n", "pfadd", "h2", "g", "x", "y"},
+		{":9\r\n", "pfcount", "h", "h2"},
+		{"+OK\r\n", "pfmerge", "m", "h", "h2"},
+		{":9\r\n", "pfcount", "m"},
+		{"+OK\r\n", "set", "s", "foo"},
+		{"-WRONGTYPE Key is not a valid HyperLogLog string value.\r\n", "pfadd", "s", "a"},
+	}
+	for _, tc := range cases {
+		if r := execTestCmd(s, c, tc[1:]...); r != tc[0] {
+			t.Errorf("%v: expect %q, actual %q", tc[1:], tc[0], r)
+		}
+	}
+}
+
+func TestPfCountDense(t *testing.T) {
+	s, c := makeMultiTestServer()
+	const n = 50000
+	for i := 0; i < n; i += 100 {
+		args := []string{"pfadd", "h"}
+		for j := i; j < i+100; j++ {
+			args = append(args, "element:"+strconv.Itoa(j))
+		}
+		execTestCmd(s, c, args...)
+	}
+	db := s.FindDB(0)
+	hll, _ := db.getHll("h")
+	if hll == nil || hll[4] != hllDense {
+		t.Fatalf("expect dense encoding")
+	}
+	regs, _ := hllRegistersOf(hll)
+	count := hllCount(regs)
+	if count < n*98/100 || count > n*102/100 {
+		t.Errorf("count %d is too far from %d", count, n)
+	}
+	//dense转sparse再转回来寄存器不变
+	for i := 0; i < hllRegisters; i++ {
+		if regs[i] > hllSparseValMax {
+			regs[i] = hllSparseValMax
+		}
+	}
+	decoded, ok := hllRegistersOf(append(newHllHeader(hllSparse), sparseEncode(regs)...))
+	if !ok {
+		t.Fatalf("decode sparse failed")
+	}
+	for i := range regs {
+		if regs[i] != decoded[i] {
+			t.Fatalf("register %d: expect %d, actual %d", i, regs[i], decoded[i])
+		}
+	}
+}
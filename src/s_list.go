@@ -25,7 +25,7 @@ func (db *SaveDBTables) GetOrCreateList(key string) (*List, error) {
 		return val.(*List), nil
 	}
 	if _, ok := val.(*List); !ok {
-		return nil, fmt.Errorf("type conversion error")
+		return nil, errWrongType
 	}
 	return val.(*List), nil
 }
@@ -36,101 +36,103 @@ func (db *SaveDBTables) GetList(key string) (*List, error) {
 		return nil, nil
 	}
 	if _, ok := val.(*List); !ok {
-		return nil, fmt.Errorf("type conversion error")
+		return nil, errWrongType
 	}
 	db.AllKeys.ActivateKey(key)
 	return val.(*List), nil
 }
-func LLen(db *SaveDBTables, args []string) Result {
+func LLen(db *SaveDBTables, args []string) Reply {
 	key := args[0]
 
 	list, err := db.GetList(key)
 	if err != nil {
-		return CreateStrResult(CErr, err.Error())
+		return errReply(err.Error())
 	}
 	if list == nil {
-		return CreateStrResult(CErr, "list not exist")
+		return MakeIntReply(0)
 	}
 	size := list.L.Len()
-	return CreateStrResult(COk, strconv.Itoa(size))
+	return MakeIntReply(int64(size))
 }
 
-func LPop(db *SaveDBTables, args []string) Result {
+func LPop(db *SaveDBTables, args []string) Reply {
 	key := args[0]
 
 	// get data
 	list, err := db.GetList(key)
 	if err != nil {
-		return CreateStrResult(CErr, err.Error())
+		return errReply(err.Error())
 	}
 	if list == nil {
-		return CreateStrResult(CErr, "list not exist")
+		return MakeNullBulkReply()
 	}
-	val, _ := list.L.Remove(0).(string)
+	val, _ := list.L.Remove(0).([]byte)
 	if list.L.Len() == 0 {
 		Del(db, args)
 	}
 	db.addAof(ToCmdLine2("lpop", args...))
-	return CreateStrResult(COk, val)
+	return MakeBulkReply(val)
 }
 
-func LPush(db *SaveDBTables, args []string) Result {
+func LPush(db *SaveDBTables, args []string) Reply {
 	key := args[0]
 	values := args[1:]
 
 	list, err := db.GetOrCreateList(key)
 	if err != nil {
-		return CreateStrResult(CErr, err.Error())
+		return errReply(err.Error())
 	}
 	// insert
 	for _, value := range values {
-		list.L.Insert(0, value)
+		list.L.Insert(0, []byte(value))
 	}
 	db.addAof(ToCmdLine2("lpush", args...))
-	return CreateStrResult(COk, strconv.Itoa(list.L.Len()))
+	db.signalKey(key)
+	return MakeIntReply(int64(list.L.Len()))
 }
 
-func LPushX(db *SaveDBTables, args []string) Result {
+func LPushX(db *SaveDBTables, args []string) Reply {
 	key := args[0]
 	values := args[1:]
 
 	// get or init entity
 	list, err := db.GetList(key)
 	if err != nil {
-		return CreateStrResult(CErr, err.Error())
+		return errReply(err.Error())
 	}
 	if list == nil {
-		return CreateStrResult(CErr, "list not exist")
+		return MakeIntReply(0)
 	}
 	// insert
 	for _, value := range values {
-		list.L.Insert(0, value)
+		list.L.Insert(0, []byte(value))
 	}
 	db.addAof(ToCmdLine2("lpushx", args...))
-	return CreateStrResult(COk, strconv.Itoa(len(values)))
+	db.signalKey(key)
+	return MakeIntReply(int64(len(values)))
 }
 
-func LRange(db *SaveDBTables, args []string) Result {
+func LRange(db *SaveDBTables, args []string) Reply {
 	// parse args
 	key := args[0]
 	start64, err := strconv.ParseInt(string(args[1]), 10, 64)
 	if err != nil {
-		return CreateStrResult(CErr, "ERR value is not an integer or out of range")
+		return errReply("ERR value is not an integer or out of range")
 	}
 	start := int(start64)
 	stop64, err := strconv.ParseInt(args[2], 10, 64)
 	if err != nil {
-		return CreateStrResult(CErr, "args tar")
+		return errReply("args tar")
 	}
 	stop := int(stop64)
 
 	// get data
 	list, err := db.GetList(key)
 	if err != nil {
-		return CreateStrResult(CErr, err.Error())
+		return errReply(err.Error())
 	}
 	if list == nil {
-		return CreateStrResult(CErr, "list not exist")
+		return MakeEmptyMultiBulkReply()
 	}
 
 	// compute index
@@ -140,7 +142,7 @@ func LRange(db *SaveDBTables, args []string) Result {
 	} else if start < 0 {
 		start = size + start
 	} else if start >= size {
-		return CreateResult(COk, nil)
+		return MakeEmptyMultiBulkReply()
 	}
 	if stop < -1*size {
 		stop = 0
@@ -157,32 +159,29 @@ func LRange(db *SaveDBTables, args []string) Result {
 
 	// assert: start in [0, size - 1], stop in [start, size]
 	slice := list.L.Range(start, stop)
-	result := make([]string, len(slice))
-
+	result := make([][]byte, len(slice))
 	for i, raw := range slice {
-		bytes, _ := raw.(string)
-		result[i] = bytes
+		result[i], _ = raw.([]byte)
 	}
-	r := strings.Join(result, ",")
-	return CreateStrResult(COk, r)
+	return MakeMultiBulkReply(result)
 }
 
-func LRem(db *SaveDBTables, args []string) Result {
+func LRem(db *SaveDBTables, args []string) Reply {
 	// parse args
 	key := args[0]
 	count64, err := strconv.ParseInt(args[1], 10, 64)
 	if err != nil {
-		return CreateStrResult(CErr, "ERR value is not an integer or out of range")
+		return errReply("ERR value is not an integer or out of range")
 	}
 	count := int(count64)
-	value := args[2]
+	value := []byte(args[2])
 
 	list, err := db.GetList(key)
 	if err != nil {
-		return CreateStrResult(CErr, err.Error())
+		return errReply(err.Error())
 	}
 	if list == nil {
-		return CreateStrResult(CErr, "list not exist")
+		return MakeIntReply(0)
 	}
 
 	var removed int
@@ -201,22 +200,22 @@ func LRem(db *SaveDBTables, args []string) Result {
 	}
 
 	if list.L.Len() == 0 {
-		Del(db, args)
+		Del(db, args[:1])
 	}
 	if removed > 0 {
 		//persistence
 		db.addAof(ToCmdLine2("lrem", args...))
 	}
 
-	return CreateStrResult(COk, strconv.Itoa(removed))
+	return MakeIntReply(int64(removed))
 }
 
-func LSet(db *SaveDBTables, args []string) Result {
+func LSet(db *SaveDBTables, args []string) Reply {
 	// parse args
 	key := args[0]
 	index64, err := strconv.ParseInt(args[1], 10, 64)
 	if err != nil {
-		return CreateStrResult(CErr, "ERR value is not an integer or out of range")
+		return errReply("ERR value is not an integer or out of range")
 	}
 
 	index := int(index64)
@@ -224,114 +223,82 @@ func LSet(db *SaveDBTables, args []string) Result {
 	// get data
 	list, err := db.GetList(key)
 	if err != nil {
-		return CreateStrResult(CErr, err.Error())
-	}
-	if list == nil {
-		return CreateStrResult(CErr, "list not exist")
+		return errReply(err.Error())
 	}
 	if list == nil {
-		return CreateStrResult(CErr, "ERR no such key")
+		return errReply("ERR no such key")
 	}
 
 	size := list.L.Len() // assert: size > 0
 	if index < -1*size {
-		return CreateStrResult(CErr, "ERR index out of range")
+		return errReply("ERR index out of range")
 	} else if index < 0 {
 		index = size + index
 	} else if index >= size {
-		return CreateStrResult(CErr, "ERR index out of range")
+		return errReply("ERR index out of range")
 	}
 
-	list.L.Set(index, value)
+	list.L.Set(index, []byte(value))
 	db.AllKeys.PutKey(key, TypeList)
 	db.addAof(ToCmdLine2("lset", args...))
-	return CreateResult(COk, nil)
+	return MakeOkReply()
 }
 
-func RPop(db *SaveDBTables, args []string) Result {
+func RPop(db *SaveDBTables, args []string) Reply {
 	// parse args
 	key := args[0]
 
 	// get data
 	list, err := db.GetList(key)
 	if err != nil {
-		return CreateStrResult(CErr, err.Error())
+		return errReply(err.Error())
 	}
 	if list == nil {
-		return CreateStrResult(CErr, "list not exist")
-	}
-	if list == nil {
-		return CreateStrResult(CErr, "ERR no such key")
+		return MakeNullBulkReply()
 	}
 
-	val, _ := list.L.RemoveLast().(string)
+	val, _ := list.L.RemoveLast().([]byte)
 	if list.L.Len() == 0 {
 		Del(db, args)
 	}
 	//persistence
 	db.addAof(ToCmdLine2("rpop", args...))
-	return CreateStrResult(COk, val)
+	return MakeBulkReply(val)
 }
 
-func RPopLPush(db *SaveDBTables, args []string) Result {
-	sourceKey := args[0]
-	destKey := args[1]
-
-	// get source entity
-	list, err := db.GetList(sourceKey)
-	if err != nil {
-		return CreateStrResult(CErr, err.Error())
-	}
-	if list == nil {
-		return CreateStrResult(CErr, "list not exist")
-	}
-
-	// get dest entity
-	destList, err := db.GetList(destKey)
-	if err != nil {
-		return CreateStrResult(CErr, err.Error())
-	}
-	if destList == nil {
-		return CreateStrResult(CErr, "list not exist")
+func RPopLPush(db *SaveDBTables, args []string) Reply {
+	val, moved, errRes := listMove(db, args[0], args[1], false, true)
+	if errRes != nil {
+		return errRes
 	}
-	if destList == nil {
-		return CreateStrResult(CErr, "list not exist")
+	if !moved {
+		return MakeNullBulkReply()
 	}
-	// pop and push
-	val, _ := list.L.RemoveLast().(string)
-	destList.L.Insert(0, val)
-
-	if list.L.Len() == 0 {
-		Del(db, args)
-	}
-	db.addAof(ToCmdLine2("rpoplpush", args...))
-	return CreateStrResult(COk, val)
+	return MakeBulkReply(val)
 }
 
-func RPush(db *SaveDBTables, args []string) Result {
+func RPush(db *SaveDBTables, args []string) Reply {
 	// parse args
 	key := args[0]
 	values := args[1:]
 
 	// get or init entity
-	list, err := db.GetList(key)
+	list, err := db.GetOrCreateList(key)
 	if err != nil {
-		return CreateStrResult(CErr, err.Error())
-	}
-	if list == nil {
-		return CreateStrResult(CErr, "list not exist")
+		return errReply(err.Error())
 	}
 	// put list
 	for _, value := range values {
-		list.L.Add(value)
+		list.L.Add([]byte(value))
 	}
 	db.addAof(ToCmdLine2("rpush", args...))
-	return CreateStrResult(COk, strconv.Itoa(list.L.Len()))
+	db.signalKey(key)
+	return MakeIntReply(int64(list.L.Len()))
 }
 
-func RPushX(db *SaveDBTables, args []string) Result {
+func RPushX(db *SaveDBTables, args []string) Reply {
 	if len(args) < 2 {
-		return CreateStrResult(CErr, "ERR wrong number of arguments for 'rpush' command")
+		return errReply("ERR wrong number of arguments for 'rpush' command")
 	}
 	key := args[0]
 	values := args[1:]
@@ -339,40 +306,41 @@ func RPushX(db *SaveDBTables, args []string) Result {
 	// get or init entity
 	list, err := db.GetList(key)
 	if err != nil {
-		return CreateStrResult(CErr, err.Error())
+		return errReply(err.Error())
 	}
 	if list == nil {
-		return CreateStrResult(CErr, "list not exist")
+		return MakeIntReply(0)
 	}
 
 	// put list
 	for _, value := range values {
-		list.L.Add(value)
+		list.L.Add([]byte(value))
 	}
 	db.addAof(ToCmdLine2("rpushx", args...))
-	return CreateStrResult(COk, strconv.Itoa(list.L.Len()))
+	db.signalKey(key)
+	return MakeIntReply(int64(list.L.Len()))
 }
 
-func LTrim(db *SaveDBTables, args []string) Result {
+func LTrim(db *SaveDBTables, args []string) Reply {
 	n := len(args)
 	if n != 3 {
-		return CreateStrResult(CErr, fmt.Sprintf("ERR wrong number of arguments (given %d, expected 3)", n))
+		return errReply(fmt.Sprintf("ERR wrong number of arguments (given %d, expected 3)", n))
 	}
 	key := args[0]
 	start, err := strconv.Atoi(args[1])
 	if err != nil {
-		return CreateStrResult(CErr, "ERR value is not an integer or out of range")
+		return errReply("ERR value is not an integer or out of range")
 	}
 	end, err := strconv.Atoi(args[2])
 	if err != nil {
-		return CreateStrResult(CErr, "ERR value is not an integer or out of range")
+		return errReply("ERR value is not an integer or out of range")
 	}
 	list, err := db.GetList(key)
 	if err != nil {
-		return CreateStrResult(CErr, err.Error())
+		return errReply(err.Error())
 	}
 	if list == nil {
-		return CreateStrResult(CErr, "list not exist")
+		return MakeOkReply()
 	}
 	length := list.L.Len()
 	if start < 0 {
@@ -392,26 +360,26 @@ func LTrim(db *SaveDBTables, args []string) Result {
 		list.L.RemoveLast()
 	}
 	db.addAof(ToCmdLine2("ltrim", args...))
-	return CreateResult(COk, nil)
+	return MakeOkReply()
 }
 
-func LInsert(db *SaveDBTables, args []string) Result {
+func LInsert(db *SaveDBTables, args []string) Reply {
 	n := len(args)
 	if n != 4 {
-		return CreateStrResult(CErr, "ERR wrong number of arguments for 'linsert' command")
+		return errReply("ERR wrong number of arguments for 'linsert' command")
 	}
 	key := args[0]
 	list, err := db.GetList(key)
 	if err != nil {
-		return CreateStrResult(CErr, err.Error())
+		return errReply(err.Error())
 	}
 	if list == nil {
-		return CreateStrResult(CErr, "list not exist")
+		return MakeIntReply(0)
 	}
 
 	dir := strings.ToLower(args[1])
 	if dir != "before" && dir != "after" {
-		return CreateStrResult(CErr, "ERR syntax error")
+		return errReply("ERR syntax error")
 	}
 
 	pivot := args[2]
@@ -424,15 +392,167 @@ func LInsert(db *SaveDBTables, args []string) Result {
 		return true
 	})
 	if index == -1 {
-		return CreateStrResult(CErr, "index = -1")
+		return MakeIntReply(-1)
 	}
 
-	val := args[3]
+	val := []byte(args[3])
 	if dir == "before" {
 		list.L.Insert(index, val)
 	} else {
 		list.L.Insert(index+1, val)
 	}
 	db.addAof(ToCmdLine2("linsert", args...))
-	return CreateStrResult(COk, strconv.Itoa(list.L.Len()))
+	db.signalKey(key)
+	return MakeIntReply(int64(list.L.Len()))
+}
+
+// 从list的一端弹出, list空了之后删除key, 记录成等价的非阻塞命令
+func popListEnd(db *SaveDBTables, key string, list *List, left bool) []byte {
+	var val []byte
+	if left {
+		val, _ = list.L.Remove(0).([]byte)
+		db.addAof(ToCmdLine2("lpop", key))
+	} else {
+		val, _ = list.L.RemoveLast().([]byte)
+		db.addAof(ToCmdLine2("rpop", key))
+	}
+	if list.L.Len() == 0 {
+		db.Data.RemoveWithLock(key)
+		db.AllKeys.RemoveKey(db, key)
+	}
+	return val
+}
+
+func parseListEnd(arg string) (left bool, ok bool) {
+	switch strings.ToLower(arg) {
+	case "left":
+		return true, true
+	case "right":
+		return false, true
+	}
+	return false, false
+}
+
+// 依次检查每个key, 从第一个非空的list中弹出, 都为空时阻塞
+func blockingPop(db *SaveDBTables, args []string, left bool) Reply {
+	if len(args) < 2 {
+		name := "brpop"
+		if left {
+			name = "blpop"
+		}
+		return errReply("ERR wrong number of arguments for '" + name + "' command")
+	}
+	timeout, err := parseBlockSeconds(args[len(args)-1])
+	if err != nil {
+		return errReply(err.Error())
+	}
+	for _, key := range args[:len(args)-1] {
+		list, err := db.GetList(key)
+		if err != nil {
+			return errReply(err.Error())
+		}
+		if list == nil {
+			continue
+		}
+		val := popListEnd(db, key, list, left)
+		return MakeMultiBulkReply([][]byte{[]byte(key), val})
+	}
+	return blockResult(timeout)
+}
+
+// BLPOP key [key ...] timeout
+func BLPop(db *SaveDBTables, args []string) Reply {
+	return blockingPop(db, args, true)
+}
+
+// BRPOP key [key ...] timeout
+func BRPop(db *SaveDBTables, args []string) Reply {
+	return blockingPop(db, args, false)
+}
+
+// source为空时moved为false, 调用方决定是否阻塞
+func listMove(db *SaveDBTables, source, destination string, from, to bool) (val []byte, moved bool, errRes Reply) {
+	list, err := db.GetList(source)
+	if err != nil {
+		return nil, false, errReply(err.Error())
+	}
+	if _, err = db.GetList(destination); err != nil {
+		return nil, false, errReply(err.Error())
+	}
+	if list == nil {
+		return nil, false, nil
+	}
+	if from {
+		val, _ = list.L.Remove(0).([]byte)
+	} else {
+		val, _ = list.L.RemoveLast().([]byte)
+	}
+	if list.L.Len() == 0 {
+		db.Data.RemoveWithLock(source)
+		db.AllKeys.RemoveKey(db, source)
+	}
+	//source和destination可能是同一个key, 弹出之后再取destination
+	destList, _ := db.GetOrCreateList(destination)
+	if to {
+		destList.L.Insert(0, val)
+	} else {
+		destList.L.Add(val)
+	}
+	db.addAof(ToCmdLine2("lmove", source, destination, listEndName(from), listEndName(to)))
+	db.signalKey(destination)
+	return val, true, nil
+}
+
+func listEndName(left bool) string {
+	if left {
+		return "left"
+	}
+	return "right"
+}
+
+// LMOVE source destination LEFT|RIGHT LEFT|RIGHT
+func LMove(db *SaveDBTables, args []string) Reply {
+	from, ok1 := parseListEnd(args[2])
+	to, ok2 := parseListEnd(args[3])
+	if !ok1 || !ok2 {
+		return errReply("ERR syntax error")
+	}
+	val, moved, errRes := listMove(db, args[0], args[1], from, to)
+	if errRes != nil {
+		return errRes
+	}
+	if !moved {
+		return MakeNullBulkReply()
+	}
+	return MakeBulkReply(val)
+}
+
+// BLMOVE source destination LEFT|RIGHT LEFT|RIGHT timeout
+func BLMove(db *SaveDBTables, args []string) Reply {
+	from, ok1 := parseListEnd(args[2])
+	to, ok2 := parseListEnd(args[3])
+	if !ok1 || !ok2 {
+		return errReply("ERR syntax error")
+	}
+	return blockingMove(db, args[0], args[1], from, to, args[4])
+}
+
+// BRPOPLPUSH source destination timeout, 等价于BLMOVE source destination RIGHT LEFT timeout
+func BRPopLPush(db *SaveDBTables, args []string) Reply {
+	return blockingMove(db, args[0], args[1], false, true, args[2])
+}
+
+func blockingMove(db *SaveDBTables, source, destination string, from, to bool, rawTimeout string) Reply {
+	timeout, err := parseBlockSeconds(rawTimeout)
+	if err != nil {
+		return errReply(err.Error())
+	}
+	val, moved, errRes := listMove(db, source, destination, from, to)
+	if errRes != nil {
+		return errRes
+	}
+	if !moved {
+		return blockResult(timeout)
+	}
+	return MakeBulkReply(val)
 }
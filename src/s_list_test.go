@@ -0,0 +1,82 @@
+package src
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBlockingPop(t *testing.T) {
+	initTestLog(t)
+	s, c := makeMultiTestServer()
+	cases := [][]string{
+		{":2\r\n", "rpush", "l", "a", "b"},
+		{"*2\r\n$1\r\nl\r\n$1\r\na\r\n", "blpop", "none", "l", "0"},
+		{"*2\r\n$1\r\nl\r\n$1\r\nb\r\n", "brpop", "l", "1"},
+		{"*-1\r\n", "blpop", "l", "0.01"},
+		{"-ERR timeout is negative\r\n", "blpop", "l", "-1"},
+		{":2\r\n", "rpush", "src", "x", "y"},
+		{"$1\r\ny\r\n", "blmove", "src", "dst", "right", "left", "0"},
+		{"$1\r\nx\r\n", "brpoplpush", "src", "dst", "0"},
+		{"*2\r\n$1\r\nx\r\n$1\r\ny\r\n", "lrange", "dst", "0", "-1"},
+		{":2\r\n", "zadd", "z", "1", "a", "2", "b"},
+		{"*3\r\n$1\r\nz\r\n$1\r\nb\r\n$1\r\n2\r\n", "bzpopmax", "z", "0"},
+		{"*3\r\n$1\r\nz\r\n$1\r\na\r\n$1\r\n1\r\n", "bzpopmin", "z", "0"},
+		{"*-1\r\n", "bzpopmin", "z", "0.01"},
+	}
+	for _, tc := range cases {
+		if r := execTestCmd(s, c, tc[1:]...); r != tc[0] {
+			t.Errorf("%v: expect %q, actual %q", tc[1:], tc[0], r)
+		}
+	}
+}
+
+func TestBlockingPopFIFO(t *testing.T) {
+	initTestLog(t)
+	s, c := makeMultiTestServer()
+	var waiters []*Connection
+	for i := 0; i < 2; i++ {
+		w := &Connection{protocol: ProtocolResp2, Writer: make(chan *Message, WriterQueueSize)}
+		command := "blpop"
+		go s.Exec(w, &Message{Command: &command, Args: []string{"l", "0"}})
+		waiters = append(waiters, w)
+		time.Sleep(50 * time.Millisecond)
+	}
+	for i, expect := range []string{"*2\r\n$1\r\nl\r\n$1\r\na\r\n", "*2\r\n$1\r\nl\r\n$1\r\nb\r\n"} {
+		execTestCmd(s, c, "rpush", "l", string(rune('a'+i)))
+		select {
+		case msg := <-waiters[i].Writer:
+			if r := string(*msg.ReturnData); r != expect {
+				t.Errorf("waiter %d expect %q, actual %q", i, expect, r)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("waiter %d is not served", i)
+		}
+	}
+	if r := execTestCmd(s, c, "llen", "l"); r != ":0\r\n" {
+		t.Errorf("list should be consumed, actual %q", r)
+	}
+}
+
+func TestBlockingPopClientClosed(t *testing.T) {
+	initTestLog(t)
+	s, c := makeMultiTestServer()
+	w := &Connection{protocol: ProtocolResp2, Writer: make(chan *Message, WriterQueueSize), closing: &connClosing{ch: make(chan struct{})}}
+	done := make(chan struct{})
+	go func() {
+		command := "blpop"
+		s.Exec(w, &Message{Command: &command, Args: []string{"l", "0"}})
+		close(done)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	//客户端断开之后不再等待, 之后写入的数据不能被它弹出
+	w.markClosed()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("blocked client should stop waiting after the connection is closed")
+	}
+	execTestCmd(s, c, "rpush", "l", "a")
+	if r := execTestCmd(s, c, "llen", "l"); r != ":1\r\n" {
+		t.Errorf("element should not be popped for a closed client, actual %q", r)
+	}
+}
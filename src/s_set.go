@@ -1,9 +1,10 @@
 package src
 
 import (
-	"fmt"
+	"math/rand"
 	"strconv"
 	"strings"
+	"time"
 )
 
 // Set 表的实现直接使用go的map,在此之前需要了解go中的map基本机制
@@ -31,7 +32,7 @@ func (db *SaveDBTables) GetOrCreateSet(key string) (*Set, error) {
 		return val.(*Set), nil
 	}
 	if _, ok := val.(*Set); !ok {
-		return nil, fmt.Errorf("type conversion error")
+		return nil, errWrongType
 	}
 	return val.(*Set), nil
 }
@@ -42,240 +43,461 @@ func (db *SaveDBTables) GetSet(key string) (*Set, error) {
 		return nil, nil
 	}
 	if _, ok := val.(*Set); !ok {
-		return nil, fmt.Errorf("type conversion error")
+		return nil, errWrongType
 	}
 	db.AllKeys.ActivateKey(key)
 	return val.(*Set), nil
 }
 
-func SAdd(db *SaveDBTables, args []string) Result {
+func SAdd(db *SaveDBTables, args []string) Reply {
 	key := args[0]
 	set, err := db.GetOrCreateSet(key)
 	if err != nil {
-		return CreateStrResult(CErr, err.Error())
+		return errReply(err.Error())
 	}
+	added := 0
 	for _, value := range args[1:] {
-		set.M[value] = &struct{}{}
+		if _, ok := set.M[value]; !ok {
+			set.M[value] = &struct{}{}
+			added++
+		}
 	}
 	db.addAof(ToCmdLine2("sadd", args...))
-	return CreateStrResult(COk, strconv.Itoa(len(args[1:])))
+	return MakeIntReply(int64(added))
 }
-func SRem(db *SaveDBTables, args []string) Result {
+func SRem(db *SaveDBTables, args []string) Reply {
 	key := args[0]
 	members := args[1:]
-	set, errReply := db.GetSet(key)
-	if errReply != nil {
-		return CreateStrResult(CErr, errReply.Error())
+	set, err := db.GetSet(key)
+	if err != nil {
+		return errReply(err.Error())
 	}
 	if set == nil {
-		return CreateStrResult(CErr, "key inexistence")
+		return MakeIntReply(0)
 	}
 	counter := 0
 	for _, member := range members {
-		delete(set.M, member)
-		counter++
+		if _, ok := set.M[member]; ok {
+			delete(set.M, member)
+			counter++
+		}
 	}
 	if len(set.M) == 0 {
-		Del(db, args)
+		Del(db, args[:1])
 	}
 	if counter > 0 {
 		db.addAof(ToCmdLine2("srem", args...))
 	}
-	return CreateStrResult(COk, strconv.Itoa(counter))
+	return MakeIntReply(int64(counter))
 }
 
-func SHasKey(db *SaveDBTables, args []string) Result {
+func SHasKey(db *SaveDBTables, args []string) Reply {
 	key := args[0]
 	v, err := db.GetSet(key)
 	if err != nil {
-		return CreateStrResult(CErr, err.Error())
+		return errReply(err.Error())
 	}
 	if v == nil {
-		return CreateStrResult(COk, "-1")
+		return MakeIntReply(-1)
 	}
-	return CreateStrResult(COk, "1")
+	return MakeIntReply(1)
 }
 
-func SPop(db *SaveDBTables, args []string) Result {
+// SPOP key [count], 弹出的成员记录成srem, 重放时删除同样的成员
+func SPop(db *SaveDBTables, args []string) Reply {
+	if len(args) != 1 && len(args) != 2 {
+		return errReply("ERR wrong number of arguments for 'spop' command")
+	}
 	key := args[0]
-	res := SHasKey(db, args)
-	if res.Status != 1 {
-		return res
+	count := 1
+	if len(args) == 2 {
+		n, err := strconv.Atoi(args[1])
+		if err != nil || n < 0 {
+			return errReply("ERR value is out of range, must be positive")
+		}
+		count = n
 	}
-	if string(res.Res) != "1" {
-		return CreateStrResult(CErr, "key inexistence")
+	set, err := db.GetSet(key)
+	if err != nil {
+		return errReply(err.Error())
 	}
-	v, _ := db.GetSet(key)
-
-	if v == nil {
-		return CreateStrResult(COk, "-1")
+	if set == nil || count == 0 {
+		if len(args) == 1 {
+			return MakeNullBulkReply()
+		}
+		return MakeEmptyMultiBulkReply()
 	}
-	for val, _ := range v.M {
-		delete(v.M, val)
-		return CreateStrResult(COk, val)
+	members := randomPick(set.members(), count, true)
+	for _, member := range members {
+		delete(set.M, member)
 	}
-
-	return CreateStrResult(CErr, "value inexistence")
-}
-
-func SCard(db *SaveDBTables, args []string) Result {
-	key := args[0]
-	res := SHasKey(db, args)
-	if res.Status != 1 {
-		return res
+	if len(set.M) == 0 {
+		db.removeKey(key)
 	}
-	if string(res.Res) != "1" {
-		return CreateStrResult(CErr, "key inexistence")
+	db.addAof(ToCmdLine2("srem", append([]string{key}, members...)...))
+	if len(args) == 1 {
+		return MakeBulkReply([]byte(members[0]))
 	}
-	v, _ := db.GetSet(key)
-
-	return CreateStrResult(COk, strconv.Itoa(len(v.M)))
+	return MakeStringsReply(members)
 }
 
-func SDiff(db *SaveDBTables, args []string) Result {
-	if (SHasKey(db, args[:1]).Status != COk) || (SHasKey(db, args[1:]).Status != COk) {
-		return CreateStrResult(CErr, "set not exist")
+// SRANDMEMBER key [count], count为负数时可以返回重复的成员
+func SRandMember(db *SaveDBTables, args []string) Reply {
+	if len(args) != 1 && len(args) != 2 {
+		return errReply("ERR wrong number of arguments for 'srandmember' command")
+	}
+	count := 1
+	if len(args) == 2 {
+		n, err := strconv.Atoi(args[1])
+		if err != nil {
+			return errReply("ERR value is not an integer or out of range")
+		}
+		count = n
 	}
-	records := make([]string, 0)
-	key1 := args[0]
-	key2 := args[1]
-	set, err := db.GetSet(key1)
+	set, err := db.GetSet(args[0])
 	if err != nil {
-		return CreateStrResult(CErr, err.Error())
-	}
-	if set == nil {
-		return CreateStrResult(CErr, "key inexistence")
+		return errReply(err.Error())
 	}
-	set2, err := db.GetSet(key2)
-	if err != nil {
-		return CreateStrResult(CErr, err.Error())
+	if set == nil || count == 0 {
+		if len(args) == 1 {
+			return MakeNullBulkReply()
+		}
+		return MakeEmptyMultiBulkReply()
 	}
-	if set2 == nil {
-		return CreateStrResult(CErr, "key inexistence")
+	if count < 0 {
+		return MakeStringsReply(randomPick(set.members(), -count, false))
 	}
-	for v, _ := range set.M {
-		if _, ok := set2.M[v]; !ok {
-			records = append(records, v)
-		}
+	members := randomPick(set.members(), count, true)
+	if len(args) == 1 {
+		return MakeBulkReply([]byte(members[0]))
 	}
-	result := strings.Join(records, ",")
-	return CreateStrResult(COk, result)
+	return MakeStringsReply(members)
 }
 
-func SInter(db *SaveDBTables, args []string) Result {
-	if SHasKey(db, args[:1]).Status != COk || SHasKey(db, args[1:]).Status != COk {
-		return CreateStrResult(CErr, "set not exist")
+// SMOVE source destination member
+func SMove(db *SaveDBTables, args []string) Reply {
+	src, dst, member := args[0], args[1], args[2]
+	srcSet, err := db.GetSet(src)
+	if err != nil {
+		return errReply(err.Error())
 	}
-	key1 := args[0]
-	key2 := args[1]
-	set, err := db.GetSet(key1)
+	dstSet, err := db.GetSet(dst)
 	if err != nil {
-		return CreateStrResult(CErr, err.Error())
+		return errReply(err.Error())
 	}
-	if set == nil {
-		return CreateStrResult(CErr, "key inexistence")
+	if srcSet == nil {
+		return MakeIntReply(0)
 	}
-	set2, err := db.GetSet(key2)
-	if err != nil {
-		return CreateStrResult(CErr, err.Error())
+	if _, ok := srcSet.M[member]; !ok {
+		return MakeIntReply(0)
 	}
-	if set2 == nil {
-		return CreateStrResult(CErr, "key inexistence")
+	if src == dst {
+		return MakeIntReply(1)
 	}
-	values := make([]string, 0)
-	for v, _ := range set.M {
-		if _, ok := set2.M[v]; ok {
-			values = append(values, v)
-		}
+	delete(srcSet.M, member)
+	if len(srcSet.M) == 0 {
+		db.removeKey(src)
+	}
+	if dstSet == nil {
+		dstSet = NewSet()
+		db.putKey(dst, dstSet, TypeSet, nil)
+	}
+	dstSet.M[member] = &struct{}{}
+	db.addAof(ToCmdLine2("smove", args...))
+	return MakeIntReply(1)
+}
+
+func SCard(db *SaveDBTables, args []string) Reply {
+	key := args[0]
+	v, err := db.GetSet(key)
+	if err != nil {
+		return errReply(err.Error())
 	}
-	result := strings.Join(values, ", ")
-	return CreateStrResult(COk, result)
+	if v == nil {
+		return MakeIntReply(0)
+	}
+	return MakeIntReply(int64(len(v.M)))
 }
 
-func SIsMember(db *SaveDBTables, args []string) Result {
+func SIsMember(db *SaveDBTables, args []string) Reply {
 	key := args[0]
 	set, err := db.GetSet(key)
 	if err != nil {
-		return CreateStrResult(CErr, err.Error())
+		return errReply(err.Error())
 	}
 	if set == nil {
-		return CreateStrResult(CErr, "key inexistence")
+		return MakeIntReply(0)
 	}
 	value := args[1]
 	if _, ok := set.M[value]; ok {
-		return CreateResult(COk, nil)
+		return MakeIntReply(1)
 	}
-
-	return CreateStrResult(CErr, "set not exist")
+	return MakeIntReply(0)
 }
 
-func SAreMembers(db *SaveDBTables, args []string) Result {
+func SAreMembers(db *SaveDBTables, args []string) Reply {
 	key := args[0]
 	set, err := db.GetSet(key)
 	if err != nil {
-		return CreateStrResult(CErr, err.Error())
+		return errReply(err.Error())
 	}
 	if set == nil {
-		return CreateStrResult(CErr, "key inexistence")
+		return MakeIntReply(0)
 	}
 
 	values := args[1:]
 	for _, value := range values {
 		if _, ok := set.M[value]; !ok {
-			return CreateStrResult(CErr, "set not exist")
+			return MakeIntReply(0)
 		}
 	}
-	return CreateResult(COk, nil)
+	return MakeIntReply(1)
 }
 
-func SMembers(db *SaveDBTables, args []string) Result {
+// SMISMEMBER key member [member ...]
+func SMIsMember(db *SaveDBTables, args []string) Reply {
+	if len(args) < 2 {
+		return errReply("ERR wrong number of arguments for 'smismember' command")
+	}
+	set, err := db.GetSet(args[0])
+	if err != nil {
+		return errReply(err.Error())
+	}
+	result := make([]Reply, len(args)-1)
+	for i, member := range args[1:] {
+		result[i] = MakeIntReply(0)
+		if set == nil {
+			continue
+		}
+		if _, ok := set.M[member]; ok {
+			result[i] = MakeIntReply(1)
+		}
+	}
+	return MakeMultiRawReply(result)
+}
+
+func SMembers(db *SaveDBTables, args []string) Reply {
 	key := args[0]
 	set, err := db.GetSet(key)
 	if err != nil {
-		return CreateStrResult(CErr, err.Error())
+		return errReply(err.Error())
 	}
 	if set == nil {
-		return CreateStrResult(CErr, "key inexistence")
+		return MakeEmptyMultiBulkReply()
 	}
 	records := make([]string, 0)
 	for k, _ := range set.M {
 		records = append(records, k)
 	}
-	result := strings.Join(records, ",")
-	return CreateStrResult(COk, result)
+	return MakeStringsReply(records)
 }
 
-func SUnion(db *SaveDBTables, args []string) Result {
-	if SHasKey(db, args[:1]).Status != COk || SHasKey(db, args[1:]).Status != COk {
-		return CreateStrResult(CErr, "set not exist")
+func (set *Set) members() []string {
+	members := make([]string, 0, len(set.M))
+	for member := range set.M {
+		members = append(members, member)
 	}
+	return members
+}
 
-	key1 := args[0]
-	key2 := args[1]
-	set, err := db.GetSet(key1)
+// 随机选出count个成员, distinct时不重复并且最多选出全部的成员
+// go的map遍历顺序不是均匀随机的, 所以先拿到所有的成员再用Fisher-Yates洗牌
+func randomPick(members []string, count int, distinct bool) []string {
+	r := rand.New(rand.NewSource(time.Now().UnixNano()))
+	if !distinct {
+		result := make([]string, count)
+		for i := range result {
+			result[i] = members[r.Intn(len(members))]
+		}
+		return result
+	}
+	if count > len(members) {
+		count = len(members)
+	}
+	for i := 0; i < count; i++ {
+		j := i + r.Intn(len(members)-i)
+		members[i], members[j] = members[j], members[i]
+	}
+	return members[:count]
+}
+
+// 集合运算的参数, 不存在的key当作空集合
+func (db *SaveDBTables) getSets(keys []string) ([]*Set, error) {
+	sets := make([]*Set, len(keys))
+	for i, key := range keys {
+		set, err := db.GetSet(key)
+		if err != nil {
+			return nil, err
+		}
+		sets[i] = set
+	}
+	return sets, nil
+}
+
+// 第一个集合中不在其他集合中的成员
+func diffSets(sets []*Set) *Set {
+	result := NewSet()
+	if sets[0] == nil {
+		return result
+	}
+	for member := range sets[0].M {
+		found := false
+		for _, other := range sets[1:] {
+			if other == nil {
+				continue
+			}
+			if _, ok := other.M[member]; ok {
+				found = true
+				break
+			}
+		}
+		if !found {
+			result.M[member] = &struct{}{}
+		}
+	}
+	return result
+}
+
+// 所有集合中都有的成员, 从最小的集合开始检查
+func interSets(sets []*Set) *Set {
+	result := NewSet()
+	smallest := 0
+	for i, set := range sets {
+		if set == nil {
+			return result
+		}
+		if len(set.M) < len(sets[smallest].M) {
+			smallest = i
+		}
+	}
+	for member := range sets[smallest].M {
+		found := true
+		for _, other := range sets {
+			if _, ok := other.M[member]; !ok {
+				found = false
+				break
+			}
+		}
+		if found {
+			result.M[member] = &struct{}{}
+		}
+	}
+	return result
+}
+
+func unionSets(sets []*Set) *Set {
+	result := NewSet()
+	for _, set := range sets {
+		if set == nil {
+			continue
+		}
+		for member := range set.M {
+			result.M[member] = &struct{}{}
+		}
+	}
+	return result
+}
+
+func setMembersReply(set *Set) Reply {
+	return MakeStringsReply(set.members())
+}
+
+func setAlgebra(db *SaveDBTables, cmd string, keys []string, op func(sets []*Set) *Set) Reply {
+	if len(keys) == 0 {
+		return errReply("ERR wrong number of arguments for '" + cmd + "' command")
+	}
+	sets, err := db.getSets(keys)
 	if err != nil {
-		return CreateStrResult(CErr, err.Error())
+		return errReply(err.Error())
 	}
-	if set == nil {
-		return CreateStrResult(CErr, "key inexistence")
+	return setMembersReply(op(sets))
+}
+
+// 结果保存到destination, 覆盖原来的值, 结果为空时删除destination
+func setAlgebraStore(db *SaveDBTables, cmd string, args []string, op func(sets []*Set) *Set) Reply {
+	if len(args) < 2 {
+		return errReply("ERR wrong number of arguments for '" + cmd + "' command")
 	}
-	set2, err := db.GetSet(key2)
+	dest := args[0]
+	sets, err := db.getSets(args[1:])
 	if err != nil {
-		return CreateStrResult(CErr, err.Error())
+		return errReply(err.Error())
 	}
-	if set2 == nil {
-		return CreateStrResult(CErr, "key inexistence")
+	result := op(sets)
+	db.removeKey(dest)
+	if len(result.M) > 0 {
+		db.putKey(dest, result, TypeSet, nil)
 	}
-	record1s := make([]string, 0)
-	for k, _ := range set.M {
-		record1s = append(record1s, k)
+	db.addAof(ToCmdLine2(cmd, args...))
+	return MakeIntReply(int64(len(result.M)))
+}
+
+// SDIFF key [key ...]
+func SDiff(db *SaveDBTables, args []string) Reply {
+	return setAlgebra(db, "sdiff", args, diffSets)
+}
+
+// SINTER key [key ...]
+func SInter(db *SaveDBTables, args []string) Reply {
+	return setAlgebra(db, "sinter", args, interSets)
+}
+
+// SUNION key [key ...]
+func SUnion(db *SaveDBTables, args []string) Reply {
+	return setAlgebra(db, "sunion", args, unionSets)
+}
+
+// SDIFFSTORE destination key [key ...]
+func SDiffStore(db *SaveDBTables, args []string) Reply {
+	return setAlgebraStore(db, "sdiffstore", args, diffSets)
+}
+
+// SINTERSTORE destination key [key ...]
+func SInterStore(db *SaveDBTables, args []string) Reply {
+	return setAlgebraStore(db, "sinterstore", args, interSets)
+}
+
+// SUNIONSTORE destination key [key ...]
+func SUnionStore(db *SaveDBTables, args []string) Reply {
+	return setAlgebraStore(db, "sunionstore", args, unionSets)
+}
+
+// SINTERCARD numkeys key [key ...] [LIMIT limit], limit为0时不限制
+func SInterCard(db *SaveDBTables, args []string) Reply {
+	if len(args) < 2 {
+		return errReply("ERR wrong number of arguments for 'sintercard' command")
+	}
+	numKeys, err := strconv.Atoi(args[0])
+	if err != nil {
+		return errReply("ERR numkeys should be greater than 0")
+	}
+	if numKeys <= 0 {
+		return errReply("ERR numkeys should be greater than 0")
 	}
-	record2s := make([]string, 0)
-	for v, _ := range set2.M {
-		if _, ok := set.M[v]; !ok {
-			record2s = append(record2s, v)
+	if numKeys > len(args)-1 {
+		return errReply("ERR Number of keys can't be greater than number of args")
+	}
+	limit := 0
+	for i := 1 + numKeys; i < len(args); i++ {
+		if strings.ToLower(args[i]) != "limit" || i+1 >= len(args) {
+			return errReply("ERR syntax error")
+		}
+		limit, err = strconv.Atoi(args[i+1])
+		if err != nil {
+			return errReply("ERR value is not an integer or out of range")
+		}
+		if limit < 0 {
+			return errReply("ERR LIMIT can't be negative")
 		}
+		i++
+	}
+	sets, err := db.getSets(args[1 : 1+numKeys])
+	if err != nil {
+		return errReply(err.Error())
+	}
+	count := len(interSets(sets).M)
+	if limit > 0 && count > limit {
+		count = limit
 	}
-	result := strings.Join(record2s, ",")
-	return CreateStrResult(COk, result)
+	return MakeIntReply(int64(count))
 }
@@ -0,0 +1,77 @@
+package src
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSetAlgebra(t *testing.T) {
+	s, c := makeMultiTestServer()
+	execTestCmd(s, c, "sadd", "a", "1", "2", "3", "4")
+	execTestCmd(s, c, "sadd", "b", "2", "3", "5")
+	execTestCmd(s, c, "sadd", "c", "3", "4")
+	tests := [][]string{
+		{"*1\r\n$1\r\n1\r\n", "sdiff", "a", "b", "c", "none"},
+		{"*1\r\n$1\r\n3\r\n", "sinter", "a", "b", "c"},
+		{"*0\r\n", "sinter", "a", "none"},
+		{":5\r\n", "sunionstore", "u", "a", "b", "none"},
+		{":5\r\n", "scard", "u"},
+		{":2\r\n", "sintercard", "2", "a", "b"},
+		{":1\r\n", "sintercard", "2", "a", "b", "limit", "1"},
+		{"-ERR Number of keys can't be greater than number of args\r\n", "sintercard", "3", "a", "b"},
+		{":1\r\n", "sinterstore", "u", "a", "b", "c"},
+		{"*1\r\n$1\r\n3\r\n", "smembers", "u"},
+		{":0\r\n", "sdiffstore", "u", "c", "a"},
+		{":0\r\n", "exists", "u"},
+		{"*0\r\n", "smembers", "none"},
+		{":0\r\n", "scard", "none"},
+		{":0\r\n", "sismember", "none", "1"},
+		{"+OK\r\n", "set", "str", "x"},
+		{"-WRONGTYPE Operation against a key holding the wrong kind of value\r\n", "sunion", "a", "str"},
+	}
+	for _, test := range tests {
+		if r := execTestCmd(s, c, test[1:]...); r != test[0] {
+			t.Errorf("%v: expect %q, actual %q", test[1:], test[0], r)
+		}
+	}
+}
+
+func TestSetPopMove(t *testing.T) {
+	s, c := makeMultiTestServer()
+	var aof []string
+	s.FindDB(0).addAof = func(line CmdLine) {
+		aof = append(aof, strings.ToLower(string(line[0])))
+	}
+	execTestCmd(s, c, "sadd", "a", "1", "2", "3", "4", "5")
+	tests := [][]string{
+		{"*0\r\n", "spop", "a", "0"},
+		{"-ERR value is out of range, must be positive\r\n", "spop", "a", "-1"},
+		{":1\r\n", "smove", "a", "b", "1"},
+		{":0\r\n", "smove", "a", "b", "1"},
+		{"*3\r\n:0\r\n:1\r\n:0\r\n", "smismember", "a", "1", "2", "none"},
+		{"*2\r\n:1\r\n:0\r\n", "smismember", "b", "1", "2"},
+		{"$-1\r\n", "srandmember", "none"},
+		{"*0\r\n", "spop", "none", "2"},
+	}
+	for _, test := range tests {
+		if r := execTestCmd(s, c, test[1:]...); r != test[0] {
+			t.Errorf("%v: expect %q, actual %q", test[1:], test[0], r)
+		}
+	}
+	if r := execTestCmd(s, c, "srandmember", "a", "-10"); !strings.HasPrefix(r, "*10\r\n") {
+		t.Errorf("srandmember with negative count should repeat members, actual %q", r)
+	}
+	if r := execTestCmd(s, c, "srandmember", "a", "10"); !strings.HasPrefix(r, "*4\r\n") {
+		t.Errorf("srandmember should return distinct members, actual %q", r)
+	}
+	if r := execTestCmd(s, c, "spop", "a", "3"); !strings.HasPrefix(r, "*3\r\n") {
+		t.Errorf("spop count error, actual %q", r)
+	}
+	execTestCmd(s, c, "spop", "a")
+	if r := execTestCmd(s, c, "exists", "a"); r != ":0\r\n" {
+		t.Errorf("empty set should be removed, actual %q", r)
+	}
+	if expect := "sadd smove srem srem"; strings.Join(aof, " ") != expect {
+		t.Errorf("expect aof %q, actual %q", expect, strings.Join(aof, " "))
+	}
+}
@@ -0,0 +1,1011 @@
+package src
+
+import (
+	"savedb/src/data"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Stream
+// 1.消息按id保存在btree中, id由毫秒时间和序号组成, XADD时自动生成的id写入aof时换成真实的id, 保证重放结果一样
+// 2.消费组记录最后投递的id和未确认的消息(PEL), XREADGROUP/XCLAIM/XAUTOCLAIM修改PEL
+// 3.XREAD和XREADGROUP支持BLOCK, 没有数据时在blocking.go中等待, XADD唤醒等待的客户端; 写入aof的命令不带BLOCK
+// 4.回复和其他命令一样用逗号拼接, 一条消息是 id,field,value...
+
+const (
+	errStreamID    = "ERR Invalid stream ID specified as stream command argument"
+	errXAddSmaller = "ERR The ID specified in XADD is equal or smaller than the target stream top item"
+	errXAddZero    = "ERR The ID specified in XADD must be greater than 0-0"
+	errXAddFull    = "ERR The stream has exhausted the last possible ID, unable to add more items"
+	errXGroupKey   = "ERR The XGROUP subcommand requires the key to exist. Note that for CREATE you may want to use the MKSTREAM option to create an empty stream automatically."
+	//XAUTOCLAIM默认的COUNT
+	xAutoClaimCount = 100
+)
+
+type Stream struct {
+	S *data.Stream
+}
+
+func NewStream() *Stream {
+	return &Stream{S: data.MakeStream()}
+}
+
+func (db *SaveDBTables) GetStream(key string) (*Stream, error) {
+	val, ok := db.Data.GetWithLock(key)
+	if !ok {
+		return nil, nil
+	}
+	stream, ok := val.(*Stream)
+	if !ok {
+		return nil, errWrongType
+	}
+	db.AllKeys.ActivateKey(key)
+	return stream, nil
+}
+
+func (db *SaveDBTables) GetOrCreateStream(key string) (*Stream, error) {
+	stream, err := db.GetStream(key)
+	if err != nil || stream != nil {
+		return stream, err
+	}
+	stream = NewStream()
+	db.Data.PutWithLock(key, stream)
+	db.AllKeys.PutKey(key, TypeStream)
+	return stream, nil
+}
+
+func noGroupErr(key, group string) Reply {
+	return errReply("NOGROUP No such key '" + key + "' or consumer group '" + group + "'")
+}
+
+// 读取key和消费组, 不存在时返回NOGROUP
+func (db *SaveDBTables) getStreamGroup(key, name string) (*Stream, *data.StreamGroup, Reply) {
+	stream, err := db.GetStream(key)
+	if err != nil {
+		return nil, nil, errReply(err.Error())
+	}
+	if stream == nil || stream.S.Group(name) == nil {
+		return nil, nil, noGroupErr(key, name)
+	}
+	return stream, stream.S.Group(name), nil
+}
+
+// 一条消息: [id, [field, value, ...]]
+func entryReply(entry *data.StreamEntry) Reply {
+	return MakeMultiRawReply([]Reply{
+		MakeBulkReply([]byte(entry.ID.String())),
+		MakeStringsReply(entry.Fields),
+	})
+}
+
+// 每个key和它的消息: [[key, [entry, ...]], ...]
+func streamKeyReply(key string, entries []Reply) Reply {
+	return MakeMultiRawReply([]Reply{MakeBulkReply([]byte(key)), MakeMultiRawReply(entries)})
+}
+
+// 范围查询的id, "-"和"+"表示最小和最大, "("开头表示不包含, 只有毫秒时开始取0结束取最大序号
+func parseRangeID(arg string, isStart bool) (id data.StreamID, empty bool, ok bool) {
+	switch arg {
+	case "-":
+		return data.StreamID{}, false, true
+	case "+":
+		return data.MaxStreamID, false, true
+	}
+	exclusive := strings.HasPrefix(arg, "(")
+	if exclusive {
+		arg = arg[1:]
+	}
+	missingSeq := uint64(0)
+	if !isStart {
+		missingSeq = data.MaxStreamID.Seq
+	}
+	id, ok = data.ParseStreamID(arg, missingSeq)
+	if !ok {
+		return id, false, false
+	}
+	if exclusive {
+		var inRange bool
+		if isStart {
+			id, inRange = id.Incr()
+		} else {
+			id, inRange = id.Decr()
+		}
+		return id, !inRange, true
+	}
+	return id, false, true
+}
+
+// XADD的id: * 或者 ms-* 或者 ms-seq
+func nextStreamID(last data.StreamID, arg string) (data.StreamID, string) {
+	if arg == "*" {
+		ms := uint64(time.Now().UnixMilli())
+		if ms > last.Ms {
+			return data.StreamID{Ms: ms}, ""
+		}
+		id, ok := last.Incr()
+		if !ok {
+			return id, errXAddFull
+		}
+		return id, ""
+	}
+	if strings.HasSuffix(arg, "-*") {
+		ms, err := strconv.ParseUint(strings.TrimSuffix(arg, "-*"), 10, 64)
+		if err != nil {
+			return data.StreamID{}, errStreamID
+		}
+		if ms < last.Ms {
+			return data.StreamID{}, errXAddSmaller
+		}
+		if ms > last.Ms {
+			return data.StreamID{Ms: ms}, ""
+		}
+		id, ok := last.Incr()
+		if !ok || id.Ms != ms {
+			return id, errXAddSmaller
+		}
+		return id, ""
+	}
+	id, ok := data.ParseStreamID(arg, 0)
+	if !ok {
+		return id, errStreamID
+	}
+	if id == (data.StreamID{}) {
+		return id, errXAddZero
+	}
+	if !last.Less(id) {
+		return id, errXAddSmaller
+	}
+	return id, ""
+}
+
+// XAdd XADD key [NOMKSTREAM] [MAXLEN|MINID [=|~] threshold [LIMIT count]] *|id field value [field value ...]
+func XAdd(db *SaveDBTables, args []string) Reply {
+	if len(args) < 4 {
+		return errReply("ERR wrong number of arguments for 'xadd' command")
+	}
+	key := args[0]
+	noMkStream := false
+	trim, threshold := "", ""
+	i := 1
+	for ; i < len(args); i++ {
+		opt := strings.ToLower(args[i])
+		if opt == "nomkstream" {
+			noMkStream = true
+		} else if (opt == "maxlen" || opt == "minid") && i+1 < len(args) {
+			trim = opt
+			i++
+			//精确裁剪, ~和LIMIT只是为了兼容
+			if args[i] == "=" || args[i] == "~" {
+				i++
+			}
+			if i >= len(args) {
+				return errReply("ERR syntax error")
+			}
+			threshold = args[i]
+			if i+2 < len(args) && strings.ToLower(args[i+1]) == "limit" {
+				i += 2
+			}
+		} else {
+			break
+		}
+	}
+	if i >= len(args) || (len(args)-i-1)%2 != 0 || len(args)-i-1 == 0 {
+		return errReply("ERR wrong number of arguments for 'xadd' command")
+	}
+	var maxLen int
+	var minID data.StreamID
+	if trim == "maxlen" {
+		n, err := strconv.Atoi(threshold)
+		if err != nil || n < 0 {
+			return errReply("ERR The MAXLEN argument must be >= 0.")
+		}
+		maxLen = n
+	} else if trim == "minid" {
+		id, ok := data.ParseStreamID(threshold, 0)
+		if !ok {
+			return errReply(errStreamID)
+		}
+		minID = id
+	}
+	stream, err := db.GetStream(key)
+	if err != nil {
+		return errReply(err.Error())
+	}
+	if stream == nil && noMkStream {
+		return MakeNullBulkReply()
+	}
+	var last data.StreamID
+	if stream != nil {
+		last = stream.S.LastID
+	}
+	id, msg := nextStreamID(last, args[i])
+	if msg != "" {
+		return errReply(msg)
+	}
+	if stream == nil {
+		stream, _ = db.GetOrCreateStream(key)
+	}
+	fields := make([]string, len(args)-i-1)
+	copy(fields, args[i+1:])
+	stream.S.Add(id, fields)
+	cmd := []string{key}
+	if trim == "maxlen" {
+		stream.S.TrimMaxLen(maxLen)
+		cmd = append(cmd, "maxlen", "=", strconv.Itoa(maxLen))
+	} else if trim == "minid" {
+		stream.S.TrimMinID(minID)
+		cmd = append(cmd, "minid", "=", minID.String())
+	}
+	cmd = append(append(cmd, id.String()), fields...)
+	db.addAof(ToCmdLine2("xadd", cmd...))
+	db.signalKey(key)
+	return MakeBulkReply([]byte(id.String()))
+}
+
+// XLen XLEN key
+func XLen(db *SaveDBTables, args []string) Reply {
+	stream, err := db.GetStream(args[0])
+	if err != nil {
+		return errReply(err.Error())
+	}
+	if stream == nil {
+		return MakeIntReply(0)
+	}
+	return MakeIntReply(int64(stream.S.Len()))
+}
+
+// XSetID XSETID key last-id [ENTRIESADDED entries-added], 用于aof重写时恢复最后的id
+func XSetID(db *SaveDBTables, args []string) Reply {
+	if len(args) != 2 && len(args) != 4 {
+		return errReply("ERR syntax error")
+	}
+	id, ok := data.ParseStreamID(args[1], 0)
+	if !ok {
+		return errReply(errStreamID)
+	}
+	var added uint64
+	if len(args) == 4 {
+		var err error
+		if strings.ToLower(args[2]) != "entriesadded" {
+			return errReply("ERR syntax error")
+		}
+		if added, err = strconv.ParseUint(args[3], 10, 64); err != nil {
+			return errReply("ERR value is not an integer or out of range")
+		}
+	}
+	stream, err := db.GetStream(args[0])
+	if err != nil {
+		return errReply(err.Error())
+	}
+	if stream == nil {
+		return errReply("ERR no such key")
+	}
+	if last, ok := stream.S.Last(); ok && id.Less(last.ID) {
+		return errReply("ERR The ID specified in XSETID is smaller than the target stream top item")
+	}
+	stream.S.LastID = id
+	if len(args) == 4 {
+		stream.S.EntriesAdded = added
+	}
+	db.addAof(ToCmdLine2("xsetid", args...))
+	return MakeOkReply()
+}
+
+func xRange(db *SaveDBTables, args []string, desc bool) Reply {
+	if len(args) != 3 && len(args) != 5 {
+		return errReply("ERR syntax error")
+	}
+	startArg, endArg := args[1], args[2]
+	if desc {
+		startArg, endArg = endArg, startArg
+	}
+	start, empty1, ok1 := parseRangeID(startArg, true)
+	end, empty2, ok2 := parseRangeID(endArg, false)
+	if !ok1 || !ok2 {
+		return errReply(errStreamID)
+	}
+	count := -1
+	if len(args) == 5 {
+		if strings.ToLower(args[3]) != "count" {
+			return errReply("ERR syntax error")
+		}
+		n, err := strconv.Atoi(args[4])
+		if err != nil {
+			return errReply("ERR value is not an integer or out of range")
+		}
+		count = n
+	}
+	stream, err := db.GetStream(args[0])
+	if err != nil {
+		return errReply(err.Error())
+	}
+	if stream == nil || empty1 || empty2 || count == 0 {
+		return MakeEmptyMultiBulkReply()
+	}
+	result := make([]Reply, 0)
+	stream.S.Range(start, end, count, desc, func(entry *data.StreamEntry) bool {
+		result = append(result, entryReply(entry))
+		return true
+	})
+	return MakeMultiRawReply(result)
+}
+
+// XRange XRANGE key start end [COUNT count]
+func XRange(db *SaveDBTables, args []string) Reply {
+	return xRange(db, args, false)
+}
+
+// XRevRange XREVRANGE key end start [COUNT count]
+func XRevRange(db *SaveDBTables, args []string) Reply {
+	return xRange(db, args, true)
+}
+
+type xReadOptions struct {
+	group    string
+	consumer string
+	count    int
+	block    time.Duration
+	blocking bool
+	noAck    bool
+	//STREAMS后面第一个参数的下标
+	streams int
+	keys    []string
+	ids     []string
+}
+
+// 解析XREAD和XREADGROUP的参数, group为true时必须有GROUP group consumer
+func parseXRead(args []string, group bool) (*xReadOptions, string) {
+	opts := &xReadOptions{}
+	i := 0
+	for ; i < len(args); i++ {
+		opt := strings.ToLower(args[i])
+		switch {
+		case opt == "count" && i+1 < len(args):
+			n, err := strconv.Atoi(args[i+1])
+			if err != nil {
+				return nil, "ERR value is not an integer or out of range"
+			}
+			opts.count = n
+			i++
+		case opt == "block" && i+1 < len(args):
+			timeout, ok := parseBlockTimeout(args[i+1])
+			if !ok {
+				return nil, "ERR timeout is not an integer or out of range"
+			}
+			opts.block, opts.blocking = timeout, true
+			i++
+		case opt == "group" && group && i+2 < len(args):
+			opts.group, opts.consumer = args[i+1], args[i+2]
+			i += 2
+		case opt == "noack" && group:
+			opts.noAck = true
+		case opt == "streams":
+			opts.streams = i + 1
+			rest := args[i+1:]
+			if len(rest) == 0 || len(rest)%2 != 0 {
+				cmd := "xread"
+				if group {
+					cmd = "xreadgroup"
+				}
+				return nil, "ERR Unbalanced '" + cmd + "' list of streams: for each stream key an ID or '$' must be specified."
+			}
+			opts.keys = rest[:len(rest)/2]
+			opts.ids = rest[len(rest)/2:]
+			if group && opts.group == "" {
+				return nil, "ERR Missing GROUP option for XREADGROUP"
+			}
+			return opts, ""
+		default:
+			return nil, "ERR syntax error"
+		}
+	}
+	return nil, "ERR syntax error"
+}
+
+// XREAD和XREADGROUP的key
+func xReadKeys(args []string) ([]string, []string) {
+	opts, msg := parseXRead(args, false)
+	if msg != "" {
+		return nil, nil
+	}
+	return opts.keys, nil
+}
+
+func xReadGroupKeys(args []string) ([]string, []string) {
+	opts, msg := parseXRead(args, true)
+	if msg != "" {
+		return nil, nil
+	}
+	return nil, opts.keys
+}
+
+// XRead XREAD [COUNT count] [BLOCK milliseconds] STREAMS key [key ...] id [id ...]
+func XRead(db *SaveDBTables, args []string) Reply {
+	opts, msg := parseXRead(args, false)
+	if msg != "" {
+		return errReply(msg)
+	}
+	streams := make([]*Stream, len(opts.keys))
+	starts := make([]data.StreamID, len(opts.keys))
+	for i, key := range opts.keys {
+		stream, err := db.GetStream(key)
+		if err != nil {
+			return errReply(err.Error())
+		}
+		streams[i] = stream
+		if opts.ids[i] == "$" {
+			var last data.StreamID
+			if stream != nil {
+				last = stream.S.LastID
+			}
+			//BLOCK时把$换成当前最后的id, 被唤醒后重试使用同一个id
+			if opts.blocking {
+				args[opts.streams+len(opts.keys)+i] = last.String()
+			}
+			starts[i], _ = last.Incr()
+			continue
+		}
+		id, ok := data.ParseStreamID(opts.ids[i], 0)
+		if !ok {
+			return errReply(errStreamID)
+		}
+		var inRange bool
+		if starts[i], inRange = id.Incr(); !inRange {
+			starts[i] = data.MaxStreamID
+		}
+	}
+	result := make([]Reply, 0)
+	for i, stream := range streams {
+		if stream == nil {
+			continue
+		}
+		entries := make([]Reply, 0)
+		stream.S.Range(starts[i], data.MaxStreamID, opts.count, false, func(entry *data.StreamEntry) bool {
+			entries = append(entries, entryReply(entry))
+			return true
+		})
+		if len(entries) > 0 {
+			result = append(result, streamKeyReply(opts.keys[i], entries))
+		}
+	}
+	if len(result) == 0 {
+		if opts.blocking {
+			return blockResult(opts.block)
+		}
+		return MakeNullMultiBulkReply()
+	}
+	return MakeMultiRawReply(result)
+}
+
+// XReadGroup XREADGROUP GROUP group consumer [COUNT count] [BLOCK milliseconds] [NOACK] STREAMS key [key ...] id [id ...]
+// id为>时读取新消息并加入PEL, 否则读取这个消费者PEL中大于id的消息
+func XReadGroup(db *SaveDBTables, args []string) Reply {
+	opts, msg := parseXRead(args, true)
+	if msg != "" {
+		return errReply(msg)
+	}
+	streams := make([]*Stream, len(opts.keys))
+	groups := make([]*data.StreamGroup, len(opts.keys))
+	for i, key := range opts.keys {
+		stream, group, errRes := db.getStreamGroup(key, opts.group)
+		if errRes != nil {
+			return errRes
+		}
+		if opts.ids[i] != ">" {
+			if _, ok := data.ParseStreamID(opts.ids[i], 0); !ok {
+				return errReply(errStreamID)
+			}
+		}
+		streams[i], groups[i] = stream, group
+	}
+	now := time.Now().UnixMilli()
+	result := make([]Reply, 0)
+	delivered := false
+	onlyNew := true
+	for i, key := range opts.keys {
+		group := groups[i]
+		consumer := group.Consumer(opts.consumer, false)
+		if consumer == nil {
+			consumer = group.Consumer(opts.consumer, true)
+			db.addAof(ToCmdLine("xgroup", "createconsumer", key, opts.group, opts.consumer))
+		}
+		consumer.SeenTime = now
+		entries := make([]Reply, 0)
+		if opts.ids[i] == ">" {
+			start, _ := group.LastID.Incr()
+			streams[i].S.Range(start, data.MaxStreamID, opts.count, false, func(entry *data.StreamEntry) bool {
+				group.LastID = entry.ID
+				if !opts.noAck {
+					group.AddPending(entry.ID, consumer, now).DeliveryCount = 1
+				}
+				entries = append(entries, entryReply(entry))
+				return true
+			})
+			delivered = delivered || len(entries) > 0
+		} else {
+			onlyNew = false
+			id, _ := data.ParseStreamID(opts.ids[i], 0)
+			start, ok := id.Incr()
+			n := 0
+			if ok {
+				group.RangePending(start, data.MaxStreamID, consumer, func(nack *data.StreamNACK) bool {
+					if entry, exists := streams[i].S.Get(nack.ID); exists {
+						entries = append(entries, entryReply(entry))
+					} else {
+						//已经被删除的消息只返回id, 字段为空
+						entries = append(entries, MakeMultiRawReply([]Reply{
+							MakeBulkReply([]byte(nack.ID.String())), MakeNullMultiBulkReply()}))
+					}
+					n++
+					return opts.count <= 0 || n < opts.count
+				})
+			}
+		}
+		result = append(result, streamKeyReply(key, entries))
+	}
+	if delivered {
+		//写入aof的命令不带BLOCK
+		cmd := []string{"group", opts.group, opts.consumer}
+		if opts.count > 0 {
+			cmd = append(cmd, "count", strconv.Itoa(opts.count))
+		}
+		if opts.noAck {
+			cmd = append(cmd, "noack")
+		}
+		cmd = append(append(cmd, "streams"), args[opts.streams:]...)
+		db.addAof(ToCmdLine2("xreadgroup", cmd...))
+	}
+	if !delivered && onlyNew {
+		if opts.blocking {
+			return blockResult(opts.block)
+		}
+		return MakeNullMultiBulkReply()
+	}
+	return MakeMultiRawReply(result)
+}
+
+// 解析XGROUP CREATE和SETID的id, $表示最后的id
+func parseGroupID(stream *Stream, arg string) (data.StreamID, bool) {
+	if arg == "$" {
+		if stream == nil {
+			return data.StreamID{}, true
+		}
+		return stream.S.LastID, true
+	}
+	return data.ParseStreamID(arg, 0)
+}
+
+// XGroup XGROUP CREATE key group id|$ [MKSTREAM] [ENTRIESREAD n] | SETID key group id|$ | DESTROY key group |
+// CREATECONSUMER key group consumer | DELCONSUMER key group consumer
+func XGroup(db *SaveDBTables, args []string) Reply {
+	if len(args) < 3 {
+		return errReply("ERR wrong number of arguments for 'xgroup' command")
+	}
+	sub, key, name := strings.ToLower(args[0]), args[1], args[2]
+	stream, err := db.GetStream(key)
+	if err != nil {
+		return errReply(err.Error())
+	}
+	switch sub {
+	case "create":
+		if len(args) < 4 {
+			return errReply("ERR wrong number of arguments for 'xgroup|create' command")
+		}
+		mkStream := false
+		for i := 4; i < len(args); i++ {
+			opt := strings.ToLower(args[i])
+			if opt == "mkstream" {
+				mkStream = true
+			} else if opt == "entriesread" && i+1 < len(args) {
+				i++
+			} else {
+				return errReply("ERR syntax error")
+			}
+		}
+		id, ok := parseGroupID(stream, args[3])
+		if !ok {
+			return errReply(errStreamID)
+		}
+		if stream == nil {
+			if !mkStream {
+				return errReply(errXGroupKey)
+			}
+			stream, _ = db.GetOrCreateStream(key)
+		}
+		if _, ok := stream.S.CreateGroup(name, id); !ok {
+			return errReply("BUSYGROUP Consumer Group name already exists")
+		}
+		cmd := []string{"create", key, name, id.String()}
+		if mkStream {
+			cmd = append(cmd, "mkstream")
+		}
+		db.addAof(ToCmdLine2("xgroup", cmd...))
+		return MakeOkReply()
+	case "setid", "destroy", "createconsumer", "delconsumer":
+	default:
+		return errReply("ERR unknown subcommand '" + args[0] + "'. Try XGROUP HELP.")
+	}
+	if stream == nil {
+		return errReply(errXGroupKey)
+	}
+	if sub == "destroy" {
+		if !stream.S.DestroyGroup(name) {
+			return MakeIntReply(0)
+		}
+		db.addAof(ToCmdLine2("xgroup", args...))
+		return MakeIntReply(1)
+	}
+	group := stream.S.Group(name)
+	if group == nil {
+		return errReply("NOGROUP No such consumer group '" + name + "' for key name '" + key + "'")
+	}
+	if len(args) < 4 {
+		return errReply("ERR wrong number of arguments for 'xgroup|" + sub + "' command")
+	}
+	switch sub {
+	case "setid":
+		id, ok := parseGroupID(stream, args[3])
+		if !ok {
+			return errReply(errStreamID)
+		}
+		group.LastID = id
+		db.addAof(ToCmdLine("xgroup", "setid", key, name, id.String()))
+		return MakeOkReply()
+	case "createconsumer":
+		if group.Consumer(args[3], false) != nil {
+			return MakeIntReply(0)
+		}
+		group.Consumer(args[3], true).SeenTime = time.Now().UnixMilli()
+		db.addAof(ToCmdLine2("xgroup", args...))
+		return MakeIntReply(1)
+	default:
+		pending, ok := group.DeleteConsumer(args[3])
+		if ok {
+			db.addAof(ToCmdLine2("xgroup", args...))
+		}
+		return MakeIntReply(int64(pending))
+	}
+}
+
+// XGROUP subcommand key ...
+func xGroupKeys(args []string) ([]string, []string) {
+	if len(args) < 2 {
+		return nil, nil
+	}
+	return nil, []string{args[1]}
+}
+
+// XAck XACK key group id [id ...]
+func XAck(db *SaveDBTables, args []string) Reply {
+	if len(args) < 3 {
+		return errReply("ERR wrong number of arguments for 'xack' command")
+	}
+	ids := make([]data.StreamID, 0, len(args)-2)
+	for _, arg := range args[2:] {
+		id, ok := data.ParseStreamID(arg, 0)
+		if !ok {
+			return errReply(errStreamID)
+		}
+		ids = append(ids, id)
+	}
+	stream, err := db.GetStream(args[0])
+	if err != nil {
+		return errReply(err.Error())
+	}
+	if stream == nil || stream.S.Group(args[1]) == nil {
+		return MakeIntReply(0)
+	}
+	group := stream.S.Group(args[1])
+	acked := 0
+	for _, id := range ids {
+		if group.Ack(id) {
+			acked++
+		}
+	}
+	if acked > 0 {
+		db.addAof(ToCmdLine2("xack", args...))
+	}
+	return MakeIntReply(int64(acked))
+}
+
+// XPending XPENDING key group [[IDLE min-idle-time] start end count [consumer]]
+func XPending(db *SaveDBTables, args []string) Reply {
+	if len(args) < 2 {
+		return errReply("ERR wrong number of arguments for 'xpending' command")
+	}
+	_, group, errRes := db.getStreamGroup(args[0], args[1])
+	if errRes != nil {
+		return errRes
+	}
+	//没有范围时返回摘要: 总数 最小id 最大id 每个消费者的数量
+	if len(args) == 2 {
+		if group.PendingLen() == 0 {
+			return MakeMultiRawReply([]Reply{MakeIntReply(0), MakeNullBulkReply(), MakeNullBulkReply(), MakeNullMultiBulkReply()})
+		}
+		var first, last data.StreamID
+		n := 0
+		group.RangePending(data.StreamID{}, data.MaxStreamID, nil, func(nack *data.StreamNACK) bool {
+			if n == 0 {
+				first = nack.ID
+			}
+			last = nack.ID
+			n++
+			return true
+		})
+		consumers := make([]Reply, 0)
+		for _, consumer := range group.Consumers() {
+			if consumer.PendingLen() > 0 {
+				consumers = append(consumers, MakeStringsReply([]string{consumer.Name, strconv.Itoa(consumer.PendingLen())}))
+			}
+		}
+		return MakeMultiRawReply([]Reply{
+			MakeIntReply(int64(n)),
+			MakeBulkReply([]byte(first.String())),
+			MakeBulkReply([]byte(last.String())),
+			MakeMultiRawReply(consumers),
+		})
+	}
+	rest := args[2:]
+	var minIdle int64
+	if strings.ToLower(rest[0]) == "idle" {
+		if len(rest) < 2 {
+			return errReply("ERR syntax error")
+		}
+		var err error
+		if minIdle, err = strconv.ParseInt(rest[1], 10, 64); err != nil {
+			return errReply("ERR value is not an integer or out of range")
+		}
+		rest = rest[2:]
+	}
+	if len(rest) != 3 && len(rest) != 4 {
+		return errReply("ERR syntax error")
+	}
+	start, empty1, ok1 := parseRangeID(rest[0], true)
+	end, empty2, ok2 := parseRangeID(rest[1], false)
+	if !ok1 || !ok2 {
+		return errReply(errStreamID)
+	}
+	count, err := strconv.Atoi(rest[2])
+	if err != nil {
+		return errReply("ERR value is not an integer or out of range")
+	}
+	var consumer *data.StreamConsumer
+	if len(rest) == 4 {
+		if consumer = group.Consumer(rest[3], false); consumer == nil {
+			return MakeEmptyMultiBulkReply()
+		}
+	}
+	result := make([]Reply, 0)
+	if empty1 || empty2 || count <= 0 {
+		return MakeEmptyMultiBulkReply()
+	}
+	now := time.Now().UnixMilli()
+	n := 0
+	group.RangePending(start, end, consumer, func(nack *data.StreamNACK) bool {
+		idle := now - nack.DeliveryTime
+		if idle < minIdle {
+			return true
+		}
+		result = append(result, MakeMultiRawReply([]Reply{
+			MakeBulkReply([]byte(nack.ID.String())),
+			MakeBulkReply([]byte(nack.Consumer.Name)),
+			MakeIntReply(idle),
+			MakeIntReply(int64(nack.DeliveryCount)),
+		}))
+		n++
+		return n < count
+	})
+	return MakeMultiRawReply(result)
+}
+
+// 把消息转给consumer, 写入aof的命令带上TIME和RETRYCOUNT保证重放结果一样
+func claimPending(db *SaveDBTables, key string, group *data.StreamGroup, consumer *data.StreamConsumer,
+	id data.StreamID, deliveryTime int64, deliveryCount uint64) {
+	nack := group.AddPending(id, consumer, deliveryTime)
+	nack.DeliveryCount = deliveryCount
+	db.addAof(ToCmdLine("xclaim", key, group.Name, consumer.Name, "0", id.String(),
+		"time", strconv.FormatInt(deliveryTime, 10), "retrycount", strconv.FormatUint(deliveryCount, 10), "force", "justid"))
+}
+
+// 消费者不存在时创建
+func claimConsumer(db *SaveDBTables, key string, group *data.StreamGroup, name string) *data.StreamConsumer {
+	consumer := group.Consumer(name, false)
+	if consumer == nil {
+		consumer = group.Consumer(name, true)
+		db.addAof(ToCmdLine("xgroup", "createconsumer", key, group.Name, name))
+	}
+	consumer.SeenTime = time.Now().UnixMilli()
+	return consumer
+}
+
+// XClaim XCLAIM key group consumer min-idle-time id [id ...] [IDLE ms] [TIME unix-time-milliseconds]
+// [RETRYCOUNT count] [FORCE] [JUSTID] [LASTID lastid]
+func XClaim(db *SaveDBTables, args []string) Reply {
+	if len(args) < 5 {
+		return errReply("ERR wrong number of arguments for 'xclaim' command")
+	}
+	key := args[0]
+	minIdle, err := strconv.ParseInt(args[3], 10, 64)
+	if err != nil {
+		return errReply("ERR Invalid min-idle-time argument for XCLAIM")
+	}
+	ids := make([]data.StreamID, 0)
+	i := 4
+	for ; i < len(args); i++ {
+		id, ok := data.ParseStreamID(args[i], 0)
+		if !ok {
+			break
+		}
+		ids = append(ids, id)
+	}
+	if len(ids) == 0 {
+		return errReply(errStreamID)
+	}
+	now := time.Now().UnixMilli()
+	deliveryTime := now
+	retryCount := int64(-1)
+	force, justID := false, false
+	var lastID *data.StreamID
+	for ; i < len(args); i++ {
+		opt := strings.ToLower(args[i])
+		switch {
+		case (opt == "idle" || opt == "time" || opt == "retrycount") && i+1 < len(args):
+			n, err := strconv.ParseInt(args[i+1], 10, 64)
+			if err != nil {
+				return errReply("ERR Invalid " + strings.ToUpper(opt) + " option argument for XCLAIM")
+			}
+			if opt == "idle" {
+				deliveryTime = now - n
+			} else if opt == "time" {
+				deliveryTime = n
+			} else {
+				retryCount = n
+			}
+			i++
+		case opt == "force":
+			force = true
+		case opt == "justid":
+			justID = true
+		case opt == "lastid" && i+1 < len(args):
+			id, ok := data.ParseStreamID(args[i+1], 0)
+			if !ok {
+				return errReply(errStreamID)
+			}
+			lastID = &id
+			i++
+		default:
+			return errReply("ERR Unrecognized XCLAIM option '" + args[i] + "'")
+		}
+	}
+	stream, group, errRes := db.getStreamGroup(key, args[1])
+	if errRes != nil {
+		return errRes
+	}
+	if lastID != nil && group.LastID.Less(*lastID) {
+		group.LastID = *lastID
+		db.addAof(ToCmdLine("xgroup", "setid", key, group.Name, lastID.String()))
+	}
+	consumer := claimConsumer(db, key, group, args[2])
+	result := make([]Reply, 0)
+	deleted := make([]string, 0)
+	for _, id := range ids {
+		nack, ok := group.Pending(id)
+		entry, exists := stream.S.Get(id)
+		if !ok {
+			if !force || !exists {
+				continue
+			}
+		} else {
+			if minIdle > 0 && now-nack.DeliveryTime < minIdle {
+				continue
+			}
+			//消息已经被删除, 从PEL中删除
+			if !exists {
+				group.Ack(id)
+				deleted = append(deleted, id.String())
+				continue
+			}
+		}
+		count := uint64(0)
+		if ok {
+			count = nack.DeliveryCount
+		}
+		if retryCount >= 0 {
+			count = uint64(retryCount)
+		} else if !justID {
+			count++
+		}
+		claimPending(db, key, group, consumer, id, deliveryTime, count)
+		if justID {
+			result = append(result, MakeBulkReply([]byte(id.String())))
+		} else {
+			result = append(result, entryReply(entry))
+		}
+	}
+	if len(deleted) > 0 {
+		db.addAof(ToCmdLine2("xack", append([]string{key, group.Name}, deleted...)...))
+	}
+	return MakeMultiRawReply(result)
+}
+
+// XAutoClaim XAUTOCLAIM key group consumer min-idle-time start [COUNT count] [JUSTID]
+// 回复 [下一次的start, [转移的消息...], [已经被删除的id...]]
+func XAutoClaim(db *SaveDBTables, args []string) Reply {
+	if len(args) < 5 {
+		return errReply("ERR wrong number of arguments for 'xautoclaim' command")
+	}
+	key := args[0]
+	minIdle, err := strconv.ParseInt(args[3], 10, 64)
+	if err != nil {
+		return errReply("ERR Invalid min-idle-time argument for XAUTOCLAIM")
+	}
+	start, empty, ok := parseRangeID(args[4], true)
+	if !ok {
+		return errReply(errStreamID)
+	}
+	count, justID := xAutoClaimCount, false
+	for i := 5; i < len(args); i++ {
+		opt := strings.ToLower(args[i])
+		if opt == "count" && i+1 < len(args) {
+			n, err := strconv.Atoi(args[i+1])
+			if err != nil || n < 1 {
+				return errReply("ERR COUNT must be > 0")
+			}
+			count = n
+			i++
+		} else if opt == "justid" {
+			justID = true
+		} else {
+			return errReply("ERR syntax error")
+		}
+	}
+	stream, group, errRes := db.getStreamGroup(key, args[1])
+	if errRes != nil {
+		return errRes
+	}
+	consumer := claimConsumer(db, key, group, args[2])
+	if empty {
+		return MakeMultiRawReply([]Reply{MakeBulkReply([]byte("0-0")), MakeEmptyMultiBulkReply(), MakeEmptyMultiBulkReply()})
+	}
+	//最多检查count*10条未确认的消息
+	attempts := count * 10
+	now := time.Now().UnixMilli()
+	candidates := make([]*data.StreamNACK, 0)
+	next := data.StreamID{}
+	group.RangePending(start, data.MaxStreamID, nil, func(nack *data.StreamNACK) bool {
+		if len(candidates) == count || attempts == 0 {
+			next = nack.ID
+			return false
+		}
+		attempts--
+		if now-nack.DeliveryTime >= minIdle {
+			candidates = append(candidates, nack)
+		}
+		return true
+	})
+	result := make([]Reply, 0)
+	deleted := make([]string, 0)
+	for _, nack := range candidates {
+		entry, exists := stream.S.Get(nack.ID)
+		if !exists {
+			deleted = append(deleted, nack.ID.String())
+			group.Ack(nack.ID)
+			continue
+		}
+		deliveryCount := nack.DeliveryCount
+		if !justID {
+			deliveryCount++
+		}
+		claimPending(db, key, group, consumer, nack.ID, now, deliveryCount)
+		if justID {
+			result = append(result, MakeBulkReply([]byte(nack.ID.String())))
+		} else {
+			result = append(result, entryReply(entry))
+		}
+	}
+	if len(deleted) > 0 {
+		db.addAof(ToCmdLine2("xack", append([]string{key, group.Name}, deleted...)...))
+	}
+	return MakeMultiRawReply([]Reply{MakeBulkReply([]byte(next.String())), MakeMultiRawReply(result), MakeStringsReply(deleted)})
+}
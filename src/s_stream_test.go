@@ -0,0 +1,95 @@
+package src
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hdt3213/rdb/core"
+)
+
+func TestStream(t *testing.T) {
+	initTestLog(t)
+	s, c := makeMultiTestServer()
+	cases := [][]string{
+		{"$3\r\n1-1\r\n", "xadd", "s", "1-1", "a", "1"},
+		{"$3\r\n1-2\r\n", "xadd", "s", "1-*", "a", "2", "b", "x"},
+		{"$3\r\n2-0\r\n", "xadd", "s", "2", "a", "3"},
+		{"-ERR The ID specified in XADD is equal or smaller than the target stream top item\r\n", "xadd", "s", "1-0", "a", "1"},
+		{":3\r\n", "xlen", "s"},
+		{"*2\r\n*2\r\n$3\r\n1-1\r\n*2\r\n$1\r\na\r\n$1\r\n1\r\n*2\r\n$3\r\n1-2\r\n*4\r\n$1\r\na\r\n$1\r\n2\r\n$1\r\nb\r\n$1\r\nx\r\n", "xrange", "s", "-", "+", "count", "2"},
+		{"*1\r\n*2\r\n$3\r\n2-0\r\n*2\r\n$1\r\na\r\n$1\r\n3\r\n", "xrevrange", "s", "+", "-", "count", "1"},
+		{"*1\r\n*2\r\n$1\r\ns\r\n*1\r\n*2\r\n$3\r\n2-0\r\n*2\r\n$1\r\na\r\n$1\r\n3\r\n", "xread", "streams", "s", "1-2"},
+		{"+OK\r\n", "xgroup", "create", "s", "g", "0"},
+		{"-BUSYGROUP Consumer Group name already exists\r\n", "xgroup", "create", "s", "g", "$"},
+		{"*1\r\n*2\r\n$1\r\ns\r\n*2\r\n*2\r\n$3\r\n1-1\r\n*2\r\n$1\r\na\r\n$1\r\n1\r\n*2\r\n$3\r\n1-2\r\n*4\r\n$1\r\na\r\n$1\r\n2\r\n$1\r\nb\r\n$1\r\nx\r\n", "xreadgroup", "group", "g", "c1", "count", "2", "streams", "s", ">"},
+		{"*4\r\n:2\r\n$3\r\n1-1\r\n$3\r\n1-2\r\n*1\r\n*2\r\n$2\r\nc1\r\n$1\r\n2\r\n", "xpending", "s", "g"},
+		{":1\r\n", "xack", "s", "g", "1-1"},
+		{":0\r\n", "xack", "s", "g", "1-1"},
+		{"*1\r\n$3\r\n1-2\r\n", "xclaim", "s", "g", "c2", "0", "1-2", "justid"},
+		{"*3\r\n$3\r\n0-0\r\n*1\r\n*2\r\n$3\r\n1-2\r\n*4\r\n$1\r\na\r\n$1\r\n2\r\n$1\r\nb\r\n$1\r\nx\r\n*0\r\n", "xautoclaim", "s", "g", "c3", "0", "0"},
+		{"*4\r\n:1\r\n$3\r\n1-2\r\n$3\r\n1-2\r\n*1\r\n*2\r\n$2\r\nc3\r\n$1\r\n1\r\n", "xpending", "s", "g"},
+		{"$3\r\n3-0\r\n", "xadd", "s", "maxlen", "2", "3", "a", "4"},
+		{":2\r\n", "xlen", "s"},
+		{"-NOGROUP No such key 's' or consumer group 'none'\r\n", "xreadgroup", "group", "none", "c", "streams", "s", ">"},
+	}
+	for _, tc := range cases {
+		if r := execTestCmd(s, c, tc[1:]...); r != tc[0] {
+			t.Errorf("%v: expect %q, actual %q", tc[1:], tc[0], r)
+		}
+	}
+}
+
+func TestXReadBlock(t *testing.T) {
+	initTestLog(t)
+	s, c := makeMultiTestServer()
+	c2 := &Connection{protocol: ProtocolResp2, Writer: make(chan *Message, WriterQueueSize)}
+	if r := execTestCmd(s, c2, "xread", "block", "10", "streams", "s", "$"); r != "*-1\r\n" {
+		t.Errorf("expect timeout, actual %q", r)
+	}
+	command := "xread"
+	go s.Exec(c2, &Message{Command: &command, Args: []string{"block", "0", "streams", "s", "$"}})
+	time.Sleep(100 * time.Millisecond)
+	execTestCmd(s, c, "xadd", "s", "1-1", "a", "1")
+	select {
+	case msg := <-c2.Writer:
+		if r := string(*msg.ReturnData); r != "*1\r\n*2\r\n$1\r\ns\r\n*1\r\n*2\r\n$3\r\n1-1\r\n*2\r\n$1\r\na\r\n$1\r\n1\r\n" {
+			t.Errorf("blocked xread result error, actual %q", r)
+		}
+	case <-time.After(time.Second):
+		t.Error("blocked xread is not served")
+	}
+}
+
+func TestStreamRDB(t *testing.T) {
+	initTestLog(t)
+	s, c := makeMultiTestServer()
+	execTestCmd(s, c, "xadd", "s", "1-1", "a", "1")
+	execTestCmd(s, c, "xadd", "s", "2", "a", strings.Repeat("v", 200), "b", "-5")
+	execTestCmd(s, c, "xadd", "s", "3", "a", "x")
+	execTestCmd(s, c, "xgroup", "create", "s", "g", "0")
+	execTestCmd(s, c, "xreadgroup", "group", "g", "c1", "count", "2", "streams", "s", ">")
+
+	var buf bytes.Buffer
+	buf.WriteString("REDIS0009")
+	stream, _ := s.FindDB(0).GetStream("s")
+	w := &rdbStreamWriter{w: &buf}
+	w.writeDBHeader(0, 1, 0)
+	w.writeStream(&rdbStream{key: "s", stream: stream})
+	buf.Write([]byte{0xff, 0, 0, 0, 0, 0, 0, 0, 0})
+	s2, c2 := makeMultiTestServer()
+	if err := s2.LoadRDB(core.NewDecoder(&buf)); err != nil {
+		t.Fatal(err)
+	}
+	for _, args := range [][]string{
+		{"xrange", "s", "-", "+"},
+		{"xpending", "s", "g"},
+		{"xreadgroup", "group", "g", "c1", "streams", "s", "0"},
+	} {
+		expect, actual := execTestCmd(s, c, args...), execTestCmd(s2, c2, args...)
+		if expect != actual {
+			t.Errorf("%v: expect %q, actual %q", args, expect, actual)
+		}
+	}
+}
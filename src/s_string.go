@@ -1,21 +1,442 @@
 package src
 
-func Get(db *SaveDBTables, args []string) Result {
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 字符串
+// 1.值是[]byte, 计数器也是字符串, INCR之类的命令解析成整数/浮点数计算后再写回
+// 2.SET默认会清除过期时间, KEEPTTL时保留
+// 3.写aof时过期时间都转换成绝对时间, INCRBYFLOAT记录计算后的结果, 重放时结果不受精度影响
+
+// 字符串的最大长度, 和redis的proto-max-bulk-len一样
+const maxStringLength = 512 << 20
+
+func (db *SaveDBTables) GetString(key string) ([]byte, error) {
+	val, ok := db.Data.GetWithLock(key)
+	if !ok {
+		return nil, nil
+	}
+	bytes, ok := val.([]byte)
+	if !ok {
+		return nil, errWrongType
+	}
+	db.AllKeys.ActivateKey(key)
+	return bytes, nil
+}
+
+// 写入字符串, keepTTL为false时清除原来的过期时间
+func (db *SaveDBTables) putString(key string, value []byte, keepTTL bool) {
+	db.Data.PutWithLock(key, value)
+	db.AllKeys.PutKey(key, TypeStr)
+	if !keepTTL {
+		removeExpire(db, key)
+	}
+}
+
+func Get(db *SaveDBTables, args []string) Reply {
 	key := args[0]
 	s, ok := db.Data.GetWithLock(key)
 	if ok {
 		db.AllKeys.ActivateKey(args[0])
 		if _, ok := s.([]byte); !ok {
-			return CreateStrResult(CErr, "type conversion error")
+			return errReply(errWrongType.Error())
+		}
+		return MakeBulkReply(s.([]byte))
+	}
+	return MakeNullBulkReply()
+}
+
+// 解析 EX seconds | PX milliseconds | EXAT timestamp | PXAT milliseconds-timestamp, 返回绝对的过期时间
+func parseExpireOption(cmd string, option string, arg string) (time.Time, error) {
+	n, err := strconv.ParseInt(arg, 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("ERR value is not an integer or out of range")
+	}
+	if n <= 0 {
+		return time.Time{}, fmt.Errorf("ERR invalid expire time in '%s' command", cmd)
+	}
+	switch option {
+	case "ex":
+		return time.Now().Add(time.Duration(n) * time.Second), nil
+	case "px":
+		return time.Now().Add(time.Duration(n) * time.Millisecond), nil
+	case "exat":
+		return time.Unix(n, 0), nil
+	default:
+		return time.UnixMilli(n), nil
+	}
+}
+
+// SetExc SET key value [NX|XX] [GET] [EX seconds|PX milliseconds|EXAT timestamp|PXAT milliseconds-timestamp|KEEPTTL]
+func SetExc(db *SaveDBTables, args []string) Reply {
+	if len(args) < 2 {
+		return errReply("ERR wrong number of arguments for 'set' command")
+	}
+	key, value := args[0], args[1]
+	var nx, xx, get, keepTTL bool
+	var expireAt *time.Time
+	for i := 2; i < len(args); i++ {
+		option := strings.ToLower(args[i])
+		switch option {
+		case "nx":
+			nx = true
+		case "xx":
+			xx = true
+		case "get":
+			get = true
+		case "keepttl":
+			keepTTL = true
+		case "ex", "px", "exat", "pxat":
+			if expireAt != nil || i+1 >= len(args) {
+				return errReply("ERR syntax error")
+			}
+			at, err := parseExpireOption("set", option, args[i+1])
+			if err != nil {
+				return errReply(err.Error())
+			}
+			expireAt = &at
+			i++
+		default:
+			return errReply("ERR syntax error")
+		}
+	}
+	if (nx && xx) || (keepTTL && expireAt != nil) {
+		return errReply("ERR syntax error")
+	}
+	old, err := db.GetString(key)
+	if get && err != nil {
+		return errReply(err.Error())
+	}
+	_, exists := db.Data.GetWithLock(key)
+	//GET选项回复原来的值, 没有设置成功时也一样
+	var reply Reply = MakeOkReply()
+	if get {
+		reply = MakeBulkReply(old)
+	}
+	if (nx && exists) || (xx && !exists) {
+		if get {
+			return reply
+		}
+		return MakeNullBulkReply()
+	}
+	db.putString(key, []byte(value), keepTTL)
+	if keepTTL {
+		db.addAof(ToCmdLine("set", key, value, "keepttl"))
+	} else {
+		db.addAof(ToCmdLine("set", key, value))
+	}
+	if expireAt != nil {
+		PutExpire(db, key, *expireAt)
+		db.addAof(MakeExpireCmd(key, *expireAt).Args)
+	}
+	return reply
+}
+
+// SetNX SETNX key value, 返回1表示设置成功
+func SetNX(db *SaveDBTables, args []string) Reply {
+	key := args[0]
+	if _, exists := db.Data.GetWithLock(key); exists {
+		return MakeIntReply(0)
+	}
+	db.putString(key, []byte(args[1]), false)
+	db.addAof(ToCmdLine("set", key, args[1]))
+	return MakeIntReply(1)
+}
+
+// SetEX SETEX key seconds value
+func SetEX(db *SaveDBTables, args []string) Reply {
+	key := args[0]
+	expireAt, err := parseExpireOption("setex", "ex", args[1])
+	if err != nil {
+		return errReply(err.Error())
+	}
+	db.putString(key, []byte(args[2]), false)
+	PutExpire(db, key, expireAt)
+	db.addAof(ToCmdLine("set", key, args[2]))
+	db.addAof(MakeExpireCmd(key, expireAt).Args)
+	return MakeOkReply()
+}
+
+// GetSet GETSET key value, 回复原来的值
+func GetSet(db *SaveDBTables, args []string) Reply {
+	key := args[0]
+	old, err := db.GetString(key)
+	if err != nil {
+		return errReply(err.Error())
+	}
+	db.putString(key, []byte(args[1]), false)
+	db.addAof(ToCmdLine("set", key, args[1]))
+	return MakeBulkReply(old)
+}
+
+// GetDel GETDEL key
+func GetDel(db *SaveDBTables, args []string) Reply {
+	key := args[0]
+	old, err := db.GetString(key)
+	if err != nil {
+		return errReply(err.Error())
+	}
+	if old == nil {
+		return MakeNullBulkReply()
+	}
+	Del(db, []string{key})
+	return MakeBulkReply(old)
+}
+
+// GetEX GETEX key [EX seconds|PX milliseconds|EXAT timestamp|PXAT milliseconds-timestamp|PERSIST]
+func GetEX(db *SaveDBTables, args []string) Reply {
+	if len(args) < 1 {
+		return errReply("ERR wrong number of arguments for 'getex' command")
+	}
+	key := args[0]
+	var expireAt *time.Time
+	persist := false
+	for i := 1; i < len(args); i++ {
+		option := strings.ToLower(args[i])
+		switch option {
+		case "persist":
+			persist = true
+		case "ex", "px", "exat", "pxat":
+			if expireAt != nil || i+1 >= len(args) {
+				return errReply("ERR syntax error")
+			}
+			at, err := parseExpireOption("getex", option, args[i+1])
+			if err != nil {
+				return errReply(err.Error())
+			}
+			expireAt = &at
+			i++
+		default:
+			return errReply("ERR syntax error")
+		}
+	}
+	if persist && expireAt != nil {
+		return errReply("ERR syntax error")
+	}
+	value, err := db.GetString(key)
+	if err != nil {
+		return errReply(err.Error())
+	}
+	if value == nil {
+		return MakeNullBulkReply()
+	}
+	if expireAt != nil {
+		PutExpire(db, key, *expireAt)
+		db.addAof(MakeExpireCmd(key, *expireAt).Args)
+	} else if persist && db.getExpiration(key) != nil {
+		removeExpire(db, key)
+		db.addAof(ToCmdLine("persist", key))
+	}
+	return MakeBulkReply(value)
+}
+
+// MGet MGET key [key ...], 不存在或者不是字符串的key回复nil
+func MGet(db *SaveDBTables, args []string) Reply {
+	if len(args) < 1 {
+		return errReply("ERR wrong number of arguments for 'mget' command")
+	}
+	values := make([][]byte, len(args))
+	for i, key := range args {
+		values[i], _ = db.GetString(key)
+	}
+	return MakeMultiBulkReply(values)
+}
+
+// MSet MSET key value [key value ...]
+func MSet(db *SaveDBTables, args []string) Reply {
+	if len(args) == 0 || len(args)%2 != 0 {
+		return errReply("ERR wrong number of arguments for 'mset' command")
+	}
+	for i := 0; i < len(args); i += 2 {
+		db.putString(args[i], []byte(args[i+1]), false)
+	}
+	db.addAof(ToCmdLine2("mset", args...))
+	return MakeOkReply()
+}
+
+// MSetNX MSETNX key value [key value ...], 有一个key存在时都不设置
+func MSetNX(db *SaveDBTables, args []string) Reply {
+	if len(args) == 0 || len(args)%2 != 0 {
+		return errReply("ERR wrong number of arguments for 'msetnx' command")
+	}
+	for i := 0; i < len(args); i += 2 {
+		if _, exists := db.Data.GetWithLock(args[i]); exists {
+			return MakeIntReply(0)
+		}
+	}
+	for i := 0; i < len(args); i += 2 {
+		db.putString(args[i], []byte(args[i+1]), false)
+	}
+	db.addAof(ToCmdLine2("mset", args...))
+	return MakeIntReply(1)
+}
+
+// Append APPEND key value, 回复追加之后的长度
+func Append(db *SaveDBTables, args []string) Reply {
+	key := args[0]
+	value, err := db.GetString(key)
+	if err != nil {
+		return errReply(err.Error())
+	}
+	if len(value)+len(args[1]) > maxStringLength {
+		return errReply("ERR string exceeds maximum allowed size (proto-max-bulk-len)")
+	}
+	//不能直接在原来的切片上append, 快照中可能还引用着旧值
+	newValue := make([]byte, 0, len(value)+len(args[1]))
+	newValue = append(append(newValue, value...), args[1]...)
+	db.putString(key, newValue, true)
+	db.addAof(ToCmdLine2("append", args...))
+	return MakeIntReply(int64(len(newValue)))
+}
+
+// StrLen STRLEN key
+func StrLen(db *SaveDBTables, args []string) Reply {
+	value, err := db.GetString(args[0])
+	if err != nil {
+		return errReply(err.Error())
+	}
+	return MakeIntReply(int64(len(value)))
+}
+
+// GetRange GETRANGE key start end, 负数表示从末尾开始
+func GetRange(db *SaveDBTables, args []string) Reply {
+	start, err1 := strconv.Atoi(args[1])
+	end, err2 := strconv.Atoi(args[2])
+	if err1 != nil || err2 != nil {
+		return errReply("ERR value is not an integer or out of range")
+	}
+	value, err := db.GetString(args[0])
+	if err != nil {
+		return errReply(err.Error())
+	}
+	size := len(value)
+	if start < 0 && end < 0 && start > end {
+		return MakeBulkReply([]byte(""))
+	}
+	if start < 0 {
+		start = size + start
+	}
+	if end < 0 {
+		end = size + end
+	}
+	if start < 0 {
+		start = 0
+	}
+	if end >= size {
+		end = size - 1
+	}
+	if size == 0 || start > end {
+		return MakeBulkReply([]byte(""))
+	}
+	return MakeBulkReply(value[start : end+1])
+}
+
+// SetRange SETRANGE key offset value, 不够长时用0补齐, 回复修改之后的长度
+func SetRange(db *SaveDBTables, args []string) Reply {
+	key := args[0]
+	offset, err := strconv.Atoi(args[1])
+	if err != nil || offset < 0 {
+		return errReply("ERR offset is out of range")
+	}
+	value, err := db.GetString(key)
+	if err != nil {
+		return errReply(err.Error())
+	}
+	patch := args[2]
+	if len(patch) == 0 {
+		return MakeIntReply(int64(len(value)))
+	}
+	if offset+len(patch) > maxStringLength {
+		return errReply("ERR string exceeds maximum allowed size (proto-max-bulk-len)")
+	}
+	size := len(value)
+	if offset+len(patch) > size {
+		size = offset + len(patch)
+	}
+	newValue := make([]byte, size)
+	copy(newValue, value)
+	copy(newValue[offset:], patch)
+	db.putString(key, newValue, true)
+	db.addAof(ToCmdLine2("setrange", args...))
+	return MakeIntReply(int64(len(newValue)))
+}
+
+// 整数计数器加上delta, 不存在的key从0开始
+func incrBy(db *SaveDBTables, cmd string, key string, delta int64) Reply {
+	value, err := db.GetString(key)
+	if err != nil {
+		return errReply(err.Error())
+	}
+	var n int64
+	if value != nil {
+		n, err = strconv.ParseInt(string(value), 10, 64)
+		if err != nil {
+			return errReply("ERR value is not an integer or out of range")
 		}
-		return CreateStrResult(COk, string(s.([]byte)))
 	}
-	return CreateStrResult(CErr, "key not exist")
+	if (delta > 0 && n > math.MaxInt64-delta) || (delta < 0 && n < math.MinInt64-delta) {
+		return errReply("ERR increment or decrement would overflow")
+	}
+	n += delta
+	result := strconv.FormatInt(n, 10)
+	db.putString(key, []byte(result), true)
+	db.addAof(ToCmdLine(cmd, key, strconv.FormatInt(delta, 10)))
+	return MakeIntReply(n)
+}
+
+func Incr(db *SaveDBTables, args []string) Reply {
+	return incrBy(db, "incrby", args[0], 1)
 }
 
-func SetExc(db *SaveDBTables, arg []string) Result {
-	db.Data.PutWithLock(arg[0], []byte(arg[1]))
-	db.AllKeys.PutKey(arg[0], TypeStr)
-	db.addAof(ToCmdLine2("set", arg...))
-	return CreateResult(COk, StringToBytes(OkStr))
+func Decr(db *SaveDBTables, args []string) Reply {
+	return incrBy(db, "incrby", args[0], -1)
+}
+
+func IncrBy(db *SaveDBTables, args []string) Reply {
+	delta, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil {
+		return errReply("ERR value is not an integer or out of range")
+	}
+	return incrBy(db, "incrby", args[0], delta)
+}
+
+func DecrBy(db *SaveDBTables, args []string) Reply {
+	delta, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil || delta == math.MinInt64 {
+		return errReply("ERR value is not an integer or out of range")
+	}
+	return incrBy(db, "incrby", args[0], -delta)
+}
+
+// IncrByFloat INCRBYFLOAT key increment, aof记录计算后的结果
+func IncrByFloat(db *SaveDBTables, args []string) Reply {
+	key := args[0]
+	delta, err := strconv.ParseFloat(args[1], 64)
+	if err != nil || math.IsNaN(delta) || math.IsInf(delta, 0) {
+		return errReply("ERR value is not a valid float")
+	}
+	value, err := db.GetString(key)
+	if err != nil {
+		return errReply(err.Error())
+	}
+	var f float64
+	if value != nil {
+		f, err = strconv.ParseFloat(string(value), 64)
+		if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+			return errReply("ERR value is not a valid float")
+		}
+	}
+	f += delta
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return errReply("ERR increment would produce NaN or Infinity")
+	}
+	result := strconv.FormatFloat(f, 'f', -1, 64)
+	db.putString(key, []byte(result), true)
+	db.addAof(ToCmdLine("set", key, result, "keepttl"))
+	return MakeBulkReply([]byte(result))
 }
@@ -0,0 +1,55 @@
+package src
+
+import (
+	"testing"
+)
+
+func TestSetOptions(t *testing.T) {
+	s, c := makeMultiTestServer()
+	cases := [][]string{
+		{"+OK\r\n", "set", "a", "1"},
+		{"$-1\r\n", "set", "a", "2", "nx"},
+		{"$-1\r\n", "set", "none", "2", "xx"},
+		{"$1\r\n1\r\n", "set", "a", "3", "xx", "get"},
+		{"-ERR syntax error\r\n", "set", "a", "4", "nx", "xx"},
+		{"-ERR invalid expire time in 'set' command\r\n", "set", "a", "4", "ex", "0"},
+		{"+OK\r\n", "set", "b", "x", "px", "100000"},
+		{"+OK\r\n", "set", "b", "y", "keepttl"},
+		{":1\r\n", "msetnx", "c", "1", "d", "1"},
+		{":0\r\n", "msetnx", "a", "1", "e", "1"},
+		{"*3\r\n$1\r\n3\r\n$1\r\ny\r\n$1\r\n1\r\n", "mget", "a", "b", "c"},
+		{"$-1\r\n", "get", "none"},
+		{"$-1\r\n", "getdel", "none"},
+		{"$-1\r\n", "getex", "none", "persist"},
+	}
+	for _, tc := range cases {
+		if r := execTestCmd(s, c, tc[1:]...); r != tc[0] {
+			t.Errorf("%v: expect %q, actual %q", tc[1:], tc[0], r)
+		}
+	}
+	if s.FindDB(0).getExpiration("b") == nil {
+		t.Error("KEEPTTL should keep the expiration")
+	}
+}
+
+func TestIncr(t *testing.T) {
+	s, c := makeMultiTestServer()
+	cases := [][]string{
+		{":1\r\n", "incr", "n"},
+		{":11\r\n", "incrby", "n", "10"},
+		{":9\r\n", "decrby", "n", "2"},
+		{"$3\r\n9.5\r\n", "incrbyfloat", "n", "0.5"},
+		{"-ERR value is not an integer or out of range\r\n", "incr", "n"},
+		{"+OK\r\n", "set", "m", "9223372036854775807"},
+		{"-ERR increment or decrement would overflow\r\n", "incr", "m"},
+		{":5\r\n", "append", "s", "hello"},
+		{"$3\r\nell\r\n", "getrange", "s", "1", "-2"},
+		{":10\r\n", "setrange", "s", "5", "world"},
+		{"$10\r\nhelloworld\r\n", "get", "s"},
+	}
+	for _, tc := range cases {
+		if r := execTestCmd(s, c, tc[1:]...); r != tc[0] {
+			t.Errorf("%v: expect %q, actual %q", tc[1:], tc[0], r)
+		}
+	}
+}
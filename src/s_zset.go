@@ -1,7 +1,6 @@
 package src
 
 import (
-	"fmt"
 	"math"
 	"savedb/src/data"
 	"strconv"
@@ -26,7 +25,7 @@ func (db *SaveDBTables) GetOrCreateZSet(key string) (*ZSet, error) {
 		return val.(*ZSet), nil
 	}
 	if _, ok := val.(*ZSet); !ok {
-		return nil, fmt.Errorf("type conversion error")
+		return nil, errWrongType
 	}
 	return val.(*ZSet), nil
 }
@@ -37,15 +36,15 @@ func (db *SaveDBTables) GetZSet(key string) (*ZSet, error) {
 		return nil, nil
 	}
 	if _, ok := val.(*ZSet); !ok {
-		return nil, fmt.Errorf("type conversion error")
+		return nil, errWrongType
 	}
 	db.AllKeys.ActivateKey(key)
 	return val.(*ZSet), nil
 }
 
-func ZAdd(db *SaveDBTables, args []string) Result {
+func ZAdd(db *SaveDBTables, args []string) Reply {
 	if len(args)%2 != 1 {
-		return CreateStrResult(CErr, "syntax err")
+		return errReply("syntax err")
 	}
 	key := args[0]
 	size := (len(args) - 1) / 2
@@ -55,7 +54,7 @@ func ZAdd(db *SaveDBTables, args []string) Result {
 		member := args[2*i+2]
 		score, err := strconv.ParseFloat(string(scoreValue), 64)
 		if err != nil {
-			return CreateStrResult(CErr, "ERR value is not a valid float")
+			return errReply("ERR value is not a valid float")
 		}
 		elements[i] = &data.Element{
 			Member: member,
@@ -66,7 +65,7 @@ func ZAdd(db *SaveDBTables, args []string) Result {
 	// get or init entity
 	sortedSet, err := db.GetOrCreateZSet(key)
 	if err != nil {
-		return CreateStrResult(CErr, err.Error())
+		return errReply(err.Error())
 	}
 	i := 0
 	for _, e := range elements {
@@ -77,34 +76,34 @@ func ZAdd(db *SaveDBTables, args []string) Result {
 
 	//persistence
 	db.addAof(ToCmdLine2("zadd", args...))
+	db.signalKey(key)
 	//添加全局key
 	db.AllKeys.PutKey(key, TypeZSet)
-	return CreateStrResult(COk, strconv.Itoa(i))
+	return MakeIntReply(int64(i))
 }
 
 // execZScore gets score of a member in sortedset
-func ZScore(db *SaveDBTables, args []string) Result {
+func ZScore(db *SaveDBTables, args []string) Reply {
 	// parse args
 	key := args[0]
 	member := args[1]
 
 	sortedSet, err := db.GetZSet(key)
 	if err != nil {
-		return CreateStrResult(CErr, err.Error())
+		return errReply(err.Error())
 	}
 	if sortedSet == nil {
-		return CreateStrResult(CErr, "zSet is exists")
+		return MakeNullBulkReply()
 	}
 
 	element, exists := sortedSet.Z.Get(member)
 	if !exists {
-		return CreateStrResult(CErr, "zSet key not exists")
+		return MakeNullBulkReply()
 	}
-	value := strconv.FormatFloat(element.Score, 'f', -1, 64)
-	return CreateStrResult(COk, value)
+	return MakeDoubleReply(element.Score)
 }
 
-func ZRank(db *SaveDBTables, args []string) Result {
+func ZRank(db *SaveDBTables, args []string) Reply {
 	// parse args
 	key := args[0]
 	member := args[1]
@@ -112,20 +111,20 @@ func ZRank(db *SaveDBTables, args []string) Result {
 	// get entity
 	sortedSet, err := db.GetZSet(key)
 	if err != nil {
-		return CreateStrResult(CErr, err.Error())
+		return errReply(err.Error())
 	}
 	if sortedSet == nil {
-		return CreateStrResult(CErr, "zSet is exists")
+		return MakeNullBulkReply()
 	}
 
 	rank := sortedSet.Z.GetRank(member, false)
 	if rank < 0 {
-		return CreateResult(COk, nil)
+		return MakeNullBulkReply()
 	}
-	return CreateStrResult(COk, strconv.FormatInt(rank, 10))
+	return MakeIntReply(rank)
 }
 
-func ZRevRank(db *SaveDBTables, args []string) Result {
+func ZRevRank(db *SaveDBTables, args []string) Reply {
 	// parse args
 	key := args[0]
 	member := args[1]
@@ -133,93 +132,93 @@ func ZRevRank(db *SaveDBTables, args []string) Result {
 	// get entity
 	sortedSet, err := db.GetZSet(key)
 	if err != nil {
-		return CreateStrResult(CErr, err.Error())
+		return errReply(err.Error())
 	}
 	if sortedSet == nil {
-		return CreateStrResult(CErr, "zSet is exists")
+		return MakeNullBulkReply()
 	}
 
 	rank := sortedSet.Z.GetRank(member, true)
 	if rank < 0 {
-		return CreateResult(COk, nil)
+		return MakeNullBulkReply()
 	}
-	return CreateStrResult(COk, strconv.FormatInt(rank, 10))
+	return MakeIntReply(rank)
 }
 
 // execZCard gets number of members in sortedset
-func ZCard(db *SaveDBTables, args []string) Result {
+func ZCard(db *SaveDBTables, args []string) Reply {
 	// parse args
 	key := args[0]
 
 	// get entity
 	sortedSet, err := db.GetZSet(key)
 	if err != nil {
-		return CreateStrResult(CErr, err.Error())
+		return errReply(err.Error())
 	}
 	if sortedSet == nil {
-		return CreateStrResult(CErr, "zSet is exists")
+		return MakeIntReply(0)
 	}
 
-	return CreateStrResult(COk, strconv.FormatInt(sortedSet.Z.Len(), 10))
+	return MakeIntReply(sortedSet.Z.Len())
 }
 
-func ZRange(db *SaveDBTables, args []string) Result {
+func ZRange(db *SaveDBTables, args []string) Reply {
 	// parse args
 	if len(args) != 3 && len(args) != 4 {
-		return CreateStrResult(CErr, "ERR wrong number of arguments for 'zrange' command")
+		return errReply("ERR wrong number of arguments for 'zrange' command")
 	}
 	withScores := false
 	if len(args) == 4 {
 		if strings.ToUpper(args[3]) != "WITHSCORES" {
-			return CreateStrResult(CErr, "syntax error")
+			return errReply("syntax error")
 		}
 		withScores = true
 	}
 	key := args[0]
 	start, err := strconv.ParseInt(args[1], 10, 64)
 	if err != nil {
-		return CreateStrResult(CErr, "ERR value is not an integer or out of range")
+		return errReply("ERR value is not an integer or out of range")
 	}
 	stop, err := strconv.ParseInt(args[2], 10, 64)
 	if err != nil {
-		return CreateStrResult(CErr, "ERR value is not an integer or out of range")
+		return errReply("ERR value is not an integer or out of range")
 	}
 	return range0(db, key, start, stop, withScores, false)
 }
 
 // execZRevRange gets members in range, sort by score in descending order
-func ZRevRange(db *SaveDBTables, args []string) Result {
+func ZRevRange(db *SaveDBTables, args []string) Reply {
 	// parse args
 	if len(args) != 3 && len(args) != 4 {
-		return CreateStrResult(CErr, "ERR wrong number of arguments for 'zrevrange' command")
+		return errReply("ERR wrong number of arguments for 'zrevrange' command")
 	}
 	withScores := false
 	if len(args) == 4 {
 		if string(args[3]) != "WITHSCORES" {
-			return CreateStrResult(CErr, "syntax error")
+			return errReply("syntax error")
 		}
 		withScores = true
 	}
 	key := args[0]
 	start, err := strconv.ParseInt(args[1], 10, 64)
 	if err != nil {
-		return CreateStrResult(CErr, "ERR value is not an integer or out of range")
+		return errReply("ERR value is not an integer or out of range")
 	}
 	stop, err := strconv.ParseInt(args[2], 10, 64)
 	if err != nil {
-		return CreateStrResult(CErr, "ERR value is not an integer or out of range")
+		return errReply("ERR value is not an integer or out of range")
 	}
 	return range0(db, key, start, stop, withScores, true)
 }
 
-func range0(db *SaveDBTables, key string, start int64, stop int64, withScores bool, desc bool) Result {
+func range0(db *SaveDBTables, key string, start int64, stop int64, withScores bool, desc bool) Reply {
 	// get data
 	sortedSet, err := db.GetZSet(key)
 	if err != nil {
-		return CreateStrResult(CErr, err.Error())
+		return errReply(err.Error())
 	}
 	if sortedSet == nil {
-		return CreateStrResult(CErr, "zSet is exists")
+		return MakeEmptyMultiBulkReply()
 	}
 
 	// compute index
@@ -229,7 +228,7 @@ func range0(db *SaveDBTables, key string, start int64, stop int64, withScores bo
 	} else if start < 0 {
 		start = size + start
 	} else if start >= size {
-		return CreateResult(COk, nil)
+		return MakeEmptyMultiBulkReply()
 	}
 	if stop < -1*size {
 		stop = 0
@@ -246,102 +245,78 @@ func range0(db *SaveDBTables, key string, start int64, stop int64, withScores bo
 
 	// assert: start in [0, size - 1], stop in [start, size]
 	slice := sortedSet.Z.RangeByRank(start, stop, desc)
-	if withScores {
-		result := make([]string, len(slice)*2)
-		i := 0
-		for _, element := range slice {
-			result[i] = element.Member
-			i++
-			scoreStr := strconv.FormatFloat(element.Score, 'f', -1, 64)
-			result[i] = scoreStr
-			i++
-		}
-		res := strings.Join(result, ",")
-		return CreateStrResult(COk, res)
-	}
-	result := make([]string, len(slice))
-	i := 0
-	for _, element := range slice {
-		result[i] = element.Member
-		i++
-	}
-	res := strings.Join(result, ",")
-	return CreateStrResult(COk, res)
+	return elementsReply(slice, withScores)
 }
 
-func ZCount(db *SaveDBTables, args []string) Result {
+func ZCount(db *SaveDBTables, args []string) Reply {
 	key := args[0]
 
 	min, err := data.ParseScoreBorder(args[1])
 	if err != nil {
-		return CreateStrResult(COk, err.Error())
+		return errReply(err.Error())
 	}
 
 	max, err := data.ParseScoreBorder(args[2])
 	if err != nil {
-		return CreateStrResult(COk, err.Error())
+		return errReply(err.Error())
 	}
 
 	// get data
 	sortedSet, err := db.GetZSet(key)
 	if err != nil {
-		return CreateStrResult(CErr, err.Error())
+		return errReply(err.Error())
 	}
 	if sortedSet == nil {
-		return CreateStrResult(CErr, "zSet is exists")
+		return MakeIntReply(0)
 	}
 
-	return CreateStrResult(COk, strconv.FormatInt(sortedSet.Z.RangeCount(min, max), 10))
+	return MakeIntReply(sortedSet.Z.RangeCount(min, max))
 }
 
-func rangeByScore0(db *SaveDBTables, key string, min data.Border, max data.Border, offset int64, limit int64, withScores bool, desc bool) Result {
+func rangeByScore0(db *SaveDBTables, key string, min data.Border, max data.Border, offset int64, limit int64, withScores bool, desc bool) Reply {
 	sortedSet, err := db.GetZSet(key)
 	if err != nil {
-		return CreateStrResult(CErr, err.Error())
+		return errReply(err.Error())
 	}
 	if sortedSet == nil {
-		return CreateStrResult(CErr, "zSet is exists")
+		return MakeEmptyMultiBulkReply()
 	}
 
 	slice := sortedSet.Z.Range(min, max, offset, limit, desc)
-	if withScores {
-		result := make([]string, len(slice)*2)
-		i := 0
-		for _, element := range slice {
-			result[i] = element.Member
-			i++
-			scoreStr := strconv.FormatFloat(element.Score, 'f', -1, 64)
-			result[i] = scoreStr
-			i++
+	return elementsReply(slice, withScores)
+}
+
+// 成员数组, WITHSCORES时成员后面跟着分数
+func elementsReply(elements []*data.Element, withScores bool) Reply {
+	if !withScores {
+		members := make([]string, len(elements))
+		for i, element := range elements {
+			members[i] = element.Member
 		}
-		res := strings.Join(result, ",")
-		return CreateStrResult(COk, res)
+		return MakeStringsReply(members)
 	}
-	result := make([]string, len(slice))
-	i := 0
-	for _, element := range slice {
-		result[i] = element.Member
-		i++
+	replies := make([]Reply, 0, len(elements)*2)
+	for _, element := range elements {
+		replies = append(replies, MakeBulkReply([]byte(element.Member)), MakeDoubleReply(element.Score))
 	}
-	res := strings.Join(result, ",")
-	return CreateStrResult(COk, res)
+	return MakeMultiRawReply(replies)
 }
 
 // execZRangeByScore gets members which score within given range, in ascending order
-func ZRangeByScore(db *SaveDBTables, args []string) Result {
+func ZRangeByScore(db *SaveDBTables, args []string) Reply {
 	if len(args) < 3 {
-		return CreateStrResult(CErr, "ERR wrong number of arguments for 'zrangebyscore' command")
+		return errReply("ERR wrong number of arguments for 'zrangebyscore' command")
 	}
 	key := args[0]
 
 	min, err := data.ParseScoreBorder(args[1])
 	if err != nil {
-		return CreateStrResult(CErr, err.Error())
+		return errReply(err.Error())
 	}
 
 	max, err := data.ParseScoreBorder(args[2])
 	if err != nil {
-		return CreateStrResult(CErr, err.Error())
+		return errReply(err.Error())
 	}
 
 	withScores := false
@@ -355,39 +330,39 @@ func ZRangeByScore(db *SaveDBTables, args []string) Result {
 				i++
 			} else if strings.ToUpper(s) == "LIMIT" {
 				if len(args) < i+3 {
-					return CreateStrResult(CErr, "ERR syntax error")
+					return errReply("ERR syntax error")
 				}
 				offset, err = strconv.ParseInt(string(args[i+1]), 10, 64)
 				if err != nil {
-					return CreateStrResult(CErr, "ERR value is not an integer or out of range")
+					return errReply("ERR value is not an integer or out of range")
 				}
 				limit, err = strconv.ParseInt(string(args[i+2]), 10, 64)
 				if err != nil {
-					return CreateStrResult(CErr, "ERR value is not an integer or out of range")
+					return errReply("ERR value is not an integer or out of range")
 				}
 				i += 3
 			} else {
-				return CreateStrResult(CErr, "ERR syntax error")
+				return errReply("ERR syntax error")
 			}
 		}
 	}
 	return rangeByScore0(db, key, min, max, offset, limit, withScores, false)
 }
 
-func ZRevRangeByScore(db *SaveDBTables, args []string) Result {
+func ZRevRangeByScore(db *SaveDBTables, args []string) Reply {
 	if len(args) < 3 {
-		return CreateStrResult(CErr, "ERR wrong number of arguments for 'zrangebyscore' command")
+		return errReply("ERR wrong number of arguments for 'zrangebyscore' command")
 	}
 	key := args[0]
 
 	min, err := data.ParseScoreBorder(args[2])
 	if err != nil {
-		return CreateStrResult(CErr, err.Error())
+		return errReply(err.Error())
 	}
 
 	max, err := data.ParseScoreBorder(args[1])
 	if err != nil {
-		return CreateStrResult(CErr, err.Error())
+		return errReply(err.Error())
 	}
 
 	withScores := false
@@ -401,72 +376,72 @@ func ZRevRangeByScore(db *SaveDBTables, args []string) Result {
 				i++
 			} else if strings.ToUpper(s) == "LIMIT" {
 				if len(args) < i+3 {
-					return CreateStrResult(CErr, "ERR syntax error")
+					return errReply("ERR syntax error")
 				}
 				offset, err = strconv.ParseInt(string(args[i+1]), 10, 64)
 				if err != nil {
-					return CreateStrResult(CErr, "ERR value is not an integer or out of range")
+					return errReply("ERR value is not an integer or out of range")
 				}
 				limit, err = strconv.ParseInt(string(args[i+2]), 10, 64)
 				if err != nil {
-					return CreateStrResult(CErr, "ERR value is not an integer or out of range")
+					return errReply("ERR value is not an integer or out of range")
 				}
 				i += 3
 			} else {
-				return CreateStrResult(CErr, "ERR syntax error")
+				return errReply("ERR syntax error")
 			}
 		}
 	}
 	return rangeByScore0(db, key, min, max, offset, limit, withScores, true)
 }
 
-func ZRemRangeByScore(db *SaveDBTables, args []string) Result {
+func ZRemRangeByScore(db *SaveDBTables, args []string) Reply {
 	if len(args) != 3 {
-		return CreateStrResult(CErr, "ERR wrong number of arguments for 'zremrangebyscore' command")
+		return errReply("ERR wrong number of arguments for 'zremrangebyscore' command")
 	}
 	key := args[0]
 
 	min, err := data.ParseScoreBorder(args[1])
 	if err != nil {
-		return CreateStrResult(CErr, err.Error())
+		return errReply(err.Error())
 	}
 
 	max, err := data.ParseScoreBorder(args[2])
 	if err != nil {
-		return CreateStrResult(CErr, err.Error())
+		return errReply(err.Error())
 	}
 	sortedSet, err := db.GetZSet(key)
 	if err != nil {
-		return CreateStrResult(CErr, err.Error())
+		return errReply(err.Error())
 	}
 	if sortedSet == nil {
-		return CreateStrResult(CErr, "zSet is exists")
+		return MakeIntReply(0)
 	}
 
 	removed := sortedSet.Z.RemoveRange(min, max)
 	if removed > 0 {
 		db.addAof(ToCmdLine2("zremrangebyscore", args...))
 	}
-	return CreateStrResult(COk, strconv.FormatInt(removed, 10))
+	return MakeIntReply(removed)
 }
 
-func ZRemRangeByRank(db *SaveDBTables, args []string) Result {
+func ZRemRangeByRank(db *SaveDBTables, args []string) Reply {
 	key := args[0]
 	start, err := strconv.ParseInt(args[1], 10, 64)
 	if err != nil {
-		return CreateStrResult(CErr, "ERR value is not an integer or out of range")
+		return errReply("ERR value is not an integer or out of range")
 	}
 	stop, err := strconv.ParseInt(string(args[2]), 10, 64)
 	if err != nil {
-		return CreateStrResult(CErr, "ERR value is not an integer or out of range")
+		return errReply("ERR value is not an integer or out of range")
 	}
 
 	sortedSet, err := db.GetZSet(key)
 	if err != nil {
-		return CreateStrResult(CErr, err.Error())
+		return errReply(err.Error())
 	}
 	if sortedSet == nil {
-		return CreateStrResult(CErr, "zSet is exists")
+		return MakeIntReply(0)
 	}
 
 	// compute index
@@ -476,7 +451,7 @@ func ZRemRangeByRank(db *SaveDBTables, args []string) Result {
 	} else if start < 0 {
 		start = size + start
 	} else if start >= size {
-		return CreateResult(COk, nil)
+		return MakeIntReply(0)
 	}
 	if stop < -1*size {
 		stop = 0
@@ -496,44 +471,113 @@ func ZRemRangeByRank(db *SaveDBTables, args []string) Result {
 	if removed > 0 {
 		db.addAof(ToCmdLine2("zremrangebyrank", args...))
 	}
-	return CreateStrResult(COk, strconv.FormatInt(removed, 10))
+	return MakeIntReply(removed)
+}
+
+func ZPopMin(db *SaveDBTables, args []string) Reply {
+	return zPop(db, args, false)
+}
+
+func ZPopMax(db *SaveDBTables, args []string) Reply {
+	return zPop(db, args, true)
 }
 
-func ZPopMin(db *SaveDBTables, args []string) Result {
+func zPop(db *SaveDBTables, args []string, max bool) Reply {
+	if len(args) == 0 || len(args) > 2 {
+		name := "zpopmin"
+		if max {
+			name = "zpopmax"
+		}
+		return errReply("ERR wrong number of arguments for '" + name + "' command")
+	}
 	key := string(args[0])
 	count := 1
 	if len(args) > 1 {
 		var err error
 		count, err = strconv.Atoi(args[1])
 		if err != nil {
-			return CreateStrResult(CErr, "ERR value is not an integer or out of range")
+			return errReply("ERR value is not an integer or out of range")
 		}
 	}
 
 	sortedSet, err := db.GetZSet(key)
 	if err != nil {
-		return CreateStrResult(CErr, err.Error())
+		return errReply(err.Error())
 	}
 	if sortedSet == nil {
-		return CreateStrResult(CErr, "zSet is exists")
+		return MakeEmptyMultiBulkReply()
 	}
 
-	removed := sortedSet.Z.PopMin(count)
+	removed := popZSet(db, key, sortedSet, count, max)
+	return elementsReply(removed, true)
+}
+
+// 弹出分数最小或最大的count个成员, 记录成等价的zpopmin/zpopmax, zset空了之后删除key
+func popZSet(db *SaveDBTables, key string, sortedSet *ZSet, count int, max bool) []*data.Element {
+	var removed []*data.Element
+	if max {
+		removed = sortedSet.Z.PopMax(count)
+	} else {
+		removed = sortedSet.Z.PopMin(count)
+	}
 	if len(removed) > 0 {
 		//persistence
-		db.addAof(ToCmdLine2("zpopmin", args...))
+		cmd := "zpopmin"
+		if max {
+			cmd = "zpopmax"
+		}
+		db.addAof(ToCmdLine2(cmd, key, strconv.Itoa(count)))
+	}
+	if sortedSet.Z.Len() == 0 {
+		db.Data.RemoveWithLock(key)
+		db.AllKeys.RemoveKey(db, key)
+	}
+	return removed
+}
+
+// 依次检查每个key, 从第一个非空的zset中弹出一个成员, 都为空时阻塞
+func blockingZPop(db *SaveDBTables, args []string, max bool) Reply {
+	if len(args) < 2 {
+		name := "bzpopmin"
+		if max {
+			name = "bzpopmax"
+		}
+		return errReply("ERR wrong number of arguments for '" + name + "' command")
 	}
-	result := make([]string, 0, len(removed)*2)
-	for _, element := range removed {
-		scoreStr := strconv.FormatFloat(element.Score, 'f', -1, 64)
-		result = append(result, element.Member, scoreStr)
+	timeout, err := parseBlockSeconds(args[len(args)-1])
+	if err != nil {
+		return errReply(err.Error())
 	}
-	res := strings.Join(result, ",")
-	return CreateStrResult(COk, res)
+	for _, key := range args[:len(args)-1] {
+		sortedSet, err := db.GetZSet(key)
+		if err != nil {
+			return errReply(err.Error())
+		}
+		if sortedSet == nil || sortedSet.Z.Len() == 0 {
+			continue
+		}
+		element := popZSet(db, key, sortedSet, 1, max)[0]
+		return MakeMultiRawReply([]Reply{
+			MakeBulkReply([]byte(key)),
+			MakeBulkReply([]byte(element.Member)),
+			MakeDoubleReply(element.Score),
+		})
+	}
+	return blockResult(timeout)
+}
+
+// BZPOPMIN key [key ...] timeout
+func BZPopMin(db *SaveDBTables, args []string) Reply {
+	return blockingZPop(db, args, false)
+}
+
+// BZPOPMAX key [key ...] timeout
+func BZPopMax(db *SaveDBTables, args []string) Reply {
+	return blockingZPop(db, args, true)
 }
 
 // execZRem removes given members
-func ZRem(db *SaveDBTables, args []string) Result {
+func ZRem(db *SaveDBTables, args []string) Reply {
 	// parse args
 	key := string(args[0])
 	fields := make([]string, len(args)-1)
@@ -545,10 +589,10 @@ func ZRem(db *SaveDBTables, args []string) Result {
 	// get entity
 	sortedSet, err := db.GetZSet(key)
 	if err != nil {
-		return CreateStrResult(CErr, err.Error())
+		return errReply(err.Error())
 	}
 	if sortedSet == nil {
-		return CreateStrResult(CErr, "zSet is exists")
+		return MakeIntReply(0)
 	}
 
 	var deleted int64 = 0
@@ -561,25 +605,22 @@ func ZRem(db *SaveDBTables, args []string) Result {
 		//persistence
 		db.addAof(ToCmdLine2("zrem", args...))
 	}
-	return CreateStrResult(COk, strconv.FormatInt(deleted, 10))
+	return MakeIntReply(deleted)
 }
 
-func ZIncrBy(db *SaveDBTables, args []string) Result {
+func ZIncrBy(db *SaveDBTables, args []string) Reply {
 	key := args[0]
 	rawDelta := args[1]
 	field := args[2]
 	delta, err := strconv.ParseFloat(rawDelta, 64)
 	if err != nil {
-		return CreateStrResult(CErr, "ERR value is not a valid float")
+		return errReply("ERR value is not a valid float")
 	}
 
 	// get or init entity
-	sortedSet, err := db.GetZSet(key)
+	sortedSet, err := db.GetOrCreateZSet(key)
 	if err != nil {
-		return CreateStrResult(CErr, err.Error())
-	}
-	if sortedSet == nil {
-		return CreateStrResult(CErr, "zSet is exists")
+		return errReply(err.Error())
 	}
 
 	element, exists := sortedSet.Z.Get(field)
@@ -587,66 +628,66 @@ func ZIncrBy(db *SaveDBTables, args []string) Result {
 		sortedSet.Z.Add(field, delta)
 		//persistence
 		db.addAof(ToCmdLine2("zincrby", args...))
-		return CreateStrResult(COk, args[1])
+		return MakeDoubleReply(delta)
 	}
 	score := element.Score + delta
 	sortedSet.Z.Add(field, score)
 	//persistence
 	db.addAof(ToCmdLine2("zincrby", args...))
-	return CreateStrResult(COk, strconv.FormatFloat(score, 'f', -1, 64))
+	return MakeDoubleReply(score)
 }
 
-func ZLexCount(db *SaveDBTables, args []string) Result {
+func ZLexCount(db *SaveDBTables, args []string) Reply {
 	key := args[0]
 	sortedSet, err := db.GetZSet(key)
 	if err != nil {
-		return CreateStrResult(CErr, err.Error())
+		return errReply(err.Error())
 	}
 	if sortedSet == nil {
-		return CreateStrResult(CErr, "zSet is exists")
+		return MakeIntReply(0)
 	}
 
 	minEle, maxEle := args[1], args[2]
 	min, err := data.ParseLexBorder(minEle)
 	if err != nil {
-		CreateStrResult(CErr, err.Error())
+		errReply(err.Error())
 	}
 	max, err := data.ParseLexBorder(maxEle)
 	if err != nil {
-		CreateStrResult(CErr, err.Error())
+		errReply(err.Error())
 	}
 
 	count := sortedSet.Z.RangeCount(min, max)
 
-	return CreateStrResult(COk, strconv.FormatInt(count, 10))
+	return MakeIntReply(count)
 }
 
-func ZRangeByLex(db *SaveDBTables, args []string) Result {
+func ZRangeByLex(db *SaveDBTables, args []string) Reply {
 	n := len(args)
 	if n > 3 && strings.ToLower(args[3]) != "limit" {
-		return CreateStrResult(CErr, "ERR syntax error")
+		return errReply("ERR syntax error")
 	}
 	if n != 3 && n != 6 {
-		return CreateStrResult(CErr, "ERR wrong number of arguments for 'zrangebylex' command")
+		return errReply("ERR wrong number of arguments for 'zrangebylex' command")
 	}
 
 	key := args[0]
 	sortedSet, err := db.GetZSet(key)
 	if err != nil {
-		return CreateStrResult(CErr, err.Error())
+		return errReply(err.Error())
 	}
 	if sortedSet == nil {
-		return CreateStrResult(CErr, "zSet is exists")
+		return MakeEmptyMultiBulkReply()
 	}
 
 	minEle, maxEle := args[1], args[2]
 	min, err := data.ParseLexBorder(minEle)
 	if err != nil {
-		return CreateStrResult(CErr, err.Error())
+		return errReply(err.Error())
 	}
 	max, err := data.ParseLexBorder(maxEle)
 	if err != nil {
-		return CreateStrResult(CErr, err.Error())
+		return errReply(err.Error())
 	}
 
 	offset := int64(0)
@@ -655,14 +696,14 @@ func ZRangeByLex(db *SaveDBTables, args []string) Result {
 		var err error
 		offset, err = strconv.ParseInt(string(args[4]), 10, 64)
 		if err != nil {
-			return CreateStrResult(CErr, "ERR value is not an integer or out of range")
+			return errReply("ERR value is not an integer or out of range")
 		}
 		if offset < 0 {
-			return CreateResult(COk, nil)
+			return MakeEmptyMultiBulkReply()
 		}
 		count, err := strconv.ParseInt(string(args[5]), 10, 64)
 		if err != nil {
-			return CreateStrResult(CErr, "ERR value is not an integer or out of range")
+			return errReply("ERR value is not an integer or out of range")
 		}
 		if count >= 0 {
 			limitCnt = count
@@ -670,43 +711,208 @@ func ZRangeByLex(db *SaveDBTables, args []string) Result {
 	}
 
 	elements := sortedSet.Z.Range(min, max, offset, limitCnt, false)
-	result := make([]string, 0, len(elements))
-	for _, ele := range elements {
-		result = append(result, ele.Member)
-	}
-	if len(result) == 0 {
-		return CreateResult(COk, nil)
-	}
-	res := strings.Join(result, ",")
-	return CreateStrResult(COk, res)
+	return elementsReply(elements, false)
 }
 
-func ZRemRangeByLex(db *SaveDBTables, args []string) Result {
+func ZRemRangeByLex(db *SaveDBTables, args []string) Reply {
 	n := len(args)
 	if n != 3 {
-		return CreateStrResult(CErr, "ERR wrong number of arguments for 'zremrangebylex' command")
+		return errReply("ERR wrong number of arguments for 'zremrangebylex' command")
 	}
 
 	key := args[0]
 	sortedSet, err := db.GetZSet(key)
 	if err != nil {
-		return CreateStrResult(CErr, err.Error())
+		return errReply(err.Error())
 	}
 	if sortedSet == nil {
-		return CreateStrResult(CErr, "zSet is exists")
+		return MakeIntReply(0)
 	}
 
 	minEle, maxEle := args[1], args[2]
 	min, err := data.ParseLexBorder(minEle)
 	if err != nil {
-		return CreateStrResult(CErr, err.Error())
+		return errReply(err.Error())
 	}
 	max, err := data.ParseLexBorder(maxEle)
 	if err != nil {
-		return CreateStrResult(CErr, err.Error())
+		return errReply(err.Error())
 	}
 
 	count := sortedSet.Z.RemoveRange(min, max)
 
-	return CreateStrResult(COk, strconv.FormatInt(count, 10))
+	return MakeIntReply(count)
+}
+
+// 有序集合运算的参数, 普通集合的成员分数按1处理, 不存在的key当作空集合
+func (db *SaveDBTables) getSortedSets(keys []string) ([]*data.SortedSet, error) {
+	sets := make([]*data.SortedSet, len(keys))
+	for i, key := range keys {
+		val, ok := db.Data.GetWithLock(key)
+		if !ok {
+			continue
+		}
+		switch v := val.(type) {
+		case *ZSet:
+			sets[i] = v.Z
+		case *Set:
+			sortedSet := data.MakeSortedSet()
+			for member := range v.M {
+				sortedSet.Add(member, 1)
+			}
+			sets[i] = sortedSet
+		default:
+			return nil, errWrongType
+		}
+		db.AllKeys.ActivateKey(key)
+	}
+	return sets, nil
+}
+
+type zsetAlgebraArgs struct {
+	keys       []string
+	weights    []float64
+	aggregate  data.Aggregate
+	withScores bool
+}
+
+// 解析 numkeys key [key ...] [WEIGHTS weight [weight ...]] [AGGREGATE SUM|MIN|MAX] [WITHSCORES]
+// ZDIFF不支持WEIGHTS和AGGREGATE, STORE命令不支持WITHSCORES
+func parseZSetAlgebra(cmd string, args []string, diff bool, store bool) (*zsetAlgebraArgs, string) {
+	if len(args) < 2 {
+		return nil, "ERR wrong number of arguments for '" + cmd + "' command"
+	}
+	numKeys, err := strconv.Atoi(args[0])
+	if err != nil {
+		return nil, "ERR value is not an integer or out of range"
+	}
+	if numKeys <= 0 {
+		return nil, "ERR at least 1 input key is needed for '" + cmd + "' command"
+	}
+	if numKeys > len(args)-1 {
+		return nil, "ERR syntax error"
+	}
+	opts := &zsetAlgebraArgs{keys: args[1 : 1+numKeys], aggregate: data.AggregateSum}
+	for i := 1 + numKeys; i < len(args); i++ {
+		switch strings.ToLower(args[i]) {
+		case "weights":
+			if diff || i+numKeys >= len(args) {
+				return nil, "ERR syntax error"
+			}
+			opts.weights = make([]float64, numKeys)
+			for j := 0; j < numKeys; j++ {
+				w, err := strconv.ParseFloat(args[i+1+j], 64)
+				if err != nil || math.IsNaN(w) {
+					return nil, "ERR weight value is not a float"
+				}
+				opts.weights[j] = w
+			}
+			i += numKeys
+		case "aggregate":
+			if diff || i+1 >= len(args) {
+				return nil, "ERR syntax error"
+			}
+			switch strings.ToLower(args[i+1]) {
+			case "sum":
+				opts.aggregate = data.AggregateSum
+			case "min":
+				opts.aggregate = data.AggregateMin
+			case "max":
+				opts.aggregate = data.AggregateMax
+			default:
+				return nil, "ERR syntax error"
+			}
+			i++
+		case "withscores":
+			if store {
+				return nil, "ERR syntax error"
+			}
+			opts.withScores = true
+		default:
+			return nil, "ERR syntax error"
+		}
+	}
+	return opts, ""
+}
+
+func zsetAlgebraResult(db *SaveDBTables, opts *zsetAlgebraArgs, op string) (*data.SortedSet, error) {
+	sets, err := db.getSortedSets(opts.keys)
+	if err != nil {
+		return nil, err
+	}
+	switch op {
+	case "union":
+		return data.Union(sets, opts.weights, opts.aggregate), nil
+	case "inter":
+		return data.Inter(sets, opts.weights, opts.aggregate), nil
+	}
+	return data.Diff(sets), nil
+}
+
+// ZUNION ZINTER ZDIFF 按分数从小到大返回结果
+func zsetAlgebra(db *SaveDBTables, cmd string, op string, args []string) Reply {
+	opts, msg := parseZSetAlgebra(cmd, args, op == "diff", false)
+	if opts == nil {
+		return errReply(msg)
+	}
+	result, err := zsetAlgebraResult(db, opts, op)
+	if err != nil {
+		return errReply(err.Error())
+	}
+	if result.Len() == 0 {
+		return MakeEmptyMultiBulkReply()
+	}
+	return elementsReply(result.RangeByRank(0, result.Len(), false), opts.withScores)
+}
+
+// ZUNIONSTORE ZINTERSTORE ZDIFFSTORE, 结果覆盖destination, 为空时删除destination
+func zsetAlgebraStore(db *SaveDBTables, cmd string, op string, args []string) Reply {
+	if len(args) < 3 {
+		return errReply("ERR wrong number of arguments for '" + cmd + "' command")
+	}
+	dest := args[0]
+	opts, msg := parseZSetAlgebra(cmd, args[1:], op == "diff", true)
+	if opts == nil {
+		return errReply(msg)
+	}
+	result, err := zsetAlgebraResult(db, opts, op)
+	if err != nil {
+		return errReply(err.Error())
+	}
+	db.removeKey(dest)
+	if result.Len() > 0 {
+		db.putKey(dest, &ZSet{Z: result}, TypeZSet, nil)
+	}
+	db.addAof(ToCmdLine2(cmd, args...))
+	return MakeIntReply(result.Len())
+}
+
+// ZUNION numkeys key [key ...] [WEIGHTS weight [weight ...]] [AGGREGATE SUM|MIN|MAX] [WITHSCORES]
+func ZUnion(db *SaveDBTables, args []string) Reply {
+	return zsetAlgebra(db, "zunion", "union", args)
+}
+
+// ZINTER numkeys key [key ...] [WEIGHTS weight [weight ...]] [AGGREGATE SUM|MIN|MAX] [WITHSCORES]
+func ZInter(db *SaveDBTables, args []string) Reply {
+	return zsetAlgebra(db, "zinter", "inter", args)
+}
+
+// ZDIFF numkeys key [key ...] [WITHSCORES]
+func ZDiff(db *SaveDBTables, args []string) Reply {
+	return zsetAlgebra(db, "zdiff", "diff", args)
+}
+
+// ZUNIONSTORE destination numkeys key [key ...] [WEIGHTS weight [weight ...]] [AGGREGATE SUM|MIN|MAX]
+func ZUnionStore(db *SaveDBTables, args []string) Reply {
+	return zsetAlgebraStore(db, "zunionstore", "union", args)
+}
+
+// ZINTERSTORE destination numkeys key [key ...] [WEIGHTS weight [weight ...]] [AGGREGATE SUM|MIN|MAX]
+func ZInterStore(db *SaveDBTables, args []string) Reply {
+	return zsetAlgebraStore(db, "zinterstore", "inter", args)
+}
+
+// ZDIFFSTORE destination numkeys key [key ...]
+func ZDiffStore(db *SaveDBTables, args []string) Reply {
+	return zsetAlgebraStore(db, "zdiffstore", "diff", args)
 }
@@ -7,3 +7,34 @@ import (
 func TestRand(t *testing.T) {
 
 }
+
+func TestZSetAlgebra(t *testing.T) {
+	s, c := makeMultiTestServer()
+	execTestCmd(s, c, "zadd", "a", "1", "x", "2", "y")
+	execTestCmd(s, c, "zadd", "b", "3", "y", "4", "z")
+	execTestCmd(s, c, "sadd", "s", "x")
+	tests := [][]string{
+		{"*6\r\n$1\r\nx\r\n$1\r\n1\r\n$1\r\nz\r\n$1\r\n4\r\n$1\r\ny\r\n$1\r\n5\r\n", "zunion", "2", "a", "b", "withscores"},
+		{"*6\r\n$1\r\nx\r\n$1\r\n2\r\n$1\r\nz\r\n$1\r\n4\r\n$1\r\ny\r\n$1\r\n7\r\n", "zunion", "2", "a", "b", "weights", "2", "1", "aggregate", "sum", "withscores"},
+		{"*2\r\n$1\r\ny\r\n$1\r\n2\r\n", "zinter", "2", "a", "b", "aggregate", "min", "withscores"},
+		{"*1\r\n$1\r\nx\r\n", "zdiff", "2", "a", "b"},
+		{":1\r\n", "zinterstore", "out", "2", "a", "s", "aggregate", "max"},
+		{"*2\r\n$1\r\nx\r\n$1\r\n1\r\n", "zrange", "out", "0", "-1", "withscores"},
+		{":3\r\n", "zunionstore", "out", "3", "a", "b", "none"},
+		{":0\r\n", "zdiffstore", "out", "2", "s", "a"},
+		{":0\r\n", "exists", "out"},
+		{"-ERR at least 1 input key is needed for 'zunion' command\r\n", "zunion", "0", "a"},
+		{"-ERR weight value is not a float\r\n", "zinter", "2", "a", "b", "weights", "1", "x"},
+		{"-ERR syntax error\r\n", "zdiff", "2", "a", "b", "aggregate", "max"},
+		{"-ERR syntax error\r\n", "zunionstore", "out", "1", "a", "withscores"},
+		{"$-1\r\n", "zscore", "none", "x"},
+		{"$-1\r\n", "zscore", "a", "none"},
+		{"*0\r\n", "zrange", "none", "0", "-1"},
+		{":0\r\n", "zcard", "none"},
+	}
+	for _, test := range tests {
+		if r := execTestCmd(s, c, test[1:]...); r != test[0] {
+			t.Errorf("%v: expect %q, actual %q", test[1:], test[0], r)
+		}
+	}
+}
@@ -0,0 +1,289 @@
+package src
+
+import (
+	"container/heap"
+	"savedb/src/data"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// 游标遍历 SCAN HSCAN SSCAN ZSCAN
+// 1.SCAN按AllKeys的btree中key的顺序遍历, 游标对应上一次检查过的最后一个key, 下一次从比它大的key开始, 所以整个遍历期间一直存在的key一定会被返回
+// 2.HSCAN/SSCAN/ZSCAN按成员的字典序遍历, 每次从比游标大的成员中选出最小的COUNT个, 不受map的无序和zset中分数变化的影响
+// 3.游标是服务端分配的数字, 对应的位置保存在scanCursors中, 超过scanCursorsMax个时淘汰最早的; 0表示开始或者遍历结束
+// 4.和redis一样, COUNT是每次检查的元素个数, MATCH和TYPE在检查之后过滤, 所以返回的元素可能少于COUNT
+
+const (
+	scanDefaultCount = 10
+	scanCursorsMax   = 10000
+)
+
+type scanCursorTable struct {
+	mu      sync.Mutex
+	next    uint64
+	cursors map[uint64]string
+	//分配的顺序, 用来淘汰最早的游标
+	order []uint64
+}
+
+var scanCursors = &scanCursorTable{cursors: make(map[uint64]string)}
+
+// 保存下一次开始的位置, 返回新的游标
+func (t *scanCursorTable) save(position string) string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.next++
+	t.cursors[t.next] = position
+	t.order = append(t.order, t.next)
+	if len(t.order) > scanCursorsMax {
+		delete(t.cursors, t.order[0])
+		t.order = t.order[1:]
+	}
+	return strconv.FormatUint(t.next, 10)
+}
+
+// 游标为0时从头开始, started为false
+func (t *scanCursorTable) load(cursor string) (position string, started bool, ok bool) {
+	id, err := strconv.ParseUint(cursor, 10, 64)
+	if err != nil {
+		return "", false, false
+	}
+	if id == 0 {
+		return "", false, true
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	position, ok = t.cursors[id]
+	return position, true, ok
+}
+
+type scanOptions struct {
+	position string
+	started  bool
+	count    int
+	match    string
+	typeName string
+}
+
+// cursor [MATCH pattern] [COUNT count] [TYPE type], 只有SCAN支持TYPE
+func parseScanArgs(args []string, allowType bool) (*scanOptions, string) {
+	position, started, ok := scanCursors.load(args[0])
+	if !ok {
+		return nil, "ERR invalid cursor"
+	}
+	opts := &scanOptions{position: position, started: started, count: scanDefaultCount, match: "*"}
+	for i := 1; i < len(args); i += 2 {
+		if i+1 >= len(args) {
+			return nil, "ERR syntax error"
+		}
+		switch strings.ToLower(args[i]) {
+		case "match":
+			opts.match = args[i+1]
+		case "count":
+			count, err := strconv.Atoi(args[i+1])
+			if err != nil {
+				return nil, "ERR value is not an integer or out of range"
+			}
+			if count < 1 {
+				return nil, "ERR syntax error"
+			}
+			opts.count = count
+		case "type":
+			if !allowType {
+				return nil, "ERR syntax error"
+			}
+			opts.typeName = strings.ToLower(args[i+1])
+		default:
+			return nil, "ERR syntax error"
+		}
+	}
+	return opts, ""
+}
+
+func (opts *scanOptions) matches(s string) bool {
+	return opts.match == "*" || globMatch(opts.match, s)
+}
+
+// 检查完一批之后的游标, 没有更多元素时为0
+func (opts *scanOptions) nextCursor(last string, more bool) string {
+	if !more {
+		return "0"
+	}
+	return scanCursors.save(last)
+}
+
+// SCAN cursor [MATCH pattern] [COUNT count] [TYPE type]
+func Scan(db *SaveDBTables, args []string) Reply {
+	if len(args) == 0 {
+		return errReply("ERR wrong number of arguments for 'scan' command")
+	}
+	opts, msg := parseScanArgs(args, true)
+	if opts == nil {
+		return errReply(msg)
+	}
+	var keys []string
+	var last string
+	checked := 0
+	more := false
+	//btree自带读写锁, 每次只在检查COUNT个key的期间持有读锁
+	db.AllKeys.keys.Ascend(&keyItem{key: []byte(opts.position)}, func(item *keyItem) bool {
+		key := string(item.key)
+		if opts.started && key == opts.position {
+			return true
+		}
+		if checked == opts.count {
+			more = true
+			return false
+		}
+		checked++
+		last = key
+		if opts.typeName != "" && typeName(item.saveObj.dataType) != opts.typeName {
+			return true
+		}
+		if opts.matches(key) {
+			keys = append(keys, key)
+		}
+		return true
+	})
+	return scanReply(opts.nextCursor(last, more), keys)
+}
+
+// 成员的最大堆, 用来选出比游标大的最小的COUNT个成员
+type memberHeap []string
+
+func (h memberHeap) Len() int            { return len(h) }
+func (h memberHeap) Less(i, j int) bool  { return h[i] > h[j] }
+func (h memberHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *memberHeap) Push(x interface{}) { *h = append(*h, x.(string)) }
+func (h *memberHeap) Pop() interface{} {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
+
+// forEach遍历集合中所有的成员, 返回这一批按字典序排好的成员和之后是否还有成员
+func scanMembers(opts *scanOptions, forEach func(visit func(member string))) ([]string, bool) {
+	h := make(memberHeap, 0, opts.count)
+	more := false
+	forEach(func(member string) {
+		if opts.started && member <= opts.position {
+			return
+		}
+		if h.Len() < opts.count {
+			heap.Push(&h, member)
+			return
+		}
+		more = true
+		if member < h[0] {
+			h[0] = member
+			heap.Fix(&h, 0)
+		}
+	})
+	members := []string(h)
+	sort.Strings(members)
+	return members, more
+}
+
+func scanCollectionResult(opts *scanOptions, members []string, more bool, value func(member string) string) Reply {
+	last := ""
+	if len(members) > 0 {
+		last = members[len(members)-1]
+	}
+	var result []string
+	for _, member := range members {
+		if !opts.matches(member) {
+			continue
+		}
+		result = append(result, member)
+		if value != nil {
+			result = append(result, value(member))
+		}
+	}
+	return scanReply(opts.nextCursor(last, more), result)
+}
+
+// 两个元素的数组: 下一次的游标和这一批的元素
+func scanReply(cursor string, elements []string) Reply {
+	return MakeMultiRawReply([]Reply{MakeBulkReply([]byte(cursor)), MakeStringsReply(elements)})
+}
+
+// HSCAN key cursor [MATCH pattern] [COUNT count]
+func HScan(db *SaveDBTables, args []string) Reply {
+	if len(args) < 2 {
+		return errReply("ERR wrong number of arguments for 'hscan' command")
+	}
+	opts, msg := parseScanArgs(args[1:], false)
+	if opts == nil {
+		return errReply(msg)
+	}
+	hash, err := db.GetHash(args[0])
+	if err != nil {
+		return errReply(err.Error())
+	}
+	if hash == nil {
+		return scanReply("0", nil)
+	}
+	members, more := scanMembers(opts, func(visit func(member string)) {
+		for field := range hash.M {
+			visit(field)
+		}
+	})
+	return scanCollectionResult(opts, members, more, func(member string) string {
+		return *hash.M[member]
+	})
+}
+
+// SSCAN key cursor [MATCH pattern] [COUNT count]
+func SScan(db *SaveDBTables, args []string) Reply {
+	if len(args) < 2 {
+		return errReply("ERR wrong number of arguments for 'sscan' command")
+	}
+	opts, msg := parseScanArgs(args[1:], false)
+	if opts == nil {
+		return errReply(msg)
+	}
+	set, err := db.GetSet(args[0])
+	if err != nil {
+		return errReply(err.Error())
+	}
+	if set == nil {
+		return scanReply("0", nil)
+	}
+	members, more := scanMembers(opts, func(visit func(member string)) {
+		for member := range set.M {
+			visit(member)
+		}
+	})
+	return scanCollectionResult(opts, members, more, nil)
+}
+
+// ZSCAN key cursor [MATCH pattern] [COUNT count]
+func ZScan(db *SaveDBTables, args []string) Reply {
+	if len(args) < 2 {
+		return errReply("ERR wrong number of arguments for 'zscan' command")
+	}
+	opts, msg := parseScanArgs(args[1:], false)
+	if opts == nil {
+		return errReply(msg)
+	}
+	sortedSet, err := db.GetZSet(args[0])
+	if err != nil {
+		return errReply(err.Error())
+	}
+	if sortedSet == nil || sortedSet.Z.Len() == 0 {
+		return scanReply("0", nil)
+	}
+	members, more := scanMembers(opts, func(visit func(member string)) {
+		sortedSet.Z.ForEachByRank(0, sortedSet.Z.Len(), false, func(element *data.Element) bool {
+			visit(element.Member)
+			return true
+		})
+	})
+	return scanCollectionResult(opts, members, more, func(member string) string {
+		element, _ := sortedSet.Z.Get(member)
+		return strconv.FormatFloat(element.Score, 'f', -1, 64)
+	})
+}
@@ -0,0 +1,97 @@
+package src
+
+import (
+	"bufio"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// 解析[cursor, [element...]]形式的结果
+func execScan(t *testing.T, s *SaveServer, c *Connection, args ...string) (string, []string) {
+	r := execTestCmd(s, c, args...)
+	reply, err := ReadReply(bufio.NewReader(strings.NewReader(r)))
+	arr, ok := reply.(*MultiRawReply)
+	if err != nil || !ok || len(arr.Replies) != 2 {
+		t.Fatalf("%v: unexpected result %q", args, r)
+	}
+	var elements []string
+	for _, e := range arr.Replies[1].(*MultiRawReply).Replies {
+		elements = append(elements, string(e.(*BulkReply).Arg))
+	}
+	return string(arr.Replies[0].(*BulkReply).Arg), elements
+}
+
+func TestScan(t *testing.T) {
+	initTestLog(t)
+	s, c := makeMultiTestServer()
+	for i := 0; i < 30; i++ {
+		execTestCmd(s, c, "set", "key:"+strconv.Itoa(i), "v")
+	}
+	execTestCmd(s, c, "rpush", "list", "a")
+	seen := make(map[string]int)
+	cursor := "0"
+	for round := 0; ; round++ {
+		var keys []string
+		cursor, keys = execScan(t, s, c, "scan", cursor, "count", "7", "type", "string")
+		for _, key := range keys {
+			seen[key]++
+		}
+		if round == 1 {
+			//遍历期间删除和新增的key不影响一直存在的key
+			execTestCmd(s, c, "del", "key:5")
+			execTestCmd(s, c, "set", "key:0a", "v")
+		}
+		if cursor == "0" {
+			break
+		}
+	}
+	for i := 0; i < 30; i++ {
+		key := "key:" + strconv.Itoa(i)
+		if key != "key:5" && seen[key] != 1 {
+			t.Errorf("%s returned %d times", key, seen[key])
+		}
+	}
+	if seen["list"] != 0 {
+		t.Error("TYPE should filter out list")
+	}
+	if _, keys := execScan(t, s, c, "scan", "0", "match", "key:1*", "count", "100"); len(keys) != 11 {
+		t.Errorf("scan match expect 11 keys, actual %v", keys)
+	}
+	if _, keys := execScan(t, s, c, "scan", "0", "match", "", "count", "100"); len(keys) != 0 {
+		t.Errorf("empty match should only match the empty key, actual %v", keys)
+	}
+	execTestCmd(s, c, "set", "", "v")
+	execTestCmd(s, c, "set", "key:[1", "v")
+	if _, keys := execScan(t, s, c, "scan", "0", "match", "", "count", "100"); len(keys) != 1 || keys[0] != "" {
+		t.Errorf("empty match should return the empty key, actual %v", keys)
+	}
+	if _, keys := execScan(t, s, c, "scan", "0", "match", "key:[1", "count", "100"); len(keys) != 1 || keys[0] != "key:[1" {
+		t.Errorf("unclosed [ should match literally, actual %v", keys)
+	}
+	if r := execTestCmd(s, c, "scan", "12345678"); r != "-ERR invalid cursor\r\n" {
+		t.Errorf("expect invalid cursor, actual %q", r)
+	}
+}
+
+func TestCollectionScan(t *testing.T) {
+	initTestLog(t)
+	s, c := makeMultiTestServer()
+	execTestCmd(s, c, "hmset", "h", "f3", "3", "f1", "1", "f2", "2")
+	cursor, elements := execScan(t, s, c, "hscan", "h", "0", "count", "2")
+	if strings.Join(elements, ",") != "f1,1,f2,2" || cursor == "0" {
+		t.Errorf("hscan first batch error, cursor %s elements %v", cursor, elements)
+	}
+	cursor, elements = execScan(t, s, c, "hscan", "h", cursor, "count", "2")
+	if strings.Join(elements, ",") != "f3,3" || cursor != "0" {
+		t.Errorf("hscan second batch error, cursor %s elements %v", cursor, elements)
+	}
+	execTestCmd(s, c, "sadd", "s", "b", "a", "c")
+	if _, elements = execScan(t, s, c, "sscan", "s", "0", "match", "a*"); strings.Join(elements, ",") != "a" {
+		t.Errorf("sscan error, actual %v", elements)
+	}
+	execTestCmd(s, c, "zadd", "z", "2", "a", "1", "b")
+	if _, elements = execScan(t, s, c, "zscan", "z", "0"); strings.Join(elements, ",") != "a,2,b,1" {
+		t.Errorf("zscan error, actual %v", elements)
+	}
+}
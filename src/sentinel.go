@@ -1,16 +1,688 @@
 package src
 
 import (
+	"fmt"
 	"github.com/robfig/cron/v3"
+	"net"
 	"savedb/src/log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 )
 
+// 哨兵
+// 1.每秒PING监控的主节点、从节点和其他哨兵, 超过down-after-milliseconds没有有效回复时标记为主观下线(sdown)
+// 2.定期INFO主节点发现从节点, 通过主从节点上的__sentinel__:hello频道发现其他哨兵并同步主节点的配置
+// 3.主节点主观下线后询问其他哨兵, 达到quorum个哨兵认为下线时标记为客观下线(odown), 然后选举leader执行故障转移
+// 4.拓扑变化通过哨兵自己的发布订阅通知客户端, 频道名就是事件名, 比如+sdown +odown +switch-master
+
+const (
+	sentinelHelloChannel = "__sentinel__:hello"
+	//cron每秒执行10次, 网络请求按各自的周期执行
+	sentinelCronPeriod     = 100 * time.Millisecond
+	sentinelPingPeriod     = time.Second
+	sentinelInfoPeriod     = 10 * time.Second
+	sentinelHelloPeriod    = 2 * time.Second
+	sentinelRequestTimeout = time.Second
+	//实例报告的角色和配置不一致的时间超过这个值才修正, 等待其他哨兵的新配置通过hello传过来
+	sentinelReconfDelay    = 4 * sentinelHelloPeriod
+	defaultQuorum          = 2
+	defaultDownAfter       = 30 * time.Second
+	defaultFailoverTimeout = 3 * time.Minute
+)
+
+// Sentinel 以哨兵模式启动时不为nil
+var Sentinel *SentinelServer
+
 type SentinelConfig struct {
-	Port int            `yaml:"port"`
-	Logs *log.LogConfig `yaml:"logs"`
+	Port int `yaml:"port"`
+	//哨兵使用的协议, 默认resp
+	Protocol string `yaml:"protocol"`
+	//其他哨兵连接这个哨兵使用的ip, 默认使用连接主节点时的本地地址
+	AnnounceIp string                 `yaml:"announce-ip"`
+	Masters    []SentinelMasterConfig `yaml:"masters"`
+	Logs       *log.LogConfig         `yaml:"logs"`
+}
+
+// SentinelMasterConfig 监控的主节点, 地址是主节点resp端口的地址
+type SentinelMasterConfig struct {
+	Name                  string `yaml:"name"`
+	Host                  string `yaml:"host"`
+	Port                  int    `yaml:"port"`
+	Quorum                int    `yaml:"quorum"`
+	DownAfterMilliseconds int    `yaml:"down-after-milliseconds"`
+	FailoverTimeout       int    `yaml:"failover-timeout"`
+}
+
+type SentinelServer struct {
+	mu           sync.Mutex
+	runId        string
+	currentEpoch int64
+	announceIp   string
+	port         int
+	masters      map[string]*sentinelMaster
+}
+
+// 主节点、从节点或者其他哨兵
+type sentinelInstance struct {
+	host     string
+	port     int
+	runId    string
+	client   *peerClient
+	lastPing time.Time
+	//最后一次收到有效回复的时间
+	lastPong  time.Time
+	lastInfo  time.Time
+	lastHello time.Time
+	sdown     bool
+	//INFO中的角色和复制状态
+	role         string
+	masterHost   string
+	masterPort   int
+	masterLinkUp bool
+	replOffset   int64
+	roleChanged  time.Time
+	//已经订阅了这个实例的hello频道
+	subscribed bool
+	//其他哨兵对主节点是否下线的判断和投票
+	masterDown     bool
+	masterDownTime time.Time
+	leader         string
+	leaderEpoch    int64
+}
+
+// cron中一次要向实例发送的请求
+type sentinelTask struct {
+	inst  *sentinelInstance
+	info  bool
+	hello bool
+}
+
+type sentinelMaster struct {
+	*sentinelInstance
+	name            string
+	quorum          int
+	downAfter       time.Duration
+	failoverTimeout time.Duration
+	configEpoch     int64
+	odown           bool
+	odownSince      time.Time
+	//key是host:port
+	replicas map[string]*sentinelInstance
+	//key是runid
+	sentinels map[string]*sentinelInstance
+	//本哨兵在voteEpoch中投票给了votedFor
+	votedFor     string
+	voteEpoch    int64
+	lastAsk      time.Time
+	failover     *failoverState
+	lastFailover time.Time
+}
+
+func newSentinelInstance(host string, port int) *sentinelInstance {
+	now := time.Now()
+	return &sentinelInstance{
+		host:        host,
+		port:        port,
+		client:      &peerClient{addr: net.JoinHostPort(host, strconv.Itoa(port)), timeout: sentinelRequestTimeout},
+		lastPong:    now,
+		roleChanged: now,
+	}
+}
+
+func (inst *sentinelInstance) addr() string {
+	return net.JoinHostPort(inst.host, strconv.Itoa(inst.port))
 }
 
 func InitSentinelServer() {
+	if SConfig.Protocol == "" {
+		SConfig.Protocol = ProtocolNameResp
+	}
+	Sentinel = MakeSentinelServer(SConfig)
 	CronManager = cron.New(cron.WithSeconds())
 	CronManager.Start()
+	go Sentinel.cron()
+}
+
+func MakeSentinelServer(config *SentinelConfig) *SentinelServer {
+	s := &SentinelServer{
+		runId:      newReplId(),
+		announceIp: config.AnnounceIp,
+		port:       config.Port,
+		masters:    make(map[string]*sentinelMaster),
+	}
+	for _, mc := range config.Masters {
+		m := &sentinelMaster{
+			sentinelInstance: newSentinelInstance(mc.Host, mc.Port),
+			name:             mc.Name,
+			quorum:           mc.Quorum,
+			downAfter:        time.Duration(mc.DownAfterMilliseconds) * time.Millisecond,
+			failoverTimeout:  time.Duration(mc.FailoverTimeout) * time.Millisecond,
+			replicas:         make(map[string]*sentinelInstance),
+			sentinels:        make(map[string]*sentinelInstance),
+		}
+		if m.quorum <= 0 {
+			m.quorum = defaultQuorum
+		}
+		if m.downAfter <= 0 {
+			m.downAfter = defaultDownAfter
+		}
+		if m.failoverTimeout <= 0 {
+			m.failoverTimeout = defaultFailoverTimeout
+		}
+		s.masters[m.name] = m
+		log.SaveDBLogger.Infof("sentinel monitor master %s %s quorum %d", m.name, m.addr(), m.quorum)
+	}
+	return s
+}
+
+func (s *SentinelServer) cron() {
+	ticker := time.NewTicker(sentinelCronPeriod)
+	defer ticker.Stop()
+	for range ticker.C {
+		s.mu.Lock()
+		masters := make([]*sentinelMaster, 0, len(s.masters))
+		for _, m := range s.masters {
+			masters = append(masters, m)
+		}
+		s.mu.Unlock()
+		for _, m := range masters {
+			s.checkMaster(m)
+		}
+	}
+}
+
+// 所有网络请求都在cron协程中执行, 不持有锁, 结果在锁中更新
+func (s *SentinelServer) checkMaster(m *sentinelMaster) {
+	s.mu.Lock()
+	master := m.sentinelInstance
+	dataNodes := []*sentinelInstance{master}
+	for _, replica := range m.replicas {
+		dataNodes = append(dataNodes, replica)
+	}
+	peers := make([]*sentinelInstance, 0, len(m.sentinels))
+	for _, peer := range m.sentinels {
+		peers = append(peers, peer)
+	}
+	//主节点下线或者故障转移期间每秒INFO一次
+	infoPeriod := sentinelInfoPeriod
+	if m.sdown || m.failover != nil {
+		infoPeriod = sentinelPingPeriod
+	}
+	s.ensureHelloSubscribers(dataNodes)
+	//INFO和hello跟着PING一起发送, 实例下线时每秒只尝试一次. 时间在锁中判断和更新, 请求在锁外执行
+	now := time.Now()
+	var tasks []sentinelTask
+	for i, inst := range append(dataNodes, peers...) {
+		if now.Sub(inst.lastPing) < sentinelPingPeriod {
+			continue
+		}
+		inst.lastPing = now
+		task := sentinelTask{inst: inst}
+		if i < len(dataNodes) {
+			task.info = now.Sub(inst.lastInfo) >= infoPeriod
+			task.hello = now.Sub(inst.lastHello) >= sentinelHelloPeriod
+		}
+		tasks = append(tasks, task)
+	}
+	s.mu.Unlock()
+
+	for _, task := range tasks {
+		s.ping(task.inst)
+		if task.info {
+			s.refreshInfo(m, task.inst)
+		}
+		if task.hello {
+			s.sendHello(m, task.inst)
+		}
+	}
+	s.checkSubjectivelyDown(m, dataNodes)
+	s.mu.Lock()
+	//选举期间每次都询问, 其他时候每秒一次
+	ask := (m.sdown && now.Sub(m.lastAsk) >= sentinelPingPeriod) || (m.failover != nil && m.failover.state == failoverWaitStart)
+	if ask {
+		m.lastAsk = now
+	}
+	s.mu.Unlock()
+	if ask {
+		s.askMasterState(m, peers)
+	}
+	s.checkObjectivelyDown(m)
+	s.failoverStep(m)
+	s.fixReplicaConfig(m, dataNodes)
+}
+
+func (s *SentinelServer) ping(inst *sentinelInstance) {
+	reply, err := inst.client.Do("PING")
+	if err != nil || IsErrorReply(reply) {
+		return
+	}
+	s.mu.Lock()
+	inst.lastPong = time.Now()
+	s.mu.Unlock()
+}
+
+func (s *SentinelServer) checkSubjectivelyDown(m *sentinelMaster, dataNodes []*sentinelInstance) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	for _, inst := range dataNodes {
+		down := now.Sub(inst.lastPong) > m.downAfter
+		if down == inst.sdown {
+			continue
+		}
+		inst.sdown = down
+		if down {
+			s.event("+sdown", m, inst, "")
+		} else {
+			s.event("-sdown", m, inst, "")
+		}
+	}
+}
+
+func (s *SentinelServer) refreshInfo(m *sentinelMaster, inst *sentinelInstance) {
+	reply, err := inst.client.Do("INFO", "replication")
+	if err != nil {
+		return
+	}
+	bulk, ok := reply.(*BulkReply)
+	if !ok {
+		return
+	}
+	fields, slaves := parseInfo(string(bulk.Arg))
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	inst.lastInfo = time.Now()
+	masterHost := fields["master_host"]
+	masterPort, _ := strconv.Atoi(fields["master_port"])
+	if fields["role"] != inst.role || masterHost != inst.masterHost || masterPort != inst.masterPort {
+		inst.roleChanged = time.Now()
+	}
+	inst.role = fields["role"]
+	inst.masterHost, inst.masterPort = masterHost, masterPort
+	inst.masterLinkUp = fields["master_link_status"] == "up"
+	inst.replOffset, _ = strconv.ParseInt(fields["slave_repl_offset"], 10, 64)
+	//只从当前的主节点发现从节点
+	if inst != m.sentinelInstance || inst.role != "master" {
+		return
+	}
+	for _, slave := range slaves {
+		port, err := strconv.Atoi(slave["port"])
+		if err != nil || slave["ip"] == "" {
+			continue
+		}
+		addr := net.JoinHostPort(slave["ip"], slave["port"])
+		if _, ok := m.replicas[addr]; ok || addr == m.addr() {
+			continue
+		}
+		replica := newSentinelInstance(slave["ip"], port)
+		m.replicas[addr] = replica
+		s.event("+slave", m, replica, "")
+	}
+}
+
+// 解析INFO的回复, 返回所有字段和slaveN的内容
+func parseInfo(info string) (map[string]string, []map[string]string) {
+	fields := make(map[string]string)
+	var slaves []map[string]string
+	for _, line := range strings.Split(info, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		fields[key] = value
+		if strings.HasPrefix(key, "slave") && strings.Contains(value, "ip=") {
+			slave := make(map[string]string)
+			for _, kv := range strings.Split(value, ",") {
+				if k, v, ok := strings.Cut(kv, "="); ok {
+					slave[k] = v
+				}
+			}
+			slaves = append(slaves, slave)
+		}
+	}
+	return fields, slaves
+}
+
+// hello消息: ip,port,runid,current_epoch,master_name,master_ip,master_port,master_config_epoch
+func (s *SentinelServer) sendHello(m *sentinelMaster, inst *sentinelInstance) {
+	s.mu.Lock()
+	ip := s.announceIp
+	if ip == "" && inst.client.conn != nil {
+		ip, _, _ = net.SplitHostPort(inst.client.conn.LocalAddr().String())
+	}
+	hello := fmt.Sprintf("%s,%d,%s,%d,%s,%s,%d,%d", ip, s.port, s.runId, s.currentEpoch,
+		m.name, m.host, m.port, m.configEpoch)
+	s.mu.Unlock()
+	if ip == "" {
+		return
+	}
+	reply, err := inst.client.Do("PUBLISH", sentinelHelloChannel, hello)
+	if err != nil || IsErrorReply(reply) {
+		return
+	}
+	s.mu.Lock()
+	inst.lastHello = time.Now()
+	s.mu.Unlock()
+}
+
+// 调用方持有锁
+func (s *SentinelServer) ensureHelloSubscribers(dataNodes []*sentinelInstance) {
+	for _, inst := range dataNodes {
+		if !inst.subscribed {
+			inst.subscribed = true
+			go s.subscribeHello(inst.addr())
+		}
+	}
+}
+
+// 断开后每秒重连一次, 实例不会从表中删除, 订阅也不会停止
+func (s *SentinelServer) subscribeHello(addr string) {
+	for {
+		conn, err := net.DialTimeout("tcp", addr, sentinelRequestTimeout)
+		if err == nil {
+			s.readHello(conn)
+		}
+		time.Sleep(sentinelPingPeriod)
+	}
+}
+
+func (s *SentinelServer) readHello(conn net.Conn) {
+	defer func() {
+		_ = conn.Close()
+	}()
+	if _, err := conn.Write(MakeMultiBulkReply(ToCmdLine("SUBSCRIBE", sentinelHelloChannel)).ToBytes()); err != nil {
+		return
+	}
+	ch := ParseStream(conn)
+	defer func() {
+		go func() {
+			for range ch {
+			}
+		}()
+	}()
+	for p := range ch {
+		if p.Err != nil {
+			//订阅的回复中有整数, 解析器只支持bulk string数组, 忽略这个错误
+			if strings.HasPrefix(p.Err.Error(), "protocol error") {
+				continue
+			}
+			return
+		}
+		msg, ok := p.Data.(*MultiBulkReply)
+		if ok && len(msg.Args) == 3 && string(msg.Args[0]) == "message" {
+			s.processHello(string(msg.Args[2]))
+		}
+	}
+}
+
+func (s *SentinelServer) processHello(hello string) {
+	parts := strings.Split(hello, ",")
+	if len(parts) != 8 {
+		return
+	}
+	port, err1 := strconv.Atoi(parts[1])
+	epoch, err2 := strconv.ParseInt(parts[3], 10, 64)
+	masterPort, err3 := strconv.Atoi(parts[6])
+	configEpoch, err4 := strconv.ParseInt(parts[7], 10, 64)
+	if err1 != nil || err2 != nil || err3 != nil || err4 != nil {
+		return
+	}
+	runId := parts[2]
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if runId == s.runId {
+		return
+	}
+	m, ok := s.masters[parts[4]]
+	if !ok {
+		return
+	}
+	if epoch > s.currentEpoch {
+		s.currentEpoch = epoch
+		s.event("+new-epoch", nil, nil, strconv.FormatInt(epoch, 10))
+	}
+	peer, ok := m.sentinels[runId]
+	if !ok {
+		//同一个地址的哨兵重启之后runid会变
+		for id, old := range m.sentinels {
+			if old.host == parts[0] && old.port == port {
+				old.client.Close()
+				delete(m.sentinels, id)
+			}
+		}
+		peer = newSentinelInstance(parts[0], port)
+		peer.runId = runId
+		m.sentinels[runId] = peer
+		s.event("+sentinel", m, peer, "")
+	}
+	peer.lastHello = time.Now()
+	//其他哨兵完成了故障转移
+	if configEpoch > m.configEpoch {
+		m.configEpoch = configEpoch
+		if parts[5] != m.host || masterPort != m.port {
+			s.switchMaster(m, parts[5], masterPort)
+		}
+	}
+}
+
+// 切换主节点, 原来的主节点作为从节点, 恢复之后会被修正为新主节点的从节点. 调用方持有锁
+func (s *SentinelServer) switchMaster(m *sentinelMaster, host string, port int) {
+	old := m.sentinelInstance
+	addr := net.JoinHostPort(host, strconv.Itoa(port))
+	promoted, ok := m.replicas[addr]
+	if ok {
+		delete(m.replicas, addr)
+	} else {
+		promoted = newSentinelInstance(host, port)
+	}
+	m.replicas[old.addr()] = old
+	//等从节点的INFO更新之后再判断配置是否正确
+	for _, replica := range m.replicas {
+		replica.roleChanged = time.Now()
+	}
+	m.sentinelInstance = promoted
+	promoted.sdown = false
+	promoted.lastPong = time.Now()
+	m.odown = false
+	m.failover = nil
+	s.event("+switch-master", nil, nil, fmt.Sprintf("%s %s %d %s %d", m.name, old.host, old.port, host, port))
+}
+
+// 实例报告的角色或者主节点和配置不一致时发送REPLICAOF修正, 比如恢复后的旧主节点
+func (s *SentinelServer) fixReplicaConfig(m *sentinelMaster, dataNodes []*sentinelInstance) {
+	s.mu.Lock()
+	if m.sdown || m.failover != nil || m.sentinelInstance != dataNodes[0] {
+		s.mu.Unlock()
+		return
+	}
+	host, port := m.host, m.port
+	var wrong []*sentinelInstance
+	for _, inst := range dataNodes[1:] {
+		if inst.sdown || inst.role == "" || time.Since(inst.roleChanged) < sentinelReconfDelay {
+			continue
+		}
+		if inst.role == "master" || inst.masterHost != host || inst.masterPort != port {
+			wrong = append(wrong, inst)
+		}
+	}
+	s.mu.Unlock()
+	for _, inst := range wrong {
+		reply, err := inst.client.Do("REPLICAOF", host, strconv.Itoa(port))
+		if err != nil || IsErrorReply(reply) {
+			continue
+		}
+		s.mu.Lock()
+		inst.role = ""
+		inst.roleChanged = time.Now()
+		s.event("+convert-to-slave", m, inst, "")
+		s.mu.Unlock()
+	}
+}
+
+// 在哨兵自己的发布订阅上发布事件, 格式和redis一致: <type> <name> <ip> <port> @ <master-name> <master-ip> <master-port>
+func (s *SentinelServer) event(kind string, m *sentinelMaster, inst *sentinelInstance, msg string) {
+	if inst != nil {
+		role := "slave"
+		name := inst.addr()
+		if m != nil && inst == m.sentinelInstance {
+			role, name = "master", m.name
+		} else if inst.runId != "" {
+			role, name = "sentinel", inst.runId
+		}
+		msg = fmt.Sprintf("%s %s %s %d", role, name, inst.host, inst.port)
+		if role != "master" {
+			msg += fmt.Sprintf(" @ %s %s %d", m.name, m.host, m.port)
+		}
+	}
+	log.SaveDBLogger.Infof("sentinel %s %s", kind, msg)
+	PubSub.Publish(kind, []byte(msg))
+}
+
+// SentinelCommand SENTINEL <subcommand> [args]
+func SentinelCommand(args []string) Reply {
+	s := Sentinel
+	if s == nil {
+		return MakeErrReply("ERR This instance has sentinel support disabled")
+	}
+	if len(args) == 0 {
+		return MakeErrReply("ERR wrong number of arguments for 'sentinel' command")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sub := strings.ToLower(args[0])
+	if sub == "myid" {
+		return MakeBulkReply([]byte(s.runId))
+	}
+	if sub == "masters" {
+		names := sortedNames(s.masters)
+		replies := make([]Reply, 0, len(names))
+		for _, name := range names {
+			replies = append(replies, s.masters[name].masterFields())
+		}
+		return MakeMultiRawReply(replies)
+	}
+	if sub == "is-master-down-by-addr" {
+		if len(args) != 5 {
+			return MakeErrReply("ERR wrong number of arguments for 'sentinel|is-master-down-by-addr' command")
+		}
+		return s.isMasterDownByAddr(args[1:])
+	}
+	if len(args) != 2 {
+		return MakeErrReply("ERR wrong number of arguments for 'sentinel|" + sub + "' command")
+	}
+	m, ok := s.masters[args[1]]
+	if !ok {
+		if sub == "get-master-addr-by-name" {
+			return MakeNullMultiBulkReply()
+		}
+		return MakeErrReply("ERR No such master with that name")
+	}
+	switch sub {
+	case "get-master-addr-by-name":
+		return MakeMultiBulkReply(ToCmdLine(m.host, strconv.Itoa(m.port)))
+	case "master":
+		return m.masterFields()
+	case "replicas", "slaves":
+		addrs := sortedNames(m.replicas)
+		replies := make([]Reply, 0, len(addrs))
+		for _, addr := range addrs {
+			replies = append(replies, m.replicaFields(m.replicas[addr]))
+		}
+		return MakeMultiRawReply(replies)
+	case "sentinels":
+		ids := sortedNames(m.sentinels)
+		replies := make([]Reply, 0, len(ids))
+		for _, id := range ids {
+			peer := m.sentinels[id]
+			replies = append(replies, fieldsReply(
+				"name", peer.runId, "ip", peer.host, "port", strconv.Itoa(peer.port), "runid", peer.runId,
+				"flags", peer.flags("sentinel"),
+				"last-hello-message", strconv.FormatInt(time.Since(peer.lastHello).Milliseconds(), 10)))
+		}
+		return MakeMultiRawReply(replies)
+	case "failover":
+		return s.forceFailover(m)
+	}
+	return MakeErrReply("ERR Unknown sentinel subcommand '" + args[0] + "'")
+}
+
+func (inst *sentinelInstance) flags(role string) string {
+	flags := role
+	if inst.sdown {
+		flags += ",s_down"
+	}
+	return flags
+}
+
+func (m *sentinelMaster) masterFields() Reply {
+	flags := m.flags("master")
+	if m.odown {
+		flags += ",o_down"
+	}
+	if m.failover != nil {
+		flags += ",failover_in_progress"
+	}
+	return fieldsReply(
+		"name", m.name, "ip", m.host, "port", strconv.Itoa(m.port), "flags", flags,
+		"last-ok-ping-reply", strconv.FormatInt(time.Since(m.lastPong).Milliseconds(), 10),
+		"num-slaves", strconv.Itoa(len(m.replicas)),
+		"num-other-sentinels", strconv.Itoa(len(m.sentinels)),
+		"quorum", strconv.Itoa(m.quorum),
+		"config-epoch", strconv.FormatInt(m.configEpoch, 10),
+		"down-after-milliseconds", strconv.FormatInt(m.downAfter.Milliseconds(), 10),
+		"failover-timeout", strconv.FormatInt(m.failoverTimeout.Milliseconds(), 10))
+}
+
+func (m *sentinelMaster) replicaFields(replica *sentinelInstance) Reply {
+	status := "err"
+	if replica.masterLinkUp {
+		status = "ok"
+	}
+	return fieldsReply(
+		"name", replica.addr(), "ip", replica.host, "port", strconv.Itoa(replica.port),
+		"flags", replica.flags("slave"),
+		"last-ok-ping-reply", strconv.FormatInt(time.Since(replica.lastPong).Milliseconds(), 10),
+		"master-link-status", status,
+		"master-host", replica.masterHost,
+		"master-port", strconv.Itoa(replica.masterPort),
+		"slave-repl-offset", strconv.FormatInt(replica.replOffset, 10))
+}
+
+func fieldsReply(fields ...string) Reply {
+	return MakeMultiBulkReply(ToCmdLine(fields...))
+}
+
+func (s *SentinelServer) info(sb *strings.Builder) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	names := sortedNames(s.masters)
+	sb.WriteString("sentinel_masters:" + strconv.Itoa(len(names)) + CRLF)
+	for i, name := range names {
+		m := s.masters[name]
+		status := "ok"
+		if m.odown {
+			status = "odown"
+		} else if m.sdown {
+			status = "sdown"
+		}
+		sb.WriteString(fmt.Sprintf("master%d:name=%s,status=%s,address=%s,slaves=%d,sentinels=%d\r\n",
+			i, name, status, m.addr(), len(m.replicas), len(m.sentinels)+1))
+	}
+}
+
+// 按offset从大到小, 地址从小到大排序选择从节点
+func sortReplicas(replicas []*sentinelInstance) {
+	sort.Slice(replicas, func(i, j int) bool {
+		if replicas[i].replOffset != replicas[j].replOffset {
+			return replicas[i].replOffset > replicas[j].replOffset
+		}
+		return replicas[i].addr() < replicas[j].addr()
+	})
 }
@@ -0,0 +1,292 @@
+package src
+
+import (
+	"math/rand"
+	"strconv"
+	"time"
+)
+
+// 故障转移
+// 1.主节点客观下线后epoch加1并请求其他哨兵投票, 每个哨兵在一个epoch中只投给第一个请求的哨兵
+// 2.得到多数并且不少于quorum的票数时成为leader, 选择offset最大的从节点发送REPLICAOF NO ONE
+// 3.新主节点的INFO显示role:master之后, 让其他从节点复制新主节点, 更新配置的epoch并切换主节点
+// 4.其他哨兵通过hello中更大的配置epoch得知新的主节点
+
+const (
+	failoverWaitStart = iota + 1
+	failoverSelectReplica
+	failoverWaitPromotion
+)
+
+// 选举最多等待的时间
+const sentinelElectionTimeout = 10 * time.Second
+
+type failoverState struct {
+	state    int
+	epoch    int64
+	start    time.Time
+	promoted *sentinelInstance
+}
+
+// 询问其他哨兵主节点是否下线, 故障转移开始时同时请求投票
+func (s *SentinelServer) askMasterState(m *sentinelMaster, peers []*sentinelInstance) {
+	s.mu.Lock()
+	host, port := m.host, strconv.Itoa(m.port)
+	runId, epoch := "*", s.currentEpoch
+	if m.failover != nil && m.failover.state == failoverWaitStart {
+		runId, epoch = s.runId, m.failover.epoch
+	}
+	s.mu.Unlock()
+	for _, peer := range peers {
+		reply, err := peer.client.Do("SENTINEL", "is-master-down-by-addr", host, port, strconv.FormatInt(epoch, 10), runId)
+		if err != nil {
+			continue
+		}
+		r, ok := reply.(*MultiBulkReply)
+		if !ok || len(r.Args) != 3 {
+			continue
+		}
+		leaderEpoch, _ := strconv.ParseInt(string(r.Args[2]), 10, 64)
+		s.mu.Lock()
+		peer.masterDown = string(r.Args[0]) == "1"
+		peer.masterDownTime = time.Now()
+		if leader := string(r.Args[1]); leader != "*" {
+			peer.leader, peer.leaderEpoch = leader, leaderEpoch
+		}
+		s.mu.Unlock()
+	}
+}
+
+// 回复 [是否下线, 投票给的leader, leader的epoch], 都使用bulk string方便ParseStream解析
+func (s *SentinelServer) isMasterDownByAddr(args []string) Reply {
+	port, err := strconv.Atoi(args[1])
+	if err != nil {
+		return MakeErrReply("ERR value is not an integer or out of range")
+	}
+	epoch, err := strconv.ParseInt(args[2], 10, 64)
+	if err != nil {
+		return MakeErrReply("ERR value is not an integer or out of range")
+	}
+	var m *sentinelMaster
+	for _, master := range s.masters {
+		if master.host == args[0] && master.port == port {
+			m = master
+		}
+	}
+	down, leader, leaderEpoch := "0", "*", int64(0)
+	if m == nil {
+		return MakeMultiBulkReply(ToCmdLine(down, leader, "0"))
+	}
+	if m.sdown {
+		down = "1"
+	}
+	if runId := args[3]; runId != "*" {
+		if epoch > s.currentEpoch {
+			s.currentEpoch = epoch
+			s.event("+new-epoch", nil, nil, strconv.FormatInt(epoch, 10))
+		}
+		//每个epoch只投一票
+		if m.voteEpoch < epoch {
+			m.votedFor, m.voteEpoch = runId, epoch
+			s.event("+vote-for-leader", nil, nil, runId+" "+strconv.FormatInt(epoch, 10))
+			//投给了其他哨兵, 推迟自己发起故障转移
+			if runId != s.runId {
+				m.lastFailover = time.Now()
+			}
+		}
+		leader, leaderEpoch = m.votedFor, m.voteEpoch
+	}
+	return MakeMultiBulkReply(ToCmdLine(down, leader, strconv.FormatInt(leaderEpoch, 10)))
+}
+
+func (s *SentinelServer) checkObjectivelyDown(m *sentinelMaster) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	votes := 0
+	if m.sdown {
+		votes = 1
+		for _, peer := range m.sentinels {
+			if peer.masterDown && time.Since(peer.masterDownTime) < 5*sentinelPingPeriod {
+				votes++
+			}
+		}
+	}
+	odown := votes >= m.quorum
+	if odown == m.odown {
+		return
+	}
+	m.odown = odown
+	if odown {
+		//随机等待一段时间, 避免多个哨兵同时发起选举
+		m.odownSince = time.Now().Add(time.Duration(rand.Intn(1000)) * time.Millisecond)
+		s.event("+odown", m, m.sentinelInstance, "")
+	} else {
+		s.event("-odown", m, m.sentinelInstance, "")
+	}
+}
+
+func (s *SentinelServer) failoverStep(m *sentinelMaster) {
+	s.mu.Lock()
+	if m.failover == nil {
+		if m.odown && time.Now().After(m.odownSince) && time.Since(m.lastFailover) > 2*m.failoverTimeout {
+			s.startFailover(m, failoverWaitStart)
+		}
+		s.mu.Unlock()
+		return
+	}
+	f := m.failover
+	switch f.state {
+	case failoverWaitStart:
+		s.checkElection(m, f)
+		s.mu.Unlock()
+	case failoverSelectReplica:
+		s.mu.Unlock()
+		s.promoteReplica(m, f)
+	case failoverWaitPromotion:
+		s.mu.Unlock()
+		s.reconfigureReplicas(m, f)
+	default:
+		s.mu.Unlock()
+	}
+}
+
+// 调用方持有锁
+func (s *SentinelServer) startFailover(m *sentinelMaster, state int) {
+	s.currentEpoch++
+	m.failover = &failoverState{state: state, epoch: s.currentEpoch, start: time.Now()}
+	m.lastFailover = time.Now()
+	//先投给自己
+	if m.voteEpoch < s.currentEpoch {
+		m.votedFor, m.voteEpoch = s.runId, s.currentEpoch
+	}
+	s.event("+new-epoch", nil, nil, strconv.FormatInt(s.currentEpoch, 10))
+	s.event("+try-failover", m, m.sentinelInstance, "")
+}
+
+// 调用方持有锁
+func (s *SentinelServer) checkElection(m *sentinelMaster, f *failoverState) {
+	votes := 0
+	if m.votedFor == s.runId && m.voteEpoch == f.epoch {
+		votes++
+	}
+	for _, peer := range m.sentinels {
+		if peer.leader == s.runId && peer.leaderEpoch == f.epoch {
+			votes++
+		}
+	}
+	need := (len(m.sentinels)+1)/2 + 1
+	if need < m.quorum {
+		need = m.quorum
+	}
+	if votes >= need {
+		f.state = failoverSelectReplica
+		s.event("+elected-leader", m, m.sentinelInstance, "")
+		return
+	}
+	timeout := sentinelElectionTimeout
+	if m.failoverTimeout < timeout {
+		timeout = m.failoverTimeout
+	}
+	if time.Since(f.start) > timeout {
+		m.failover = nil
+		s.event("-failover-abort-not-elected", m, m.sentinelInstance, "")
+	}
+}
+
+func (s *SentinelServer) selectReplica(m *sentinelMaster) *sentinelInstance {
+	candidates := make([]*sentinelInstance, 0, len(m.replicas))
+	for _, replica := range m.replicas {
+		if replica.sdown || replica.role != "slave" ||
+			time.Since(replica.lastPong) > 5*sentinelPingPeriod ||
+			time.Since(replica.lastInfo) > 3*sentinelInfoPeriod {
+			continue
+		}
+		candidates = append(candidates, replica)
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+	sortReplicas(candidates)
+	return candidates[0]
+}
+
+func (s *SentinelServer) promoteReplica(m *sentinelMaster, f *failoverState) {
+	s.mu.Lock()
+	replica := s.selectReplica(m)
+	if replica == nil {
+		m.failover = nil
+		s.event("-failover-abort-no-good-slave", m, m.sentinelInstance, "")
+		s.mu.Unlock()
+		return
+	}
+	s.event("+selected-slave", m, replica, "")
+	s.mu.Unlock()
+	reply, err := replica.client.Do("REPLICAOF", "NO", "ONE")
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if m.failover != f {
+		return
+	}
+	if err != nil || IsErrorReply(reply) {
+		m.failover = nil
+		s.event("-failover-abort-slave-timeout", m, replica, "")
+		return
+	}
+	f.promoted = replica
+	f.state = failoverWaitPromotion
+	s.event("+failover-state-wait-promotion", m, replica, "")
+}
+
+// 新主节点已经是master之后让其他从节点复制它, 然后切换主节点
+func (s *SentinelServer) reconfigureReplicas(m *sentinelMaster, f *failoverState) {
+	s.mu.Lock()
+	promoted := f.promoted
+	if promoted.role != "master" {
+		if time.Since(f.start) > m.failoverTimeout {
+			m.failover = nil
+			s.event("-failover-abort-timeout", m, promoted, "")
+		}
+		s.mu.Unlock()
+		return
+	}
+	s.event("+promoted-slave", m, promoted, "")
+	others := make([]*sentinelInstance, 0, len(m.replicas))
+	for _, replica := range m.replicas {
+		if replica != promoted {
+			others = append(others, replica)
+		}
+	}
+	s.mu.Unlock()
+	host, port := promoted.host, strconv.Itoa(promoted.port)
+	for _, replica := range others {
+		//下线的从节点恢复之后由fixReplicaConfig修正
+		reply, err := replica.client.Do("REPLICAOF", host, port)
+		if err == nil && !IsErrorReply(reply) {
+			s.mu.Lock()
+			replica.role = ""
+			replica.roleChanged = time.Now()
+			s.event("+slave-reconf-sent", m, replica, "")
+			s.mu.Unlock()
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if m.failover != f {
+		return
+	}
+	m.configEpoch = f.epoch
+	s.event("+failover-end", m, m.sentinelInstance, "")
+	s.switchMaster(m, promoted.host, promoted.port)
+}
+
+// SENTINEL FAILOVER 不需要其他哨兵同意, 直接开始故障转移. 调用方持有锁
+func (s *SentinelServer) forceFailover(m *sentinelMaster) Reply {
+	if m.failover != nil {
+		return MakeErrReply("INPROG Failover already in progress")
+	}
+	if s.selectReplica(m) == nil {
+		return MakeErrReply("NOGOODSLAVE No suitable replica to promote")
+	}
+	s.startFailover(m, failoverSelectReplica)
+	return MakeStatusReply(OkStr)
+}
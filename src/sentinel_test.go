@@ -0,0 +1,158 @@
+package src
+
+import (
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestParseInfo(t *testing.T) {
+	info := "# Replication\r\nrole:master\r\nconnected_slaves:2\r\n" +
+		"slave0:ip=127.0.0.1,port=6380,state=online,offset=10,lag=0\r\n" +
+		"slave1:ip=127.0.0.1,port=6381,state=online,offset=8,lag=1\r\nmaster_repl_offset:10\r\n"
+	fields, slaves := parseInfo(info)
+	if fields["role"] != "master" || fields["master_repl_offset"] != "10" {
+		t.Fatalf("unexpected fields %v", fields)
+	}
+	if len(slaves) != 2 || slaves[1]["port"] != "6381" || slaves[1]["offset"] != "8" {
+		t.Fatalf("unexpected slaves %v", slaves)
+	}
+}
+
+func TestSentinelVote(t *testing.T) {
+	initTestLog(t)
+	s := MakeSentinelServer(&SentinelConfig{Masters: []SentinelMasterConfig{{Name: "mymaster", Host: "127.0.0.1", Port: 6379}}})
+	vote := func(epoch string, runId string) []string {
+		reply := s.isMasterDownByAddr([]string{"127.0.0.1", "6379", epoch, runId})
+		return BytesArrayToStringArray(reply.(*MultiBulkReply).Args)
+	}
+	if r := vote("0", "*"); r[0] != "0" || r[1] != "*" {
+		t.Fatalf("unexpected reply %v", r)
+	}
+	s.masters["mymaster"].sdown = true
+	//每个epoch只投给第一个请求的哨兵
+	if r := vote("1", "a"); r[0] != "1" || r[1] != "a" || r[2] != "1" {
+		t.Fatalf("unexpected reply %v", r)
+	}
+	if r := vote("1", "b"); r[1] != "a" {
+		t.Fatalf("expect vote for a, actual %v", r)
+	}
+	if r := vote("2", "b"); r[1] != "b" || r[2] != "2" {
+		t.Fatalf("expect vote for b, actual %v", r)
+	}
+	if s.currentEpoch != 2 {
+		t.Fatalf("expect current epoch 2, actual %d", s.currentEpoch)
+	}
+}
+
+func makeSentinelTestServer(t *testing.T, quorum int, peers ...string) (*SentinelServer, *sentinelMaster) {
+	initTestLog(t)
+	s := MakeSentinelServer(&SentinelConfig{Masters: []SentinelMasterConfig{{Name: "mymaster", Host: "127.0.0.1", Port: 6379, Quorum: quorum}}})
+	m := s.masters["mymaster"]
+	for i, runId := range peers {
+		peer := newSentinelInstance("127.0.0.1", 26380+i)
+		peer.runId = runId
+		m.sentinels[runId] = peer
+	}
+	return s, m
+}
+
+func TestSentinelObjectivelyDown(t *testing.T) {
+	s, m := makeSentinelTestServer(t, 2, "a", "b")
+	//只有自己认为下线时达不到quorum
+	m.sdown = true
+	s.checkObjectivelyDown(m)
+	if m.odown {
+		t.Fatal("one sentinel should not reach quorum 2")
+	}
+	m.sentinels["a"].masterDown = true
+	m.sentinels["a"].masterDownTime = time.Now()
+	s.checkObjectivelyDown(m)
+	if !m.odown {
+		t.Fatal("expect odown after another sentinel agrees")
+	}
+	//其他哨兵的回复过期之后不再计数
+	m.sentinels["a"].masterDownTime = time.Now().Add(-10 * sentinelPingPeriod)
+	s.checkObjectivelyDown(m)
+	if m.odown {
+		t.Fatal("stale replies should not be counted")
+	}
+	//自己没有认为下线时不会客观下线
+	m.sentinels["a"].masterDownTime = time.Now()
+	m.sentinels["b"].masterDown = true
+	m.sentinels["b"].masterDownTime = time.Now()
+	m.sdown = false
+	s.checkObjectivelyDown(m)
+	if m.odown {
+		t.Fatal("master should not be odown when this sentinel sees it up")
+	}
+}
+
+func TestSentinelElection(t *testing.T) {
+	s, m := makeSentinelTestServer(t, 2, "a", "b", "c", "d")
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.startFailover(m, failoverWaitStart)
+	f := m.failover
+	if m.votedFor != s.runId || m.voteEpoch != f.epoch {
+		t.Fatalf("expect vote for itself in epoch %d, actual %s %d", f.epoch, m.votedFor, m.voteEpoch)
+	}
+	//5个哨兵需要3票, 投给其他哨兵或者旧epoch的票不算
+	m.sentinels["a"].leader, m.sentinels["a"].leaderEpoch = s.runId, f.epoch
+	m.sentinels["b"].leader, m.sentinels["b"].leaderEpoch = "b", f.epoch
+	m.sentinels["c"].leader, m.sentinels["c"].leaderEpoch = s.runId, f.epoch-1
+	s.checkElection(m, f)
+	if f.state != failoverWaitStart {
+		t.Fatal("two votes should not win the election of 5 sentinels")
+	}
+	m.sentinels["d"].leader, m.sentinels["d"].leaderEpoch = s.runId, f.epoch
+	s.checkElection(m, f)
+	if f.state != failoverSelectReplica {
+		t.Fatal("expect elected with a majority of votes")
+	}
+
+	//超时没有选上时放弃
+	m.failover = nil
+	s.startFailover(m, failoverWaitStart)
+	m.failover.start = time.Now().Add(-2 * sentinelElectionTimeout)
+	s.checkElection(m, m.failover)
+	if m.failover != nil {
+		t.Fatal("expect failover aborted after the election timeout")
+	}
+}
+
+// cron更新实例状态的同时执行SENTINEL命令, 配合-race检查
+func TestSentinelCronConcurrent(t *testing.T) {
+	initTestLog(t)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := ln.Addr().(*net.TCPAddr).Port
+	_ = ln.Close()
+	s := MakeSentinelServer(&SentinelConfig{Masters: []SentinelMasterConfig{{Name: "mymaster", Host: "127.0.0.1", Port: port}}})
+	m := s.masters["mymaster"]
+	//不启动订阅hello的协程
+	m.subscribed = true
+	old := Sentinel
+	Sentinel = s
+	defer func() {
+		Sentinel = old
+	}()
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 3; i++ {
+			m.lastPing = time.Time{}
+			s.checkMaster(m)
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		SentinelCommand([]string{"master", "mymaster"})
+		SentinelCommand([]string{"is-master-down-by-addr", "127.0.0.1", strconv.Itoa(port), "0", "*"})
+	}
+	wg.Wait()
+}
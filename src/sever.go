@@ -1,6 +1,7 @@
 package src
 
 import (
+	"bufio"
 	"context"
 	"encoding/binary"
 	"errors"
@@ -16,6 +17,7 @@ import (
 	"savedb/src/log"
 	"strconv"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 )
@@ -23,11 +25,21 @@ import (
 var TcpServer = &TCPServer{}
 
 type TCPServer struct {
-	Connections map[net.Conn]Connection
+	Connections map[net.Conn]*Connection
 	Close       atomic.Bool
+	mu          sync.Mutex
 }
 
 func StartTCPServer(port int) error {
+	return StartListener(port, ProtocolNameLegacy)
+}
+
+// StartListener 在port上监听, protocol决定这个端口使用的协议: legacy=私有的长度前缀协议 resp=redis协议
+func StartListener(port int, protocol string) error {
+	proto, err := parseProtocol(protocol)
+	if err != nil {
+		return err
+	}
 	address := ":" + strconv.Itoa(port)
 	var lc net.ListenConfig
 	var ctx context.Context
@@ -36,17 +48,32 @@ func StartTCPServer(port int) error {
 		log.SaveDBLogger.Error("TCP Server start fail, Listen :%s", address)
 		return err
 	}
-	log.SaveDBLogger.Infof("TCP Server started, Listen :%s", address)
-	conns := make(map[net.Conn]Connection)
-	TcpServer.Connections = conns
-	go TcpServer.acceptConn(listener)
+	log.SaveDBLogger.Infof("TCP Server started, Listen :%s, protocol=%s", address, protocol)
+	TcpServer.mu.Lock()
+	if TcpServer.Connections == nil {
+		TcpServer.Connections = make(map[net.Conn]*Connection)
+	}
+	TcpServer.mu.Unlock()
+	go TcpServer.acceptConn(listener, proto)
 	return nil
 }
-func (server *TCPServer) acceptConn(listener net.Listener) {
+
+func parseProtocol(protocol string) (int, error) {
+	switch strings.ToLower(protocol) {
+	case "", ProtocolNameLegacy:
+		return ProtocolLegacy, nil
+	case ProtocolNameResp:
+		//resp端口默认使用RESP2, 客户端可以通过HELLO 3切换到RESP3
+		return ProtocolResp2, nil
+	}
+	return 0, fmt.Errorf("unknown protocol %s", protocol)
+}
+
+func (server *TCPServer) acceptConn(listener net.Listener, protocol int) {
 	defer func() {
 		if r := recover(); r != nil {
 			log.SaveDBLogger.Errorf("AcceptConn  from panic:%v, recover again", r)
-			go server.acceptConn(listener)
+			go server.acceptConn(listener, protocol)
 		}
 	}()
 	for {
@@ -61,7 +88,7 @@ func (server *TCPServer) acceptConn(listener net.Listener) {
 			log.SaveDBLogger.Error("Error setting TCP NoDelay:", err)
 		}
 		//逻辑处理
-		go onMessage(&conn)
+		go onMessage(&conn, protocol)
 	}
 }
 
@@ -74,6 +101,34 @@ type Connection struct {
 	Writer     chan *Message
 	RemoteAddr net.Addr
 	dbIndex    int
+	//ProtocolLegacy ProtocolResp2 ProtocolResp3
+	protocol int
+	id       int64
+	name     string
+	//事务状态 MULTI之后的命令先放进queue, EXEC时一起执行
+	multi    bool
+	txError  bool
+	queue    []*Message
+	watching map[watchKey]uint64
+	//订阅的频道和模式, 只在连接自己的读协程中修改
+	subChannels map[string]struct{}
+	subPatterns map[string]struct{}
+	//从节点上执行主节点命令的连接, 不受只读限制
+	fromMaster bool
+	//从节点通过REPLCONF listening-port告知的端口
+	replPort int
+	//集群模式下收到ASKING, 下一条命令可以访问正在导入的slot
+	asking bool
+	//阻塞命令通过它发现客户端已经断开, 只有服务端接受的连接才有
+	closing *connClosing
+}
+
+type connClosing struct {
+	//连接断开时关闭
+	ch   chan struct{}
+	once sync.Once
+	//正在等待的阻塞命令
+	blocked atomic.Pointer[blockedClient]
 }
 type OnConnection interface {
 	ConnOpen()
@@ -87,7 +142,12 @@ func (c *Connection) ConnOpen() {
 }
 
 func (c *Connection) ConnClose() {
+	c.markClosed()
+	PubSub.UnsubscribeAll(c)
+	ReplMaster.RemoveSlave(c)
+	TcpServer.mu.Lock()
 	delete(TcpServer.Connections, c.Conn)
+	TcpServer.mu.Unlock()
 	log.SaveDBLogger.Infof("connection closed conn=%v", c.Conn.RemoteAddr())
 	_ = (c.Conn).Close()
 }
@@ -97,85 +157,160 @@ func (c *Connection) ReadMsg() {
 		if r := recover(); r != nil {
 			log.SaveDBLogger.Errorf("read error from panic:%v", r)
 		}
+		//watching只在读协程中修改
+		Server.unwatch(c.watching)
+		c.watching = nil
 		c.ConnClose()
 	}()
-	for {
-		bufData := make([]byte, MsgBufferSize)
-		buff1 := bufData[:MsgBufferOffset]
-		_, err := io.ReadFull(c.Conn, buff1)
-		if err != nil {
-			if err.Error() == io.EOF.Error() || errors.Is(err, io.ErrUnexpectedEOF) {
-				log.SaveDBLogger.Infof("connection closed conn=%v", c.Conn.RemoteAddr())
-				c.ConnClose()
-			} else {
+	if c.protocol != ProtocolLegacy {
+		c.readResp()
+		return
+	}
+	//在单独的协程中读取请求, 阻塞命令等待期间也能发现连接断开
+	for frame := range c.readLegacy() {
+		if frame.err != nil {
+			ReturnErr(frame.err.Error(), c)
+			continue
+		}
+		c.dispatch(frame.args)
+	}
+}
+
+// legacy协议的一个请求, err是参数解析失败
+type legacyFrame struct {
+	args []string
+	err  error
+}
+
+func (c *Connection) readLegacy() <-chan *legacyFrame {
+	ch := make(chan *legacyFrame)
+	go func() {
+		defer close(ch)
+		//pipeline时客户端会连续发送多个请求, 使用带缓冲的reader减少系统调用
+		reader := bufio.NewReaderSize(&closeNotifyReader{c: c}, ReaderBufferSize)
+		for {
+			head := make([]byte, MsgBufferOffset)
+			_, err := io.ReadFull(reader, head)
+			if err != nil {
+				if err.Error() == io.EOF.Error() || errors.Is(err, io.ErrUnexpectedEOF) {
+					log.SaveDBLogger.Infof("connection closed conn=%v", c.Conn.RemoteAddr())
+				} else {
+					log.SaveDBLogger.Errorf("Read data error %v, conn=%v", err, c.Conn.RemoteAddr())
+				}
+				return
+			}
+
+			mlen := ReadInt(head)
+			if mlen <= 0 || mlen > MsgMaxSize {
+				log.SaveDBLogger.Errorf("illegal message length %d, conn=%v", mlen, c.Conn.RemoteAddr())
+				return
+			}
+			body := make([]byte, mlen)
+			_, err = io.ReadFull(reader, body)
+			if err != nil {
 				log.SaveDBLogger.Errorf("Read data error %v, conn=%v", err, c.Conn.RemoteAddr())
+				return
 			}
-			return
-		}
 
-		var mlen int32
-		mlen = ReadInt(buff1)
-		bufd := bufData[MsgBufferOffset : mlen+MsgBufferOffset]
-		_, err = io.ReadFull(c.Conn, bufd)
-		if err != nil {
-			log.SaveDBLogger.Errorf("Read data error %v, conn=%v", err, c.Conn.RemoteAddr())
-			return
+			//指令格式为: [参数长度][command][参数长度][参数1][参数长度][参数2]........ 参数是二进制安全的
+			args, err := DecodeArgs(body)
+			ch <- &legacyFrame{args: args, err: err}
 		}
+	}()
+	return ch
+}
 
-		//序列化指令格式为: command 参数1 参数2 参数3 ........
-		str := string(bufd)
-		if str == "" {
-			ReturnErr("command is null", c)
-			continue
-		}
+// 读取失败时标记连接已经关闭, 唤醒正在等待的阻塞命令
+type closeNotifyReader struct {
+	c *Connection
+}
 
-		words := strings.Fields(str)
-		command := strings.ToLower(words[0])
-		com, ok := saveCommandMap[command]
-		if command == "heart" {
-			log.SaveDBLogger.Infof("heart packet conn=%v", c.Conn.RemoteAddr())
-			continue
-		}
+func (r *closeNotifyReader) Read(p []byte) (int, error) {
+	n, err := r.c.Conn.Read(p)
+	if err != nil {
+		r.c.markClosed()
+	}
+	return n, err
+}
 
-		//非法格式直接返回错误
-		if !ok {
-			ReturnErr("command error", c)
-			continue
-		}
-		//非法参数长度直接返回错误
-		if com.arity > 0 && len(words)-1 != com.arity {
-			ReturnErr("command error", c)
-			continue
-		}
-		args := words[1:]
-		msg := CreateMsg(&c.Conn, command, args)
-		Server.Exec(c, msg)
+// 连接断开时调用, 可以调用多次; 正在阻塞的命令不再等待
+func (c *Connection) markClosed() {
+	if c.closing == nil {
+		return
+	}
+	c.closing.once.Do(func() {
+		close(c.closing.ch)
+	})
+	if w := c.closing.blocked.Load(); w != nil {
+		BlockedClients.cancel(w)
 	}
 }
 
+// 校验命令和参数个数后交给Server执行, legacy和resp连接共用
+func (c *Connection) dispatch(words []string) {
+	if len(words) == 0 {
+		ReturnErr("command is null", c)
+		return
+	}
+	command := strings.ToLower(words[0])
+	if command == "heart" {
+		log.SaveDBLogger.Infof("heart packet conn=%v", c.Conn.RemoteAddr())
+		return
+	}
+	com, ok := saveCommandMap[command]
+	//非法格式直接返回错误
+	if !ok {
+		c.flagTxError()
+		ReturnErr("command error", c)
+		return
+	}
+	//非法参数长度直接返回错误
+	if com.arity > 0 && len(words)-1 != com.arity {
+		c.flagTxError()
+		ReturnErr("command error", c)
+		return
+	}
+	msg := CreateMsg(&c.Conn, command, words[1:])
+	Server.Exec(c, msg)
+}
+
 func (c *Connection) WriterMsg() {
 	defer func() {
 		if r := recover(); r != nil {
 			log.SaveDBLogger.Errorf("WriterMsg from panic:%v, conn=%v", r, c.Conn.RemoteAddr())
 		}
 	}()
+	//pipeline时一次读取多个请求, 回复先写进缓冲区, 队列中没有待写的回复时再一次性刷到socket
+	writer := bufio.NewWriterSize(c.Conn, WriterBufferSize)
 	for {
-		select {
-		case msg, ok := <-c.Writer:
-			if !ok {
-				//主动关闭链接了
-				log.SaveDBLogger.Info("Connection close by self", c.Conn.RemoteAddr())
-				if !c.Close.Load() {
-					c.ConnClose()
-				}
-				return
-			} else {
-				data := msg.ReturnData
-				if c.Conn == nil {
-					continue
-				}
-				_, _ = c.Conn.Write(*data)
+		msg, ok := <-c.Writer
+		if !ok {
+			//主动关闭链接了
+			log.SaveDBLogger.Info("Connection close by self", c.Conn.RemoteAddr())
+			if !c.Close.Load() {
+				c.ConnClose()
 			}
+			return
+		}
+		var flushed []chan struct{}
+		for {
+			_, _ = writer.Write(*msg.ReturnData)
+			if msg.flushed != nil {
+				flushed = append(flushed, msg.flushed)
+			}
+			if len(c.Writer) == 0 {
+				break
+			}
+			if msg, ok = <-c.Writer; !ok {
+				break
+			}
+		}
+		if err := writer.Flush(); err != nil {
+			log.SaveDBLogger.Errorf("Write data error %v, conn=%v", err, c.Conn.RemoteAddr())
+			writer.Reset(c.Conn)
+		}
+		for _, ch := range flushed {
+			close(ch)
 		}
 	}
 }
@@ -185,23 +320,33 @@ type Message struct {
 	Command    *string
 	Args       []string
 	ReturnData *[]byte
+	//不为nil时, 回复刷到socket之后关闭
+	flushed chan struct{}
 }
 
-func onMessage(conn *net.Conn) {
+var connectionId atomic.Int64
+
+func onMessage(conn *net.Conn, protocol int) {
 	defer func() {
 		if r := recover(); r != nil {
 			log.SaveDBLogger.Errorf("onMessage from panic:%v, conn=%v", r, (*conn).RemoteAddr())
 		}
 	}()
 	var connection = &Connection{}
-	w := make(chan *Message)
+	w := make(chan *Message, WriterQueueSize)
 	connection.Writer = w
 	connection.Conn = *conn
 	var flag atomic.Bool
 	connection.Close = &flag
+	connection.closing = &connClosing{ch: make(chan struct{})}
 	//默认0号数据库
 	connection.dbIndex = 0
-	TcpServer.Connections[*conn] = *connection
+	connection.protocol = protocol
+	connection.Type = ConnTypeClient
+	connection.id = connectionId.Add(1)
+	TcpServer.mu.Lock()
+	TcpServer.Connections[*conn] = connection
+	TcpServer.mu.Unlock()
 	//先建立连接
 	connection.ConnOpen()
 	connection.RemoteAddr = (*conn).RemoteAddr()
@@ -222,34 +367,102 @@ func createWriterMsg(res Result) *Message {
 	return msg
 }
 func ReturnErr(str string, c *Connection) {
-	msg := createWriterMsg(CreateStrResult(CErr, str))
-	c.Writer <- msg
+	c.writeReply(errReply(str))
 }
-func CreateSpecialCMD(c *Connection, result Result, err error) {
-	var wm *Message
+func CreateSpecialCMD(c *Connection, reply Reply, err error) {
 	if err != nil {
-		wm = createWriterMsg(CreateStrResult(CErr, err.Error()))
+		c.writeReply(errReply(err.Error()))
 	} else {
-		wm = createWriterMsg(result)
+		c.writeReply(reply)
 	}
-	if c.Writer != nil {
-		c.Writer <- wm
+}
+
+func (c *Connection) writeReply(reply Reply) {
+	if c.Writer == nil {
+		return
 	}
+	c.Writer <- c.replyMessage(reply)
+}
+
+// 关闭连接之前的最后一个回复, 等待写协程把它刷到socket
+func (c *Connection) writeReplyAndWait(reply Reply) {
+	if c.Writer == nil {
+		return
+	}
+	msg := c.replyMessage(reply)
+	msg.flushed = make(chan struct{})
+	c.Writer <- msg
+	select {
+	case <-msg.flushed:
+	case <-time.After(time.Second):
+	}
+}
+
+// 按连接的协议编码回复
+func (c *Connection) replyMessage(reply Reply) *Message {
+	//老协议没有数组之类的类型, 直接把RESP2编码放进结果里
+	if c.protocol == ProtocolLegacy {
+		status := byte(COk)
+		if IsErrorReply(reply) {
+			status = CErr
+		}
+		return createWriterMsg(CreateResult(status, reply.ToBytes()))
+	}
+	data := ToProtocolBytes(reply, c.protocol)
+	return &Message{ReturnData: &data}
 }
 
 var SConfig = &SentinelConfig{}
 var Config = &serverConfig{}
 
 type serverConfig struct {
-	Port              int            `yaml:"port"`
-	Appendfsync       string         `yaml:"appendfsync"`
-	AofUseRdbPreamble bool           `yaml:"aof-use-rdb-preamble"`
-	Dir               string         `yaml:"dir"`
-	RDBFilename       string         `yaml:"rdbfilename"`
-	AppendOnly        bool           `yaml:"appendonly"`
-	AppendFilename    string         `yaml:"appendfilename"`
-	Maxmemory         uint64         `yaml:"maxmemory"`
-	Logs              *log.LogConfig `yaml:"logs"`
+	Port              int              `yaml:"port"`
+	Protocol          string           `yaml:"protocol"`
+	Listeners         []ListenerConfig `yaml:"listeners"`
+	Appendfsync       string           `yaml:"appendfsync"`
+	AofUseRdbPreamble bool             `yaml:"aof-use-rdb-preamble"`
+	Dir               string           `yaml:"dir"`
+	RDBFilename       string           `yaml:"rdbfilename"`
+	AppendOnly        bool             `yaml:"appendonly"`
+	AppendFilename    string           `yaml:"appendfilename"`
+	Maxmemory         uint64           `yaml:"maxmemory"`
+	//订阅者待写消息数的上限, 超过后断开连接, 0表示使用WriterQueueSize
+	PubSubBufferLimit int `yaml:"pubsub-buffer-limit"`
+	//主从复制: 启动时复制的主节点"host port", 从节点是否只读, backlog的大小
+	Replicaof       string `yaml:"replicaof"`
+	ReplicaReadOnly bool   `yaml:"replica-read-only"`
+	ReplBacklogSize int    `yaml:"repl-backlog-size"`
+	//集群模式: 其他节点连接这个节点使用的ip, 节点超时时间(毫秒), 保存集群配置的文件名
+	ClusterEnabled     bool           `yaml:"cluster-enabled"`
+	ClusterAnnounceIp  string         `yaml:"cluster-announce-ip"`
+	ClusterNodeTimeout int            `yaml:"cluster-node-timeout"`
+	ClusterConfigFile  string         `yaml:"cluster-config-file"`
+	Logs               *log.LogConfig `yaml:"logs"`
+}
+
+// ListenerConfig 额外的监听端口, 每个端口可以单独选择协议
+type ListenerConfig struct {
+	Port     int    `yaml:"port"`
+	Protocol string `yaml:"protocol"`
+}
+
+func (config *serverConfig) LoadConfig(path string) {
+	yamlFile, err := ioutil.ReadFile(path)
+	if err != nil {
+		fmt.Println("Open config file error", err.Error())
+		return
+	}
+	//没有配置时的默认值
+	config.ReplicaReadOnly = true
+	e := yaml.Unmarshal(yamlFile, config)
+	if e != nil {
+		fmt.Println("read config file error", e.Error())
+		return
+	}
+	if config.Logs == nil {
+		config.Logs = &log.LogConfig{Path: "logs"}
+	}
+	config.Logs.DefaultLevel = "info"
 }
 
 func (config *SentinelConfig) LoadSentinelConfig(path string) {
@@ -260,9 +473,12 @@ func (config *SentinelConfig) LoadSentinelConfig(path string) {
 	}
 	e := yaml.Unmarshal(yamlFile, config)
 	if e != nil {
-		fmt.Println("read config file error", err.Error())
+		fmt.Println("read config file error", e.Error())
 		return
 	}
+	if config.Logs == nil {
+		config.Logs = &log.LogConfig{Path: "logs"}
+	}
 	config.Logs.DefaultLevel = "info"
 }
 
@@ -293,7 +509,15 @@ var CronManager *cron.Cron
 func InitServer() {
 	//Initialize the LRU keys pool
 	evictionPoolAlloc()
+	//集群模式下的db在加载数据之前创建
+	InitCluster()
 	NewSingleServer()
+	if Config.Replicaof != "" {
+		args := strings.Fields(Config.Replicaof)
+		if len(args) != 2 || IsErrorReply(ReplSlave.ReplicaOf(args)) {
+			log.SaveDBLogger.Errorf("illegal replicaof config: %s", Config.Replicaof)
+		}
+	}
 	CronManager = cron.New(cron.WithSeconds())
 	CronManager.Start()
 	_, _ = CronManager.AddFunc("@every 5s", printMemoryStats)
@@ -311,12 +535,12 @@ func NewSingleServer() {
 	if Config.AppendOnly {
 		validAof = fileExists(GetAofFilePath())
 	}
-	aofHandler, err := NewPersister2(*Server, Config.Appendfsync)
+	aofHandler, err := NewPersister(*Server, Config.Appendfsync)
 	if err != nil {
 		panic(err)
 	}
 	if Config.AppendOnly {
-		//todo 启动时暂时只重放aof文件
+		//aof文件以rdb开头时先加载rdb前缀再重放aof尾部
 		aofHandler.LoadAof(0)
 		//打开文件时的标志位，使用位掩码
 		//os.O_APPEND: 将文件指针设置为文件末尾，在文件中追加数据。 os.O_CREATE: 如果文件不存在，则创建文件。 os.O_RDWR: 以读写方式打开文件。
@@ -325,9 +549,12 @@ func NewSingleServer() {
 			panic(err)
 		}
 		aofHandler.aofFile = aofFile
-		aofHandler.aofChan = make(chan *payload, aofQueueSize)
-		aofHandler.aofFinished = make(chan struct{})
 	}
+	//没有开启aof时主从复制也通过aofChan把写命令发给listener
+	aofHandler.aofChan = make(chan *payload, aofQueueSize)
+	aofHandler.aofFinished = make(chan struct{})
+	// start aof goroutine to write aof file in background
+	go aofHandler.listenCmd()
 	Server.bindPersister(aofHandler)
 	//3.如果aof文件不存在则加载rdb
 	if Config.RDBFilename != "" && !validAof {
@@ -0,0 +1,256 @@
+package src
+
+import (
+	"errors"
+	"os"
+	"savedb/src/data"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 基于写时复制的快照, bgsave和bgrewriteaof直接遍历当前的数据, 不需要重放aof生成一份临时的数据
+// 1.开始快照时等待正在执行的写命令结束, 把aof刷到文件里并记录文件大小, 然后激活快照
+// 2.快照期间写命令加锁之后、修改数据之前先保存key原来的值(深拷贝), 每个key只保存一次
+// 3.遍历快照时被修改过的key使用保存的旧值, 额外的内存只和快照期间修改过的key有关
+
+// 写命令持有读锁, 开始快照时持有写锁, 保证激活快照时没有执行到一半的写命令
+var snapshotBarrier sync.RWMutex
+var activeSnapshot atomic.Pointer[Snapshot]
+
+var errSnapshotInProgress = errors.New("background save or rewrite already in progress")
+
+type Snapshot struct {
+	dbs     []*snapshotDB
+	byTable map[*SaveDBTables]*snapshotDB
+	//开始快照时aof文件的大小和选中的db, 重写时从这个位置开始追加快照期间产生的aof
+	aofSize int64
+	dbIndex int
+}
+
+type snapshotDB struct {
+	db        *SaveDBTables
+	mu        sync.Mutex
+	preserved map[string]*preservedEntry
+}
+
+type preservedEntry struct {
+	//nil表示快照开始时key不存在
+	entity     any
+	expiration *time.Time
+	//遍历时已经输出过了
+	visited bool
+}
+
+// 开始快照, hook在暂停aof期间执行, 可以用来注册aof的listener
+func (persister *Persister) startSnapshot(hook func()) (*Snapshot, error) {
+	snapshotBarrier.Lock()
+	defer snapshotBarrier.Unlock()
+	if activeSnapshot.Load() != nil {
+		return nil, errSnapshotInProgress
+	}
+	//写命令都已经结束了, 等待它们的aof写进文件
+	persister.flushAof()
+
+	persister.pausingAof.Lock()
+	defer persister.pausingAof.Unlock()
+	snap := &Snapshot{
+		dbs:     make([]*snapshotDB, len(persister.db.Dbs)),
+		byTable: make(map[*SaveDBTables]*snapshotDB, len(persister.db.Dbs)),
+		dbIndex: persister.currentDB,
+	}
+	for i := range persister.db.Dbs {
+		sdb := &snapshotDB{
+			db:        persister.db.FindDB(i),
+			preserved: make(map[string]*preservedEntry),
+		}
+		snap.dbs[i] = sdb
+		snap.byTable[sdb.db] = sdb
+	}
+	if persister.aofFile != nil {
+		if err := persister.aofFile.Sync(); err != nil {
+			return nil, err
+		}
+		fileInfo, err := os.Stat(GetAofFilePath())
+		if err != nil {
+			return nil, err
+		}
+		snap.aofSize = fileInfo.Size()
+	}
+	if hook != nil {
+		hook()
+	}
+	activeSnapshot.Store(snap)
+	return snap, nil
+}
+
+// Release 快照结束, 之后的写命令不再保存旧值
+func (snap *Snapshot) Release() {
+	activeSnapshot.CompareAndSwap(snap, nil)
+}
+
+// KeyCount 快照中key和带过期时间的key的大概数量, 只用于rdb中的提示
+func (snap *Snapshot) KeyCount(dbIndex int) (int, int) {
+	sdb := snap.dbs[dbIndex]
+	sdb.mu.Lock()
+	preserved := len(sdb.preserved)
+	sdb.mu.Unlock()
+	return sdb.db.Data.Len() + preserved, sdb.db.Expires.Len()
+}
+
+// ForEach 遍历快照开始时db中的数据
+func (snap *Snapshot) ForEach(dbIndex int, cb func(key string, entity any, expiration *time.Time) bool) {
+	sdb := snap.dbs[dbIndex]
+	db := sdb.db
+	//按分片遍历, 每次只复制一个分片的key
+	for i := 0; i < db.Data.ShardCount(); i++ {
+		for _, key := range db.Data.ShardKeys(i) {
+			if !sdb.visit(key, cb) {
+				return
+			}
+		}
+	}
+	//快照开始之后被删除的key
+	sdb.mu.Lock()
+	var rest []string
+	for key, entry := range sdb.preserved {
+		if !entry.visited && entry.entity != nil {
+			rest = append(rest, key)
+		}
+	}
+	sdb.mu.Unlock()
+	for _, key := range rest {
+		if !sdb.visit(key, cb) {
+			return
+		}
+	}
+}
+
+func (sdb *snapshotDB) visit(key string, cb func(key string, entity any, expiration *time.Time) bool) bool {
+	keys := []string{key}
+	//持有key的读锁, 写命令在保存旧值之前不会修改数据
+	sdb.db.Data.RWLocks(nil, keys)
+	defer sdb.db.Data.RWUnLocks(nil, keys)
+	sdb.mu.Lock()
+	entry, ok := sdb.preserved[key]
+	if ok {
+		if entry.visited {
+			sdb.mu.Unlock()
+			return true
+		}
+		entry.visited = true
+	}
+	sdb.mu.Unlock()
+	if ok {
+		if entry.entity == nil {
+			return true
+		}
+		return cb(key, entry.entity, entry.expiration)
+	}
+	entity, exists := sdb.db.Data.GetWithLock(key)
+	if !exists {
+		return true
+	}
+	return cb(key, entity, sdb.db.getExpiration(key))
+}
+
+// 写命令已经持有key的写锁, 在修改之前保存旧值
+func (db *SaveDBTables) preserve(keys []string) {
+	snap := activeSnapshot.Load()
+	if snap == nil {
+		return
+	}
+	sdb, ok := snap.byTable[db]
+	if !ok {
+		return
+	}
+	for _, key := range keys {
+		sdb.mu.Lock()
+		_, exists := sdb.preserved[key]
+		sdb.mu.Unlock()
+		if exists {
+			continue
+		}
+		//持有写锁, 同一个key不会并发保存, 拷贝放在sdb.mu外面
+		entry := &preservedEntry{}
+		if entity, ok := db.Data.GetWithLock(key); ok {
+			entry.entity = cloneEntity(entity)
+			entry.expiration = db.getExpiration(key)
+		}
+		sdb.mu.Lock()
+		if _, exists = sdb.preserved[key]; !exists {
+			sdb.preserved[key] = entry
+		}
+		sdb.mu.Unlock()
+	}
+}
+
+// flushdb之前保存整个db, 清空之后原来的对象不会再被修改, 不需要拷贝
+func (db *SaveDBTables) preserveAll() {
+	snap := activeSnapshot.Load()
+	if snap == nil {
+		return
+	}
+	sdb, ok := snap.byTable[db]
+	if !ok {
+		return
+	}
+	entries := make(map[string]*preservedEntry, db.Data.Len())
+	db.Data.ForEach(func(key string, entity interface{}) bool {
+		entries[key] = &preservedEntry{entity: entity, expiration: db.getExpiration(key)}
+		return true
+	})
+	sdb.mu.Lock()
+	defer sdb.mu.Unlock()
+	for key, entry := range entries {
+		if _, exists := sdb.preserved[key]; !exists {
+			sdb.preserved[key] = entry
+		}
+	}
+}
+
+func cloneEntity(entity any) any {
+	switch obj := entity.(type) {
+	case []byte:
+		return append([]byte(nil), obj...)
+	case *List:
+		l := NewList()
+		obj.L.ForEach(func(i int, v interface{}) bool {
+			l.L.Add(v)
+			return true
+		})
+		return l
+	case *Hash:
+		hash := NewHash()
+		for field, val := range obj.M {
+			v := *val
+			hash.M[field] = &v
+		}
+		for field, at := range obj.E {
+			if hash.E == nil {
+				hash.E = make(map[string]time.Time)
+			}
+			hash.E[field] = at
+		}
+		return hash
+	case *Set:
+		set := NewSet()
+		for member, val := range obj.M {
+			set.M[member] = val
+		}
+		return set
+	case *ZSet:
+		zSet := NewZSet()
+		if obj.Z.Len() == 0 {
+			return zSet
+		}
+		obj.Z.ForEachByRank(0, obj.Z.Len(), false, func(element *data.Element) bool {
+			zSet.Z.Add(element.Member, element.Score)
+			return true
+		})
+		return zSet
+	case *Stream:
+		return &Stream{S: obj.S.Clone()}
+	}
+	return entity
+}
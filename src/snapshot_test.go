@@ -0,0 +1,64 @@
+package src
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func snapshotEntities(snap *Snapshot, dbIndex int) map[string]any {
+	entities := make(map[string]any)
+	snap.ForEach(dbIndex, func(key string, entity any, expiration *time.Time) bool {
+		if _, ok := entities[key]; ok {
+			panic("duplicate key " + key)
+		}
+		entities[key] = entity
+		return true
+	})
+	return entities
+}
+
+func TestSnapshot(t *testing.T) {
+	s, c := makeMultiTestServer()
+	p := &Persister{db: *s, loading: &atomic.Bool{}, listeners: make(map[Listener]struct{})}
+	execTestCmd(s, c, "set", "a", "1")
+	execTestCmd(s, c, "set", "b", "2")
+	execTestCmd(s, c, "rpush", "l", "x", "y")
+
+	snap, err := p.startSnapshot(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = p.startSnapshot(nil); err != errSnapshotInProgress {
+		t.Errorf("expect snapshot in progress, actual %v", err)
+	}
+	execTestCmd(s, c, "set", "a", "10")
+	execTestCmd(s, c, "del", "b")
+	execTestCmd(s, c, "set", "c", "3")
+	execTestCmd(s, c, "rpush", "l", "z")
+
+	entities := snapshotEntities(snap, 0)
+	if len(entities) != 3 {
+		t.Fatalf("expect 3 keys, actual %v", entities)
+	}
+	if string(entities["a"].([]byte)) != "1" || string(entities["b"].([]byte)) != "2" {
+		t.Errorf("snapshot should keep old values, actual %s %s", entities["a"], entities["b"])
+	}
+	if n := entities["l"].(*List).L.Len(); n != 2 {
+		t.Errorf("expect list len 2, actual %d", n)
+	}
+	snap.Release()
+
+	snap, err = p.startSnapshot(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	execTestCmd(s, c, "flushdb")
+	if entities = snapshotEntities(snap, 0); len(entities) != 3 {
+		t.Errorf("expect 3 keys after flushdb, actual %v", entities)
+	}
+	snap.Release()
+	if activeSnapshot.Load() != nil {
+		t.Error("snapshot should be released")
+	}
+}
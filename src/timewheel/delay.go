@@ -15,7 +15,12 @@ func Delay(duration time.Duration, key string, job func()) {
 }
 
 func AddTimer(at time.Time, key string, job func()) {
-	tw.AddJob(at.Sub(time.Now()), key, job)
+	delay := at.Sub(time.Now())
+	if delay < 0 {
+		//已经过期的任务在下一次转动时执行, AddJob会丢掉负的延迟
+		delay = 0
+	}
+	tw.AddJob(delay, key, job)
 }
 
 // Cancel stops a pending job
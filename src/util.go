@@ -48,20 +48,6 @@ func CreateResult(status byte, res []byte) Result {
 	}
 	return Result{Status: status, Res: res}
 }
-func CreateIntResult(status byte, i int64) Result {
-	res := make([]byte, 0)
-	writeInt64(res, 0, i)
-	return Result{Status: status, Res: res}
-}
-func CreateStrResult(status byte, res string) Result {
-	var b []byte
-	if res == "" {
-		b = make([]byte, 0)
-	} else {
-		b = []byte(res)
-	}
-	return Result{Status: status, Res: b}
-}
 
 func Equals(a interface{}, b interface{}) bool {
 	sliceA, okA := a.([]byte)
@@ -90,12 +76,18 @@ func BytesEquals(a []byte, b []byte) bool {
 }
 
 func readFirstKey(args []string) ([]string, []string) {
-	// assert len(args) > 0
+	//参数个数不对时由命令自己返回错误
+	if len(args) == 0 {
+		return nil, nil
+	}
 	key := args[0]
 	return []string{key}, nil
 }
 
 func writeFirstKey(args []string) ([]string, []string) {
+	if len(args) == 0 {
+		return nil, nil
+	}
 	key := args[0]
 	return nil, []string{key}
 }
@@ -108,6 +100,79 @@ func writeAllKeys(args []string) ([]string, []string) {
 	return nil, keys
 }
 
+// key value key value ... 这样成对的参数, 比如mset
+func writePairKeys(args []string) ([]string, []string) {
+	keys := make([]string, 0, len(args)/2)
+	for i := 0; i+1 < len(args); i += 2 {
+		keys = append(keys, args[i])
+	}
+	return nil, keys
+}
+
+// 最后一个参数是超时时间的阻塞命令, 比如blpop
+func writeKeysExceptLast(args []string) ([]string, []string) {
+	if len(args) == 0 {
+		return nil, nil
+	}
+	keys := make([]string, len(args)-1)
+	copy(keys, args)
+	return nil, keys
+}
+
+// source destination ... 这样的命令, 比如blmove
+func writeFirstTwoKeys(args []string) ([]string, []string) {
+	if len(args) < 2 {
+		return writeFirstKey(args)
+	}
+	return nil, []string{args[0], args[1]}
+}
+
+// COPY source destination ..., 读source写destination
+func copyKeys(args []string) ([]string, []string) {
+	if len(args) < 2 {
+		return readFirstKey(args)
+	}
+	return []string{args[0]}, []string{args[1]}
+}
+
+// 没有key但是会修改整个db的命令, 比如flushdb
+// SDIFFSTORE destination key [key ...], 写destination读其他的key
+func storeKeys(args []string) ([]string, []string) {
+	if len(args) < 2 {
+		return writeFirstKey(args)
+	}
+	readKeys := make([]string, len(args)-1)
+	copy(readKeys, args[1:])
+	return readKeys, []string{args[0]}
+}
+
+// numkeys key [key ...] ..., 比如zunion sintercard
+func readNumKeys(args []string) ([]string, []string) {
+	if len(args) == 0 {
+		return nil, nil
+	}
+	n, err := strconv.Atoi(args[0])
+	if err != nil || n <= 0 || n > len(args)-1 {
+		return nil, nil
+	}
+	readKeys := make([]string, n)
+	copy(readKeys, args[1:])
+	return readKeys, nil
+}
+
+// destination numkeys key [key ...] ..., 比如zunionstore
+func storeNumKeys(args []string) ([]string, []string) {
+	if len(args) == 0 {
+		return nil, nil
+	}
+	readKeys, _ := readNumKeys(args[1:])
+	return readKeys, []string{args[0]}
+}
+
+func writeNoKeys(args []string) ([]string, []string) {
+	return nil, []string{}
+}
+
 func readAllKeys(args []string) ([]string, []string) {
 	keys := make([]string, len(args))
 	for i, v := range args {
@@ -158,6 +223,45 @@ func ToBytes(args [][]byte) []byte {
 	return buf.Bytes()
 }
 
+// EncodeArgs 序列化一条命令: [4字节总长度]{[4字节参数长度][参数]}...
+func EncodeArgs(args ...[]byte) []byte {
+	size := 0
+	for _, arg := range args {
+		size += MsgBufferOffset + len(arg)
+	}
+	data := make([]byte, MsgBufferOffset+size)
+	writeInt32(data, 0, int32(size))
+	pos := MsgBufferOffset
+	for _, arg := range args {
+		writeInt32(data, pos, int32(len(arg)))
+		pos += MsgBufferOffset
+		copy(data[pos:], arg)
+		pos += len(arg)
+	}
+	return data
+}
+
+// DecodeArgs 反序列化EncodeArgs生成的消息体(不包含总长度)
+func DecodeArgs(body []byte) ([]string, error) {
+	args := make([]string, 0, 4)
+	for pos := 0; pos < len(body); {
+		if pos+MsgBufferOffset > len(body) {
+			return nil, fmt.Errorf("ERR Protocol error: illegal argument header")
+		}
+		l := int(ReadInt(body[pos:]))
+		pos += MsgBufferOffset
+		if l < 0 || pos+l > len(body) {
+			return nil, fmt.Errorf("ERR Protocol error: illegal argument length")
+		}
+		args = append(args, string(body[pos:pos+l]))
+		pos += l
+	}
+	if len(args) == 0 {
+		return nil, fmt.Errorf("command is null")
+	}
+	return args, nil
+}
+
 func BytesArrayToStringArray(b [][]byte) []string {
 	s := make([]string, len(b))
 	for i, i2 := range b {
@@ -183,3 +287,92 @@ func NewFakeConn() *Connection {
 	c := &Connection{}
 	return c
 }
+
+// SplitArgs 和redis-cli一样切分命令行, 支持双引号(可以使用\n \t \" \xHH等转义)和单引号
+func SplitArgs(line string) ([]string, error) {
+	args := make([]string, 0)
+	i := 0
+	for {
+		for i < len(line) && (line[i] == ' ' || line[i] == '\t' || line[i] == '\n' || line[i] == '\r') {
+			i++
+		}
+		if i >= len(line) {
+			return args, nil
+		}
+		var current []byte
+		inDQ, inSQ, done := false, false, false
+		for !done {
+			if inDQ {
+				if i >= len(line) {
+					return nil, fmt.Errorf("unbalanced quotes")
+				}
+				if line[i] == '\\' && i+3 < len(line) && line[i+1] == 'x' && isHexDigit(line[i+2]) && isHexDigit(line[i+3]) {
+					b, _ := strconv.ParseUint(line[i+2:i+4], 16, 8)
+					current = append(current, byte(b))
+					i += 3
+				} else if line[i] == '\\' && i+1 < len(line) {
+					i++
+					switch line[i] {
+					case 'n':
+						current = append(current, '\n')
+					case 'r':
+						current = append(current, '\r')
+					case 't':
+						current = append(current, '\t')
+					case 'b':
+						current = append(current, '\b')
+					case 'a':
+						current = append(current, '\a')
+					default:
+						current = append(current, line[i])
+					}
+				} else if line[i] == '"' {
+					//引号后面必须是空白或者结束
+					if i+1 < len(line) && line[i+1] != ' ' && line[i+1] != '\t' {
+						return nil, fmt.Errorf("unbalanced quotes")
+					}
+					done = true
+				} else {
+					current = append(current, line[i])
+				}
+			} else if inSQ {
+				if i >= len(line) {
+					return nil, fmt.Errorf("unbalanced quotes")
+				}
+				if line[i] == '\\' && i+1 < len(line) && line[i+1] == '\'' {
+					i++
+					current = append(current, '\'')
+				} else if line[i] == '\'' {
+					if i+1 < len(line) && line[i+1] != ' ' && line[i+1] != '\t' {
+						return nil, fmt.Errorf("unbalanced quotes")
+					}
+					done = true
+				} else {
+					current = append(current, line[i])
+				}
+			} else {
+				if i >= len(line) {
+					break
+				}
+				switch line[i] {
+				case ' ', '\n', '\r', '\t':
+					done = true
+				case '"':
+					inDQ = true
+				case '\'':
+					inSQ = true
+				default:
+					current = append(current, line[i])
+				}
+			}
+			if i < len(line) {
+				i++
+			}
+		}
+		args = append(args, string(current))
+	}
+}
+
+func isHexDigit(c byte) bool {
+	return (c >= '0' && c <= '9') || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
@@ -0,0 +1,48 @@
+package src
+
+import (
+	"testing"
+)
+
+func TestEncodeArgs(t *testing.T) {
+	args := [][]byte{[]byte("set"), []byte("key"), []byte("a b\r\n\x00c"), {}}
+	data := EncodeArgs(args...)
+	if int(ReadInt(data)) != len(data)-MsgBufferOffset {
+		t.Fatalf("frame length error")
+	}
+	decoded, err := DecodeArgs(data[MsgBufferOffset:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(decoded) != len(args) {
+		t.Fatalf("expect %d args, actual %d", len(args), len(decoded))
+	}
+	for i, arg := range args {
+		if decoded[i] != string(arg) {
+			t.Errorf("expect %q, actual %q", arg, decoded[i])
+		}
+	}
+	if _, err := DecodeArgs(data[MsgBufferOffset : len(data)-3]); err == nil {
+		t.Error("expect error for truncated body")
+	}
+}
+
+func TestSplitArgs(t *testing.T) {
+	args, err := SplitArgs(`set "a key" 'it''s' "x\ty\x41" plain`)
+	if err == nil {
+		t.Fatalf("expect unbalanced quotes error, actual %q", args)
+	}
+	args, err = SplitArgs(`set "a key" 'it\'s' "x\ty\x41"  plain`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := []string{"set", "a key", "it's", "x\tyA", "plain"}
+	if len(args) != len(expect) {
+		t.Fatalf("expect %q, actual %q", expect, args)
+	}
+	for i := range expect {
+		if args[i] != expect[i] {
+			t.Errorf("expect %q, actual %q", expect[i], args[i])
+		}
+	}
+}